@@ -0,0 +1,133 @@
+// Package apikeys generates the API keys event admins give to other systems
+// and carries the key a request was made with through its context.
+//
+// Keys look like cap_<id>_<secret>. The cap_<id> part is stored as is so the
+// key can be found, and the whole key is only stored hashed.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix starts every key, so they can be told apart from JWTs and found by
+// secret scanners.
+const Prefix = "cap_"
+
+// Scope is something a key is allowed to do with its event.
+type Scope string
+
+const (
+	ReadInvitees  Scope = "invitees:read"
+	WriteInvitees Scope = "invitees:write"
+	ReadReports   Scope = "reports:read"
+)
+
+// Scopes are all the scopes a key can be given.
+var Scopes = []Scope{ReadInvitees, WriteInvitees, ReadReports}
+
+// Valid checks whether s is one of Scopes.
+func (s Scope) Valid() bool {
+	for _, v := range Scopes {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Generate creates a new key. id is the part of it that can be stored and
+// shown, hash is what to store to check it against later.
+func Generate() (key string, id string, hash string, err error) {
+	idBytes := make([]byte, 6)
+	secret := make([]byte, 32)
+
+	if _, err = rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	id = Prefix + hex.EncodeToString(idBytes)
+	key = id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	return key, id, Hash(key), nil
+}
+
+// IsKey checks whether s looks like an API key rather than some other
+// credential.
+func IsKey(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// ID gets the id part of key. ok is false if key isn't shaped like one.
+func ID(key string) (id string, ok bool) {
+	if !IsKey(key) {
+		return "", false
+	}
+
+	// the secret can have underscores in it, the id can't
+	i := strings.IndexByte(key[len(Prefix):], '_') + len(Prefix)
+
+	if i <= len(Prefix) || i == len(key)-1 {
+		return "", false
+	}
+
+	return key[:i], true
+}
+
+// Hash hashes key for storing. Keys are long and random, so a fast hash is
+// enough.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches checks key against a stored hash in constant time.
+func Matches(key string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(key)), []byte(hash)) == 1
+}
+
+// Principal is who a request made with an API key acts as. It is limited to
+// one event and the key's scopes.
+type Principal struct {
+	KeyID   string
+	UserID  string
+	EventID string
+	Scopes  []Scope
+}
+
+// Has checks whether the key was given scope.
+func (p Principal) Has(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext gets the principal of the API key the request was made with.
+// ok is false if it wasn't made with one.
+func FromContext(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(contextKey{}).(Principal)
+
+	return p, ok
+}
@@ -0,0 +1,160 @@
+// Package audit describes who made a change and what it changed, for the
+// audit log.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ActorType is the kind of credential a change was made with.
+type ActorType string
+
+const (
+	// ActorUser is an admin using a token.
+	ActorUser ActorType = "user"
+	// ActorAPIKey is another system using an API key.
+	ActorAPIKey ActorType = "api_key"
+	// ActorInvitee is an invitee using the id they were sent.
+	ActorInvitee ActorType = "invitee"
+)
+
+// Actor is who made a change.
+type Actor struct {
+	Type ActorType
+	ID   string
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying a.
+func NewContext(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, contextKey{}, a)
+}
+
+// FromContext gets who the request carried by ctx was made by. ok is false
+// if it wasn't made with a credential.
+func FromContext(ctx context.Context) (a Actor, ok bool) {
+	a, ok = ctx.Value(contextKey{}).(Actor)
+
+	return a, ok
+}
+
+// Change is what a field was changed from and to. A field that didn't exist
+// before or after is nil on that side.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Diff compares the JSON forms of before and after and gets the fields that
+// differ, keyed by their dotted path, e.g. "friends.0.self.attending". Either
+// can be nil for something that was created or deleted.
+func Diff(before interface{}, after interface{}) (map[string]Change, error) {
+	b, err := normalise(before)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := normalise(after)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	diff("", b, a, changes)
+
+	return changes, nil
+}
+
+// normalise turns v into the generic maps, slices and values its JSON decodes
+// to, so values of any type can be compared.
+func normalise(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var n interface{}
+	err = json.Unmarshal(b, &n)
+
+	return n, err
+}
+
+// diff walks before and after together, adding the leaves that differ to
+// changes. Something missing on one side is treated as empty, so every field
+// of it is listed.
+func diff(path string, before interface{}, after interface{}, changes map[string]Change) {
+	bm, bIsMap := before.(map[string]interface{})
+	am, aIsMap := after.(map[string]interface{})
+
+	if (bIsMap || before == nil) && (aIsMap || after == nil) && (bIsMap || aIsMap) {
+		for _, k := range keys(bm, am) {
+			diff(join(path, k), bm[k], am[k], changes)
+		}
+
+		return
+	}
+
+	bs, bIsSlice := before.([]interface{})
+	as, aIsSlice := after.([]interface{})
+
+	if (bIsSlice || before == nil) && (aIsSlice || after == nil) && (bIsSlice || aIsSlice) {
+		for i := 0; i < len(bs) || i < len(as); i++ {
+			var b, a interface{}
+
+			if i < len(bs) {
+				b = bs[i]
+			}
+
+			if i < len(as) {
+				a = as[i]
+			}
+
+			diff(join(path, strconv.Itoa(i)), b, a, changes)
+		}
+
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		changes[path] = Change{From: before, To: after}
+	}
+}
+
+// keys gets the keys of both maps, sorted.
+func keys(a map[string]interface{}, b map[string]interface{}) []string {
+	var ks []string
+
+	for k := range a {
+		ks = append(ks, k)
+	}
+
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			ks = append(ks, k)
+		}
+	}
+
+	sort.Strings(ks)
+
+	return ks
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
@@ -167,3 +167,11 @@ CREATE TABLE IF NOT EXISTS event_admins (
 
 DROP TRIGGER IF EXISTS update_event_admin_updated_at_time ON event_admins;
 CREATE TRIGGER update_event_admin_updated_at_time BEFORE UPDATE ON event_admins FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+
+CREATE TABLE IF NOT EXISTS schema_versions (
+  version int PRIMARY KEY,
+  applied_at timestamp default current_timestamp
+);
+
+INSERT INTO schema_versions(version) VALUES (1) ON CONFLICT DO NOTHING;
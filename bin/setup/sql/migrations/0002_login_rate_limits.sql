@@ -0,0 +1,14 @@
+-- failed login tracking for lockouts and the shared store for rate limits
+
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS failed_logins int NOT NULL DEFAULT 0;
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS locked_until timestamptz;
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+  key text PRIMARY KEY,
+  tokens double precision,
+  updated_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_at_idx ON rate_limit_buckets (updated_at);
+
+INSERT INTO schema_versions(version) VALUES (2) ON CONFLICT DO NOTHING;
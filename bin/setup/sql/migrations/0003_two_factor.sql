@@ -0,0 +1,48 @@
+-- TOTP two factor authentication for users and event owners who can require
+-- it of their co-admins
+
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS totp_secret text NOT NULL DEFAULT '';
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  recovery_code_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  fk_user_id uuid NOT NULL REFERENCES users (user_id),
+  code_hash varchar(64) NOT NULL,
+  used_at timestamptz,
+  created_at timestamp default current_timestamp,
+  updated_at timestamp default current_timestamp,
+  UNIQUE (fk_user_id, code_hash)
+);
+
+DROP TRIGGER IF EXISTS update_recovery_codes_updated_at_time ON recovery_codes;
+CREATE TRIGGER update_recovery_codes_updated_at_time BEFORE UPDATE ON recovery_codes FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- a login that has passed the password check and is waiting on a code
+CREATE TABLE IF NOT EXISTS login_challenges (
+  login_challenge_id varchar(64) PRIMARY KEY,
+  fk_user_login_id uuid NOT NULL REFERENCES user_logins (user_login_id),
+  attempts int NOT NULL DEFAULT 0,
+  expires_at timestamptz NOT NULL,
+  created_at timestamp default current_timestamp,
+  updated_at timestamp default current_timestamp
+);
+
+DROP TRIGGER IF EXISTS update_login_challenges_updated_at_time ON login_challenges;
+CREATE TRIGGER update_login_challenges_updated_at_time BEFORE UPDATE ON login_challenges FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS require_two_factor boolean NOT NULL DEFAULT false;
+
+-- the first admin of an event is the one who created it
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'event_admins' AND column_name = 'owner') THEN
+    ALTER TABLE event_admins ADD COLUMN owner boolean NOT NULL DEFAULT false;
+
+    UPDATE event_admins SET owner = true WHERE event_admin_id IN (
+      SELECT DISTINCT ON (fk_event_id) event_admin_id FROM event_admins ORDER BY fk_event_id, created_at
+    );
+  END IF;
+END $$;
+
+INSERT INTO schema_versions(version) VALUES (3) ON CONFLICT DO NOTHING;
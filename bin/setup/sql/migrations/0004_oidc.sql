@@ -0,0 +1,30 @@
+-- logging admins in with an OpenID Connect provider
+
+-- a login that has been sent to the provider and is waiting to come back
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  state varchar(64) PRIMARY KEY,
+  code_verifier varchar(64) NOT NULL,
+  nonce varchar(64) NOT NULL,
+  expires_at timestamptz NOT NULL,
+  created_at timestamp default current_timestamp,
+  updated_at timestamp default current_timestamp
+);
+
+DROP TRIGGER IF EXISTS update_oidc_login_states_updated_at_time ON oidc_login_states;
+CREATE TRIGGER update_oidc_login_states_updated_at_time BEFORE UPDATE ON oidc_login_states FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- the provider accounts users have logged in with
+CREATE TABLE IF NOT EXISTS user_identities (
+  user_identity_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  fk_user_id uuid NOT NULL REFERENCES users (user_id),
+  issuer varchar(255) NOT NULL,
+  subject varchar(255) NOT NULL,
+  created_at timestamp default current_timestamp,
+  updated_at timestamp default current_timestamp,
+  UNIQUE (issuer, subject)
+);
+
+DROP TRIGGER IF EXISTS update_user_identities_updated_at_time ON user_identities;
+CREATE TRIGGER update_user_identities_updated_at_time BEFORE UPDATE ON user_identities FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+INSERT INTO schema_versions(version) VALUES (4) ON CONFLICT DO NOTHING;
@@ -0,0 +1,22 @@
+-- API keys event admins create for other systems to use
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  api_key_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  fk_event_id uuid NOT NULL REFERENCES events (event_id),
+  fk_user_id uuid NOT NULL REFERENCES users (user_id),
+  name varchar(255) NOT NULL,
+  key_id varchar(32) NOT NULL UNIQUE,
+  key_hash varchar(64) NOT NULL,
+  scopes varchar(255) NOT NULL,
+  last_used_at timestamptz,
+  revoked_at timestamptz,
+  created_at timestamp default current_timestamp,
+  updated_at timestamp default current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_fk_event_id ON api_keys (fk_event_id);
+
+DROP TRIGGER IF EXISTS update_api_keys_updated_at_time ON api_keys;
+CREATE TRIGGER update_api_keys_updated_at_time BEFORE UPDATE ON api_keys FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+INSERT INTO schema_versions(version) VALUES (5) ON CONFLICT DO NOTHING;
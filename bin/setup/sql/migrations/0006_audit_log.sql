@@ -0,0 +1,37 @@
+-- an append only log of every change to events and invitees' RSVPs
+
+CREATE TABLE IF NOT EXISTS audit_entries (
+  audit_entry_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  -- no foreign keys, entries have to outlive what they are about
+  fk_event_id uuid NOT NULL,
+  actor_type varchar(16) NOT NULL,
+  actor_id varchar(64) NOT NULL,
+  entity_type varchar(32) NOT NULL,
+  entity_id varchar(64) NOT NULL,
+  invitee_id varchar(64) NOT NULL DEFAULT '',
+  guest_id varchar(64) NOT NULL DEFAULT '',
+  action varchar(16) NOT NULL,
+  before jsonb,
+  after jsonb,
+  diff jsonb,
+  request_id varchar(128) NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS audit_entries_fk_event_id_created_at ON audit_entries (fk_event_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_entries_invitee_id ON audit_entries (invitee_id) WHERE invitee_id <> '';
+CREATE INDEX IF NOT EXISTS audit_entries_guest_id ON audit_entries (guest_id) WHERE guest_id <> '';
+
+CREATE OR REPLACE FUNCTION forbid_audit_entry_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit entries can not be changed or deleted';
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS forbid_audit_entries_changes ON audit_entries;
+CREATE TRIGGER forbid_audit_entries_changes BEFORE UPDATE OR DELETE ON audit_entries FOR EACH ROW EXECUTE PROCEDURE forbid_audit_entry_changes();
+DROP TRIGGER IF EXISTS forbid_audit_entries_truncate ON audit_entries;
+CREATE TRIGGER forbid_audit_entries_truncate BEFORE TRUNCATE ON audit_entries FOR EACH STATEMENT EXECUTE PROCEDURE forbid_audit_entry_changes();
+
+INSERT INTO schema_versions(version) VALUES (6) ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+-- versions for optimistic concurrency, they are sent to clients as ETags and
+-- bumped on every change
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE invitees ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+INSERT INTO schema_versions(version) VALUES (7) ON CONFLICT DO NOTHING;
@@ -0,0 +1,16 @@
+-- responses kept for requests sent with an Idempotency-Key, so retries get
+-- the same response
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  key text PRIMARY KEY,
+  request_hash varchar(64) NOT NULL,
+  status int,
+  header jsonb,
+  body bytea,
+  expires_at timestamptz NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+INSERT INTO schema_versions(version) VALUES (8) ON CONFLICT DO NOTHING;
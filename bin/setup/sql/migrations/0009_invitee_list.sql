@@ -0,0 +1,98 @@
+-- what the invitee list is filtered, sorted and searched by: when invitees
+-- answered, the tags admins give them and a search document of their names
+
+ALTER TABLE invitees ADD COLUMN IF NOT EXISTS responded_at timestamptz;
+ALTER TABLE invitees ADD COLUMN IF NOT EXISTS search tsvector;
+
+CREATE TABLE IF NOT EXISTS invitee_tags (
+  fk_invitee_id uuid NOT NULL REFERENCES invitees (invitee_id) ON DELETE CASCADE,
+  tag varchar(64) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT current_timestamp,
+  PRIMARY KEY (fk_invitee_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS invitee_tags_tag ON invitee_tags (tag);
+CREATE INDEX IF NOT EXISTS invitees_search ON invitees USING gin (search);
+CREATE INDEX IF NOT EXISTS invitees_fk_event_id ON invitees (fk_event_id);
+
+-- the search document of an invitee: their email, whole and split into
+-- words, and the names of the invitee and their friends
+CREATE OR REPLACE FUNCTION invitee_search_vector(p_invitee_id uuid, p_email text, p_guest_id uuid)
+RETURNS tsvector AS $$
+  SELECT to_tsvector('simple',
+    coalesce(p_email, '') || ' ' ||
+    regexp_replace(coalesce(p_email, ''), '[^[:alnum:]]+', ' ', 'g') || ' ' ||
+    coalesce((SELECT first_name || ' ' || last_name FROM guests WHERE guest_id = p_guest_id), '') || ' ' ||
+    coalesce((SELECT string_agg(g.first_name || ' ' || g.last_name, ' ') FROM invitee_friends f JOIN guests g ON g.guest_id = f.fk_guest_id WHERE f.fk_invitee_id = p_invitee_id), '')
+  );
+$$ language 'sql' STABLE;
+
+CREATE OR REPLACE FUNCTION update_invitee_search()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.search = invitee_search_vector(NEW.invitee_id, NEW.email, NEW.fk_guest_id);
+  RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS update_invitee_search ON invitees;
+CREATE TRIGGER update_invitee_search BEFORE INSERT OR UPDATE ON invitees FOR EACH ROW EXECUTE PROCEDURE update_invitee_search();
+
+-- refreshing the search document isn't a change to the invitee, so it leaves
+-- updated_at alone
+DROP TRIGGER IF EXISTS update_invitee_updated_at_time ON invitees;
+CREATE TRIGGER update_invitee_updated_at_time BEFORE UPDATE ON invitees FOR EACH ROW
+  WHEN ((to_jsonb(OLD) - 'search') IS DISTINCT FROM (to_jsonb(NEW) - 'search'))
+  EXECUTE PROCEDURE update_updated_at_column();
+
+-- renamed guests are searched for by their new names
+CREATE OR REPLACE FUNCTION refresh_guest_invitee_search()
+RETURNS TRIGGER AS $$
+BEGIN
+  UPDATE invitees SET search = invitee_search_vector(invitee_id, email, fk_guest_id)
+  WHERE (fk_guest_id = NEW.guest_id
+    OR invitee_id IN (SELECT fk_invitee_id FROM invitee_friends WHERE fk_guest_id = NEW.guest_id))
+    AND search IS DISTINCT FROM invitee_search_vector(invitee_id, email, fk_guest_id);
+  RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS refresh_guest_invitee_search ON guests;
+CREATE TRIGGER refresh_guest_invitee_search AFTER UPDATE OF first_name, last_name ON guests FOR EACH ROW EXECUTE PROCEDURE refresh_guest_invitee_search();
+
+-- as are friends that are added, and not those that are removed
+CREATE OR REPLACE FUNCTION refresh_friend_invitee_search()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    UPDATE invitees SET search = invitee_search_vector(invitee_id, email, fk_guest_id) WHERE invitee_id = OLD.fk_invitee_id;
+  ELSE
+    UPDATE invitees SET search = invitee_search_vector(invitee_id, email, fk_guest_id) WHERE invitee_id = NEW.fk_invitee_id;
+  END IF;
+
+  RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS refresh_friend_invitee_search ON invitee_friends;
+CREATE TRIGGER refresh_friend_invitee_search AFTER INSERT OR DELETE ON invitee_friends FOR EACH ROW EXECUTE PROCEDURE refresh_friend_invitee_search();
+
+-- fill in the existing invitees without making them look updated. Invitees
+-- answered when they last changed their RSVP, or, for those who accepted
+-- before changes were recorded, when they were last updated.
+ALTER TABLE invitees DISABLE TRIGGER update_invitee_updated_at_time;
+
+UPDATE invitees SET search = invitee_search_vector(invitee_id, email, fk_guest_id);
+
+UPDATE invitees i SET responded_at = a.responded_at FROM (
+  SELECT invitee_id, max(created_at) AS responded_at FROM audit_entries
+  WHERE entity_type = 'invitee' AND action = 'update'
+  GROUP BY invitee_id
+) a WHERE a.invitee_id = i.invitee_id::text AND i.responded_at IS NULL;
+
+UPDATE invitees i SET responded_at = i.updated_at FROM guests g
+WHERE g.guest_id = i.fk_guest_id AND g.attending AND i.responded_at IS NULL;
+
+ALTER TABLE invitees ENABLE TRIGGER update_invitee_updated_at_time;
+
+INSERT INTO schema_versions(version) VALUES (9) ON CONFLICT DO NOTHING;
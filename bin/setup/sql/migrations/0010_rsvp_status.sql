@@ -0,0 +1,59 @@
+-- the answer each guest gave to the invitation, rather than only whether they
+-- are attending, and when they gave it
+
+ALTER TABLE guests ADD COLUMN IF NOT EXISTS rsvp_status varchar(16) NOT NULL DEFAULT 'pending'
+  CHECK (rsvp_status IN ('pending', 'attending', 'declined', 'maybe'));
+ALTER TABLE guests ADD COLUMN IF NOT EXISTS rsvp_status_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS guests_rsvp_status ON guests (rsvp_status);
+
+-- fill in the existing guests once, without making them look updated. Guests
+-- attending said so; the others declined if their invitee has answered and
+-- haven't answered yet otherwise.
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM schema_versions WHERE version = 10) THEN
+    ALTER TABLE guests DISABLE TRIGGER update_guest_updated_at_time;
+
+    UPDATE guests g SET
+      rsvp_status = CASE WHEN g.attending THEN 'attending' ELSE 'declined' END,
+      rsvp_status_at = coalesce(r.responded_at, g.updated_at)
+    FROM (
+      SELECT i.fk_guest_id AS guest_id, i.responded_at FROM invitees i
+      UNION ALL
+      SELECT f.fk_guest_id, i.responded_at FROM invitee_friends f JOIN invitees i ON i.invitee_id = f.fk_invitee_id
+    ) r
+    WHERE r.guest_id = g.guest_id AND (g.attending OR r.responded_at IS NOT NULL);
+
+    ALTER TABLE guests ENABLE TRIGGER update_guest_updated_at_time;
+  END IF;
+END;
+$$;
+
+-- the status is what counts: attending follows it, and changing it records
+-- when. Guests added only as attending or not get the matching status.
+CREATE OR REPLACE FUNCTION update_guest_rsvp_status()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' AND NEW.rsvp_status = 'pending' AND coalesce(NEW.attending, false) THEN
+    NEW.rsvp_status = 'attending';
+  END IF;
+
+  NEW.attending = NEW.rsvp_status = 'attending';
+
+  IF TG_OP = 'INSERT' THEN
+    IF NEW.rsvp_status <> 'pending' THEN
+      NEW.rsvp_status_at = coalesce(NEW.rsvp_status_at, now());
+    END IF;
+  ELSIF NEW.rsvp_status IS DISTINCT FROM OLD.rsvp_status THEN
+    NEW.rsvp_status_at = now();
+  END IF;
+
+  RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS update_guest_rsvp_status ON guests;
+CREATE TRIGGER update_guest_rsvp_status BEFORE INSERT OR UPDATE ON guests FOR EACH ROW EXECUTE PROCEDURE update_guest_rsvp_status();
+
+INSERT INTO schema_versions(version) VALUES (10) ON CONFLICT DO NOTHING;
@@ -0,0 +1,37 @@
+-- the emails sent to invitees: the templates admins change for their events,
+-- and every email sent with how its delivery went
+
+CREATE TABLE IF NOT EXISTS email_templates (
+  fk_event_id uuid NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+  kind varchar(32) NOT NULL,
+  subject text NOT NULL,
+  body text NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT current_timestamp,
+  updated_at timestamptz NOT NULL DEFAULT current_timestamp,
+  PRIMARY KEY (fk_event_id, kind)
+);
+
+DROP TRIGGER IF EXISTS update_email_template_updated_at_time ON email_templates;
+CREATE TRIGGER update_email_template_updated_at_time BEFORE UPDATE ON email_templates FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS email_deliveries (
+  email_delivery_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  fk_event_id uuid NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+  fk_invitee_id uuid NOT NULL REFERENCES invitees (invitee_id) ON DELETE CASCADE,
+  kind varchar(32) NOT NULL,
+  to_address text NOT NULL,
+  subject text NOT NULL DEFAULT '',
+  status varchar(16) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'sent', 'failed')),
+  error text NOT NULL DEFAULT '',
+  sent_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT current_timestamp,
+  updated_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS email_deliveries_fk_event_id_created_at ON email_deliveries (fk_event_id, created_at);
+CREATE INDEX IF NOT EXISTS email_deliveries_fk_invitee_id ON email_deliveries (fk_invitee_id);
+
+DROP TRIGGER IF EXISTS update_email_delivery_updated_at_time ON email_deliveries;
+CREATE TRIGGER update_email_delivery_updated_at_time BEFORE UPDATE ON email_deliveries FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+INSERT INTO schema_versions(version) VALUES (11) ON CONFLICT DO NOTHING;
@@ -0,0 +1,34 @@
+-- a durable queue of background jobs. Workers claim queued jobs with SKIP
+-- LOCKED; running jobs whose lease ran out were left by a worker that died
+-- and are claimed again. Jobs that fail too often are dead and kept to be
+-- looked at.
+
+CREATE TABLE IF NOT EXISTS jobs (
+  job_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  kind varchar(64) NOT NULL,
+  payload jsonb NOT NULL DEFAULT '{}',
+  -- jobs enqueued with the same key are only enqueued once
+  unique_key text UNIQUE,
+  status varchar(16) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'done', 'dead')),
+  attempts integer NOT NULL DEFAULT 0,
+  max_attempts integer NOT NULL DEFAULT 10,
+  run_at timestamptz NOT NULL DEFAULT current_timestamp,
+  locked_until timestamptz,
+  last_error text NOT NULL DEFAULT '',
+  finished_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT current_timestamp,
+  updated_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS jobs_queued_run_at ON jobs (run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_locked_until ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS jobs_dead ON jobs (finished_at) WHERE status = 'dead';
+
+DROP TRIGGER IF EXISTS update_job_updated_at_time ON jobs;
+CREATE TRIGGER update_job_updated_at_time BEFORE UPDATE ON jobs FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- invitees can't change their RSVPs once they are locked, which happens at
+-- the event's respond_by
+ALTER TABLE events ADD COLUMN IF NOT EXISTS rsvps_locked_at timestamptz;
+
+INSERT INTO schema_versions(version) VALUES (12) ON CONFLICT DO NOTHING;
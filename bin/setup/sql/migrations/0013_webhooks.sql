@@ -0,0 +1,42 @@
+-- the endpoints event admins register to be told about changes to their
+-- events, and every request made to them with how it went
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  webhook_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  fk_event_id uuid NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+  url text NOT NULL,
+  secret varchar(64) NOT NULL,
+  -- space separated
+  event_types varchar(255) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT current_timestamp,
+  updated_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_fk_event_id ON webhooks (fk_event_id);
+
+DROP TRIGGER IF EXISTS update_webhook_updated_at_time ON webhooks;
+CREATE TRIGGER update_webhook_updated_at_time BEFORE UPDATE ON webhooks FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  webhook_delivery_id uuid DEFAULT uuid_generate_v1mc() PRIMARY KEY,
+  fk_webhook_id uuid NOT NULL REFERENCES webhooks (webhook_id) ON DELETE CASCADE,
+  fk_event_id uuid NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+  event_type varchar(32) NOT NULL,
+  payload jsonb NOT NULL,
+  status varchar(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts integer NOT NULL DEFAULT 0,
+  response_status integer NOT NULL DEFAULT 0,
+  response_body text NOT NULL DEFAULT '',
+  error text NOT NULL DEFAULT '',
+  delivered_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT current_timestamp,
+  updated_at timestamptz NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_fk_webhook_id_created_at ON webhook_deliveries (fk_webhook_id, created_at);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_fk_event_id ON webhook_deliveries (fk_event_id);
+
+DROP TRIGGER IF EXISTS update_webhook_delivery_updated_at_time ON webhook_deliveries;
+CREATE TRIGGER update_webhook_delivery_updated_at_time BEFORE UPDATE ON webhook_deliveries FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+INSERT INTO schema_versions(version) VALUES (13) ON CONFLICT DO NOTHING;
@@ -0,0 +1,100 @@
+-- tell the servers about changes to invitees, their guests and their menu
+-- choices as they are committed, so they can push them to the admins watching
+-- the event. Every server listens on the capacious_changes channel; the
+-- payload is {"event_id": ..., "invitee_id": ...} and the servers read the
+-- rest from the database, so they all send the same thing.
+
+CREATE OR REPLACE FUNCTION notify_invitee_change(changed_invitee_id uuid) RETURNS void AS $$
+DECLARE
+  changed_event_id uuid;
+BEGIN
+  SELECT fk_event_id INTO changed_event_id FROM invitees WHERE invitee_id = changed_invitee_id;
+
+  IF changed_event_id IS NOT NULL THEN
+    -- identical notifications in a transaction are only sent once
+    PERFORM pg_notify('capacious_changes', json_build_object('event_id', changed_event_id, 'invitee_id', changed_invitee_id)::text);
+  END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the invitee a guest is, or is a friend of
+CREATE OR REPLACE FUNCTION notify_guest_change(changed_guest_id uuid) RETURNS void AS $$
+BEGIN
+  PERFORM notify_invitee_change(invitee_id) FROM (
+    SELECT invitee_id FROM invitees WHERE fk_guest_id = changed_guest_id
+    UNION
+    SELECT fk_invitee_id FROM invitee_friends WHERE fk_guest_id = changed_guest_id
+  ) AS owners;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_invitees_change() RETURNS trigger AS $$
+DECLARE
+  changed invitees%ROWTYPE;
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    changed := OLD;
+  ELSE
+    changed := NEW;
+  END IF;
+
+  -- the invitee is gone by the time a delete is committed, so its event
+  -- can't be looked up then
+  PERFORM pg_notify('capacious_changes', json_build_object('event_id', changed.fk_event_id, 'invitee_id', changed.invitee_id)::text);
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_invitee_friends_change() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM notify_invitee_change(OLD.fk_invitee_id);
+  ELSE
+    PERFORM notify_invitee_change(NEW.fk_invitee_id);
+  END IF;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_guests_change() RETURNS trigger AS $$
+BEGIN
+  PERFORM notify_guest_change(NEW.guest_id);
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_menu_choices_change() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM notify_guest_change(OLD.fk_guest_id);
+  ELSE
+    PERFORM notify_guest_change(NEW.fk_guest_id);
+  END IF;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- refreshing an invitee's search document isn't a change to them
+DROP TRIGGER IF EXISTS notify_change ON invitees;
+CREATE TRIGGER notify_change AFTER INSERT OR DELETE ON invitees FOR EACH ROW EXECUTE PROCEDURE notify_invitees_change();
+
+DROP TRIGGER IF EXISTS notify_update ON invitees;
+CREATE TRIGGER notify_update AFTER UPDATE ON invitees FOR EACH ROW
+  WHEN ((to_jsonb(OLD) - 'search') IS DISTINCT FROM (to_jsonb(NEW) - 'search'))
+  EXECUTE PROCEDURE notify_invitees_change();
+
+DROP TRIGGER IF EXISTS notify_change ON invitee_friends;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON invitee_friends FOR EACH ROW EXECUTE PROCEDURE notify_invitee_friends_change();
+
+-- a guest's invitee doesn't exist yet when it is inserted, so only updates
+DROP TRIGGER IF EXISTS notify_change ON guests;
+CREATE TRIGGER notify_change AFTER UPDATE ON guests FOR EACH ROW EXECUTE PROCEDURE notify_guests_change();
+
+DROP TRIGGER IF EXISTS notify_change ON menu_choices;
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON menu_choices FOR EACH ROW EXECUTE PROCEDURE notify_menu_choices_change();
+
+INSERT INTO schema_versions(version) VALUES (14) ON CONFLICT DO NOTHING;
@@ -0,0 +1,25 @@
+-- the time zone an event's times are shown in, and the SEQUENCE of its
+-- calendar entries. Calendars only take an updated entry over the one they
+-- have if its SEQUENCE is higher, so it goes up whenever the time or place of
+-- the event changes, however it is changed.
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS time_zone varchar(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE events ADD COLUMN IF NOT EXISTS calendar_sequence int NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION bump_calendar_sequence() RETURNS trigger AS $$
+BEGIN
+  IF NEW.start_time IS DISTINCT FROM OLD.start_time
+    OR NEW.end_time IS DISTINCT FROM OLD.end_time
+    OR NEW.location IS DISTINCT FROM OLD.location
+    OR NEW.time_zone IS DISTINCT FROM OLD.time_zone THEN
+    NEW.calendar_sequence := OLD.calendar_sequence + 1;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS bump_calendar_sequence ON events;
+CREATE TRIGGER bump_calendar_sequence BEFORE UPDATE ON events FOR EACH ROW EXECUTE PROCEDURE bump_calendar_sequence();
+
+INSERT INTO schema_versions(version) VALUES (15) ON CONFLICT DO NOTHING;
@@ -189,9 +189,9 @@ BEGIN
     -- End create test user
 
     -- Link the user to the event
-    INSERT INTO event_admins (fk_user_id, fk_event_id)
+    INSERT INTO event_admins (fk_user_id, fk_event_id, owner)
     VALUES(
-      'cd7bc650-2e71-11e5-a390-675459b99309', 'cd7bc650-2e71-11e5-a390-675459d99309'
+      'cd7bc650-2e71-11e5-a390-675459b99309', 'cd7bc650-2e71-11e5-a390-675459d99309', true
     );
     -- End linking the user
 
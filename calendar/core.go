@@ -0,0 +1,358 @@
+// Package calendar writes iCalendar files (RFC 5545), so guests can add events
+// to their calendars.
+//
+// Times are written in the event's time zone, with a VTIMEZONE saying what
+// that zone's offsets are around the event, so calendars show the event at
+// the same moment wherever they are. Events in UTC are written in UTC.
+package calendar
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	// zones have to load wherever the server runs, even without tzdata
+	_ "time/tzdata"
+)
+
+// ContentType is the media type of calendars.
+const ContentType = "text/calendar; charset=utf-8"
+
+// the METHODs calendars are sent with: PUBLISH for a calendar to subscribe
+// to, REQUEST for an invitation to one attendee
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+)
+
+// the answers an attendee can have given, their PARTSTAT
+const (
+	NeedsAction = "NEEDS-ACTION"
+	Accepted    = "ACCEPTED"
+	Declined    = "DECLINED"
+	Tentative   = "TENTATIVE"
+)
+
+const prodID = "-//Capacious//Capacious//EN"
+
+// the format of local and UTC date-times
+const (
+	localFormat = "20060102T150405"
+	utcFormat   = "20060102T150405Z"
+)
+
+// Calendar is a calendar of events.
+type Calendar struct {
+	Method string
+	// Name is what calendars that subscribe to it call it
+	Name   string
+	Events []Event
+}
+
+// Event is an event in a calendar. Calendars replace an event they have with
+// one with the same UID and a higher Sequence.
+type Event struct {
+	UID         string
+	Sequence    int
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Start       time.Time
+	// End is left out if it is zero
+	End time.Time
+	// Zone is the time zone the times are written in; nil is UTC
+	Zone      *time.Location
+	Organizer *Person
+	Attendees []Attendee
+	// Stamp is when this version of the event was made; zero is now
+	Stamp time.Time
+}
+
+// Person is someone with an email address.
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Attendee is someone invited to an event, with their answer.
+type Attendee struct {
+	Person
+	Status string
+	// RSVP asks them to answer
+	RSVP bool
+}
+
+// LoadZone loads the IANA time zone called name. An empty name is UTC.
+func LoadZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+// Bytes writes c as an iCalendar file.
+func (c Calendar) Bytes() []byte {
+	w := &writer{}
+
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + prodID)
+	w.line("CALSCALE:GREGORIAN")
+
+	if c.Method != "" {
+		w.line("METHOD:" + c.Method)
+	}
+
+	if c.Name != "" {
+		w.line("X-WR-CALNAME:" + escape(c.Name))
+	}
+
+	for _, zone := range zones(c.Events) {
+		w.timezone(zone.loc, zone.from, zone.to)
+	}
+
+	for _, e := range c.Events {
+		w.event(e)
+	}
+
+	w.line("END:VCALENDAR")
+
+	return w.buf.Bytes()
+}
+
+// zoneSpan is the times a zone has to be described for.
+type zoneSpan struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// zones gets the zones the events are written in, other than UTC, and the
+// times each has to be described for.
+func zones(events []Event) []zoneSpan {
+	var spans []zoneSpan
+
+	for _, e := range events {
+		if isUTC(e.Zone) {
+			continue
+		}
+
+		from, to := e.Start, e.End
+
+		if to.Before(from) {
+			to = from
+		}
+
+		found := false
+
+		for i := range spans {
+			if spans[i].loc.String() != e.Zone.String() {
+				continue
+			}
+
+			if from.Before(spans[i].from) {
+				spans[i].from = from
+			}
+
+			if to.After(spans[i].to) {
+				spans[i].to = to
+			}
+
+			found = true
+		}
+
+		if !found {
+			spans = append(spans, zoneSpan{loc: e.Zone, from: from, to: to})
+		}
+	}
+
+	return spans
+}
+
+func isUTC(loc *time.Location) bool {
+	return loc == nil || loc.String() == "UTC"
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+// line writes a content line, folded so no line is longer than 75 octets.
+// Folds are never made inside a UTF-8 sequence.
+func (w *writer) line(s string) {
+	limit := 75
+
+	for len(s) > limit {
+		cut := limit
+
+		for s[cut]&0xc0 == 0x80 {
+			cut--
+		}
+
+		w.buf.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+
+		// the space starting the lines after the first counts towards them
+		limit = 74
+	}
+
+	w.buf.WriteString(s + "\r\n")
+}
+
+// timezone writes a VTIMEZONE for loc with an observance for each of its
+// offsets from the one in effect at from until the one in effect at to.
+func (w *writer) timezone(loc *time.Location, from time.Time, to time.Time) {
+	w.line("BEGIN:VTIMEZONE")
+	w.line("TZID:" + loc.String())
+
+	t := from.In(loc)
+
+	for {
+		start, end := t.ZoneBounds()
+		name, offset := t.Zone()
+
+		// when the offset came in, in the offset before it
+		prev, onset := offset, "19700101T000000"
+
+		if !start.IsZero() {
+			_, prev = start.Add(-time.Second).In(loc).Zone()
+			onset = start.In(time.FixedZone("", prev)).Format(localFormat)
+		}
+
+		kind := "STANDARD"
+
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+
+		w.line("BEGIN:" + kind)
+		w.line("DTSTART:" + onset)
+		w.line("TZOFFSETFROM:" + formatOffset(prev))
+		w.line("TZOFFSETTO:" + formatOffset(offset))
+
+		if name != "" && !strings.HasPrefix(name, "+") && !strings.HasPrefix(name, "-") {
+			w.line("TZNAME:" + escape(name))
+		}
+
+		w.line("END:" + kind)
+
+		if end.IsZero() || end.After(to) {
+			break
+		}
+
+		t = end.In(loc)
+	}
+
+	w.line("END:VTIMEZONE")
+}
+
+func (w *writer) event(e Event) {
+	stamp := e.Stamp
+
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + escape(e.UID))
+	w.line("SEQUENCE:" + strconv.Itoa(e.Sequence))
+	w.line("DTSTAMP:" + stamp.UTC().Format(utcFormat))
+	w.line(dateTime("DTSTART", e.Start, e.Zone))
+
+	if !e.End.IsZero() && !e.End.Before(e.Start) {
+		w.line(dateTime("DTEND", e.End, e.Zone))
+	}
+
+	w.line("SUMMARY:" + escape(e.Summary))
+
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + escape(e.Description))
+	}
+
+	if e.Location != "" {
+		w.line("LOCATION:" + escape(e.Location))
+	}
+
+	if e.URL != "" {
+		w.line("URL:" + e.URL)
+	}
+
+	w.line("STATUS:CONFIRMED")
+
+	if e.Organizer != nil {
+		w.line("ORGANIZER" + cn(e.Organizer.Name) + ":mailto:" + e.Organizer.Email)
+	}
+
+	for _, a := range e.Attendees {
+		status := a.Status
+
+		if status == "" {
+			status = NeedsAction
+		}
+
+		line := "ATTENDEE" + cn(a.Name) + ";ROLE=REQ-PARTICIPANT;PARTSTAT=" + status
+
+		if a.RSVP {
+			line += ";RSVP=TRUE"
+		}
+
+		w.line(line + ":mailto:" + a.Email)
+	}
+
+	w.line("END:VEVENT")
+}
+
+// dateTime writes the property name with t, in zone unless it is UTC.
+func dateTime(name string, t time.Time, zone *time.Location) string {
+	if isUTC(zone) {
+		return name + ":" + t.UTC().Format(utcFormat)
+	}
+
+	return name + ";TZID=" + zone.String() + ":" + t.In(zone).Format(localFormat)
+}
+
+// cn is the CN parameter for name, if there is one.
+func cn(name string) string {
+	name = strings.Join(strings.Fields(strings.ReplaceAll(name, `"`, "")), " ")
+
+	if name == "" {
+		return ""
+	}
+
+	return `;CN="` + name + `"`
+}
+
+// formatOffset writes an offset from UTC in seconds as +hhmm, or +hhmmss if it
+// isn't a whole number of minutes.
+func formatOffset(offset int) string {
+	sign := "+"
+
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+
+	s := sign + pad(offset/3600) + pad(offset/60%60)
+
+	if offset%60 != 0 {
+		s += pad(offset % 60)
+	}
+
+	return s
+}
+
+func pad(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+
+	return strconv.Itoa(n)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// escape escapes s to be a TEXT value.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
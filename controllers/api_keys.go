@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/services"
+	"github.com/grounded042/capacious/utils"
+	"github.com/zenazn/goji/web"
+)
+
+type APIKeysStub interface {
+	CreateAPIKey(ctx context.Context, eventID string, userID string, newKey services.NewAPIKey) (services.CreatedAPIKey, utils.Error)
+	GetAPIKeysForEvent(ctx context.Context, eventID string, userID string) ([]entities.APIKey, utils.Error)
+	RevokeAPIKey(ctx context.Context, eventID string, apiKeyID string, userID string) utils.Error
+}
+
+type APIKeysController struct {
+	aks APIKeysStub
+}
+
+func NewAPIKeysController(newAKS APIKeysStub) APIKeysController {
+	return APIKeysController{
+		aks: newAKS,
+	}
+}
+
+// GetAPIKeysForEvent lists the API keys of an event. The keys themselves are
+// never included.
+func (akc APIKeysController) GetAPIKeysForEvent(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get the API keys for an event!")
+
+	if !ok {
+		return
+	}
+
+	if keys, err := akc.aks.GetAPIKeysForEvent(r.Context(), c.URLParams["id"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// CreateAPIKey creates an API key for an event. The response is the only time
+// the key is shown.
+func (akc APIKeysController) CreateAPIKey(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to create an API key!")
+
+	if !ok {
+		return
+	}
+
+	var newKey services.NewAPIKey
+
+	if dErr := json.NewDecoder(r.Body).Decode(&newKey); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if created, err := akc.aks.CreateAPIKey(r.Context(), c.URLParams["id"], userID, newKey); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+// RevokeAPIKey revokes an API key of an event.
+func (akc APIKeysController) RevokeAPIKey(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to revoke an API key!")
+
+	if !ok {
+		return
+	}
+
+	if err := akc.aks.RevokeAPIKey(r.Context(), c.URLParams["id"], c.URLParams["api_key_id"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(204)
+	}
+}
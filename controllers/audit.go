@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/services"
+	"github.com/zenazn/goji/web"
+)
+
+// GetAuditLog lists the changes made to an event and its invitees, oldest
+// first. They can be narrowed down with filter[invitee], filter[guest],
+// filter[entity] and filter[entity_id].
+func (ec EventsController) GetAuditLog(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get the audit log for an event!")
+
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+
+	filter := entities.AuditFilter{
+		InviteeID:  q.Get("filter[invitee]"),
+		GuestID:    q.Get("filter[guest]"),
+		EntityType: q.Get("filter[entity]"),
+		EntityID:   q.Get("filter[entity_id]"),
+	}
+
+	p := services.NewPaginationService()
+	p.SetMaxPageSize(100)
+
+	if pErr := readPagination(q, &p); pErr != nil {
+		writeError(r, w, 400, pErr)
+		return
+	}
+
+	entries, err := ec.es.GetAuditLog(r.Context(), c.URLParams["id"], userID, filter, &p)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	setLinkHeader(w, pageLinks(r, &p))
+
+	toSend := DataWithPagination{
+		Data:       entries,
+		Pagination: paginationInfo(&p),
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(toSend)
+}
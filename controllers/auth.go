@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/grounded042/capacious/jwtkeys"
 	"github.com/grounded042/capacious/services"
 	"github.com/grounded042/capacious/utils"
 	"github.com/zenazn/goji/web"
@@ -14,9 +16,30 @@ type jsonTokenObj struct {
 	Token string `json:"token"`
 }
 
+// jsonChallengeObj is sent in place of a token when a login needs a second
+// factor.
+type jsonChallengeObj struct {
+	Challenge string `json:"challenge"`
+}
+
+type jsonCodeObj struct {
+	Code string `json:"code"`
+}
+
+type jsonRecoveryCodesObj struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
 type AuthStub interface {
-	Login(services.LoginUser) (string, utils.Error)
+	Login(context.Context, services.LoginUser) (services.LoginResult, utils.Error)
 	GenerateToken(string) (string, utils.Error)
+	GetJWKS() jwtkeys.JWKS
+	BeginOIDCLogin(ctx context.Context) (services.OIDCAuthorization, utils.Error)
+	CompleteOIDCLogin(ctx context.Context, code string, state string) (services.LoginResult, utils.Error)
+	BeginTwoFactorEnrolment(ctx context.Context, userID string) (services.TwoFactorEnrolment, utils.Error)
+	ConfirmTwoFactor(ctx context.Context, userID string, code string) ([]string, utils.Error)
+	DisableTwoFactor(ctx context.Context, userID string, code string) utils.Error
+	RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, utils.Error)
 }
 
 type AuthController struct {
@@ -34,19 +57,33 @@ func (ac AuthController) Login(c web.C, w http.ResponseWriter, r *http.Request)
 	decoder := json.NewDecoder(r.Body)
 
 	if dErr := decoder.Decode(&user); dErr != nil {
-		w.WriteHeader(400)
-		fmt.Println(dErr)
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
 		return
 	}
 
-	if token, err := ac.as.Login(*user); err != nil {
-		w.WriteHeader(err.Code())
-		fmt.Println(err)
-	} else if jsonToken, mErr := json.Marshal(jsonTokenObj{Token: token}); mErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(mErr)
+	result, err := ac.as.Login(r.Context(), *user)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	writeLoginResult(r, w, result)
+}
+
+// writeLoginResult writes the token of a login, or the challenge to answer
+// when it needs a second factor.
+func writeLoginResult(r *http.Request, w http.ResponseWriter, result services.LoginResult) {
+	var body interface{} = jsonTokenObj{Token: result.Token}
+
+	if result.Challenge != "" {
+		body = jsonChallengeObj{Challenge: result.Challenge}
+	}
+
+	if jsonBody, mErr := json.Marshal(body); mErr != nil {
+		writeError(r, w, 500, mErr)
 	} else {
-		w.Write(jsonToken)
+		w.Write(jsonBody)
 	}
 }
 
@@ -59,17 +96,113 @@ func (ac AuthController) RefreshToken(c web.C, w http.ResponseWriter, r *http.Re
 	}
 
 	if token, err := ac.as.GenerateToken(userId); err != nil {
-		w.WriteHeader(err.Code())
-		fmt.Println(err)
+		writeError(r, w, err.Code(), err)
 		return
 	} else if jsonToken, mErr := json.Marshal(jsonTokenObj{Token: token}); mErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(mErr)
+		writeError(r, w, 500, mErr)
 	} else {
 		w.Write(jsonToken)
 	}
 }
 
+// JWKS renders the public keys tokens can be verified with, so other services
+// can verify them without holding a signing key.
+func (ac AuthController) JWKS(c web.C, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwtkeys.JWKSMaxAge.Seconds())))
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(ac.as.GetJWKS())
+}
+
 func (ac AuthController) Logout(c web.C, w http.ResponseWriter, r *http.Request) {
 	// TODO: implement this
 }
+
+// BeginTwoFactorEnrolment starts two factor enrolment for the user. The
+// response holds the secret and the otpauth:// URI to show as a QR code.
+func (ac AuthController) BeginTwoFactorEnrolment(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to enable two factor authentication!")
+
+	if !ok {
+		return
+	}
+
+	if enrolment, err := ac.as.BeginTwoFactorEnrolment(r.Context(), userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(enrolment)
+	}
+}
+
+// ConfirmTwoFactor enables two factor authentication for the user once they
+// send a code from their authenticator app. The response holds their recovery
+// codes, which are only ever shown this once.
+func (ac AuthController) ConfirmTwoFactor(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to enable two factor authentication!")
+
+	if !ok {
+		return
+	}
+
+	var code jsonCodeObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&code); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if codes, err := ac.as.ConfirmTwoFactor(r.Context(), userID, code.Code); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(jsonRecoveryCodesObj{RecoveryCodes: codes})
+	}
+}
+
+// DisableTwoFactor turns two factor authentication off for the user. They
+// have to send a code from their authenticator app or a recovery code.
+func (ac AuthController) DisableTwoFactor(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to disable two factor authentication!")
+
+	if !ok {
+		return
+	}
+
+	var code jsonCodeObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&code); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if err := ac.as.DisableTwoFactor(r.Context(), userID, code.Code); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(204)
+	}
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. They have to
+// send a code from their authenticator app or a recovery code.
+func (ac AuthController) RegenerateRecoveryCodes(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get new recovery codes!")
+
+	if !ok {
+		return
+	}
+
+	var code jsonCodeObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&code); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if codes, err := ac.as.RegenerateRecoveryCodes(r.Context(), userID, code.Code); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(jsonRecoveryCodesObj{RecoveryCodes: codes})
+	}
+}
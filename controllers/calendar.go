@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grounded042/capacious/calendar"
+	"github.com/grounded042/capacious/utils"
+	"github.com/zenazn/goji/web"
+)
+
+type CalendarStub interface {
+	GetEventCalendar(ctx context.Context, eventID string) ([]byte, utils.Error)
+	GetInviteeCalendar(ctx context.Context, inviteeID string) ([]byte, utils.Error)
+}
+
+type CalendarController struct {
+	cs CalendarStub
+}
+
+func NewCalendarController(newCS CalendarStub) CalendarController {
+	return CalendarController{
+		cs: newCS,
+	}
+}
+
+// GetEventCalendar gets an event as a calendar to add to or subscribe to.
+// Like the event's info it doesn't require auth.
+func (cc CalendarController) GetEventCalendar(c web.C, w http.ResponseWriter, r *http.Request) {
+	if cal, err := cc.cs.GetEventCalendar(r.Context(), c.URLParams["id"]); err != nil {
+		writeError(r, w, utils.GetCodeForError(err), err)
+	} else {
+		writeCalendar(w, "event.ics", cal)
+	}
+}
+
+// GetInviteeCalendar gets an invitee's invitation to their event, with their
+// answer, as a calendar invitation.
+func (cc CalendarController) GetInviteeCalendar(c web.C, w http.ResponseWriter, r *http.Request) {
+	if cal, err := cc.cs.GetInviteeCalendar(r.Context(), c.URLParams["id"]); err != nil {
+		writeError(r, w, utils.GetCodeForError(err), err)
+	} else {
+		writeCalendar(w, "invite.ics", cal)
+	}
+}
+
+func writeCalendar(w http.ResponseWriter, filename string, cal []byte) {
+	w.Header().Set("Content-Type", calendar.ContentType)
+	w.Header().Set("Content-Disposition", `inline; filename="`+filename+`"`)
+	w.WriteHeader(200)
+	w.Write(cal)
+}
@@ -2,9 +2,15 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/middleware"
 	"github.com/grounded042/capacious/services"
+	"github.com/grounded042/capacious/utils"
 	"github.com/zenazn/goji/web"
 )
 
@@ -12,6 +18,12 @@ type List struct {
 	Events   EventsController
 	Invitees InviteesController
 	Auth     AuthController
+	APIKeys  APIKeysController
+	Emails   EmailsController
+	Webhooks WebhooksController
+	Stream   StreamController
+	Calendar CalendarController
+	Health   HealthController
 }
 
 func NewControllersList(coord services.Coordinator) List {
@@ -19,6 +31,12 @@ func NewControllersList(coord services.Coordinator) List {
 		Events:   NewEventsController(coord),
 		Invitees: NewInviteesController(coord),
 		Auth:     NewAuthController(coord),
+		APIKeys:  NewAPIKeysController(coord),
+		Emails:   NewEmailsController(coord),
+		Webhooks: NewWebhooksController(coord),
+		Stream:   NewStreamController(coord),
+		Calendar: NewCalendarController(coord),
+		Health:   NewHealthController(coord),
 	}
 }
 
@@ -37,3 +55,52 @@ func checkForAndHandleUserIDInContext(c web.C, w http.ResponseWriter, message st
 
 	return userID, true
 }
+
+// retryAfterer is an error that says how long until the request can be tried
+// again.
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// readErrorCode gets the status code to send when reading the request body
+// failed with err. Bodies over the route's size limit get a 413, anything else
+// gets fallback.
+func readErrorCode(err error, fallback int) int {
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return fallback
+}
+
+// writeError writes the status code to w and logs err. If err says when the
+// request can be tried again, that is sent in the Retry-After header.
+func writeError(r *http.Request, w http.ResponseWriter, code int, err error) {
+	if ra, ok := err.(retryAfterer); ok && ra.RetryAfter() > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(middleware.RetryAfterSeconds(ra.RetryAfter())))
+	}
+
+	w.WriteHeader(code)
+	logError(r, code, err)
+}
+
+// logError logs err with the request's logger. Controllers are the boundary
+// errors are logged at, so nothing below them logs an error it returns. Errors
+// with a 5xx code are unexpected and logged as errors along with where they
+// happened; anything else is the client's problem and only logged at debug
+// level.
+func logError(r *http.Request, code int, err error) {
+	attrs := []interface{}{"status", code, "error", err.Error()}
+
+	if e, ok := err.(utils.Error); ok {
+		attrs = append(attrs, "code", e.Code(), "location", e.Location())
+	}
+
+	if code >= 500 {
+		logging.FromContext(r.Context()).Error("unexpected error", attrs...)
+	} else {
+		logging.FromContext(r.Context()).Debug("request error", attrs...)
+	}
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/services"
+	"github.com/grounded042/capacious/utils"
+	"github.com/zenazn/goji/web"
+)
+
+type EmailsStub interface {
+	GetEmailTemplates(ctx context.Context, eventID string, userID string) ([]services.EmailTemplate, utils.Error)
+	SaveEmailTemplate(ctx context.Context, eventID string, userID string, template entities.EmailTemplate) (services.EmailTemplate, utils.Error)
+	ResetEmailTemplate(ctx context.Context, eventID string, kind string, userID string) utils.Error
+	SendEmails(ctx context.Context, eventID string, userID string, kind string, inviteeID string, filter entities.InviteeFilter) ([]entities.EmailDelivery, utils.Error)
+	GetEmailDeliveries(ctx context.Context, eventID string, userID string, filter entities.EmailDeliveryFilter, p *services.PaginationService) ([]entities.EmailDelivery, utils.Error)
+}
+
+type jsonEmailTemplateObj struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type jsonSendEmailsObj struct {
+	Kind      string `json:"kind"`
+	InviteeID string `json:"invitee_id"`
+}
+
+type EmailsController struct {
+	ems EmailsStub
+}
+
+func NewEmailsController(newEMS EmailsStub) EmailsController {
+	return EmailsController{
+		ems: newEMS,
+	}
+}
+
+// GetEmailTemplates lists the email templates of an event, one for each kind
+// of email.
+func (emc EmailsController) GetEmailTemplates(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get the emails for an event!")
+
+	if !ok {
+		return
+	}
+
+	if templates, err := emc.ems.GetEmailTemplates(r.Context(), c.URLParams["id"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(templates)
+	}
+}
+
+// SaveEmailTemplate replaces the email template of one kind for an event.
+func (emc EmailsController) SaveEmailTemplate(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to change the emails for an event!")
+
+	if !ok {
+		return
+	}
+
+	var body jsonEmailTemplateObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&body); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	template := entities.EmailTemplate{Kind: c.URLParams["kind"], Subject: body.Subject, Body: body.Body}
+
+	if saved, err := emc.ems.SaveEmailTemplate(r.Context(), c.URLParams["id"], userID, template); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(saved)
+	}
+}
+
+// ResetEmailTemplate goes back to the default email template of one kind for
+// an event.
+func (emc EmailsController) ResetEmailTemplate(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to change the emails for an event!")
+
+	if !ok {
+		return
+	}
+
+	if err := emc.ems.ResetEmailTemplate(r.Context(), c.URLParams["id"], c.URLParams["kind"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(204)
+	}
+}
+
+// SendEmails sends an email of one kind to the invitee_id in the body, or to
+// every invitee of the event matching the same filters as the invitee list.
+// The emails are sent in the background; the response lists their
+// deliveries.
+func (emc EmailsController) SendEmails(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to send emails for an event!")
+
+	if !ok {
+		return
+	}
+
+	filter, fErr := readInviteeFilter(r.URL.Query())
+
+	if fErr != nil {
+		writeError(r, w, 400, fErr)
+		return
+	}
+
+	var body jsonSendEmailsObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&body); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if deliveries, err := emc.ems.SendEmails(r.Context(), c.URLParams["id"], userID, body.Kind, body.InviteeID, filter); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// GetEmailDeliveries lists the emails sent for an event, newest first. They
+// can be narrowed down with filter[invitee], filter[kind] and filter[status].
+func (emc EmailsController) GetEmailDeliveries(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get the emails for an event!")
+
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+
+	filter := entities.EmailDeliveryFilter{
+		InviteeID: q.Get("filter[invitee]"),
+		Kind:      q.Get("filter[kind]"),
+		Status:    q.Get("filter[status]"),
+	}
+
+	p := services.NewPaginationService()
+	p.SetMaxPageSize(100)
+
+	if pErr := readPagination(q, &p); pErr != nil {
+		writeError(r, w, 400, pErr)
+		return
+	}
+
+	deliveries, err := emc.ems.GetEmailDeliveries(r.Context(), c.URLParams["id"], userID, filter, &p)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	setLinkHeader(w, pageLinks(r, &p))
+
+	toSend := DataWithPagination{
+		Data:       deliveries,
+		Pagination: paginationInfo(&p),
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(toSend)
+}
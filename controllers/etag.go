@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errIfMatchRequired = errors.New("an If-Match header with the resource's ETag is required")
+	errIfMatchFailed   = errors.New("the If-Match header does not match any version of the resource")
+)
+
+// etag gets the ETag of version of a resource.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag sends version as the ETag of the response.
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// notModified sends a 304 if the request's If-None-Match has the ETag of
+// version, and says whether it did. Weak ETags match too.
+func notModified(r *http.Request, w http.ResponseWriter, version int) bool {
+	header := r.Header.Get("If-None-Match")
+
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+
+		if tag == "*" || tag == etag(version) {
+			setETag(w, version)
+			w.WriteHeader(304)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatch gets the version the request's If-Match says the resource has to be
+// at. 0 means any version, for * or when the header is missing and not
+// required. It sends a 428 if the header is required but missing, or a 412 if
+// it can't match a version, and then ok is false.
+func ifMatch(r *http.Request, w http.ResponseWriter, required bool) (version int, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if header == "" {
+		if required {
+			writeError(r, w, 428, errIfMatchRequired)
+			return 0, false
+		}
+
+		return 0, true
+	}
+
+	if header == "*" {
+		return 0, true
+	}
+
+	// only strong ETags can match, and we only keep one version of a resource
+	if strings.HasPrefix(header, `"`) && strings.HasSuffix(header, `"`) && len(header) > 2 {
+		version, err := strconv.Atoi(header[1 : len(header)-1])
+
+		if err == nil && version > 0 {
+			return version, true
+		}
+	}
+
+	writeError(r, w, 412, errIfMatchFailed)
+	return 0, false
+}
@@ -1,9 +1,8 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/grounded042/capacious/entities"
@@ -13,12 +12,18 @@ import (
 )
 
 type EventsStub interface {
-	GetEvents(string) ([]entities.Event, utils.Error)
-	GetEventInfo(eventId string) (entities.Event, utils.Error)
-	GetEventStats(eventID string, userID string) (services.EventStats, utils.Error)
-	CreateEvent(*entities.Event, string) utils.Error
-	GetMenuItemsForEvent(eventID string) ([]entities.MenuItem, utils.Error)
-	GetListOfSeatingRequestChoices(eventID string) ([]entities.SeatingRequestChoice, utils.Error)
+	GetEvents(context.Context, string, *services.PaginationService) ([]entities.Event, utils.Error)
+	GetEventInfo(ctx context.Context, eventId string) (entities.Event, utils.Error)
+	GetEventStats(ctx context.Context, eventID string, userID string) (services.EventStats, utils.Error)
+	CreateEvent(context.Context, *entities.Event, string) utils.Error
+	GetMenuItemsForEvent(ctx context.Context, eventID string) ([]entities.MenuItem, utils.Error)
+	GetListOfSeatingRequestChoices(ctx context.Context, eventID string) ([]entities.SeatingRequestChoice, utils.Error)
+	SetEventRequireTwoFactor(ctx context.Context, eventID string, userID string, required bool, version int) (int, utils.Error)
+	GetAuditLog(ctx context.Context, eventID string, userID string, filter entities.AuditFilter, p *services.PaginationService) ([]entities.AuditEntry, utils.Error)
+}
+
+type jsonRequireTwoFactorObj struct {
+	Required bool `json:"required"`
 }
 
 type EventsController struct {
@@ -38,9 +43,38 @@ func (ec EventsController) GetEvents(c web.C, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if events, err := ec.es.GetEvents(userID); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+	q, ok := documentQuery(r, w)
+
+	if !ok {
+		return
+	}
+
+	// the list stays a plain array, so a page is large enough to hold every
+	// event for most admins
+	p := services.NewPaginationService()
+	p.SetMaxPageSize(100)
+	p.SetPageSize(100)
+
+	if pErr := readPagination(r.URL.Query(), &p); pErr != nil {
+		writeError(r, w, 400, pErr)
+		return
+	}
+
+	events, err := ec.es.GetEvents(r.Context(), userID, &p)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	links := pageLinks(r, &p)
+	setLinkHeader(w, links)
+
+	if q != nil {
+		doc := q.Many(eventResources(events))
+		doc.Links = links
+		doc.Meta = map[string]interface{}{"pagination": paginationInfo(&p)}
+		writeDocument(w, 200, doc)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(events)
@@ -50,13 +84,17 @@ func (ec EventsController) GetEvents(c web.C, w http.ResponseWriter, r *http.Req
 // GetEventInfo gets the info for a specific event. This function does not
 // require auth as it is used to get event info for responses to invitations
 func (ec EventsController) GetEventInfo(c web.C, w http.ResponseWriter, r *http.Request) {
+	q, ok := documentQuery(r, w)
 
-	if event, err := ec.es.GetEventInfo(c.URLParams["id"]); err != nil {
-		w.WriteHeader(utils.GetCodeForError(err))
-		fmt.Println(err)
-	} else {
-		w.WriteHeader(200)
-		json.NewEncoder(w).Encode(event)
+	if !ok {
+		return
+	}
+
+	if event, err := ec.es.GetEventInfo(r.Context(), c.URLParams["id"]); err != nil {
+		writeError(r, w, utils.GetCodeForError(err), err)
+	} else if !notModified(r, w, event.Version) {
+		setETag(w, event.Version)
+		writeResource(w, q, 200, event, eventResource(event))
 	}
 }
 
@@ -68,9 +106,8 @@ func (ec EventsController) GetEventStats(c web.C, w http.ResponseWriter, r *http
 		return
 	}
 
-	if stats, err := ec.es.GetEventStats(c.URLParams["id"], userID); err != nil {
-		w.WriteHeader(utils.GetCodeForError(err))
-		fmt.Println(err)
+	if stats, err := ec.es.GetEventStats(r.Context(), c.URLParams["id"], userID); err != nil {
+		writeError(r, w, utils.GetCodeForError(err), err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(stats)
@@ -84,38 +121,38 @@ func (ec EventsController) CreateEvent(c web.C, w http.ResponseWriter, r *http.R
 		return
 	}
 
+	q, ok := documentQuery(r, w)
+
+	if !ok {
+		return
+	}
+
 	// decode the body into an event
 	var event entities.Event
 
-	rBody, ioErr := ioutil.ReadAll(r.Body)
+	rBody, ok := readBody(r, w, eventsType, "")
 
-	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+	if !ok {
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &event); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
 	// create the event
-	if err := ec.es.CreateEvent(&event, userID); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+	if err := ec.es.CreateEvent(r.Context(), &event, userID); err != nil {
+		writeError(r, w, err.Code(), err)
 	} else {
-		w.WriteHeader(201)
-		json.NewEncoder(w).Encode(event)
+		writeResource(w, q, 201, event, eventResource(event))
 	}
 }
 
 // GetMenuItemsForEvent renders the menu items for an event using w.
 func (ec EventsController) GetMenuItemsForEvent(c web.C, w http.ResponseWriter, r *http.Request) {
-	if items, err := ec.es.GetMenuItemsForEvent(c.URLParams["id"]); err != nil {
-		w.WriteHeader(utils.GetCodeForError(err))
-		fmt.Println(err)
+	if items, err := ec.es.GetMenuItemsForEvent(r.Context(), c.URLParams["id"]); err != nil {
+		writeError(r, w, utils.GetCodeForError(err), err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(items)
@@ -123,11 +160,42 @@ func (ec EventsController) GetMenuItemsForEvent(c web.C, w http.ResponseWriter,
 }
 
 func (ec EventsController) GetListOfSeatingRequestChoices(c web.C, w http.ResponseWriter, r *http.Request) {
-	if choices, err := ec.es.GetListOfSeatingRequestChoices(c.URLParams["id"]); err != nil {
-		w.WriteHeader(utils.GetCodeForError(err))
-		fmt.Println(err)
+	if choices, err := ec.es.GetListOfSeatingRequestChoices(r.Context(), c.URLParams["id"]); err != nil {
+		writeError(r, w, utils.GetCodeForError(err), err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(choices)
 	}
 }
+
+// SetRequireTwoFactor sets whether the admins of the event need two factor
+// authentication enabled to manage it. Only the event's owner can do this.
+func (ec EventsController) SetRequireTwoFactor(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to change the event's settings!")
+
+	if !ok {
+		return
+	}
+
+	// the event's ETag can be sent in If-Match but doesn't have to be
+	version, ok := ifMatch(r, w, false)
+
+	if !ok {
+		return
+	}
+
+	var body jsonRequireTwoFactorObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&body); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if version, err := ec.es.SetEventRequireTwoFactor(r.Context(), c.URLParams["id"], userID, body.Required, version); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		setETag(w, version)
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grounded042/capacious/services"
+	"github.com/zenazn/goji/web"
+)
+
+type HealthStub interface {
+	GetReadiness(ctx context.Context) services.Readiness
+	GetVersion(ctx context.Context) services.VersionInfo
+}
+
+type HealthController struct {
+	hs HealthStub
+}
+
+func NewHealthController(newHs HealthStub) HealthController {
+	return HealthController{
+		hs: newHs,
+	}
+}
+
+// Healthz reports that the process is up. It does not check any dependencies
+// so it can be used as a liveness check.
+func (hc HealthController) Healthz(c web.C, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the process is ready to serve requests. Every check
+// is reported separately and a 503 is sent if any of them failed.
+func (hc HealthController) Readyz(c web.C, w http.ResponseWriter, r *http.Request) {
+	readiness := hc.hs.GetReadiness(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if readiness.Ready {
+		w.WriteHeader(200)
+	} else {
+		w.WriteHeader(503)
+	}
+
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// Version reports the build commit and schema version.
+func (hc HealthController) Version(c web.C, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(hc.hs.GetVersion(r.Context()))
+}
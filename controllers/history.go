@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/zenazn/goji/web"
+)
+
+type restoreInviteeBody struct {
+	AuditEntryID string `json:"audit_entry_id"`
+}
+
+// GetInviteeHistory lists the steps of an invitee's RSVP, oldest first.
+func (ic InviteesController) GetInviteeHistory(c web.C, w http.ResponseWriter, r *http.Request) {
+	history, err := ic.is.GetInviteeHistory(r.Context(), c.URLParams["invitee_id"])
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(history)
+}
+
+// RestoreInvitee puts an invitee back the way it was after one of the steps
+// in its history, given by audit_entry_id.
+func (ic InviteesController) RestoreInvitee(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to restore an invitee!")
+
+	if !ok {
+		return
+	}
+
+	q, ok := documentQuery(r, w, inviteeIncludes...)
+
+	if !ok {
+		return
+	}
+
+	var body restoreInviteeBody
+
+	rBody, ioErr := ioutil.ReadAll(r.Body)
+
+	if ioErr != nil {
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
+		return
+	}
+
+	if err := json.Unmarshal(rBody, &body); err != nil {
+		writeError(r, w, 400, err)
+		return
+	}
+
+	if body.AuditEntryID == "" {
+		writeError(r, w, 400, errors.New("audit_entry_id is required"))
+		return
+	}
+
+	invitee, err := ic.is.RestoreInvitee(r.Context(), c.URLParams["invitee_id"], body.AuditEntryID, userID)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	writeResource(w, q, 200, invitee, inviteeResource(invitee))
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/zenazn/goji/web"
+)
+
+// the RSVP statuses invitees can be filtered by. no_response is what pending
+// was called before there were statuses.
+var inviteeRSVPs = map[string]string{
+	entities.RSVPPending:   entities.RSVPPending,
+	entities.RSVPAttending: entities.RSVPAttending,
+	entities.RSVPDeclined:  entities.RSVPDeclined,
+	entities.RSVPMaybe:     entities.RSVPMaybe,
+	"no_response":          entities.RSVPPending,
+}
+
+var inviteeSortFields = map[string]bool{
+	entities.InviteeSortName:        true,
+	entities.InviteeSortEmail:       true,
+	entities.InviteeSortRespondedAt: true,
+	entities.InviteeSortUpdatedAt:   true,
+}
+
+// readInviteeFilter reads how to narrow down and order a list of invitees
+// from q:
+//
+//	filter[rsvp]             pending, attending, declined or maybe
+//	filter[has_menu_choices] true or false
+//	filter[has_menu_note]    true or false
+//	filter[friends]          exactly this many friends
+//	filter[min_friends]      at least this many friends
+//	filter[max_friends]      at most this many friends
+//	filter[tag]              any of these comma separated tags
+//	filter[search]           words in names or emails
+//	sort                     comma separated name, email, responded_at or
+//	                         updated_at, each descending with a leading -
+func readInviteeFilter(q url.Values) (entities.InviteeFilter, error) {
+	var filter entities.InviteeFilter
+	var err error
+
+	if rsvp := q.Get("filter[rsvp]"); rsvp != "" {
+		if filter.RSVP = inviteeRSVPs[rsvp]; filter.RSVP == "" {
+			return filter, fmt.Errorf("filter[rsvp] can't be %q", rsvp)
+		}
+	}
+
+	if filter.HasMenuChoices, err = boolParam(q, "filter[has_menu_choices]"); err != nil {
+		return filter, err
+	}
+
+	if filter.HasMenuNote, err = boolParam(q, "filter[has_menu_note]"); err != nil {
+		return filter, err
+	}
+
+	if filter.Friends, err = countParam(q, "filter[friends]"); err != nil {
+		return filter, err
+	}
+
+	if filter.MinFriends, err = countParam(q, "filter[min_friends]"); err != nil {
+		return filter, err
+	}
+
+	if filter.MaxFriends, err = countParam(q, "filter[max_friends]"); err != nil {
+		return filter, err
+	}
+
+	if tags := q.Get("filter[tag]"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+				filter.Tags = append(filter.Tags, tag)
+			}
+		}
+	}
+
+	filter.Search = q.Get("filter[search]")
+
+	if sort := q.Get("sort"); sort != "" {
+		for _, field := range strings.Split(sort, ",") {
+			s := entities.InviteeSort{Field: strings.TrimPrefix(field, "-"), Descending: strings.HasPrefix(field, "-")}
+
+			if !inviteeSortFields[s.Field] {
+				return filter, fmt.Errorf("invitees can't be sorted by %q", s.Field)
+			}
+
+			filter.Sort = append(filter.Sort, s)
+		}
+	}
+
+	return filter, nil
+}
+
+// boolParam reads the boolean parameter name from q. It is nil if it isn't
+// there.
+func boolParam(q url.Values, name string) (*bool, error) {
+	value := q.Get(name)
+
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s has to be true or false", name)
+	}
+
+	return &b, nil
+}
+
+// countParam reads the parameter name, a count, from q. It is nil if it isn't
+// there.
+func countParam(q url.Values, name string) (*int, error) {
+	value := q.Get(name)
+
+	if value == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%s has to be a whole number", name)
+	}
+
+	return &n, nil
+}
+
+// SetInviteeTags replaces the tags of an invitee with the list of tags in the
+// body. Only the event's admins can do this.
+func (ic InviteesController) SetInviteeTags(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to tag an invitee!")
+
+	if !ok {
+		return
+	}
+
+	var tags []string
+
+	rBody, ioErr := ioutil.ReadAll(r.Body)
+
+	if ioErr != nil {
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
+		return
+	}
+
+	if err := json.Unmarshal(rBody, &tags); err != nil {
+		writeError(r, w, 400, err)
+		return
+	}
+
+	tags, err := ic.is.SetInviteeTags(r.Context(), c.URLParams["invitee_id"], tags, userID)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(tags)
+}
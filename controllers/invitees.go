@@ -1,30 +1,33 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 
 	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/patch"
 	"github.com/grounded042/capacious/services"
 	"github.com/grounded042/capacious/utils"
 	"github.com/zenazn/goji/web"
 )
 
 type InviteeStub interface {
-	GetInviteesForEvent(string, string, *services.PaginationService) ([]entities.Invitee, utils.Error)
-	CreateInviteeForEvent(*entities.Invitee, entities.Event) utils.Error
-	GetInviteeFromID(string) (entities.Invitee, utils.Error)
-	EditInvitee(entities.Invitee) utils.Error
-	EditInviteeFriend(entities.InviteeFriend) utils.Error
-	CreateInviteeFriend(*entities.InviteeFriend) utils.Error
-	SetInviteeMenuChoices(string, []entities.MenuChoice) ([]entities.MenuChoice, utils.Error)
-	SetInviteeFriendMenuChoices(string, []entities.MenuChoice) ([]entities.MenuChoice, utils.Error)
-	SetInviteeMenuNote(string, entities.MenuNote) (entities.MenuNote, utils.Error)
-	SetInviteeFriendMenuNote(string, entities.MenuNote) (entities.MenuNote, utils.Error)
-	SetInviteeSeatingRequests(string, []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, utils.Error)
+	GetInviteesForEvent(context.Context, string, string, entities.InviteeFilter, *services.PaginationService) ([]entities.Invitee, utils.Error)
+	CreateInviteeForEvent(context.Context, *entities.Invitee, entities.Event, string) utils.Error
+	GetInviteeFromID(context.Context, string) (entities.Invitee, utils.Error)
+	PatchInvitee(context.Context, string, patch.Patch, int) (entities.Invitee, utils.Error)
+	PatchInviteeFriend(context.Context, string, string, patch.Patch, int) (entities.InviteeFriend, int, utils.Error)
+	CreateInviteeFriend(context.Context, *entities.InviteeFriend) utils.Error
+	SetInviteeMenuChoices(context.Context, string, []entities.MenuChoice) ([]entities.MenuChoice, utils.Error)
+	SetInviteeFriendMenuChoices(context.Context, string, []entities.MenuChoice) ([]entities.MenuChoice, utils.Error)
+	SetInviteeMenuNote(context.Context, string, entities.MenuNote) (entities.MenuNote, utils.Error)
+	SetInviteeFriendMenuNote(context.Context, string, entities.MenuNote) (entities.MenuNote, utils.Error)
+	SetInviteeSeatingRequests(context.Context, string, []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, utils.Error)
+	GetInviteeHistory(context.Context, string) ([]services.HistoryItem, utils.Error)
+	RestoreInvitee(context.Context, string, string, string) (entities.Invitee, utils.Error)
+	SetInviteeTags(context.Context, string, []string, string) ([]string, utils.Error)
 }
 
 type InviteesController struct {
@@ -36,11 +39,16 @@ type DataWithPagination struct {
 	Pagination PaginationInfo `json:"pagination"`
 }
 
+// PaginationInfo tells where a page is in a list. CurrentPage is left out
+// for pages got from a cursor, and the cursors are for the pages before and
+// after this one, if there are any.
 type PaginationInfo struct {
-	TotalItems  int `json:"total_items"`
-	TotalPages  int `json:"total_pages"`
-	PageSize    int `json:"page_size"`
-	CurrentPage int `json:"current_page"`
+	TotalItems  int    `json:"total_items"`
+	TotalPages  int    `json:"total_pages"`
+	PageSize    int    `json:"page_size"`
+	CurrentPage int    `json:"current_page,omitempty"`
+	PrevCursor  string `json:"prev_cursor,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
 }
 
 func NewInviteesController(newIs InviteeStub) InviteesController {
@@ -49,6 +57,9 @@ func NewInviteesController(newIs InviteeStub) InviteesController {
 	}
 }
 
+// GetInviteesForEvent lists a page of the invitees of an event. They can be
+// narrowed down with the filter[...] parameters read by readInviteeFilter and
+// ordered with sort.
 func (ec InviteesController) GetInviteesForEvent(c web.C, w http.ResponseWriter, r *http.Request) {
 	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get a list of invitees for an event!")
 
@@ -56,29 +67,45 @@ func (ec InviteesController) GetInviteesForEvent(c web.C, w http.ResponseWriter,
 		return
 	}
 
-	pagenumber, cErr := strconv.Atoi(r.URL.Query().Get("page[number]"))
-	utils.CheckErr(cErr, "Error parsing parameter 'page[number]' to int")
+	q, ok := documentQuery(r, w, inviteeIncludes...)
 
-	pagesize, cErr := strconv.Atoi(r.URL.Query().Get("page[size]"))
-	utils.CheckErr(cErr, "Error parsing parameter 'page[size]' to int")
+	if !ok {
+		return
+	}
+
+	filter, fErr := readInviteeFilter(r.URL.Query())
+
+	if fErr != nil {
+		writeError(r, w, 400, fErr)
+		return
+	}
 
 	p := services.NewPaginationService()
-	p.SetPageSize(pagesize)
-	p.SetPageNumber(pagenumber)
 
-	invitees, err := ec.is.GetInviteesForEvent(c.URLParams["id"], userID, &p)
+	if pErr := readPagination(r.URL.Query(), &p); pErr != nil {
+		writeError(r, w, 400, pErr)
+		return
+	}
+
+	invitees, err := ec.is.GetInviteesForEvent(r.Context(), c.URLParams["id"], userID, filter, &p)
 
 	if err != nil {
+		logError(r, err.Code(), err)
 		w.WriteHeader(err.Code())
 		json.NewEncoder(w).Encode(err.Error())
 		return
 	}
 
-	pInfo := PaginationInfo{
-		TotalItems:  p.GetNumItems(),
-		TotalPages:  p.GetLast(),
-		PageSize:    p.GetSize(),
-		CurrentPage: p.GetCurrent(),
+	pInfo := paginationInfo(&p)
+	links := pageLinks(r, &p)
+	setLinkHeader(w, links)
+
+	if q != nil {
+		doc := q.Many(inviteeResources(invitees))
+		doc.Links = links
+		doc.Meta = map[string]interface{}{"pagination": pInfo}
+		writeDocument(w, 200, doc)
+		return
 	}
 
 	toSend := DataWithPagination{
@@ -91,125 +118,145 @@ func (ec InviteesController) GetInviteesForEvent(c web.C, w http.ResponseWriter,
 }
 
 func (ec InviteesController) CreateInviteeForEvent(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to add an invitee to an event!")
+
+	if !ok {
+		return
+	}
+
+	q, ok := documentQuery(r, w, inviteeIncludes...)
+
+	if !ok {
+		return
+	}
+
 	var invitee entities.Invitee
 
 	event := entities.Event{EventID: c.URLParams["id"]}
 
-	rBody, ioErr := ioutil.ReadAll(r.Body)
+	rBody, ok := readBody(r, w, inviteesType, "")
 
-	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+	if !ok {
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &invitee); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 400, err)
 		return
 	}
 
-	if err := ec.is.CreateInviteeForEvent(&invitee, event); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+	if err := ec.is.CreateInviteeForEvent(r.Context(), &invitee, event, userID); err != nil {
+		writeError(r, w, err.Code(), err)
 	} else {
-		w.WriteHeader(200)
-		json.NewEncoder(w).Encode(invitee)
+		writeResource(w, q, 200, invitee, inviteeResource(invitee))
 	}
 }
 
 func (ic InviteesController) GetInvitee(c web.C, w http.ResponseWriter, r *http.Request) {
-	invitee, err := ic.is.GetInviteeFromID(c.URLParams["id"])
+	q, ok := documentQuery(r, w, inviteeIncludes...)
+
+	if !ok {
+		return
+	}
+
+	invitee, err := ic.is.GetInviteeFromID(r.Context(), c.URLParams["id"])
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
-	} else {
-		w.WriteHeader(200)
-		json.NewEncoder(w).Encode(invitee)
+		writeError(r, w, 500, err)
+	} else if !notModified(r, w, invitee.Version) {
+		setETag(w, invitee.Version)
+		writeResource(w, q, 200, invitee, inviteeResource(invitee))
 	}
 }
 
+// EditInvitee patches an invitee with a JSON Merge Patch or a JSON Patch. The
+// request needs the invitee's ETag in If-Match so changes made since it was
+// fetched aren't overwritten.
 func (ic InviteesController) EditInvitee(c web.C, w http.ResponseWriter, r *http.Request) {
-	invitee := entities.Invitee{InviteeID: c.URLParams["id"]}
+	version, ok := ifMatch(r, w, true)
 
-	rBody, ioErr := ioutil.ReadAll(r.Body)
+	if !ok {
+		return
+	}
 
-	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+	q, ok := documentQuery(r, w, inviteeIncludes...)
+
+	if !ok {
 		return
 	}
 
-	if err := json.Unmarshal(rBody, &invitee); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+	p, ok := readPatch(r, w, inviteesType, c.URLParams["id"])
+
+	if !ok {
 		return
 	}
 
-	err := ic.is.EditInvitee(invitee)
+	invitee, err := ic.is.PatchInvitee(r.Context(), c.URLParams["id"], p, version)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, err.Code(), err)
 	} else {
-		w.WriteHeader(200)
-		json.NewEncoder(w).Encode(invitee)
+		setETag(w, invitee.Version)
+		writeResource(w, q, 200, invitee, inviteeResource(invitee))
 	}
 }
 
+// EditInviteeFriend patches an invitee friend with a JSON Merge Patch or a
+// JSON Patch. The request needs the ETag of the friend's invitee in If-Match
+// so changes made since it was fetched aren't overwritten.
 func (ic InviteesController) EditInviteeFriend(c web.C, w http.ResponseWriter, r *http.Request) {
-	iGuest := entities.InviteeFriend{InviteeFriendID: c.URLParams["friend_id"], FkInviteeID: c.URLParams["invitee_id"]}
+	version, ok := ifMatch(r, w, true)
 
-	rBody, ioErr := ioutil.ReadAll(r.Body)
+	if !ok {
+		return
+	}
 
-	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+	q, ok := documentQuery(r, w, inviteeFriendIncludes...)
+
+	if !ok {
 		return
 	}
 
-	if err := json.Unmarshal(rBody, &iGuest); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+	p, ok := readPatch(r, w, inviteeFriendsType, c.URLParams["friend_id"])
+
+	if !ok {
 		return
 	}
 
-	err := ic.is.EditInviteeFriend(iGuest)
+	friend, version, err := ic.is.PatchInviteeFriend(r.Context(), c.URLParams["invitee_id"], c.URLParams["friend_id"], p, version)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, err.Code(), err)
 	} else {
-		w.WriteHeader(200)
-		json.NewEncoder(w).Encode(iGuest)
+		setETag(w, version)
+		writeResource(w, q, 200, friend, inviteeFriendResource(friend))
 	}
-
 }
 
 func (ec InviteesController) CreateInviteeFriend(c web.C, w http.ResponseWriter, r *http.Request) {
+	q, ok := documentQuery(r, w, inviteeFriendIncludes...)
+
+	if !ok {
+		return
+	}
+
 	iGuest := entities.InviteeFriend{FkInviteeID: c.URLParams["invitee_id"]}
 
-	rBody, ioErr := ioutil.ReadAll(r.Body)
+	rBody, ok := readBody(r, w, inviteeFriendsType, "")
 
-	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+	if !ok {
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &iGuest); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
-	if err := ec.is.CreateInviteeFriend(&iGuest); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+	if err := ec.is.CreateInviteeFriend(r.Context(), &iGuest); err != nil {
+		writeError(r, w, 500, err)
 	} else {
-		w.WriteHeader(201)
-		json.NewEncoder(w).Encode(iGuest)
+		writeResource(w, q, 201, iGuest, inviteeFriendResource(iGuest))
 	}
 }
 
@@ -220,22 +267,19 @@ func (ec InviteesController) SetInviteeMenuChoices(c web.C, w http.ResponseWrite
 	rBody, ioErr := ioutil.ReadAll(r.Body)
 
 	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &choices); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
-	updatedChoices, err := ec.is.SetInviteeMenuChoices(inviteeID, choices)
+	updatedChoices, err := ec.is.SetInviteeMenuChoices(r.Context(), inviteeID, choices)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(updatedChoices)
@@ -249,22 +293,19 @@ func (ec InviteesController) SetGuestMenuChoices(c web.C, w http.ResponseWriter,
 	rBody, ioErr := ioutil.ReadAll(r.Body)
 
 	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &choices); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
-	updatedChoices, err := ec.is.SetInviteeFriendMenuChoices(guestID, choices)
+	updatedChoices, err := ec.is.SetInviteeFriendMenuChoices(r.Context(), guestID, choices)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(updatedChoices)
@@ -278,22 +319,19 @@ func (ec InviteesController) SetInviteeMenuNote(c web.C, w http.ResponseWriter,
 	rBody, ioErr := ioutil.ReadAll(r.Body)
 
 	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &note); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
-	updatedNote, err := ec.is.SetInviteeMenuNote(inviteeID, note)
+	updatedNote, err := ec.is.SetInviteeMenuNote(r.Context(), inviteeID, note)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(updatedNote)
@@ -307,22 +345,19 @@ func (ec InviteesController) SetInviteeFriendMenuNote(c web.C, w http.ResponseWr
 	rBody, ioErr := ioutil.ReadAll(r.Body)
 
 	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &note); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
-	updatedNote, err := ec.is.SetInviteeFriendMenuNote(friendID, note)
+	updatedNote, err := ec.is.SetInviteeFriendMenuNote(r.Context(), friendID, note)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(updatedNote)
@@ -336,22 +371,19 @@ func (ec InviteesController) SetInviteeSeatingRequests(c web.C, w http.ResponseW
 	rBody, ioErr := ioutil.ReadAll(r.Body)
 
 	if ioErr != nil {
-		w.WriteHeader(500)
-		fmt.Println(ioErr)
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
 		return
 	}
 
 	if err := json.Unmarshal(rBody, &requests); err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 		return
 	}
 
-	updatedRequests, err := ec.is.SetInviteeSeatingRequests(inviteeID, requests)
+	updatedRequests, err := ec.is.SetInviteeSeatingRequests(r.Context(), inviteeID, requests)
 
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Println(err)
+		writeError(r, w, 500, err)
 	} else {
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(updatedRequests)
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grounded042/capacious/jsonapi"
+)
+
+// LinkPrefix goes in front of the paths in the links of JSON:API documents.
+// It must be the prefix the routes are built with.
+var LinkPrefix = "/api/v1"
+
+// documentQuery reads what a client that asked for a JSON:API document wants
+// in it. Only the relationship paths in includable can be included. It sends
+// a 400 for bad parameters, and then ok is false. q is nil for clients that
+// asked for plain JSON.
+func documentQuery(r *http.Request, w http.ResponseWriter, includable ...string) (q *jsonapi.Query, ok bool) {
+	if !jsonapi.Accepts(r) {
+		return nil, true
+	}
+
+	query, err := jsonapi.ParseQuery(r.URL.Query(), includable...)
+
+	if err != nil {
+		writeError(r, w, 400, err)
+		return nil, false
+	}
+
+	return &query, true
+}
+
+// writeResource writes v as plain JSON, or, if q is set, res as a JSON:API
+// document.
+func writeResource(w http.ResponseWriter, q *jsonapi.Query, code int, v interface{}, res *jsonapi.Resource) {
+	if q == nil {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	writeDocument(w, code, q.One(res))
+}
+
+// writeDocument writes doc with the JSON:API media type.
+func writeDocument(w http.ResponseWriter, code int, doc *jsonapi.Document) {
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// readBody reads the request body. If it is a JSON:API document, the
+// attributes of its resource are read in place of the plain JSON body; the
+// resource has to be of type typ and, if it has an id, have the id id. A bad
+// document gets a 400, and a resource of the wrong type or id a 409, and then
+// ok is false.
+func readBody(r *http.Request, w http.ResponseWriter, typ string, id string) (body []byte, ok bool) {
+	body, ioErr := ioutil.ReadAll(r.Body)
+
+	if ioErr != nil {
+		writeError(r, w, readErrorCode(ioErr, 500), ioErr)
+		return nil, false
+	}
+
+	if !jsonapi.IsDocument(r) {
+		return body, true
+	}
+
+	attributes, err := jsonapi.ReadResource(body, typ, id)
+
+	if err == jsonapi.ErrTypeMismatch || err == jsonapi.ErrIDMismatch {
+		writeError(r, w, 409, err)
+		return nil, false
+	} else if err != nil {
+		writeError(r, w, 400, err)
+		return nil, false
+	}
+
+	return attributes, true
+}
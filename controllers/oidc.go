@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zenazn/goji/web"
+)
+
+// jsonOIDCCallbackObj is what the admin UI sends back after the provider
+// redirects to it.
+type jsonOIDCCallbackObj struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// BeginOIDCLogin starts a login with the OIDC provider. The response holds the
+// URL to send the user to.
+func (ac AuthController) BeginOIDCLogin(c web.C, w http.ResponseWriter, r *http.Request) {
+	if authorization, err := ac.as.BeginOIDCLogin(r.Context()); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(authorization)
+	}
+}
+
+// CompleteOIDCLogin finishes a login with the OIDC provider using the code and
+// state the provider redirected back with. The response is the same as for a
+// password login.
+func (ac AuthController) CompleteOIDCLogin(c web.C, w http.ResponseWriter, r *http.Request) {
+	var callback jsonOIDCCallbackObj
+
+	if dErr := json.NewDecoder(r.Body).Decode(&callback); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	result, err := ac.as.CompleteOIDCLogin(r.Context(), callback.Code, callback.State)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	writeLoginResult(r, w, result)
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grounded042/capacious/jsonapi"
+	"github.com/grounded042/capacious/services"
+)
+
+// the order pages are linked in the Link header
+var pageRelations = []string{"first", "prev", "next", "last"}
+
+// readPagination sets p to the page v asks for: page[size] items from
+// page[number], or after page[after] or before page[before]. An empty
+// page[before] asks for the last page. Bad or missing numbers leave p's
+// defaults alone, so a list keeps the page size it was given when the client
+// doesn't ask for one.
+func readPagination(v url.Values, p *services.PaginationService) error {
+	if pagesize, err := strconv.Atoi(v.Get("page[size]")); err == nil {
+		p.SetPageSize(pagesize)
+	}
+
+	if pagenumber, err := strconv.Atoi(v.Get("page[number]")); err == nil {
+		p.SetPageNumber(pagenumber)
+	}
+
+	after, hasAfter := v["page[after]"]
+	before, hasBefore := v["page[before]"]
+
+	switch {
+	case hasAfter && hasBefore:
+		return errors.New("only one of page[after] and page[before] can be given")
+	case (hasAfter || hasBefore) && v.Get("page[number]") != "":
+		return errors.New("page[number] can't be given with a page cursor")
+	case hasAfter:
+		p.SetAfter(after[0])
+	case hasBefore:
+		p.SetBefore(before[0])
+	}
+
+	return nil
+}
+
+// paginationInfo gets where the page p got is in its list.
+func paginationInfo(p *services.PaginationService) PaginationInfo {
+	info := PaginationInfo{
+		TotalItems: p.GetNumItems(),
+		TotalPages: p.GetLast(),
+		PageSize:   p.GetSize(),
+	}
+
+	if !p.IsKeyset() {
+		info.CurrentPage = p.GetCurrent()
+	}
+
+	bounds := p.GetBounds()
+
+	if bounds.HasPrev {
+		info.PrevCursor = bounds.First
+	}
+
+	if bounds.HasNext {
+		info.NextCursor = bounds.Last
+	}
+
+	return info
+}
+
+// pageLinks gets the links to the other pages of the list r asked for. They
+// are numbered or cursor pages the same as the page p got.
+func pageLinks(r *http.Request, p *services.PaginationService) jsonapi.Links {
+	u, size, bounds := r.URL, p.GetSize(), p.GetBounds()
+
+	if !p.IsKeyset() {
+		links := jsonapi.Links{
+			"self":  jsonapi.PageLink(u, p.GetCurrent(), size),
+			"first": jsonapi.PageLink(u, 1, size),
+			"last":  jsonapi.PageLink(u, p.GetLast(), size),
+		}
+
+		if p.GetCurrent() > 1 {
+			links["prev"] = jsonapi.PageLink(u, p.GetPrev(), size)
+		}
+
+		if p.GetCurrent() < p.GetLast() {
+			links["next"] = jsonapi.PageLink(u, p.GetNext(), size)
+		}
+
+		return links
+	}
+
+	links := jsonapi.Links{
+		"self":  u.RequestURI(),
+		"first": jsonapi.CursorLink(u, "page[after]", "", size),
+		"last":  jsonapi.CursorLink(u, "page[before]", "", size),
+	}
+
+	if bounds.HasPrev {
+		links["prev"] = jsonapi.CursorLink(u, "page[before]", bounds.First, size)
+	}
+
+	if bounds.HasNext {
+		links["next"] = jsonapi.CursorLink(u, "page[after]", bounds.Last, size)
+	}
+
+	return links
+}
+
+// setLinkHeader sends the links to the other pages in a Link header.
+func setLinkHeader(w http.ResponseWriter, links jsonapi.Links) {
+	var header []string
+
+	for _, rel := range pageRelations {
+		if link, ok := links[rel]; ok {
+			header = append(header, "<"+link+`>; rel="`+rel+`"`)
+		}
+	}
+
+	w.Header().Set("Link", strings.Join(header, ", "))
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/grounded042/capacious/jsonapi"
+	"github.com/grounded042/capacious/patch"
+)
+
+// readPatch reads the patch in the request body, going by its Content-Type.
+// A JSON:API document is read as a merge patch of the attributes of its
+// resource, which has to be of type typ with the id id. It sends a 415 for
+// media types that aren't patches, or a 400 for a bad patch, and then ok is
+// false.
+func readPatch(r *http.Request, w http.ResponseWriter, typ string, id string) (p patch.Patch, ok bool) {
+	rBody, ok := readBody(r, w, typ, id)
+
+	if !ok {
+		return nil, false
+	}
+
+	contentType := r.Header.Get("Content-Type")
+
+	if jsonapi.IsDocument(r) {
+		contentType = patch.MergePatchType
+	}
+
+	p, err := patch.Parse(contentType, rBody)
+
+	if err == patch.ErrUnsupportedType {
+		w.Header().Set("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType+", "+jsonapi.MediaType)
+		writeError(r, w, 415, err)
+		return nil, false
+	} else if err != nil {
+		writeError(r, w, 400, err)
+		return nil, false
+	}
+
+	return p, true
+}
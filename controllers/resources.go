@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jsonapi"
+)
+
+// the JSON:API types of the resources
+const (
+	eventsType          = "events"
+	inviteesType        = "invitees"
+	inviteeFriendsType  = "invitee_friends"
+	guestsType          = "guests"
+	menuChoicesType     = "menu_choices"
+	seatingRequestsType = "seating_requests"
+)
+
+// the relationship paths that can be included with invitees and friends
+var (
+	inviteeIncludes       = []string{"self", "self.menu_choices", "friends", "friends.self", "friends.self.menu_choices", "seating_requests"}
+	inviteeFriendIncludes = []string{"self", "self.menu_choices"}
+)
+
+func eventResource(e entities.Event) *jsonapi.Resource {
+	res := jsonapi.NewResource(eventsType, e.EventID)
+	res.Attributes["name"] = e.Name
+	res.Attributes["description"] = e.Description
+	res.Attributes["location"] = e.Location
+	res.Attributes["start_time"] = e.StartTime
+	res.Attributes["end_time"] = e.EndTime
+	res.Attributes["respond_by"] = e.RespondBy
+	res.Attributes["time_zone"] = e.TimeZone
+	res.Attributes["allowed_friends"] = e.AllowedFriends
+	res.Attributes["require_two_factor"] = e.RequireTwoFactor
+	res.Attributes["rsvps_locked_at"] = e.RSVPsLockedAt
+	res.Relationships["invitees"] = jsonapi.LinksOnly(jsonapi.Links{"related": LinkPrefix + "/events/" + e.EventID + "/relationships/invitees"})
+	res.Relationships["menu_items"] = jsonapi.LinksOnly(jsonapi.Links{"related": LinkPrefix + "/events/" + e.EventID + "/relationships/menu_items"})
+	res.Links = jsonapi.Links{"self": LinkPrefix + "/events/" + e.EventID, "calendar": LinkPrefix + "/events/" + e.EventID + "/calendar.ics"}
+
+	return res
+}
+
+func eventResources(events []entities.Event) []*jsonapi.Resource {
+	res := make([]*jsonapi.Resource, len(events))
+
+	for i, e := range events {
+		res[i] = eventResource(e)
+	}
+
+	return res
+}
+
+func inviteeResource(i entities.Invitee) *jsonapi.Resource {
+	friends := make([]*jsonapi.Resource, len(i.Friends))
+
+	for n, f := range i.Friends {
+		if f.FkInviteeID == "" {
+			f.FkInviteeID = i.InviteeID
+		}
+
+		friends[n] = inviteeFriendResource(f)
+	}
+
+	requests := make([]*jsonapi.Resource, len(i.SeatingRequests))
+
+	for n, s := range i.SeatingRequests {
+		requests[n] = seatingRequestResource(s)
+	}
+
+	res := jsonapi.NewResource(inviteesType, i.InviteeID)
+	res.Attributes["email"] = i.Email
+	res.Attributes["responded_at"] = i.RespondedAt
+
+	if i.Tags != nil {
+		res.Attributes["tags"] = i.Tags
+	}
+
+	res.Relationships["self"] = jsonapi.ToOne(guestResource(i.Self))
+	res.Relationships["friends"] = jsonapi.ToMany(friends)
+	res.Relationships["seating_requests"] = jsonapi.ToMany(requests)
+	res.Relationships["history"] = jsonapi.LinksOnly(jsonapi.Links{"related": LinkPrefix + "/invitees/" + i.InviteeID + "/relationships/history"})
+	res.Links = jsonapi.Links{"self": LinkPrefix + "/invitees/" + i.InviteeID}
+
+	return res
+}
+
+func inviteeResources(invitees []entities.Invitee) []*jsonapi.Resource {
+	res := make([]*jsonapi.Resource, len(invitees))
+
+	for i, invitee := range invitees {
+		res[i] = inviteeResource(invitee)
+	}
+
+	return res
+}
+
+func inviteeFriendResource(f entities.InviteeFriend) *jsonapi.Resource {
+	res := jsonapi.NewResource(inviteeFriendsType, f.InviteeFriendID)
+	res.Relationships["self"] = jsonapi.ToOne(guestResource(f.Self))
+
+	if f.FkInviteeID != "" {
+		res.Links = jsonapi.Links{"self": LinkPrefix + "/invitees/" + f.FkInviteeID + "/relationships/friends/" + f.InviteeFriendID}
+	}
+
+	return res
+}
+
+func guestResource(g entities.Guest) *jsonapi.Resource {
+	choices := make([]*jsonapi.Resource, len(g.MenuChoices))
+
+	for i, m := range g.MenuChoices {
+		choices[i] = menuChoiceResource(m)
+	}
+
+	res := jsonapi.NewResource(guestsType, g.GuestID)
+	res.Attributes["first_name"] = g.FirstName
+	res.Attributes["last_name"] = g.LastName
+	res.Attributes["attending"] = g.Attending
+	res.Attributes["rsvp_status"] = g.RSVPStatus
+	res.Attributes["rsvp_status_at"] = g.RSVPStatusAt
+	res.Attributes["menu_note"] = g.MenuNote
+	res.Relationships["menu_choices"] = jsonapi.ToMany(choices)
+
+	return res
+}
+
+func menuChoiceResource(m entities.MenuChoice) *jsonapi.Resource {
+	res := jsonapi.NewResource(menuChoicesType, m.MenuChoiceID)
+	res.Attributes["menu_item_id"] = m.FkMenuItemID
+	res.Attributes["menu_item_option_id"] = m.FkMenuItemOptionID
+
+	return res
+}
+
+func seatingRequestResource(s entities.InviteeSeatingRequest) *jsonapi.Resource {
+	res := jsonapi.NewResource(seatingRequestsType, s.InviteeSeatingRequestID)
+	res.Attributes["invitee_request_id"] = s.FkInviteeRequestID
+	res.Attributes["first_name"] = s.FirstName
+	res.Attributes["last_name"] = s.LastName
+
+	return res
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/live"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/services"
+	"github.com/grounded042/capacious/utils"
+	"github.com/zenazn/goji/web"
+)
+
+type StreamStub interface {
+	SubscribeToEvent(ctx context.Context, eventID string, userID string) (*live.Subscription, utils.Error)
+	CanFollowInvitees(ctx context.Context) bool
+	GetChangedInvitees(ctx context.Context, eventID string, ids []string) ([]entities.Invitee, utils.Error)
+	GetEventStats(ctx context.Context, eventID string, userID string) (services.EventStats, utils.Error)
+}
+
+// how long changes are gathered for before they are sent, so a burst of them
+// is sent at once with one set of stats
+const streamCoalesce = 250 * time.Millisecond
+
+// how often a comment is sent to keep the connection from being closed by
+// proxies while nothing is changing
+const streamHeartbeat = 15 * time.Second
+
+// how long a client waits before reconnecting, in milliseconds
+const streamRetry = 3000
+
+type StreamController struct {
+	ss StreamStub
+}
+
+func NewStreamController(newSS StreamStub) StreamController {
+	return StreamController{
+		ss: newSS,
+	}
+}
+
+// inviteeRemoved is sent for an invitee that is no longer one of the event's.
+type inviteeRemoved struct {
+	InviteeID string `json:"invitee_id"`
+}
+
+// StreamEvent streams the changes to an event as Server-Sent Events. The
+// event's stats are sent first, then each changed invitee as an invitee event
+// (or invitee_removed if it was deleted) followed by the stats after the
+// change. API keys that can't read invitees are only sent the stats. If the
+// stream ends the client should connect again, which starts over with the
+// stats.
+func (sc StreamController) StreamEvent(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to follow the changes to an event!")
+
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		writeError(r, w, 500, errors.New("streaming isn't supported"))
+		return
+	}
+
+	ctx := r.Context()
+	eventID := c.URLParams["id"]
+
+	// subscribe before reading the stats so no change after them is missed
+	sub, err := sc.ss.SubscribeToEvent(ctx, eventID, userID)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	defer sub.Close()
+
+	withInvitees := sc.ss.CanFollowInvitees(ctx)
+
+	stats, err := sc.ss.GetEventStats(ctx, eventID, userID)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// don't let nginx hold on to the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	writeStreamEvent(w, "stats", stats)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case change, ok := <-sub.C:
+			if !ok {
+				// the subscription fell behind or the server is stopping;
+				// the client reconnects and starts over
+				return
+			}
+
+			changed, ok := gatherChanges(ctx, sub, change)
+
+			if !ok {
+				return
+			}
+
+			if !withInvitees {
+				changed = nil
+			}
+
+			if err := sc.sendChanges(ctx, w, eventID, userID, changed); err != nil {
+				logging.FromContext(ctx).Warn("could not send the changes to an event", "event_id", eventID, "error", err)
+				writeStreamEvent(w, "error", err.Error())
+
+				// someone who is no longer an admin stops getting changes
+				if err.Code() == 403 {
+					flusher.Flush()
+					return
+				}
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// gatherChanges gathers the ids of the invitees changed by first and the
+// changes after it for streamCoalesce. An empty id means anything may have
+// changed. It is false if the stream has to end.
+func gatherChanges(ctx context.Context, sub *live.Subscription, first live.Change) ([]string, bool) {
+	var ids []string
+	seen := map[string]bool{}
+	timer := time.NewTimer(streamCoalesce)
+	defer timer.Stop()
+
+	change := first
+
+	for {
+		if !seen[change.InviteeID] {
+			seen[change.InviteeID] = true
+			ids = append(ids, change.InviteeID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-timer.C:
+			return ids, true
+		case next, ok := <-sub.C:
+			if !ok {
+				return nil, false
+			}
+
+			change = next
+		}
+	}
+}
+
+// sendChanges sends the invitees with the ids ids and then the event's stats.
+// An empty id, for when changes may have been missed, and no ids only send
+// the stats.
+func (sc StreamController) sendChanges(ctx context.Context, w http.ResponseWriter, eventID string, userID string, ids []string) utils.Error {
+	var changed []string
+
+	for _, id := range ids {
+		if id != "" {
+			changed = append(changed, id)
+		}
+	}
+
+	if len(changed) > 0 {
+		invitees, err := sc.ss.GetChangedInvitees(ctx, eventID, changed)
+
+		if err != nil {
+			return err
+		}
+
+		found := make(map[string]bool, len(invitees))
+
+		for _, invitee := range invitees {
+			found[invitee.InviteeID] = true
+			writeStreamEvent(w, "invitee", invitee)
+		}
+
+		for _, id := range changed {
+			if !found[id] {
+				writeStreamEvent(w, "invitee_removed", inviteeRemoved{InviteeID: id})
+			}
+		}
+	}
+
+	stats, err := sc.ss.GetEventStats(ctx, eventID, userID)
+
+	if err != nil {
+		return err
+	}
+
+	writeStreamEvent(w, "stats", stats)
+
+	return nil
+}
+
+// writeStreamEvent writes data as JSON in a Server-Sent Event named name.
+func writeStreamEvent(w http.ResponseWriter, name string, data interface{}) {
+	body, _ := json.Marshal(data)
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, body)
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/services"
+	"github.com/grounded042/capacious/utils"
+	"github.com/zenazn/goji/web"
+)
+
+type WebhooksStub interface {
+	GetWebhooks(ctx context.Context, eventID string, userID string) ([]entities.Webhook, utils.Error)
+	CreateWebhook(ctx context.Context, eventID string, userID string, newWebhook services.NewWebhook) (entities.Webhook, utils.Error)
+	DeleteWebhook(ctx context.Context, eventID string, webhookID string, userID string) utils.Error
+	GetWebhookDeliveries(ctx context.Context, eventID string, webhookID string, userID string, filter entities.WebhookDeliveryFilter, p *services.PaginationService) ([]entities.WebhookDelivery, utils.Error)
+	RedeliverWebhook(ctx context.Context, eventID string, webhookID string, deliveryID string, userID string) (entities.WebhookDelivery, utils.Error)
+}
+
+type WebhooksController struct {
+	whs WebhooksStub
+}
+
+func NewWebhooksController(newWHS WebhooksStub) WebhooksController {
+	return WebhooksController{
+		whs: newWHS,
+	}
+}
+
+// GetWebhooks lists the webhooks of an event, without their secrets.
+func (whc WebhooksController) GetWebhooks(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get the webhooks for an event!")
+
+	if !ok {
+		return
+	}
+
+	if found, err := whc.whs.GetWebhooks(r.Context(), c.URLParams["id"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(found)
+	}
+}
+
+// CreateWebhook creates a webhook for an event. The response is the only time
+// its secret is shown.
+func (whc WebhooksController) CreateWebhook(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to create a webhook!")
+
+	if !ok {
+		return
+	}
+
+	var newWebhook services.NewWebhook
+
+	if dErr := json.NewDecoder(r.Body).Decode(&newWebhook); dErr != nil {
+		writeError(r, w, readErrorCode(dErr, 400), dErr)
+		return
+	}
+
+	if created, err := whc.whs.CreateWebhook(r.Context(), c.URLParams["id"], userID, newWebhook); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+// DeleteWebhook deletes a webhook of an event, and its deliveries.
+func (whc WebhooksController) DeleteWebhook(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to delete a webhook!")
+
+	if !ok {
+		return
+	}
+
+	if err := whc.whs.DeleteWebhook(r.Context(), c.URLParams["id"], c.URLParams["webhook_id"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(204)
+	}
+}
+
+// GetWebhookDeliveries lists the changes sent to a webhook, newest first. They
+// can be narrowed down with filter[event_type] and filter[status].
+func (whc WebhooksController) GetWebhookDeliveries(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to get the webhooks for an event!")
+
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+
+	filter := entities.WebhookDeliveryFilter{
+		EventType: q.Get("filter[event_type]"),
+		Status:    q.Get("filter[status]"),
+	}
+
+	p := services.NewPaginationService()
+	p.SetMaxPageSize(100)
+
+	if pErr := readPagination(q, &p); pErr != nil {
+		writeError(r, w, 400, pErr)
+		return
+	}
+
+	deliveries, err := whc.whs.GetWebhookDeliveries(r.Context(), c.URLParams["id"], c.URLParams["webhook_id"], userID, filter, &p)
+
+	if err != nil {
+		writeError(r, w, err.Code(), err)
+		return
+	}
+
+	setLinkHeader(w, pageLinks(r, &p))
+
+	toSend := DataWithPagination{
+		Data:       deliveries,
+		Pagination: paginationInfo(&p),
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(toSend)
+}
+
+// RedeliverWebhook sends a change to a webhook again. It is sent in the
+// background; the response is the delivery waiting to be sent.
+func (whc WebhooksController) RedeliverWebhook(c web.C, w http.ResponseWriter, r *http.Request) {
+	userID, ok := checkForAndHandleUserIDInContext(c, w, "You need a valid user id to redeliver a webhook!")
+
+	if !ok {
+		return
+	}
+
+	if delivery, err := whc.whs.RedeliverWebhook(r.Context(), c.URLParams["id"], c.URLParams["webhook_id"], c.URLParams["delivery_id"], userID); err != nil {
+		writeError(r, w, err.Code(), err)
+	} else {
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
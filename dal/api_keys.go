@@ -0,0 +1,55 @@
+package dal
+
+import (
+	"context"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// CreateAPIKey saves a new API key.
+func (dh DataHandler) CreateAPIKey(ctx context.Context, createMe *entities.APIKey) error {
+	return dh.db(ctx).Create(createMe).Error
+}
+
+// GetAPIKeysForEvent gets the API keys of the event with the id eventID,
+// newest first. Revoked keys are included.
+func (dh DataHandler) GetAPIKeysForEvent(ctx context.Context, eventID string) ([]entities.APIKey, error) {
+	keys := []entities.APIKey{}
+
+	db := dh.db(ctx).Where("fk_event_id = ?", eventID).Order("created_at desc").Find(&keys)
+
+	return keys, db.Error
+}
+
+// GetAPIKeyFromKeyID gets the API key with the key id keyID.
+func (dh DataHandler) GetAPIKeyFromKeyID(ctx context.Context, keyID string) (entities.APIKey, error) {
+	findMe := entities.APIKey{}
+
+	db := dh.db(ctx).Where("key_id = ?", keyID).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// RevokeAPIKey revokes the API key with the id apiKeyID of the event with the
+// id eventID. gorm.RecordNotFound is returned if the event has no such key
+// that isn't already revoked.
+func (dh DataHandler) RevokeAPIKey(ctx context.Context, eventID string, apiKeyID string) error {
+	db := dh.db(ctx).Model(entities.APIKey{}).Where("api_key_id = ? and fk_event_id = ? and revoked_at is null", apiKeyID, eventID).UpdateColumn("revoked_at", time.Now())
+
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if db.RowsAffected == 0 {
+		return gorm.RecordNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIKey sets when the API key with the id apiKeyID was last used.
+func (dh DataHandler) TouchAPIKey(ctx context.Context, apiKeyID string, usedAt time.Time) error {
+	return dh.db(ctx).Model(entities.APIKey{}).Where("api_key_id = ?", apiKeyID).UpdateColumn("last_used_at", usedAt).Error
+}
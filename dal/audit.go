@@ -0,0 +1,100 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// CreateAuditEntry appends an entry to the audit log.
+func (dh DataHandler) CreateAuditEntry(ctx context.Context, createMe *entities.AuditEntry) error {
+	return dh.db(ctx).Create(createMe).Error
+}
+
+// audit entries are listed oldest first
+var auditKeyset = keyset{id: "audit_entry_id", keys: []sortKey{{expr: "created_at"}}}
+
+// GetAuditEntriesForEvent gets a page of the audit entries of the event with
+// the id eventID that match filter, oldest first.
+func (dh DataHandler) GetAuditEntriesForEvent(ctx context.Context, eventID string, filter entities.AuditFilter, page entities.Page) ([]entities.AuditEntry, entities.PageBounds, error) {
+	ids, bounds, err := auditKeyset.page(filterAuditEntries(dh.db(ctx).Model(entities.AuditEntry{}), eventID, filter), page)
+
+	if err != nil || len(ids) == 0 {
+		return []entities.AuditEntry{}, bounds, err
+	}
+
+	found := []entities.AuditEntry{}
+
+	if db := dh.db(ctx).Where("audit_entry_id IN (?)", ids).Find(&found); db.Error != nil {
+		return []entities.AuditEntry{}, bounds, db.Error
+	}
+
+	byID := make(map[string]entities.AuditEntry, len(found))
+
+	for _, entry := range found {
+		byID[entry.AuditEntryID] = entry
+	}
+
+	entries := make([]entities.AuditEntry, 0, len(ids))
+
+	for _, id := range ids {
+		entries = append(entries, byID[id])
+	}
+
+	return entries, bounds, nil
+}
+
+// GetNumberOfAuditEntriesForEvent counts the audit entries of the event with
+// the id eventID that match filter.
+func (dh DataHandler) GetNumberOfAuditEntriesForEvent(ctx context.Context, eventID string, filter entities.AuditFilter) (int, error) {
+	var count int
+
+	db := filterAuditEntries(dh.db(ctx).Model(entities.AuditEntry{}), eventID, filter).Count(&count)
+
+	return count, db.Error
+}
+
+func filterAuditEntries(db *gorm.DB, eventID string, filter entities.AuditFilter) *gorm.DB {
+	db = db.Where("fk_event_id = ?", eventID)
+
+	if filter.InviteeID != "" {
+		db = db.Where("invitee_id = ?", filter.InviteeID)
+	}
+
+	if filter.GuestID != "" {
+		db = db.Where("guest_id = ?", filter.GuestID)
+	}
+
+	if filter.EntityType != "" {
+		db = db.Where("entity_type = ?", filter.EntityType)
+	}
+
+	if filter.EntityID != "" {
+		db = db.Where("entity_id = ?", filter.EntityID)
+	}
+
+	return db
+}
+
+// GetAuditEntriesForInvitee gets all the audit entries about the invitee with
+// the id inviteeID, oldest first.
+func (dh DataHandler) GetAuditEntriesForInvitee(ctx context.Context, inviteeID string) ([]entities.AuditEntry, error) {
+	entries := []entities.AuditEntry{}
+
+	db := dh.db(ctx).Where("invitee_id = ?", inviteeID).Order("created_at, audit_entry_id").Find(&entries)
+
+	return entries, db.Error
+}
+
+// GetAuditEntryForInvitee gets the audit entry with the id auditEntryID if it
+// is about the invitee with the id inviteeID.
+func (dh DataHandler) GetAuditEntryForInvitee(ctx context.Context, inviteeID string, auditEntryID string) (entities.AuditEntry, error) {
+	findMe := entities.AuditEntry{}
+
+	// compare as text so an id that isn't a uuid is not found rather than an
+	// error
+	db := dh.db(ctx).Where("invitee_id = ? AND audit_entry_id::text = ?", inviteeID, auditEntryID).First(&findMe)
+
+	return findMe, db.Error
+}
@@ -1,49 +1,246 @@
 package dal
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"runtime"
+	"strconv"
+	"time"
 
 	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/logging"
 	"github.com/jinzhu/gorm"
 	_ "github.com/lib/pq"
 )
 
 type DataHandler struct {
 	conn *gorm.DB
+	// url is what the database was opened with, for connections made outside
+	// of the pool
+	url string
 }
 
+// StatementTimeout caps how long a statement can run for unless
+// PSQL_STATEMENT_TIMEOUT says otherwise. 0 means no cap.
+var StatementTimeout time.Duration
+
 func NewDal() DataHandler {
 	psqlURL := "postgres://" + os.Getenv("PSQL_USERNAME") + ":" + os.Getenv("PSQL_SECRET") + "@" + os.Getenv("PSQL_HOSTNAME") + ":" + os.Getenv("PSQL_PORT") + "/" + os.Getenv("PSQL_DB_NAME") + "?sslmode=disable"
 
+	// the driver can't cancel a statement once it has been sent, so cap how
+	// long one can run for, so a query doesn't run on long after the request
+	// that made it is over
+	timeout := os.Getenv("PSQL_STATEMENT_TIMEOUT")
+
+	if timeout == "" && StatementTimeout > 0 {
+		timeout = strconv.FormatInt(StatementTimeout.Milliseconds(), 10)
+	}
+
+	if timeout != "" {
+		psqlURL += "&statement_timeout=" + timeout
+	}
+
 	db, err := gorm.Open("postgres", fmt.Sprint(psqlURL))
 
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("could not open the database", "error", err)
 	}
 
 	pErr := db.DB().Ping()
 
 	if pErr != nil {
-		fmt.Println(pErr)
+		slog.Error("could not ping the database", "error", pErr)
+	}
+
+	// send every statement through the query logger so it can be measured and
+	// logged
+	db.SetLogger(newQueryLogger(slog.Default()))
+	db.LogMode(true)
+	registerPoolGauges(db.DB())
+
+	return DataHandler{conn: &db, url: psqlURL}
+}
+
+// db gets a gorm handle whose statements are logged with the logger carried
+// by ctx, so they are tagged with the request id. Once ctx is done, because
+// the request timed out or the client went away, the handle carries ctx's
+// error so gorm won't send any more statements for the request. A statement
+// already sent isn't cancelled; it runs until the statement timeout. If ctx
+// carries a transaction the statements are made in it.
+func (dh DataHandler) db(ctx context.Context) *gorm.DB {
+	db := dh.conn
+
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		db = tx
+	}
+
+	db = db.New()
+	db.SetLogger(newQueryLogger(logging.FromContext(ctx)))
+
+	if err := ctx.Err(); err != nil {
+		db.AddError(err)
+	}
+
+	return db
+}
+
+// sqlDB gets a handle for statements written for database/sql, with $n
+// placeholders, that gorm can't write. Like dh.db(ctx) its statements are
+// made in the transaction ctx carries, if it does, and go through the query
+// logger.
+func (dh DataHandler) sqlDB(ctx context.Context) loggedDB {
+	return loggedDB{db: dh.db(ctx).CommonDB().(sqlQuerier), log: newQueryLogger(logging.FromContext(ctx))}
+}
+
+// sqlQuerier is what *sql.DB and *sql.Tx have in common.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// loggedDB sends the statements it makes to a query logger.
+type loggedDB struct {
+	db  sqlQuerier
+	log queryLogger
+}
+
+func (l loggedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer l.trace(time.Now(), query, args)
+	return l.db.ExecContext(ctx, query, args...)
+}
+
+func (l loggedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer l.trace(time.Now(), query, args)
+	return l.db.QueryContext(ctx, query, args...)
+}
+
+func (l loggedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer l.trace(time.Now(), query, args)
+	return l.db.QueryRowContext(ctx, query, args...)
+}
+
+// trace logs a statement the way gorm does, with where in dal it was made.
+func (l loggedDB) trace(start time.Time, query string, args []interface{}) {
+	source := ""
+
+	if _, file, line, ok := runtime.Caller(2); ok {
+		source = fmt.Sprintf("%s:%d", file, line)
 	}
 
-	return DataHandler{conn: &db}
+	l.log.Print("sql", source, time.Since(start), query, args)
+}
+
+type txKey struct{}
+
+// InTransaction runs fn in a transaction, which the ctx fn is given carries:
+// everything fn does with it is committed if fn returns nil and rolled back
+// otherwise. If ctx already carries a transaction fn runs in that one.
+func (dh DataHandler) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
 }
 
-func (dh DataHandler) GetAllEvents(userID string) ([]entities.Event, error) {
-	var events = []entities.Event{}
+// transaction runs fn with a transaction, committing it if fn returns nil. If
+// ctx already carries a transaction fn is given that one, and it is left to
+// whoever started it to commit. If ctx has a deadline the statements in the
+// transaction can't run past it.
+func (dh DataHandler) transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(dh.db(ctx))
+	}
 
-	db := dh.conn.Table("event_admins").Select("events.*").Where("fk_user_id = ?", userID).Joins("left join events on event_admins.fk_event_id = events.event_id").Find(&events)
+	tx := dh.db(ctx).Begin()
 
-	return events, db.Error
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		// at least a millisecond, as 0 is no timeout
+		ms := max(time.Until(deadline).Milliseconds(), 1)
+
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)).Error; err != nil {
+			return commit(tx, err)
+		}
+	}
+
+	return commit(tx, fn(tx))
 }
 
-func (dh DataHandler) GetEventInfo(eventID string) (entities.Event, error) {
+// commit commits the transaction tx, or rolls it back if it couldn't be
+// started or err, the error from its statements, is set.
+func commit(tx *gorm.DB, err error) error {
+	if err == nil {
+		err = tx.Error
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// GetAllEvents gets a page of the events the user with the id userID is an
+// admin of, by name.
+func (dh DataHandler) GetAllEvents(ctx context.Context, userID string, page entities.Page) ([]entities.Event, entities.PageBounds, error) {
+	ids, bounds, err := eventKeyset.page(adminEvents(dh.db(ctx), userID), page)
+
+	if err != nil || len(ids) == 0 {
+		return []entities.Event{}, bounds, err
+	}
+
+	var found = []entities.Event{}
+
+	if db := dh.db(ctx).Where("event_id IN (?)", ids).Find(&found); db.Error != nil {
+		return []entities.Event{}, bounds, db.Error
+	}
+
+	byID := make(map[string]entities.Event, len(found))
+
+	for _, event := range found {
+		byID[event.EventID] = event
+	}
+
+	events := make([]entities.Event, 0, len(ids))
+
+	for _, id := range ids {
+		if event, ok := byID[id]; ok {
+			events = append(events, event)
+		}
+	}
+
+	return events, bounds, nil
+}
+
+// GetNumberOfEvents counts the events the user with the id userID is an
+// admin of.
+func (dh DataHandler) GetNumberOfEvents(ctx context.Context, userID string) (int, error) {
+	var count int
+
+	db := adminEvents(dh.db(ctx), userID).Count(&count)
+
+	return count, db.Error
+}
+
+// events are listed by name, which is unique
+var eventKeyset = keyset{id: "events.event_id", keys: []sortKey{{expr: "events.name"}}}
+
+func adminEvents(db *gorm.DB, userID string) *gorm.DB {
+	return db.Table("event_admins").Joins("JOIN events ON event_admins.fk_event_id = events.event_id").Where("event_admins.fk_user_id = ?", userID)
+}
+
+func (dh DataHandler) GetEventInfo(ctx context.Context, eventID string) (entities.Event, error) {
 	var event = entities.Event{EventID: eventID}
 
-	db := dh.conn.Find(&event)
+	db := dh.db(ctx).Find(&event)
 
 	return event, db.Error
 }
@@ -55,74 +252,96 @@ func (dh DataHandler) GetEventInfo(eventID string) (entities.Event, error) {
 // is a valid user id, but we should NEVER be inserting a bad ID since if we
 // are inserting a userID that doesn't exist it means our auth system has been
 // compromised. At that point we have bigger problems.
-func (dh DataHandler) CreateEvent(createMe *entities.Event, userID string) error {
-	db := dh.conn.Create(&createMe)
+func (dh DataHandler) CreateEvent(ctx context.Context, createMe *entities.Event, userID string) error {
+	db := dh.db(ctx).Create(&createMe)
 
 	if db.Error != nil {
 		return db.Error
 	}
 
-	db.Create(&entities.EventAdmin{FkUserID: userID, FkEventID: createMe.EventID})
+	db.Create(&entities.EventAdmin{FkUserID: userID, FkEventID: createMe.EventID, Owner: true})
 
 	return db.Error
 }
 
-func (dh DataHandler) GetNumAttendingForEvent(eventID string) (int, error) {
-	// count the invitees attending
-	// -- SELECT * FROM invitees i LEFT JOIN guests g ON i.fk_guest_id = g.guest_id WHERE attending = true;
+// GetAllInviteesForEvent gets a page of the invitees of the event with the id
+// eventID that match filter, in the order it asks for.
+func (dh DataHandler) GetAllInviteesForEvent(ctx context.Context, eventID string, filter entities.InviteeFilter, page entities.Page) ([]entities.Invitee, entities.PageBounds, error) {
+	ids, bounds, err := inviteeKeyset(filter.Sort).page(filterInvitees(dh.db(ctx), eventID, filter), page)
 
-	var iCount int
+	if err != nil {
+		return []entities.Invitee{}, bounds, err
+	}
+
+	invitees, err := dh.getInviteesInOrder(ctx, ids)
+
+	return invitees, bounds, err
+}
+
+// getInviteesInOrder gets the invitees with the ids ids in the same order,
+// with their guests, seating requests, tags and friends.
+func (dh DataHandler) getInviteesInOrder(ctx context.Context, ids []string) ([]entities.Invitee, error) {
+	var found = []entities.Invitee{}
+
+	if len(ids) == 0 {
+		return found, nil
+	}
 
-	db := dh.conn.Debug().Table("invitees i").Joins("LEFT JOIN guests g ON i.fk_guest_id = g.guest_id").Where("fk_event_id = ? AND attending = true", eventID).Count(&iCount)
+	db := dh.db(ctx).Where("invitee_id IN (?)", ids).Find(&found)
 
 	if db.Error != nil {
-		return 0, db.Error
+		return []entities.Invitee{}, db.Error
 	}
 
-	// count the invitee friends attending
-	// -- SELECT * FROM invitees i LEFT JOIN invitee_friends ifs ON ifs.fk_invitee_id = i.invitee_id LEFT JOIN guests g ON ifs.fk_guest_id = g.guest_id WHERE attending = true;
-	var ifCount int
+	byID := make(map[string]entities.Invitee, len(found))
 
-	db = dh.conn.Debug().Table("invitees i").Joins("LEFT JOIN guests ig ON i.fk_guest_id = ig.guest_id LEFT JOIN invitee_friends ifs ON ifs.fk_invitee_id = i.invitee_id LEFT JOIN guests ifsg ON ifs.fk_guest_id = ifsg.guest_id").Where("fk_event_id = ? AND ig.attending = true AND ifsg.attending = true", eventID).Count(&ifCount)
+	for _, invitee := range found {
+		byID[invitee.InviteeID] = invitee
+	}
 
-	return iCount + ifCount, db.Error
-}
+	invitees := make([]entities.Invitee, 0, len(ids))
 
-func (dh DataHandler) GetAllInviteesForEvent(eventId string, start int, length int) ([]entities.Invitee, error) {
-	var invitees = []entities.Invitee{}
+	// an invitee deleted since its id was got is left out
+	for _, id := range ids {
+		if invitee, ok := byID[id]; ok {
+			invitees = append(invitees, invitee)
+		}
+	}
 
-	db := dh.conn.Debug().Offset(start).Limit(length).Order("email").Find(&invitees)
+	invitees, db.Error = dh.addInviteeSelfToInvitees(ctx, invitees)
 
 	if db.Error != nil {
 		return []entities.Invitee{}, db.Error
 	}
 
-	invitees, db.Error = dh.addInviteeSelfToInvitees(invitees)
+	invitees, db.Error = dh.addInviteeSeatingRequestsToInvitees(ctx, invitees)
 
 	if db.Error != nil {
 		return []entities.Invitee{}, db.Error
 	}
 
-	invitees, db.Error = dh.addInviteeSeatingRequestsToInvitees(invitees)
+	invitees, db.Error = dh.addInviteeTagsToInvitees(ctx, invitees)
 
 	if db.Error != nil {
 		return []entities.Invitee{}, db.Error
 	}
 
-	return dh.addInviteeFriendsToInvitees(invitees)
+	return dh.addInviteeFriendsToInvitees(ctx, invitees)
 }
 
-func (dh DataHandler) GetNumberOfInviteesForEvent(eventID string) int {
+// GetNumberOfInviteesForEvent counts the invitees of the event with the id
+// eventID that match filter.
+func (dh DataHandler) GetNumberOfInviteesForEvent(ctx context.Context, eventID string, filter entities.InviteeFilter) (int, error) {
 	var count int
 
-	dh.conn.Debug().Table("invitees").Where("fk_event_id = ?", eventID).Count(&count)
+	db := filterInvitees(dh.db(ctx), eventID, filter).Count(&count)
 
-	return count
+	return count, db.Error
 }
 
-func (dh DataHandler) addInviteeSelfToInvitees(list []entities.Invitee) ([]entities.Invitee, error) {
+func (dh DataHandler) addInviteeSelfToInvitees(ctx context.Context, list []entities.Invitee) ([]entities.Invitee, error) {
 	for key, value := range list {
-		invitee, err := dh.addInviteeSelfToInvitee(value)
+		invitee, err := dh.addInviteeSelfToInvitee(ctx, value)
 
 		if err != nil {
 			return []entities.Invitee{}, err
@@ -134,9 +353,9 @@ func (dh DataHandler) addInviteeSelfToInvitees(list []entities.Invitee) ([]entit
 	return list, nil
 }
 
-func (dh DataHandler) addInviteeSeatingRequestsToInvitees(list []entities.Invitee) ([]entities.Invitee, error) {
+func (dh DataHandler) addInviteeSeatingRequestsToInvitees(ctx context.Context, list []entities.Invitee) ([]entities.Invitee, error) {
 	for key, value := range list {
-		invitee, err := dh.addInviteeSeatingRequestsToInvitee(value)
+		invitee, err := dh.addInviteeSeatingRequestsToInvitee(ctx, value)
 
 		if err != nil {
 			return []entities.Invitee{}, err
@@ -148,10 +367,10 @@ func (dh DataHandler) addInviteeSeatingRequestsToInvitees(list []entities.Invite
 	return list, nil
 }
 
-func (dh DataHandler) addInviteeSelfToInvitee(invitee entities.Invitee) (entities.Invitee, error) {
+func (dh DataHandler) addInviteeSelfToInvitee(ctx context.Context, invitee entities.Invitee) (entities.Invitee, error) {
 	var err error
 
-	invitee.Self, err = dh.getGuestFromID(invitee.FkGuestID)
+	invitee.Self, err = dh.getGuestFromID(ctx, invitee.FkGuestID)
 
 	if err != nil {
 		return entities.Invitee{}, err
@@ -160,10 +379,10 @@ func (dh DataHandler) addInviteeSelfToInvitee(invitee entities.Invitee) (entitie
 	return invitee, nil
 }
 
-func (dh DataHandler) addInviteeSeatingRequestsToInvitee(invitee entities.Invitee) (entities.Invitee, error) {
+func (dh DataHandler) addInviteeSeatingRequestsToInvitee(ctx context.Context, invitee entities.Invitee) (entities.Invitee, error) {
 	var err error
 
-	invitee.SeatingRequests, err = dh.getInviteeSeatingRequestsForInviteeID(invitee.InviteeID)
+	invitee.SeatingRequests, err = dh.getInviteeSeatingRequestsForInviteeID(ctx, invitee.InviteeID)
 
 	if err != nil {
 		return entities.Invitee{}, err
@@ -171,7 +390,7 @@ func (dh DataHandler) addInviteeSeatingRequestsToInvitee(invitee entities.Invite
 
 	// add the first and last names
 	for key, value := range invitee.SeatingRequests {
-		firstName, lastName, err := dh.getInviteeFirstNameAndLastNameFromID(value.FkInviteeRequestID)
+		firstName, lastName, err := dh.getInviteeFirstNameAndLastNameFromID(ctx, value.FkInviteeRequestID)
 
 		if err != nil {
 			return entities.Invitee{}, err
@@ -184,9 +403,9 @@ func (dh DataHandler) addInviteeSeatingRequestsToInvitee(invitee entities.Invite
 	return invitee, nil
 }
 
-func (dh DataHandler) addInviteeFriendsToInvitees(list []entities.Invitee) ([]entities.Invitee, error) {
+func (dh DataHandler) addInviteeFriendsToInvitees(ctx context.Context, list []entities.Invitee) ([]entities.Invitee, error) {
 	for key, value := range list {
-		inviteeFriends, err := dh.GetInviteeFriendsFromInviteeID(value.InviteeID)
+		inviteeFriends, err := dh.GetInviteeFriendsFromInviteeID(ctx, value.InviteeID)
 
 		if err != nil {
 			return []entities.Invitee{}, err
@@ -198,8 +417,8 @@ func (dh DataHandler) addInviteeFriendsToInvitees(list []entities.Invitee) ([]en
 	return list, nil
 }
 
-func (dh DataHandler) addInviteeFriendsToInvitee(invitee entities.Invitee) (entities.Invitee, error) {
-	inviteeFriends, err := dh.GetInviteeFriendsFromInviteeID(invitee.InviteeID)
+func (dh DataHandler) addInviteeFriendsToInvitee(ctx context.Context, invitee entities.Invitee) (entities.Invitee, error) {
+	inviteeFriends, err := dh.GetInviteeFriendsFromInviteeID(ctx, invitee.InviteeID)
 
 	if err != nil {
 		return entities.Invitee{}, err
@@ -210,11 +429,11 @@ func (dh DataHandler) addInviteeFriendsToInvitee(invitee entities.Invitee) (enti
 	return invitee, nil
 }
 
-func (dh DataHandler) GetInviteeFriendsFromInviteeID(id string) ([]entities.InviteeFriend, error) {
+func (dh DataHandler) GetInviteeFriendsFromInviteeID(ctx context.Context, id string) ([]entities.InviteeFriend, error) {
 	var inviteeFriends []entities.InviteeFriend
 	var count int
 
-	db := dh.conn.Table("invitee_friends").Where("fk_invitee_id = ?", id).Find(&inviteeFriends).Count(&count)
+	db := dh.db(ctx).Table("invitee_friends").Where("fk_invitee_id = ?", id).Find(&inviteeFriends).Count(&count)
 
 	if count == 0 {
 		return []entities.InviteeFriend{}, nil
@@ -223,7 +442,7 @@ func (dh DataHandler) GetInviteeFriendsFromInviteeID(id string) ([]entities.Invi
 	}
 
 	for key, value := range inviteeFriends {
-		inviteeFriends[key].Self, db.Error = dh.getGuestFromID(value.FkGuestID)
+		inviteeFriends[key].Self, db.Error = dh.getGuestFromID(ctx, value.FkGuestID)
 
 		if db.Error != nil {
 			return []entities.InviteeFriend{}, db.Error
@@ -233,12 +452,12 @@ func (dh DataHandler) GetInviteeFriendsFromInviteeID(id string) ([]entities.Invi
 	return inviteeFriends, nil
 }
 
-func (dh DataHandler) CreateInvitee(createMe *entities.Invitee) error {
+func (dh DataHandler) CreateInvitee(ctx context.Context, createMe *entities.Invitee) error {
 
 	// TODO: check and make sure email doesn't exist yet
 
 	// create the invitee self
-	cErr := dh.createGuest(&createMe.Self)
+	cErr := dh.createGuest(ctx, &createMe.Self)
 
 	if cErr != nil {
 		return cErr
@@ -247,16 +466,20 @@ func (dh DataHandler) CreateInvitee(createMe *entities.Invitee) error {
 	// assign the id of self to the foreign key entry
 	createMe.FkGuestID = createMe.Self.GuestID
 
-	db := dh.conn.Create(&createMe)
+	db := dh.db(ctx).Create(&createMe)
 
 	if db.Error != nil {
 		return db.Error
 	}
 
+	if err := dh.SetInviteeTags(ctx, createMe.InviteeID, createMe.Tags); err != nil {
+		return err
+	}
+
 	for key, value := range createMe.Friends {
 		value.FkInviteeID = createMe.InviteeID
 
-		cigErr := dh.CreateInviteeFriend(&value)
+		cigErr := dh.CreateInviteeFriend(ctx, &value)
 
 		if cigErr != nil {
 			return cigErr
@@ -269,15 +492,15 @@ func (dh DataHandler) CreateInvitee(createMe *entities.Invitee) error {
 	return db.Error
 }
 
-func (dh DataHandler) createGuest(createMe *entities.Guest) error {
-	db := dh.conn.Create(&createMe)
+func (dh DataHandler) createGuest(ctx context.Context, createMe *entities.Guest) error {
+	db := dh.db(ctx).Create(&createMe)
 
 	return db.Error
 }
 
-func (dh DataHandler) CreateInviteeFriend(createMe *entities.InviteeFriend) error {
+func (dh DataHandler) CreateInviteeFriend(ctx context.Context, createMe *entities.InviteeFriend) error {
 	// create the invitee friend self
-	cErr := dh.createGuest(&createMe.Self)
+	cErr := dh.createGuest(ctx, &createMe.Self)
 
 	if cErr != nil {
 		return cErr
@@ -286,45 +509,51 @@ func (dh DataHandler) CreateInviteeFriend(createMe *entities.InviteeFriend) erro
 	// assign the id of self to the foreign key entry
 	createMe.FkGuestID = createMe.Self.GuestID
 
-	db := dh.conn.Create(&createMe)
+	db := dh.db(ctx).Create(&createMe)
 
 	return db.Error
 }
 
-func (dh DataHandler) GetInviteeFromID(id string) (entities.Invitee, error) {
+func (dh DataHandler) GetInviteeFromID(ctx context.Context, id string) (entities.Invitee, error) {
 	var invitee entities.Invitee
 
-	db := dh.conn.Where("invitee_id = ?", id).First(&invitee)
+	db := dh.db(ctx).Where("invitee_id = ?", id).First(&invitee)
+
+	if db.Error != nil {
+		return entities.Invitee{}, db.Error
+	}
+
+	invitee, db.Error = dh.addInviteeSelfToInvitee(ctx, invitee)
 
 	if db.Error != nil {
 		return entities.Invitee{}, db.Error
 	}
 
-	invitee, db.Error = dh.addInviteeSelfToInvitee(invitee)
+	invitee, db.Error = dh.addInviteeSeatingRequestsToInvitee(ctx, invitee)
 
 	if db.Error != nil {
 		return entities.Invitee{}, db.Error
 	}
 
-	invitee, db.Error = dh.addInviteeSeatingRequestsToInvitee(invitee)
+	invitee.Tags, db.Error = dh.getInviteeTags(ctx, invitee.InviteeID)
 
 	if db.Error != nil {
 		return entities.Invitee{}, db.Error
 	}
 
-	return dh.addInviteeFriendsToInvitee(invitee)
+	return dh.addInviteeFriendsToInvitee(ctx, invitee)
 }
 
-func (dh DataHandler) getInviteeFirstNameAndLastNameFromID(id string) (string, string, error) {
+func (dh DataHandler) getInviteeFirstNameAndLastNameFromID(ctx context.Context, id string) (string, string, error) {
 	var invitee entities.Invitee
 
-	db := dh.conn.Where("invitee_id = ?", id).First(&invitee)
+	db := dh.db(ctx).Where("invitee_id = ?", id).First(&invitee)
 
 	if db.Error != nil {
 		return "", "", db.Error
 	}
 
-	invitee, db.Error = dh.addInviteeSelfToInvitee(invitee)
+	invitee, db.Error = dh.addInviteeSelfToInvitee(ctx, invitee)
 
 	if db.Error != nil {
 		return "", "", db.Error
@@ -333,29 +562,29 @@ func (dh DataHandler) getInviteeFirstNameAndLastNameFromID(id string) (string, s
 	return invitee.Self.FirstName, invitee.Self.LastName, nil
 }
 
-func (dh DataHandler) getGuestFromID(id string) (entities.Guest, error) {
+func (dh DataHandler) getGuestFromID(ctx context.Context, id string) (entities.Guest, error) {
 	var guest entities.Guest
 
-	db := dh.conn.Where("guest_id = ?", id).First(&guest)
+	db := dh.db(ctx).Where("guest_id = ?", id).First(&guest)
 
 	// get the guests menu options
 	if db.Error != nil {
 		return entities.Guest{}, db.Error
 	}
 
-	return dh.addMenuInfoToGuestObj(guest)
+	return dh.addMenuInfoToGuestObj(ctx, guest)
 }
 
-func (dh DataHandler) addMenuInfoToGuestObj(guest entities.Guest) (entities.Guest, error) {
+func (dh DataHandler) addMenuInfoToGuestObj(ctx context.Context, guest entities.Guest) (entities.Guest, error) {
 	var err error
 
-	guest.MenuChoices, err = dh.getMenuChoicesForGuestID(guest.GuestID)
+	guest.MenuChoices, err = dh.getMenuChoicesForGuestID(ctx, guest.GuestID)
 
 	if err != nil {
 		return entities.Guest{}, err
 	}
 
-	note, err := dh.getMenuNoteForGuestID(guest.GuestID)
+	note, err := dh.getMenuNoteForGuestID(ctx, guest.GuestID)
 
 	if err != nil {
 		return entities.Guest{}, err
@@ -368,11 +597,11 @@ func (dh DataHandler) addMenuInfoToGuestObj(guest entities.Guest) (entities.Gues
 
 // getMenuChoicesForGuestID gets the menu choices associated with the supplied guestID.
 // It returns a slice of entities.MenuChoice objs and any error that occured.
-func (dh DataHandler) getMenuChoicesForGuestID(guestID string) ([]entities.MenuChoice, error) {
+func (dh DataHandler) getMenuChoicesForGuestID(ctx context.Context, guestID string) ([]entities.MenuChoice, error) {
 	var choices []entities.MenuChoice
 	var count int
 
-	db := dh.conn.Where("fk_guest_id = ?", guestID).Find(&choices).Count(&count)
+	db := dh.db(ctx).Where("fk_guest_id = ?", guestID).Find(&choices).Count(&count)
 
 	if count == 0 {
 		return []entities.MenuChoice{}, nil
@@ -381,9 +610,9 @@ func (dh DataHandler) getMenuChoicesForGuestID(guestID string) ([]entities.MenuC
 	return choices, db.Error
 }
 
-func (dh DataHandler) UpdateInvitee(updateMe entities.Invitee) error {
+func (dh DataHandler) UpdateInvitee(ctx context.Context, updateMe entities.Invitee) error {
 	// get the current invitee to diff
-	curInvitee, err := dh.GetInviteeFromID(updateMe.InviteeID)
+	curInvitee, err := dh.GetInviteeFromID(ctx, updateMe.InviteeID)
 
 	if err != nil {
 		return err
@@ -400,24 +629,36 @@ func (dh DataHandler) UpdateInvitee(updateMe entities.Invitee) error {
 	}
 
 	// update the invitee self
-	err = dh.updateGuest(updateMe.Self)
+	err = dh.updateGuest(ctx, updateMe.Self)
 
 	if err != nil {
 		return err
 	}
 
-	// lastly, update the invitee obj
-	db := dh.conn.Save(updateMe)
+	// lastly, update the invitee obj. only the email can change, and saving
+	// the whole obj would overwrite its version. updating the invitee is
+	// them answering the invitation.
+	db := dh.db(ctx).Model(entities.Invitee{}).Where("invitee_id = ?", updateMe.InviteeID).UpdateColumns(map[string]interface{}{
+		"email":        updateMe.Email,
+		"responded_at": time.Now(),
+	})
 
 	return db.Error
 }
 
-func (dh DataHandler) updateGuest(updateMe entities.Guest) error {
-	return dh.conn.Save(updateMe).Error
+// updateGuest updates the details of a guest. Menu choices and notes are set
+// on their own.
+func (dh DataHandler) updateGuest(ctx context.Context, updateMe entities.Guest) error {
+	return dh.db(ctx).Model(entities.Guest{}).Where("guest_id = ?", updateMe.GuestID).UpdateColumns(map[string]interface{}{
+		"first_name":  updateMe.FirstName,
+		"last_name":   updateMe.LastName,
+		"attending":   updateMe.Attending,
+		"rsvp_status": updateMe.RSVPStatus,
+	}).Error
 }
 
-func (dh DataHandler) UpdateInviteeFriend(updateMe entities.InviteeFriend) error {
-	curIG, err := dh.GetInviteeFriendFromID(updateMe.InviteeFriendID)
+func (dh DataHandler) UpdateInviteeFriend(ctx context.Context, updateMe entities.InviteeFriend) error {
+	curIG, err := dh.GetInviteeFriendFromID(ctx, updateMe.InviteeFriendID)
 
 	if err != nil {
 		return err
@@ -432,25 +673,16 @@ func (dh DataHandler) UpdateInviteeFriend(updateMe entities.InviteeFriend) error
 		return errors.New("bad invitee friend self id")
 	}
 
-	// update the invitee friend self
-	err = dh.updateGuest(updateMe.Self)
-
-	if err != nil {
-		return err
-	}
-
-	// lastly, update the invitee obj
-	db := dh.conn.Save(updateMe)
-
-	return db.Error
-
+	// update the invitee friend self, nothing on the invitee friend obj itself
+	// can change
+	return dh.updateGuest(ctx, updateMe.Self)
 }
 
-func (dh DataHandler) GetInviteeFriendFromID(id string) (entities.InviteeFriend, error) {
+func (dh DataHandler) GetInviteeFriendFromID(ctx context.Context, id string) (entities.InviteeFriend, error) {
 	var friend entities.InviteeFriend
 	var count int
 
-	db := dh.conn.Where("invitee_friend_id = ?", id).First(&friend).Count(&count)
+	db := dh.db(ctx).Where("invitee_friend_id = ?", id).First(&friend).Count(&count)
 
 	if count == 0 {
 		return entities.InviteeFriend{}, nil
@@ -458,7 +690,7 @@ func (dh DataHandler) GetInviteeFriendFromID(id string) (entities.InviteeFriend,
 		return entities.InviteeFriend{}, db.Error
 	}
 
-	friend.Self, db.Error = dh.getGuestFromID(friend.FkGuestID)
+	friend.Self, db.Error = dh.getGuestFromID(ctx, friend.FkGuestID)
 
 	return friend, db.Error
 }
@@ -466,11 +698,11 @@ func (dh DataHandler) GetInviteeFriendFromID(id string) (entities.InviteeFriend,
 // GetMenuItemsForEvent gets the menu items for an event based on the
 // event id eventID. It returns a slice of menu items and any errors that
 // occured.
-func (dh DataHandler) GetMenuItemsForEvent(eventID string) ([]entities.MenuItem, error) {
+func (dh DataHandler) GetMenuItemsForEvent(ctx context.Context, eventID string) ([]entities.MenuItem, error) {
 	var items = []entities.MenuItem{}
 	var count int
 
-	db := dh.conn.Where("fk_event_id = ?", eventID).Find(&items).Count(&count)
+	db := dh.db(ctx).Where("fk_event_id = ?", eventID).Find(&items).Count(&count)
 
 	if db.Error != nil {
 		return []entities.MenuItem{}, db.Error
@@ -478,16 +710,16 @@ func (dh DataHandler) GetMenuItemsForEvent(eventID string) ([]entities.MenuItem,
 		return []entities.MenuItem{}, errors.New("record not found")
 	}
 
-	return dh.addMenuItemOptionsToMenuItems(items)
+	return dh.addMenuItemOptionsToMenuItems(ctx, items)
 }
 
 // addMenuItemOptionsToMenuItems adds all of the possible options for a
 // menu item to that item object in the supplied entities.MenuItem slice.
 // It returns a slice of items with the options added and any error that
 // occured.
-func (dh DataHandler) addMenuItemOptionsToMenuItems(items []entities.MenuItem) ([]entities.MenuItem, error) {
+func (dh DataHandler) addMenuItemOptionsToMenuItems(ctx context.Context, items []entities.MenuItem) ([]entities.MenuItem, error) {
 	for key, value := range items {
-		newItem, err := dh.addMenuItemOptionToMenuItem(value)
+		newItem, err := dh.addMenuItemOptionToMenuItem(ctx, value)
 
 		if err != nil {
 			return items, err
@@ -502,8 +734,8 @@ func (dh DataHandler) addMenuItemOptionsToMenuItems(items []entities.MenuItem) (
 // addMenuItemOptionToMenuItem adds the possible options for a menu item to
 // the supplied entities.MenuItem object. It returns the item with the options
 // added and any error that occured.
-func (dh DataHandler) addMenuItemOptionToMenuItem(item entities.MenuItem) (entities.MenuItem, error) {
-	opts, err := dh.getMenuItemOptionsForMenuItemID(item.MenuItemID)
+func (dh DataHandler) addMenuItemOptionToMenuItem(ctx context.Context, item entities.MenuItem) (entities.MenuItem, error) {
+	opts, err := dh.getMenuItemOptionsForMenuItemID(ctx, item.MenuItemID)
 
 	if err != nil {
 		return item, err
@@ -517,11 +749,11 @@ func (dh DataHandler) addMenuItemOptionToMenuItem(item entities.MenuItem) (entit
 // getMenuItemOptionsForMenuItemID gets the menu item options associated with
 // the supplied menuItemID. It returns a slice of the entities.MenuItemOptions
 // and any error that occured.
-func (dh DataHandler) getMenuItemOptionsForMenuItemID(menuItemID string) ([]entities.MenuItemOption, error) {
+func (dh DataHandler) getMenuItemOptionsForMenuItemID(ctx context.Context, menuItemID string) ([]entities.MenuItemOption, error) {
 	var opts []entities.MenuItemOption
 	var count int
 
-	db := dh.conn.Table("menu_item_options").Where("fk_menu_item_id = ?", menuItemID).Find(&opts).Count(&count)
+	db := dh.db(ctx).Table("menu_item_options").Where("fk_menu_item_id = ?", menuItemID).Find(&opts).Count(&count)
 
 	if count == 0 {
 		return []entities.MenuItemOption{}, nil
@@ -530,10 +762,10 @@ func (dh DataHandler) getMenuItemOptionsForMenuItemID(menuItemID string) ([]enti
 	return opts, db.Error
 }
 
-func (dh DataHandler) SetGuestMenuChoices(guestID string, choices []entities.MenuChoice) ([]entities.MenuChoice, error) {
+func (dh DataHandler) SetGuestMenuChoices(ctx context.Context, guestID string, choices []entities.MenuChoice) ([]entities.MenuChoice, error) {
 	// delete all the current choices
 	//  get all the current choices
-	oldChoices, err := dh.getMenuChoicesForGuestID(guestID)
+	oldChoices, err := dh.getMenuChoicesForGuestID(ctx, guestID)
 
 	if err != nil {
 		return []entities.MenuChoice{}, err
@@ -541,7 +773,7 @@ func (dh DataHandler) SetGuestMenuChoices(guestID string, choices []entities.Men
 
 	if len(oldChoices) > 0 {
 		for _, value := range oldChoices {
-			db := dh.conn.Delete(value)
+			db := dh.db(ctx).Delete(value)
 
 			if db.Error != nil {
 				return []entities.MenuChoice{}, db.Error
@@ -551,7 +783,7 @@ func (dh DataHandler) SetGuestMenuChoices(guestID string, choices []entities.Men
 
 	// add the new choices
 	for key, value := range choices {
-		db := dh.conn.Create(&value)
+		db := dh.db(ctx).Create(&value)
 
 		if db.Error != nil {
 			return []entities.MenuChoice{}, db.Error
@@ -563,16 +795,16 @@ func (dh DataHandler) SetGuestMenuChoices(guestID string, choices []entities.Men
 	return choices, nil
 }
 
-func (dh DataHandler) SetGuestMenuNote(guestID string, note entities.MenuNote) (entities.MenuNote, error) {
+func (dh DataHandler) SetGuestMenuNote(ctx context.Context, guestID string, note entities.MenuNote) (entities.MenuNote, error) {
 	// delete the current note
-	oldNote, err := dh.getMenuNoteForGuestID(guestID)
+	oldNote, err := dh.getMenuNoteForGuestID(ctx, guestID)
 
 	if err != nil {
 		return entities.MenuNote{}, err
 	}
 
 	if oldNote.MenuNoteID != "" {
-		db := dh.conn.Delete(oldNote)
+		db := dh.db(ctx).Delete(oldNote)
 
 		if db.Error != nil {
 			return entities.MenuNote{}, db.Error
@@ -580,7 +812,7 @@ func (dh DataHandler) SetGuestMenuNote(guestID string, note entities.MenuNote) (
 	}
 
 	// add the new note
-	db := dh.conn.Create(&note)
+	db := dh.db(ctx).Create(&note)
 
 	if db.Error != nil {
 		return entities.MenuNote{}, db.Error
@@ -589,11 +821,11 @@ func (dh DataHandler) SetGuestMenuNote(guestID string, note entities.MenuNote) (
 	return note, nil
 }
 
-func (dh DataHandler) getMenuNoteForGuestID(guestID string) (entities.MenuNote, error) {
+func (dh DataHandler) getMenuNoteForGuestID(ctx context.Context, guestID string) (entities.MenuNote, error) {
 	var note entities.MenuNote
 	var count int
 
-	db := dh.conn.Where("fk_guest_id = ?", guestID).Find(&note).Count(&count)
+	db := dh.db(ctx).Where("fk_guest_id = ?", guestID).Find(&note).Count(&count)
 
 	if count == 0 {
 		return entities.MenuNote{}, nil
@@ -602,11 +834,11 @@ func (dh DataHandler) getMenuNoteForGuestID(guestID string) (entities.MenuNote,
 	return note, db.Error
 }
 
-func (dh DataHandler) getInviteeSeatingRequestsForInviteeID(inviteeID string) ([]entities.InviteeSeatingRequest, error) {
+func (dh DataHandler) getInviteeSeatingRequestsForInviteeID(ctx context.Context, inviteeID string) ([]entities.InviteeSeatingRequest, error) {
 	var requests []entities.InviteeSeatingRequest
 	var count int
 
-	db := dh.conn.Where("fk_invitee_id = ?", inviteeID).Find(&requests).Count(&count)
+	db := dh.db(ctx).Where("fk_invitee_id = ?", inviteeID).Find(&requests).Count(&count)
 
 	if count == 0 {
 		return []entities.InviteeSeatingRequest{}, nil
@@ -615,9 +847,9 @@ func (dh DataHandler) getInviteeSeatingRequestsForInviteeID(inviteeID string) ([
 	return requests, db.Error
 }
 
-func (dh DataHandler) SetInviteeSeatingRequests(inviteeID string, requests []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, error) {
+func (dh DataHandler) SetInviteeSeatingRequests(ctx context.Context, inviteeID string, requests []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, error) {
 	// delete all the current requests
-	oldRequests, err := dh.getInviteeSeatingRequestsForInviteeID(inviteeID)
+	oldRequests, err := dh.getInviteeSeatingRequestsForInviteeID(ctx, inviteeID)
 
 	if err != nil {
 		return []entities.InviteeSeatingRequest{}, err
@@ -625,7 +857,7 @@ func (dh DataHandler) SetInviteeSeatingRequests(inviteeID string, requests []ent
 
 	if len(oldRequests) > 0 {
 		for _, value := range oldRequests {
-			db := dh.conn.Delete(value)
+			db := dh.db(ctx).Delete(value)
 
 			if db.Error != nil {
 				return []entities.InviteeSeatingRequest{}, db.Error
@@ -635,7 +867,7 @@ func (dh DataHandler) SetInviteeSeatingRequests(inviteeID string, requests []ent
 
 	// add the new requests
 	for key, value := range requests {
-		db := dh.conn.Create(&value)
+		db := dh.db(ctx).Create(&value)
 
 		if db.Error != nil {
 			return []entities.InviteeSeatingRequest{}, db.Error
@@ -653,11 +885,11 @@ type getInviteesForRequest struct {
 	LastName  string
 }
 
-func (dh DataHandler) GetSeatingRequestInviteesForEvent(eventID string) ([]entities.Invitee, error) {
+func (dh DataHandler) GetSeatingRequestInviteesForEvent(ctx context.Context, eventID string) ([]entities.Invitee, error) {
 	var getStuff []getInviteesForRequest
 	invitees := []entities.Invitee{}
 
-	db := dh.conn.Table("invitees").Select("invitees.invitee_id, guests.first_name, guests.last_name").Joins("left join guests on guests.guest_id = invitees.fk_guest_id").Where("invitees.fk_event_id = ?", eventID).Scan(&getStuff)
+	db := dh.db(ctx).Table("invitees").Select("invitees.invitee_id, guests.first_name, guests.last_name").Joins("left join guests on guests.guest_id = invitees.fk_guest_id").Where("invitees.fk_event_id = ?", eventID).Scan(&getStuff)
 
 	if db.Error != nil {
 		return []entities.Invitee{}, db.Error
@@ -682,27 +914,57 @@ func (dh DataHandler) GetSeatingRequestInviteesForEvent(eventID string) ([]entit
 
 // GetUserLoginFromEmail gets a userlogin object from the database that relates
 // to a user with the specified email address
-func (dh DataHandler) GetUserLoginFromEmail(email string) (entities.UserLogin, error) {
+func (dh DataHandler) GetUserLoginFromEmail(ctx context.Context, email string) (entities.UserLogin, error) {
 	user := new(entities.User)
 
-	db := dh.conn.Where("email = ?", email).First(&user)
+	db := dh.db(ctx).Where("email = ?", email).First(&user)
 
 	if db.Error != nil {
 		return entities.UserLogin{}, db.Error
 	}
 
 	findMe := new(entities.UserLogin)
-	db = dh.conn.Where("fk_user_id = ?", user.UserID).First(&findMe)
+	db = dh.db(ctx).Where("fk_user_id = ?", user.UserID).First(&findMe)
 
 	return *findMe, db.Error
 }
 
+// RecordFailedLogin adds one to the failed logins of the user login with the id
+// userLoginID and returns the new count.
+func (dh DataHandler) RecordFailedLogin(ctx context.Context, userLoginID string) (int, error) {
+	db := dh.db(ctx).Model(entities.UserLogin{}).Where("user_login_id = ?", userLoginID).UpdateColumn("failed_logins", gorm.Expr("failed_logins + 1"))
+
+	if db.Error != nil {
+		return 0, db.Error
+	}
+
+	findMe := entities.UserLogin{}
+	db = dh.db(ctx).Where("user_login_id = ?", userLoginID).First(&findMe)
+
+	return findMe.FailedLogins, db.Error
+}
+
+// LockUserLogin stops the user login with the id userLoginID being used until
+// until.
+func (dh DataHandler) LockUserLogin(ctx context.Context, userLoginID string, until time.Time) error {
+	return dh.db(ctx).Model(entities.UserLogin{}).Where("user_login_id = ?", userLoginID).UpdateColumn("locked_until", until).Error
+}
+
+// ResetFailedLogins clears the failed logins and lock of the user login with
+// the id userLoginID.
+func (dh DataHandler) ResetFailedLogins(ctx context.Context, userLoginID string) error {
+	return dh.db(ctx).Model(entities.UserLogin{}).Where("user_login_id = ?", userLoginID).UpdateColumns(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+}
+
 // GetEventAdminRecordForUserAndEventID gets the event admin record that
 // contains both the user id UserID and the event id EventID.
-func (dh DataHandler) GetEventAdminRecordForUserAndEventID(userID string, eventID string) (entities.EventAdmin, error) {
+func (dh DataHandler) GetEventAdminRecordForUserAndEventID(ctx context.Context, userID string, eventID string) (entities.EventAdmin, error) {
 	var eAdmin entities.EventAdmin
 
-	db := dh.conn.Where("fk_user_id = ? and fk_event_id = ?", userID, eventID).Find(&eAdmin)
+	db := dh.db(ctx).Where("fk_user_id = ? and fk_event_id = ?", userID, eventID).Find(&eAdmin)
 
 	return eAdmin, db.Error
 }
@@ -0,0 +1,135 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// GetEmailTemplatesForEvent gets the email templates the admins of the event
+// with the id eventID have changed.
+func (dh DataHandler) GetEmailTemplatesForEvent(ctx context.Context, eventID string) ([]entities.EmailTemplate, error) {
+	templates := []entities.EmailTemplate{}
+
+	db := dh.db(ctx).Where("fk_event_id = ?", eventID).Order("kind").Find(&templates)
+
+	return templates, db.Error
+}
+
+// SaveEmailTemplate creates or replaces the email template of its kind for
+// its event.
+func (dh DataHandler) SaveEmailTemplate(ctx context.Context, template entities.EmailTemplate) error {
+	_, err := dh.conn.DB().ExecContext(ctx, `INSERT INTO email_templates (fk_event_id, kind, subject, body) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (fk_event_id, kind) DO UPDATE SET subject = EXCLUDED.subject, body = EXCLUDED.body`,
+		template.FkEventID, template.Kind, template.Subject, template.Body)
+
+	return err
+}
+
+// DeleteEmailTemplate deletes the email template of the kind kind for the
+// event with the id eventID, so the default one is used again.
+func (dh DataHandler) DeleteEmailTemplate(ctx context.Context, eventID string, kind string) error {
+	return dh.db(ctx).Where("fk_event_id = ? AND kind = ?", eventID, kind).Delete(entities.EmailTemplate{}).Error
+}
+
+// GetInviteesMatching gets all the invitees of the event with the id eventID
+// that match filter, with their guests and friends. The filter's sort is
+// ignored.
+func (dh DataHandler) GetInviteesMatching(ctx context.Context, eventID string, filter entities.InviteeFilter) ([]entities.Invitee, error) {
+	var ids []string
+
+	db := filterInvitees(dh.db(ctx), eventID, filter).Order("i.email, i.invitee_id").Pluck("i.invitee_id", &ids)
+
+	if db.Error != nil {
+		return []entities.Invitee{}, db.Error
+	}
+
+	return dh.getInviteesInOrder(ctx, ids)
+}
+
+// CreateEmailDeliveries records deliveries, all or none of them.
+func (dh DataHandler) CreateEmailDeliveries(ctx context.Context, deliveries []*entities.EmailDelivery) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		for _, delivery := range deliveries {
+			if err := tx.Create(delivery).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetEmailDeliveryStatus records how the delivery with the id deliveryID
+// went. Deliveries that were sent are marked sent now.
+func (dh DataHandler) SetEmailDeliveryStatus(ctx context.Context, deliveryID string, status string, reason string) error {
+	_, err := dh.conn.DB().ExecContext(ctx, `UPDATE email_deliveries
+		SET status = $2, error = $3, sent_at = CASE WHEN $2 = 'sent' THEN now() ELSE sent_at END
+		WHERE email_delivery_id = $1`, deliveryID, status, reason)
+
+	return err
+}
+
+// email deliveries are listed newest first
+var emailDeliveryKeyset = keyset{id: "email_delivery_id", keys: []sortKey{{expr: "created_at", desc: true}}}
+
+// GetEmailDeliveriesForEvent gets a page of the email deliveries of the event
+// with the id eventID that match filter, newest first.
+func (dh DataHandler) GetEmailDeliveriesForEvent(ctx context.Context, eventID string, filter entities.EmailDeliveryFilter, page entities.Page) ([]entities.EmailDelivery, entities.PageBounds, error) {
+	ids, bounds, err := emailDeliveryKeyset.page(filterEmailDeliveries(dh.db(ctx).Model(entities.EmailDelivery{}), eventID, filter), page)
+
+	if err != nil || len(ids) == 0 {
+		return []entities.EmailDelivery{}, bounds, err
+	}
+
+	found := []entities.EmailDelivery{}
+
+	if db := dh.db(ctx).Where("email_delivery_id IN (?)", ids).Find(&found); db.Error != nil {
+		return []entities.EmailDelivery{}, bounds, db.Error
+	}
+
+	byID := make(map[string]entities.EmailDelivery, len(found))
+
+	for _, delivery := range found {
+		byID[delivery.EmailDeliveryID] = delivery
+	}
+
+	deliveries := make([]entities.EmailDelivery, 0, len(ids))
+
+	for _, id := range ids {
+		deliveries = append(deliveries, byID[id])
+	}
+
+	return deliveries, bounds, nil
+}
+
+// GetNumberOfEmailDeliveriesForEvent counts the email deliveries of the event
+// with the id eventID that match filter.
+func (dh DataHandler) GetNumberOfEmailDeliveriesForEvent(ctx context.Context, eventID string, filter entities.EmailDeliveryFilter) (int, error) {
+	var count int
+
+	db := filterEmailDeliveries(dh.db(ctx).Model(entities.EmailDelivery{}), eventID, filter).Count(&count)
+
+	return count, db.Error
+}
+
+func filterEmailDeliveries(db *gorm.DB, eventID string, filter entities.EmailDeliveryFilter) *gorm.DB {
+	db = db.Where("fk_event_id = ?", eventID)
+
+	if filter.InviteeID != "" {
+		// compare as text so an id that isn't a uuid matches nothing rather
+		// than being an error
+		db = db.Where("fk_invitee_id::text = ?", filter.InviteeID)
+	}
+
+	if filter.Kind != "" {
+		db = db.Where("kind = ?", filter.Kind)
+	}
+
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+
+	return db
+}
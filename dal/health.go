@@ -0,0 +1,32 @@
+package dal
+
+import "context"
+
+// SchemaVersion is the version of the database schema this build of the code
+// expects. It must match the highest version in the schema_versions table.
+const SchemaVersion = 15
+
+type schemaVersion struct {
+	Version int
+}
+
+// Ping checks that the database can be reached.
+func (dh DataHandler) Ping(ctx context.Context) error {
+	return dh.conn.DB().PingContext(ctx)
+}
+
+// GetSchemaVersion gets the highest schema version that has been applied to
+// the database.
+func (dh DataHandler) GetSchemaVersion(ctx context.Context) (int, error) {
+	var sv schemaVersion
+
+	db := dh.db(ctx).Table("schema_versions").Select("max(version) AS version").Scan(&sv)
+
+	return sv.Version, db.Error
+}
+
+// GetExpectedSchemaVersion gets the schema version this build of the code
+// expects the database to be at.
+func (dh DataHandler) GetExpectedSchemaVersion() int {
+	return SchemaVersion
+}
@@ -0,0 +1,142 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// RestoreInvitee puts the invitee back the way it is in snapshot: its email,
+// the details, menu choices and menu notes of it and its friends, which
+// friends it has and its seating requests. Friends added since the snapshot
+// are deleted and ones that have gone since are created again with their old
+// ids. It all happens in one transaction.
+func (dh DataHandler) RestoreInvitee(ctx context.Context, snapshot entities.Invitee) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		return restoreInvitee(tx, snapshot)
+	})
+}
+
+func restoreInvitee(tx *gorm.DB, snapshot entities.Invitee) error {
+	err := tx.Model(entities.Invitee{}).Where("invitee_id = ?", snapshot.InviteeID).UpdateColumn("email", snapshot.Email).Error
+
+	if err != nil {
+		return err
+	}
+
+	if err := restoreGuest(tx, snapshot.Self); err != nil {
+		return err
+	}
+
+	var current []entities.InviteeFriend
+
+	if err := tx.Where("fk_invitee_id = ?", snapshot.InviteeID).Find(&current).Error; err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+
+	for _, friend := range snapshot.Friends {
+		keep[friend.InviteeFriendID] = true
+	}
+
+	exists := make(map[string]bool)
+
+	for _, friend := range current {
+		if keep[friend.InviteeFriendID] {
+			exists[friend.InviteeFriendID] = true
+		} else if err := deleteInviteeFriend(tx, friend); err != nil {
+			return err
+		}
+	}
+
+	for _, friend := range snapshot.Friends {
+		if !exists[friend.InviteeFriendID] {
+			err := tx.Create(&entities.Guest{GuestID: friend.Self.GuestID, FirstName: friend.Self.FirstName, LastName: friend.Self.LastName}).Error
+
+			if err != nil {
+				return err
+			}
+
+			err = tx.Create(&entities.InviteeFriend{InviteeFriendID: friend.InviteeFriendID, FkInviteeID: snapshot.InviteeID, FkGuestID: friend.Self.GuestID}).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := restoreGuest(tx, friend.Self); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("fk_invitee_id = ?", snapshot.InviteeID).Delete(entities.InviteeSeatingRequest{}).Error; err != nil {
+		return err
+	}
+
+	for _, request := range snapshot.SeatingRequests {
+		err := tx.Create(&entities.InviteeSeatingRequest{FkInviteeID: snapshot.InviteeID, FkInviteeRequestID: request.FkInviteeRequestID}).Error
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreGuest puts the details, menu choices and menu note of the guest back
+// the way they are in snapshot.
+func restoreGuest(tx *gorm.DB, snapshot entities.Guest) error {
+	err := tx.Model(entities.Guest{}).Where("guest_id = ?", snapshot.GuestID).UpdateColumns(map[string]interface{}{
+		"first_name":  snapshot.FirstName,
+		"last_name":   snapshot.LastName,
+		"attending":   snapshot.Attending,
+		"rsvp_status": snapshot.RSVPStatus,
+	}).Error
+
+	if err != nil {
+		return err
+	}
+
+	if err := deleteGuestMenuInfo(tx, snapshot.GuestID); err != nil {
+		return err
+	}
+
+	for _, choice := range snapshot.MenuChoices {
+		err := tx.Create(&entities.MenuChoice{FkGuestID: snapshot.GuestID, FkMenuItemID: choice.FkMenuItemID, FkMenuItemOptionID: choice.FkMenuItemOptionID}).Error
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if snapshot.MenuNote != "" {
+		return tx.Create(&entities.MenuNote{FkGuestID: snapshot.GuestID, NoteBody: snapshot.MenuNote}).Error
+	}
+
+	return nil
+}
+
+// deleteInviteeFriend deletes the invitee friend, its guest and the guest's
+// menu choices and note.
+func deleteInviteeFriend(tx *gorm.DB, friend entities.InviteeFriend) error {
+	if err := deleteGuestMenuInfo(tx, friend.FkGuestID); err != nil {
+		return err
+	}
+
+	if err := tx.Where("invitee_friend_id = ?", friend.InviteeFriendID).Delete(entities.InviteeFriend{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("guest_id = ?", friend.FkGuestID).Delete(entities.Guest{}).Error
+}
+
+func deleteGuestMenuInfo(tx *gorm.DB, guestID string) error {
+	if err := tx.Where("fk_guest_id = ?", guestID).Delete(entities.MenuChoice{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("fk_guest_id = ?", guestID).Delete(entities.MenuNote{}).Error
+}
@@ -0,0 +1,106 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/grounded042/capacious/idempotency"
+)
+
+// how many keys the idempotency store claims between deleting expired ones
+const idempotencySweepEvery = 1000
+
+// idempotencyStore keeps idempotency records in the idempotency_keys table so
+// retries are recognised by every process using the database.
+type idempotencyStore struct {
+	dh     DataHandler
+	begins *int64
+}
+
+// IdempotencyStore gets an idempotency.Store backed by the database.
+func (dh DataHandler) IdempotencyStore() idempotency.Store {
+	return idempotencyStore{dh: dh, begins: new(int64)}
+}
+
+// Begin implements idempotency.Store. A key whose record has expired is
+// claimed again as if it had never been used.
+func (s idempotencyStore) Begin(ctx context.Context, key string, requestHash string, expires time.Time) (idempotency.Record, bool, error) {
+	db := s.dh.conn.DB()
+
+	if atomic.AddInt64(s.begins, 1)%idempotencySweepEvery == 0 {
+		if _, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()"); err != nil {
+			return idempotency.Record{}, false, err
+		}
+	}
+
+	// the key can be released between the insert and the select, in which
+	// case it is tried again
+	for attempt := 0; attempt < 2; attempt++ {
+		var claimed string
+
+		err := db.QueryRowContext(ctx, `INSERT INTO idempotency_keys (key, request_hash, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = NULL, header = NULL, body = NULL, expires_at = EXCLUDED.expires_at, created_at = now()
+			WHERE idempotency_keys.expires_at <= now()
+			RETURNING key`, key, requestHash, expires).Scan(&claimed)
+
+		if err == nil {
+			return idempotency.Record{}, true, nil
+		}
+
+		if err != sql.ErrNoRows {
+			return idempotency.Record{}, false, err
+		}
+
+		var rec idempotency.Record
+		var status sql.NullInt64
+		var header []byte
+		var body []byte
+
+		err = db.QueryRowContext(ctx, "SELECT request_hash, status, header, body FROM idempotency_keys WHERE key = $1", key).Scan(&rec.RequestHash, &status, &header, &body)
+
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return idempotency.Record{}, false, err
+		}
+
+		if status.Valid {
+			res := idempotency.Response{Status: int(status.Int64), Body: body}
+
+			if err = json.Unmarshal(header, &res.Header); err != nil {
+				return idempotency.Record{}, false, err
+			}
+
+			rec.Response = &res
+		}
+
+		return rec, false, nil
+	}
+
+	return idempotency.Record{}, false, sql.ErrNoRows
+}
+
+// Complete implements idempotency.Store.
+func (s idempotencyStore) Complete(ctx context.Context, key string, res idempotency.Response) error {
+	header, err := json.Marshal(res.Header)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.dh.conn.DB().ExecContext(ctx, "UPDATE idempotency_keys SET status = $2, header = $3, body = $4 WHERE key = $1", key, res.Status, string(header), res.Body)
+
+	return err
+}
+
+// Release implements idempotency.Store.
+func (s idempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.dh.conn.DB().ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL", key)
+
+	return err
+}
@@ -0,0 +1,175 @@
+package dal
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// inviteeSortColumns gets the columns invitees are sorted by for an
+// entities.InviteeSort field. None of them can be null: invitees that haven't
+// responded come last whichever way they're sorted by when they did, and
+// ones without an update time sort as if it were the latest.
+func inviteeSortColumns(field string, desc bool) []string {
+	switch field {
+	case entities.InviteeSortName:
+		return []string{"lower(g.last_name)", "lower(g.first_name)"}
+	case entities.InviteeSortEmail:
+		return []string{"i.email"}
+	case entities.InviteeSortRespondedAt:
+		if desc {
+			return []string{"coalesce(i.responded_at, '-infinity')"}
+		}
+
+		return []string{"coalesce(i.responded_at, 'infinity')"}
+	case entities.InviteeSortUpdatedAt:
+		return []string{"coalesce(i.updated_at, 'infinity')"}
+	}
+
+	return nil
+}
+
+// filterInvitees narrows db down to the invitees of the event with the id
+// eventID that match filter. The invitees are i and their guests g.
+func filterInvitees(db *gorm.DB, eventID string, filter entities.InviteeFilter) *gorm.DB {
+	db = db.Table("invitees i").Joins("JOIN guests g ON g.guest_id = i.fk_guest_id").Where("i.fk_event_id = ?", eventID)
+
+	if filter.RSVP != "" {
+		db = db.Where("g.rsvp_status = ?", filter.RSVP)
+	}
+
+	if filter.HasMenuChoices != nil {
+		db = db.Where(exists(*filter.HasMenuChoices, "SELECT 1 FROM menu_choices mc WHERE mc.fk_guest_id = i.fk_guest_id"))
+	}
+
+	if filter.HasMenuNote != nil {
+		db = db.Where(exists(*filter.HasMenuNote, "SELECT 1 FROM menu_notes mn WHERE mn.fk_guest_id = i.fk_guest_id AND mn.note_body <> ''"))
+	}
+
+	const friends = "(SELECT count(*) FROM invitee_friends f WHERE f.fk_invitee_id = i.invitee_id)"
+
+	if filter.Friends != nil {
+		db = db.Where(friends+" = ?", *filter.Friends)
+	}
+
+	if filter.MinFriends != nil {
+		db = db.Where(friends+" >= ?", *filter.MinFriends)
+	}
+
+	if filter.MaxFriends != nil {
+		db = db.Where(friends+" <= ?", *filter.MaxFriends)
+	}
+
+	if len(filter.Tags) > 0 {
+		db = db.Where("EXISTS (SELECT 1 FROM invitee_tags t WHERE t.fk_invitee_id = i.invitee_id AND t.tag IN (?))", filter.Tags)
+	}
+
+	if query := searchQuery(filter.Search); query != "" {
+		db = db.Where("i.search @@ to_tsquery('simple', ?)", query)
+	}
+
+	return db
+}
+
+func exists(want bool, query string) string {
+	if want {
+		return "EXISTS (" + query + ")"
+	}
+
+	return "NOT EXISTS (" + query + ")"
+}
+
+// inviteeKeyset gets the keyset invitees are ordered by for sorts. Ties are
+// broken by email so pages don't overlap.
+func inviteeKeyset(sorts []entities.InviteeSort) keyset {
+	ks := keyset{id: "i.invitee_id"}
+
+	for _, sort := range sorts {
+		for _, column := range inviteeSortColumns(sort.Field, sort.Descending) {
+			ks.keys = append(ks.keys, sortKey{expr: column, desc: sort.Descending})
+		}
+	}
+
+	ks.keys = append(ks.keys, sortKey{expr: "i.email"})
+
+	return ks
+}
+
+// searchQuery turns what was typed into a search into a tsquery matching the
+// start of every word in it, e.g. "sax hal" becomes "sax:* & hal:*".
+func searchQuery(search string) string {
+	words := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// SetInviteeTags replaces the tags of the invitee with the id inviteeID.
+func (dh DataHandler) SetInviteeTags(ctx context.Context, inviteeID string, tags []string) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM invitee_tags WHERE fk_invitee_id = ?", inviteeID).Error
+
+		for _, tag := range tags {
+			if err != nil {
+				break
+			}
+
+			err = tx.Exec("INSERT INTO invitee_tags (fk_invitee_id, tag) VALUES (?, ?)", inviteeID, tag).Error
+		}
+
+		return err
+	})
+}
+
+func (dh DataHandler) getInviteeTags(ctx context.Context, inviteeID string) ([]string, error) {
+	var tags []string
+
+	db := dh.db(ctx).Table("invitee_tags").Where("fk_invitee_id = ?", inviteeID).Order("tag").Pluck("tag", &tags)
+
+	return tags, db.Error
+}
+
+type inviteeTag struct {
+	FkInviteeID string
+	Tag         string
+}
+
+// addInviteeTagsToInvitees loads the tags of all of the invitees in list in
+// one query.
+func (dh DataHandler) addInviteeTagsToInvitees(ctx context.Context, list []entities.Invitee) ([]entities.Invitee, error) {
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	ids := make([]string, len(list))
+
+	for i, invitee := range list {
+		ids[i] = invitee.InviteeID
+	}
+
+	var rows []inviteeTag
+
+	if db := dh.db(ctx).Table("invitee_tags").Select("fk_invitee_id, tag").Where("fk_invitee_id IN (?)", ids).Order("tag").Scan(&rows); db.Error != nil {
+		return []entities.Invitee{}, db.Error
+	}
+
+	tags := map[string][]string{}
+
+	for _, row := range rows {
+		tags[row.FkInviteeID] = append(tags[row.FkInviteeID], row.Tag)
+	}
+
+	for key, invitee := range list {
+		list[key].Tags = tags[invitee.InviteeID]
+	}
+
+	return list, nil
+}
@@ -0,0 +1,161 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/grounded042/capacious/jobs"
+)
+
+// jobStore keeps the job queue in the jobs table so every process using the
+// database works through the same jobs.
+type jobStore struct {
+	dh DataHandler
+}
+
+// JobStore gets a jobs.Store backed by the database.
+func (dh DataHandler) JobStore() jobs.Store {
+	return jobStore{dh: dh}
+}
+
+// Enqueue implements jobs.Store. The job is added in the transaction ctx
+// carries, if it does, so it is only queued if the transaction commits.
+func (s jobStore) Enqueue(ctx context.Context, job jobs.NewJob) (bool, error) {
+	payload, err := json.Marshal(job.Payload)
+
+	if err != nil {
+		return false, err
+	}
+
+	runAt := job.RunAt
+
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	maxAttempts := job.MaxAttempts
+
+	if maxAttempts == 0 {
+		maxAttempts = jobs.DefaultMaxAttempts
+	}
+
+	uniqueKey := sql.NullString{String: job.UniqueKey, Valid: job.UniqueKey != ""}
+
+	res, err := s.dh.sqlDB(ctx).ExecContext(ctx, `INSERT INTO jobs (kind, payload, unique_key, run_at, max_attempts) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (unique_key) DO NOTHING`, job.Kind, payload, uniqueKey, runAt, maxAttempts)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n == 1, err
+}
+
+// Claim implements jobs.Store. Jobs are claimed in the order they are due;
+// running jobs whose lease ran out are claimed again.
+func (s jobStore) Claim(ctx context.Context, lease time.Duration) (jobs.Job, error) {
+	var job jobs.Job
+	var payload []byte
+
+	err := s.dh.conn.DB().QueryRowContext(ctx, `UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = now() + make_interval(secs => $1)
+		WHERE job_id = (
+			SELECT job_id FROM jobs
+			WHERE (status = 'queued' AND run_at <= now()) OR (status = 'running' AND locked_until <= now())
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING job_id, kind, payload, attempts, max_attempts`, lease.Seconds()).Scan(&job.ID, &job.Kind, &payload, &job.Attempt, &job.MaxAttempts)
+
+	if err == sql.ErrNoRows {
+		return jobs.Job{}, jobs.ErrNoJob
+	}
+
+	job.Payload = payload
+
+	return job, err
+}
+
+// Complete implements jobs.Store.
+func (s jobStore) Complete(ctx context.Context, jobID string) error {
+	_, err := s.dh.conn.DB().ExecContext(ctx, `UPDATE jobs SET status = 'done', locked_until = NULL, finished_at = now() WHERE job_id = $1`, jobID)
+
+	return err
+}
+
+// Retry implements jobs.Store.
+func (s jobStore) Retry(ctx context.Context, jobID string, reason string, runAt time.Time) error {
+	_, err := s.dh.conn.DB().ExecContext(ctx, `UPDATE jobs SET status = 'queued', locked_until = NULL, last_error = $2, run_at = $3 WHERE job_id = $1`, jobID, reason, runAt)
+
+	return err
+}
+
+// Bury implements jobs.Store.
+func (s jobStore) Bury(ctx context.Context, jobID string, reason string) error {
+	_, err := s.dh.conn.DB().ExecContext(ctx, `UPDATE jobs SET status = 'dead', locked_until = NULL, last_error = $2, finished_at = now() WHERE job_id = $1`, jobID, reason)
+
+	return err
+}
+
+// DeadJob is a job that failed for good.
+type DeadJob struct {
+	ID         string
+	Kind       string
+	Payload    string
+	Attempts   int
+	LastError  string
+	FinishedAt time.Time
+}
+
+// GetDeadJobs gets the jobs that failed for good, most recent first.
+func (dh DataHandler) GetDeadJobs(ctx context.Context, limit int) ([]DeadJob, error) {
+	rows, err := dh.conn.DB().QueryContext(ctx, `SELECT job_id, kind, payload::text, attempts, last_error, finished_at
+		FROM jobs WHERE status = 'dead' ORDER BY finished_at DESC LIMIT $1`, limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	dead := []DeadJob{}
+
+	for rows.Next() {
+		var job DeadJob
+
+		if err := rows.Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.LastError, &job.FinishedAt); err != nil {
+			return nil, err
+		}
+
+		dead = append(dead, job)
+	}
+
+	return dead, rows.Err()
+}
+
+// RetryDeadJob puts the dead job with the id jobID back on the queue with
+// its attempts reset. It is false if there is no such dead job.
+func (dh DataHandler) RetryDeadJob(ctx context.Context, jobID string) (bool, error) {
+	res, err := dh.conn.DB().ExecContext(ctx, `UPDATE jobs SET status = 'queued', attempts = 0, run_at = now(), finished_at = NULL
+		WHERE job_id::text = $1 AND status = 'dead'`, jobID)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n == 1, err
+}
+
+// PruneJobs deletes the jobs that were done before before. Dead jobs are kept
+// until they are looked at.
+func (dh DataHandler) PruneJobs(ctx context.Context, before time.Time) error {
+	_, err := dh.conn.DB().ExecContext(ctx, `DELETE FROM jobs WHERE status = 'done' AND finished_at < $1`, before)
+
+	return err
+}
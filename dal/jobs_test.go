@@ -0,0 +1,200 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grounded042/capacious/jobs"
+)
+
+// testJobStore gets a job store on the database the PSQL_* variables point
+// at, with no jobs in it. These tests empty the jobs table, so they only run
+// with CAPACIOUS_TEST_DB set, against a database that is only for testing.
+func testJobStore(t *testing.T) (jobs.Store, DataHandler) {
+	t.Helper()
+
+	if os.Getenv("CAPACIOUS_TEST_DB") == "" {
+		t.Skip("set CAPACIOUS_TEST_DB to run the tests that need a database")
+	}
+
+	dh := NewDal()
+
+	if _, err := dh.conn.DB().Exec(`DELETE FROM jobs`); err != nil {
+		t.Fatal(err)
+	}
+
+	return dh.JobStore(), dh
+}
+
+func TestJobStoreEnqueuesUniqueJobsOnce(t *testing.T) {
+	store, _ := testJobStore(t)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		ok, err := store.Enqueue(ctx, jobs.NewJob{Kind: "test", Payload: i, UniqueKey: "test:unique"})
+
+		if err != nil {
+			t.Fatal(err)
+		} else if ok != want {
+			t.Errorf("enqueue %d: got %t, want %t", i, ok, want)
+		}
+	}
+
+	// jobs without a key are always enqueued
+	for i := 0; i < 2; i++ {
+		if ok, err := store.Enqueue(ctx, jobs.NewJob{Kind: "test"}); err != nil || !ok {
+			t.Errorf("enqueue without a key: got %t, %v", ok, err)
+		}
+	}
+}
+
+func TestJobStoreClaimsEachJobOnce(t *testing.T) {
+	store, _ := testJobStore(t)
+	ctx := context.Background()
+
+	const n = 20
+
+	for i := 0; i < n; i++ {
+		if _, err := store.Enqueue(ctx, jobs.NewJob{Kind: "test", Payload: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// claim them all at once; SKIP LOCKED has each claimed by one claimer
+	claimed := make(chan string, n*2)
+	errs := make(chan error, n*2)
+
+	for i := 0; i < n*2; i++ {
+		go func() {
+			job, err := store.Claim(ctx, time.Minute)
+
+			if errors.Is(err, jobs.ErrNoJob) {
+				errs <- nil
+				return
+			} else if err != nil {
+				errs <- err
+				return
+			}
+
+			claimed <- job.ID
+			errs <- nil
+		}()
+	}
+
+	for i := 0; i < n*2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(claimed)
+
+	seen := map[string]bool{}
+
+	for id := range claimed {
+		if seen[id] {
+			t.Errorf("job %s was claimed twice", id)
+		}
+
+		seen[id] = true
+	}
+
+	if len(seen) != n {
+		t.Errorf("claimed %d jobs, want %d", len(seen), n)
+	}
+}
+
+func TestJobStoreReclaimsJobsWhoseLeaseRanOut(t *testing.T) {
+	store, _ := testJobStore(t)
+	ctx := context.Background()
+
+	if _, err := store.Enqueue(ctx, jobs.NewJob{Kind: "test", MaxAttempts: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Claim(ctx, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the worker that claimed it went away without finishing it
+	time.Sleep(10 * time.Millisecond)
+
+	second, err := store.Claim(ctx, time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.ID != first.ID || second.Attempt != 2 {
+		t.Errorf("reclaimed job %s at attempt %d, want job %s at attempt 2", second.ID, second.Attempt, first.ID)
+	}
+
+	// while its lease lasts nobody else gets it
+	if _, err := store.Claim(ctx, time.Minute); !errors.Is(err, jobs.ErrNoJob) {
+		t.Errorf("claimed a leased job: %v", err)
+	}
+}
+
+func TestJobStoreRetriesAndBuries(t *testing.T) {
+	store, dh := testJobStore(t)
+	ctx := context.Background()
+
+	if _, err := store.Enqueue(ctx, jobs.NewJob{Kind: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := store.Claim(ctx, time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a job retried later isn't due yet
+	if err := store.Retry(ctx, job.ID, "boom", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Claim(ctx, time.Minute); !errors.Is(err, jobs.ErrNoJob) {
+		t.Errorf("claimed a job that isn't due: %v", err)
+	}
+
+	if err := store.Retry(ctx, job.ID, "boom", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = store.Claim(ctx, time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if job.Attempt != 2 {
+		t.Errorf("attempt = %d, want 2", job.Attempt)
+	}
+
+	if err := store.Bury(ctx, job.ID, "gave up"); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, err := dh.GetDeadJobs(ctx, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "gave up" {
+		t.Errorf("dead jobs = %+v, want the buried job", dead)
+	}
+
+	// a dead job can be put back on the queue
+	if ok, err := dh.RetryDeadJob(ctx, job.ID); err != nil || !ok {
+		t.Fatalf("retry dead job: %t, %v", ok, err)
+	}
+
+	if job, err = store.Claim(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if job.Attempt != 1 {
+		t.Errorf("attempt after retrying a dead job = %d, want 1", job.Attempt)
+	}
+}
@@ -0,0 +1,104 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/live"
+	"github.com/grounded042/capacious/logging"
+	"github.com/lib/pq"
+)
+
+// the channel the database announces changes to invitees on, see
+// 0014_live_changes.sql
+const changesChannel = "capacious_changes"
+
+// how often the connection changes are listened on is checked, so a dead one
+// is noticed even when nothing is being changed
+const listenerPingInterval = time.Minute
+
+// ListenForChanges hands the changes to invitees the database announces to
+// publish until ctx is done. Changes made while the connection was lost are
+// missed, so resync is called once it is back.
+func (dh DataHandler) ListenForChanges(ctx context.Context, publish func(live.Change), resync func()) error {
+	logger := logging.FromContext(ctx)
+
+	listener := pq.NewListener(dh.url, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			logger.Warn("lost the connection listening for changes", "error", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Warn("could not connect to listen for changes", "error", err)
+		case pq.ListenerEventReconnected:
+			logger.Info("connected to listen for changes again")
+		}
+	})
+
+	defer listener.Close()
+
+	if err := listener.Listen(changesChannel); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// a nil notification is sent after the connection was got back
+			if n == nil {
+				resync()
+				continue
+			}
+
+			var change live.Change
+
+			if err := json.Unmarshal([]byte(n.Extra), &change); err != nil {
+				logger.Warn("could not read a change", "payload", n.Extra, "error", err)
+				continue
+			}
+
+			publish(change)
+		case <-ping.C:
+			// a failed ping makes the listener reconnect
+			go listener.Ping()
+		}
+	}
+}
+
+// GetInviteesOfEvent gets those of the invitees with the ids ids that are
+// still invitees of the event with the id eventID, in the same order.
+func (dh DataHandler) GetInviteesOfEvent(ctx context.Context, eventID string, ids []string) ([]entities.Invitee, error) {
+	var found []string
+
+	if len(ids) == 0 {
+		return []entities.Invitee{}, nil
+	}
+
+	db := dh.db(ctx).Table("invitees").Where("fk_event_id = ? AND invitee_id IN (?)", eventID, ids).Pluck("invitee_id", &found)
+
+	if db.Error != nil {
+		return []entities.Invitee{}, db.Error
+	}
+
+	exists := make(map[string]bool, len(found))
+
+	for _, id := range found {
+		exists[id] = true
+	}
+
+	inOrder := make([]string, 0, len(found))
+
+	for _, id := range ids {
+		if exists[id] {
+			inOrder = append(inOrder, id)
+		}
+	}
+
+	return dh.getInviteesInOrder(ctx, inOrder)
+}
@@ -0,0 +1,97 @@
+package dal
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grounded042/capacious/metrics"
+)
+
+var (
+	dbQueries = metrics.NewCounterVec(
+		"capacious_db_queries_total",
+		"Number of SQL statements run, by operation.",
+		"operation",
+	)
+	dbQueryDuration = metrics.NewHistogramVec(
+		"capacious_db_query_duration_seconds",
+		"Time taken to run SQL statements, by operation.",
+		metrics.DefBuckets,
+		"operation",
+	)
+
+	registerPoolGaugesOnce sync.Once
+)
+
+// queryLogger is handed to gorm so that every statement gorm runs is counted,
+// timed and logged at debug level. Anything else gorm logs, i.e. errors, is
+// logged at debug level too since the error is returned to the caller, who is
+// responsible for it.
+type queryLogger struct {
+	log *slog.Logger
+}
+
+func newQueryLogger(l *slog.Logger) queryLogger {
+	return queryLogger{log: l}
+}
+
+// Print receives ("sql", source, duration, statement, vars) for statements and
+// ("log", source, values...) for everything else.
+func (ql queryLogger) Print(values ...interface{}) {
+	if len(values) < 4 || values[0] != "sql" {
+		ql.log.Debug("gorm", "values", fmt.Sprint(values...))
+		return
+	}
+
+	source, _ := values[1].(string)
+	duration, _ := values[2].(time.Duration)
+	statement, _ := values[3].(string)
+	op := queryOperation(statement)
+
+	dbQueries.Inc(op)
+	dbQueryDuration.Observe(duration.Seconds(), op)
+
+	ql.log.Debug("query",
+		"sql", statement,
+		"duration_ms", float64(duration.Nanoseconds())/1e6,
+		"source", source,
+	)
+}
+
+// queryOperation gets the lower cased first keyword of a statement, e.g.
+// select or insert.
+func queryOperation(statement string) string {
+	fields := strings.Fields(statement)
+
+	if len(fields) == 0 {
+		return "unknown"
+	}
+
+	return strings.ToLower(fields[0])
+}
+
+// registerPoolGauges exposes the connection pool stats of db. It only
+// registers once since metrics can't be registered twice.
+func registerPoolGauges(db *sql.DB) {
+	registerPoolGaugesOnce.Do(func() {
+		metrics.NewGaugeFunc("capacious_db_open_connections", "Number of open connections to the database.", func() float64 {
+			return float64(db.Stats().OpenConnections)
+		})
+		metrics.NewGaugeFunc("capacious_db_in_use_connections", "Number of connections currently in use.", func() float64 {
+			return float64(db.Stats().InUse)
+		})
+		metrics.NewGaugeFunc("capacious_db_idle_connections", "Number of idle connections.", func() float64 {
+			return float64(db.Stats().Idle)
+		})
+		metrics.NewGaugeFunc("capacious_db_wait_count", "Total number of connections waited for.", func() float64 {
+			return float64(db.Stats().WaitCount)
+		})
+		metrics.NewGaugeFunc("capacious_db_wait_duration_seconds", "Total time spent waiting for a connection.", func() float64 {
+			return db.Stats().WaitDuration.Seconds()
+		})
+	})
+}
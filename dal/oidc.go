@@ -0,0 +1,91 @@
+package dal
+
+import (
+	"context"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// CreateOidcLoginState saves a login that has been sent to the OIDC provider.
+// Expired login states are deleted at the same time.
+func (dh DataHandler) CreateOidcLoginState(ctx context.Context, createMe *entities.OidcLoginState) error {
+	db := dh.db(ctx).Where("expires_at < ?", time.Now()).Delete(entities.OidcLoginState{})
+
+	if db.Error != nil {
+		return db.Error
+	}
+
+	return dh.db(ctx).Create(createMe).Error
+}
+
+// TakeOidcLoginState gets and deletes the login state with the state state so
+// it can only be used once. gorm.RecordNotFound is returned if there isn't
+// one or it has already been taken.
+func (dh DataHandler) TakeOidcLoginState(ctx context.Context, state string) (entities.OidcLoginState, error) {
+	findMe := entities.OidcLoginState{}
+
+	db := dh.db(ctx).Where("state = ?", state).First(&findMe)
+
+	if db.Error != nil {
+		return findMe, db.Error
+	}
+
+	db = dh.db(ctx).Where("state = ?", state).Delete(entities.OidcLoginState{})
+
+	if db.Error != nil {
+		return findMe, db.Error
+	}
+
+	// someone else took it between the two statements
+	if db.RowsAffected == 0 {
+		return entities.OidcLoginState{}, gorm.RecordNotFound
+	}
+
+	return findMe, nil
+}
+
+// GetUserIdentity gets the identity of the subject subject at the OIDC
+// provider issuer.
+func (dh DataHandler) GetUserIdentity(ctx context.Context, issuer string, subject string) (entities.UserIdentity, error) {
+	findMe := entities.UserIdentity{}
+
+	db := dh.db(ctx).Where("issuer = ? and subject = ?", issuer, subject).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// CreateUserIdentity links a user to their account with an OIDC provider.
+func (dh DataHandler) CreateUserIdentity(ctx context.Context, createMe *entities.UserIdentity) error {
+	return dh.db(ctx).Create(createMe).Error
+}
+
+// GetUserFromEmail gets the user with the email address email.
+func (dh DataHandler) GetUserFromEmail(ctx context.Context, email string) (entities.User, error) {
+	findMe := entities.User{}
+
+	db := dh.db(ctx).Where("lower(email) = lower(?)", email).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// CreateUserWithIdentity creates a user and links them to their account with
+// an OIDC provider. The names of users aren't mapped by gorm, so the user is
+// inserted by hand.
+func (dh DataHandler) CreateUserWithIdentity(ctx context.Context, createMe *entities.User, identity *entities.UserIdentity) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT INTO users (email, first_name, last_name) VALUES (?, ?, ?)", createMe.Email, createMe.FirstName, createMe.LastName).Error
+
+		if err == nil {
+			err = tx.Where("email = ?", createMe.Email).First(createMe).Error
+		}
+
+		if err == nil {
+			identity.FkUserID = createMe.UserID
+			err = tx.Create(identity).Error
+		}
+
+		return err
+	})
+}
@@ -0,0 +1,214 @@
+package dal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// sortKey is an expression a list is ordered by.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// keyset is what a list is ordered by, from the id expression of its rows
+// and the keys it is sorted by. The keys must never be null, and together
+// with the id they must tell every row apart.
+type keyset struct {
+	id   string
+	keys []sortKey
+}
+
+// cursor is what a page cursor holds: the values of the keys and the id of
+// the row it was made for, and which keyset they are from.
+type cursor struct {
+	Keyset string   `json:"k"`
+	Values []string `json:"v"`
+}
+
+// all gets the keys with the id last.
+func (ks keyset) all() []sortKey {
+	return append(append([]sortKey{}, ks.keys...), sortKey{expr: ks.id})
+}
+
+// signature tells keysets apart, so a cursor can't be used on a list sorted
+// another way.
+func (ks keyset) signature() string {
+	var b strings.Builder
+
+	for _, key := range ks.all() {
+		b.WriteString(key.expr)
+		b.WriteString(strconv.FormatBool(key.desc))
+	}
+
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(b.String()))), 36)
+}
+
+// order gets the ORDER BY clause of the keyset, turned around if backwards.
+func (ks keyset) order(backwards bool) string {
+	var order []string
+
+	for _, key := range ks.all() {
+		if key.desc != backwards {
+			order = append(order, key.expr+" DESC")
+		} else {
+			order = append(order, key.expr)
+		}
+	}
+
+	return strings.Join(order, ", ")
+}
+
+// encode makes a cursor for the row with the key values values.
+func (ks keyset) encode(values []string) string {
+	// the values are plain strings, so this can't fail
+	b, _ := json.Marshal(cursor{Keyset: ks.signature(), Values: values})
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decode reads the key values from s, which has to be a cursor made by ks.
+func (ks keyset) decode(s string) ([]string, error) {
+	var c cursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return nil, entities.ErrBadCursor
+	}
+
+	if c.Keyset != ks.signature() || len(c.Values) != len(ks.all()) {
+		return nil, entities.ErrBadCursor
+	}
+
+	return c.Values, nil
+}
+
+// after narrows db down to the rows that come after the row with the key
+// values values, or before it if backwards. For keys a, b and id that is
+// a > ? OR (a = ? AND b > ?) OR (a = ? AND b = ? AND id > ?), with the
+// comparisons flipped for descending keys.
+func (ks keyset) after(db *gorm.DB, values []string, backwards bool) *gorm.DB {
+	var (
+		terms []string
+		args  []interface{}
+	)
+
+	keys := ks.all()
+
+	for n, key := range keys {
+		var conds []string
+
+		for _, equal := range keys[:n] {
+			conds = append(conds, equal.expr+" = ?")
+		}
+
+		if key.desc != backwards {
+			conds = append(conds, key.expr+" < ?")
+		} else {
+			conds = append(conds, key.expr+" > ?")
+		}
+
+		terms = append(terms, "("+strings.Join(conds, " AND ")+")")
+
+		for _, value := range values[:n+1] {
+			args = append(args, value)
+		}
+	}
+
+	return db.Where("("+strings.Join(terms, " OR ")+")", args...)
+}
+
+// page gets the ids of the rows of db on page, in order, and where the page
+// is in the list. db has to be narrowed down to the list already.
+func (ks keyset) page(db *gorm.DB, page entities.Page) ([]string, entities.PageBounds, error) {
+	var bounds entities.PageBounds
+
+	backwards := page.Keyset && page.Backwards
+
+	if page.Keyset && page.Cursor != "" {
+		values, err := ks.decode(page.Cursor)
+
+		if err != nil {
+			return nil, bounds, err
+		}
+
+		db = ks.after(db, values, backwards)
+	} else if !page.Keyset {
+		db = db.Offset(page.Start)
+	}
+
+	columns := []string{ks.id + "::text"}
+
+	for _, key := range ks.keys {
+		columns = append(columns, "("+key.expr+")::text")
+	}
+
+	// one more row than is asked for tells if there are more after the page
+	rows, err := db.Select(strings.Join(columns, ", ")).Order(ks.order(backwards)).Limit(page.Length + 1).Rows()
+
+	if err != nil {
+		return nil, bounds, err
+	}
+
+	defer rows.Close()
+
+	var ids []string
+	var values [][]string
+
+	for rows.Next() {
+		row := make([]string, len(columns))
+		dest := make([]interface{}, len(columns))
+
+		for i := range row {
+			dest[i] = &row[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, bounds, err
+		}
+
+		// the id goes last in a cursor, like it does in the order
+		ids = append(ids, row[0])
+		values = append(values, append(row[1:], row[0]))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, bounds, err
+	}
+
+	more := len(ids) > page.Length
+
+	if more {
+		ids, values = ids[:page.Length], values[:page.Length]
+	}
+
+	if backwards {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
+	switch {
+	case !page.Keyset:
+		bounds.HasPrev, bounds.HasNext = page.Start > 0, more
+	case backwards:
+		bounds.HasPrev, bounds.HasNext = more, page.Cursor != ""
+	default:
+		bounds.HasPrev, bounds.HasNext = page.Cursor != "", more
+	}
+
+	if len(ids) > 0 {
+		bounds.First = ks.encode(values[0])
+		bounds.Last = ks.encode(values[len(values)-1])
+	}
+
+	return ids, bounds, nil
+}
@@ -0,0 +1,74 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/grounded042/capacious/ratelimit"
+)
+
+// how many updates the rate limit store makes between deleting idle buckets
+const rateLimitSweepEvery = 1000
+
+// rateLimitStore keeps rate limit buckets in the rate_limit_buckets table so
+// they are shared by every process using the database.
+type rateLimitStore struct {
+	dh      DataHandler
+	idle    time.Duration
+	updates *int64
+}
+
+// RateLimitStore gets a ratelimit.Store backed by the database. Buckets that
+// haven't been updated for idle are deleted.
+func (dh DataHandler) RateLimitStore(idle time.Duration) ratelimit.Store {
+	return rateLimitStore{dh: dh, idle: idle, updates: new(int64)}
+}
+
+// Update implements ratelimit.Store. The bucket's row is locked for the
+// length of the update so concurrent updates to the same key queue up.
+func (s rateLimitStore) Update(ctx context.Context, key string, fn func(b ratelimit.Bucket, found bool) ratelimit.Bucket) error {
+	tx, err := s.dh.conn.DB().BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// make sure there is a row to lock. A row without tokens is a bucket
+	// that hasn't been used yet.
+	_, err = tx.ExecContext(ctx, "INSERT INTO rate_limit_buckets (key) VALUES ($1) ON CONFLICT (key) DO NOTHING", key)
+
+	if err != nil {
+		return err
+	}
+
+	var tokens sql.NullFloat64
+	var updated sql.NullTime
+
+	err = tx.QueryRowContext(ctx, "SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE", key).Scan(&tokens, &updated)
+
+	if err != nil {
+		return err
+	}
+
+	b := fn(ratelimit.Bucket{Tokens: tokens.Float64, Updated: updated.Time}, tokens.Valid)
+
+	_, err = tx.ExecContext(ctx, "UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1", key, b.Tokens, b.Updated)
+
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if atomic.AddInt64(s.updates, 1)%rateLimitSweepEvery == 0 {
+		_, err = s.dh.conn.DB().ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < $1", b.Updated.Add(-s.idle))
+	}
+
+	return err
+}
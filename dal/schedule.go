@@ -0,0 +1,82 @@
+package dal
+
+import (
+	"context"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// events without a respond_by have the zero time in it
+const hasRespondBy = "respond_by > '0001-01-02'"
+
+// GetEventsDueForReminders gets the events whose RSVPs are still open and
+// whose respond_by is within the next days days.
+func (dh DataHandler) GetEventsDueForReminders(ctx context.Context, days int) ([]entities.Event, error) {
+	events := []entities.Event{}
+
+	db := dh.db(ctx).Where("rsvps_locked_at IS NULL AND respond_by > now() AND respond_by <= now() + make_interval(days => ?)", days).Find(&events)
+
+	return events, db.Error
+}
+
+// GetEventsDueForLocking gets the events whose respond_by has passed but whose
+// RSVPs haven't been locked yet.
+func (dh DataHandler) GetEventsDueForLocking(ctx context.Context) ([]entities.Event, error) {
+	events := []entities.Event{}
+
+	db := dh.db(ctx).Where("rsvps_locked_at IS NULL AND " + hasRespondBy + " AND respond_by <= now()").Find(&events)
+
+	return events, db.Error
+}
+
+// LockEventRSVPs locks the RSVPs of the event with the id eventID if its
+// respond_by has passed. It is false if they were already locked or it
+// hasn't.
+func (dh DataHandler) LockEventRSVPs(ctx context.Context, eventID string) (bool, error) {
+	db := dh.db(ctx).Model(entities.Event{}).
+		Where("event_id = ? AND rsvps_locked_at IS NULL AND "+hasRespondBy+" AND respond_by <= now()", eventID).
+		UpdateColumns(map[string]interface{}{"rsvps_locked_at": time.Now(), "version": gorm.Expr("version + 1")})
+
+	return db.RowsAffected == 1, db.Error
+}
+
+// GetEventsForDigest gets the events that weren't over by since, or that
+// have no end time.
+func (dh DataHandler) GetEventsForDigest(ctx context.Context, since time.Time) ([]entities.Event, error) {
+	events := []entities.Event{}
+
+	db := dh.db(ctx).Where("end_time >= ? OR end_time <= '0001-01-02'", since).Find(&events)
+
+	return events, db.Error
+}
+
+// GetInviteesRespondedBetween gets the invitees of the event with the id
+// eventID who last answered from since until before until, in the order
+// they answered.
+func (dh DataHandler) GetInviteesRespondedBetween(ctx context.Context, eventID string, since time.Time, until time.Time) ([]entities.Invitee, error) {
+	var ids []string
+
+	db := dh.db(ctx).Table("invitees i").
+		Where("i.fk_event_id = ? AND i.responded_at >= ? AND i.responded_at < ?", eventID, since, until).
+		Order("i.responded_at, i.invitee_id").Pluck("i.invitee_id", &ids)
+
+	if db.Error != nil {
+		return []entities.Invitee{}, db.Error
+	}
+
+	return dh.getInviteesInOrder(ctx, ids)
+}
+
+// GetEventAdminUsers gets the users who are admins of the event with the id
+// eventID.
+func (dh DataHandler) GetEventAdminUsers(ctx context.Context, eventID string) ([]entities.User, error) {
+	users := []entities.User{}
+
+	db := dh.db(ctx).Table("users u").Select("u.*").
+		Joins("JOIN event_admins ea ON ea.fk_user_id = u.user_id").
+		Where("ea.fk_event_id = ?", eventID).Order("u.email").Find(&users)
+
+	return users, db.Error
+}
@@ -0,0 +1,135 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/entities"
+)
+
+// the guests of an event, $1, with whether each is an invitee or a friend
+const eventGuests = `
+	SELECT g.guest_id, g.rsvp_status, g.rsvp_status_at, true AS invitee
+	FROM invitees i JOIN guests g ON g.guest_id = i.fk_guest_id
+	WHERE i.fk_event_id = $1
+	UNION ALL
+	SELECT g.guest_id, g.rsvp_status, g.rsvp_status_at, false
+	FROM invitees i
+	JOIN invitee_friends f ON f.fk_invitee_id = i.invitee_id
+	JOIN guests g ON g.guest_id = f.fk_guest_id
+	WHERE i.fk_event_id = $1`
+
+// GetEventCounts counts the guests of the event with the id eventID by RSVP
+// status, how far the guests attending are with their menu choices and the
+// seating requests of its invitees.
+func (dh DataHandler) GetEventCounts(ctx context.Context, eventID string) (entities.EventCounts, error) {
+	var counts entities.EventCounts
+
+	db := dh.sqlDB(ctx)
+
+	err := db.QueryRowContext(ctx, `WITH event_guests AS (`+eventGuests+`)
+		SELECT
+			count(*) FILTER (WHERE invitee AND rsvp_status = 'pending'),
+			count(*) FILTER (WHERE invitee AND rsvp_status = 'attending'),
+			count(*) FILTER (WHERE invitee AND rsvp_status = 'declined'),
+			count(*) FILTER (WHERE invitee AND rsvp_status = 'maybe'),
+			count(*) FILTER (WHERE rsvp_status = 'pending'),
+			count(*) FILTER (WHERE rsvp_status = 'attending'),
+			count(*) FILTER (WHERE rsvp_status = 'declined'),
+			count(*) FILTER (WHERE rsvp_status = 'maybe'),
+			count(*) FILTER (WHERE NOT invitee)
+		FROM event_guests`, eventID).Scan(
+		&counts.InviteeRSVPs.Pending, &counts.InviteeRSVPs.Attending, &counts.InviteeRSVPs.Declined, &counts.InviteeRSVPs.Maybe,
+		&counts.GuestRSVPs.Pending, &counts.GuestRSVPs.Attending, &counts.GuestRSVPs.Declined, &counts.GuestRSVPs.Maybe,
+		&counts.Friends,
+	)
+
+	if err != nil {
+		return entities.EventCounts{}, err
+	}
+
+	// a guest's choices only count for the menu items of the event, and only
+	// once per item
+	err = db.QueryRowContext(ctx, `WITH items AS (
+			SELECT menu_item_id FROM menu_items WHERE fk_event_id = $1
+		), chosen AS (
+			SELECT g.guest_id, count(DISTINCT mc.fk_menu_item_id) AS n
+			FROM (`+eventGuests+`) g
+			LEFT JOIN menu_choices mc ON mc.fk_guest_id = g.guest_id AND mc.fk_menu_item_id IN (SELECT menu_item_id FROM items)
+			WHERE g.rsvp_status = 'attending'
+			GROUP BY g.guest_id
+		), total AS (
+			SELECT count(*) AS n FROM items
+		)
+		SELECT
+			total.n,
+			count(chosen.guest_id) FILTER (WHERE chosen.n >= total.n),
+			count(chosen.guest_id) FILTER (WHERE chosen.n > 0 AND chosen.n < total.n),
+			count(chosen.guest_id) FILTER (WHERE chosen.n = 0 AND total.n > 0)
+		FROM total LEFT JOIN chosen ON true
+		GROUP BY total.n`, eventID).Scan(&counts.MenuItems, &counts.MenuComplete, &counts.MenuPartial, &counts.MenuNone)
+
+	if err != nil {
+		return entities.EventCounts{}, err
+	}
+
+	err = db.QueryRowContext(ctx, `SELECT count(*), count(DISTINCT s.fk_invitee_id)
+		FROM invitee_seating_requests s JOIN invitees i ON i.invitee_id = s.fk_invitee_id
+		WHERE i.fk_event_id = $1`, eventID).Scan(&counts.SeatingRequests, &counts.InviteesWithSeatingRequests)
+
+	if err != nil {
+		return entities.EventCounts{}, err
+	}
+
+	return counts, nil
+}
+
+// GetResponseTimeline counts the answers of the invitees of the event with
+// the id eventID by the day they were set, for every day from the first
+// invitation to today, or to the day to respond by or the last answer if both
+// are in the past. Days are in the event's time zone.
+func (dh DataHandler) GetResponseTimeline(ctx context.Context, eventID string) ([]entities.ResponseDay, error) {
+	// columns without a time zone are in the session's, like the rest of the
+	// times the database compares with now()
+	rows, err := dh.sqlDB(ctx).QueryContext(ctx, `WITH event AS (
+			SELECT time_zone, respond_by FROM events WHERE event_id = $1
+		), answers AS (
+			SELECT (g.rsvp_status_at AT TIME ZONE e.time_zone)::date AS day, g.rsvp_status
+			FROM event e, invitees i JOIN guests g ON g.guest_id = i.fk_guest_id
+			WHERE i.fk_event_id = $1 AND g.rsvp_status <> 'pending' AND g.rsvp_status_at IS NOT NULL
+		), bounds AS (
+			SELECT
+				least((SELECT min(created_at::timestamptz AT TIME ZONE e.time_zone)::date FROM invitees WHERE fk_event_id = $1), (SELECT min(day) FROM answers)) AS first_day,
+				least((now() AT TIME ZONE e.time_zone)::date, greatest((e.respond_by::timestamptz AT TIME ZONE e.time_zone)::date, (SELECT max(day) FROM answers))) AS last_day
+			FROM event e
+		)
+		SELECT
+			d::date::text,
+			count(a.day) FILTER (WHERE a.rsvp_status = 'attending'),
+			count(a.day) FILTER (WHERE a.rsvp_status = 'declined'),
+			count(a.day) FILTER (WHERE a.rsvp_status = 'maybe')
+		FROM bounds
+		CROSS JOIN generate_series(bounds.first_day, bounds.last_day, interval '1 day') d
+		LEFT JOIN answers a ON a.day = d::date
+		GROUP BY d
+		ORDER BY d`, eventID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	days := []entities.ResponseDay{}
+
+	for rows.Next() {
+		var day entities.ResponseDay
+
+		if err := rows.Scan(&day.Date, &day.Attending, &day.Declined, &day.Maybe); err != nil {
+			return nil, err
+		}
+
+		days = append(days, day)
+	}
+
+	return days, rows.Err()
+}
@@ -0,0 +1,178 @@
+package dal
+
+import (
+	"context"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// GetUserFromID gets the user with the id userID.
+func (dh DataHandler) GetUserFromID(ctx context.Context, userID string) (entities.User, error) {
+	findMe := entities.User{}
+
+	db := dh.db(ctx).Where("user_id = ?", userID).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// GetUserLoginFromUserID gets the userlogin object of the user with the id
+// userID.
+func (dh DataHandler) GetUserLoginFromUserID(ctx context.Context, userID string) (entities.UserLogin, error) {
+	findMe := entities.UserLogin{}
+
+	db := dh.db(ctx).Where("fk_user_id = ?", userID).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// EnsureUserLogin gets the userlogin object of the user with the id userID,
+// creating one without a password if they don't have one. Users who only log
+// in with an OIDC provider need one to keep their TOTP state in.
+func (dh DataHandler) EnsureUserLogin(ctx context.Context, userID string) (entities.UserLogin, error) {
+	err := dh.db(ctx).Exec("INSERT INTO user_logins (fk_user_id, salt, password) VALUES (?, '', '') ON CONFLICT (fk_user_id) DO NOTHING", userID).Error
+
+	if err != nil {
+		return entities.UserLogin{}, err
+	}
+
+	return dh.GetUserLoginFromUserID(ctx, userID)
+}
+
+// GetUserLoginFromID gets the userlogin object with the id userLoginID.
+func (dh DataHandler) GetUserLoginFromID(ctx context.Context, userLoginID string) (entities.UserLogin, error) {
+	findMe := entities.UserLogin{}
+
+	db := dh.db(ctx).Where("user_login_id = ?", userLoginID).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// SetTotpSecret sets the encrypted TOTP secret of the user login with the id
+// userLoginID. It does not enable TOTP.
+func (dh DataHandler) SetTotpSecret(ctx context.Context, userLoginID string, secret string) error {
+	return dh.db(ctx).Model(entities.UserLogin{}).Where("user_login_id = ?", userLoginID).UpdateColumn("totp_secret", secret).Error
+}
+
+// EnableTotp enables TOTP for the user login, marking step as used, and
+// replaces the user's recovery codes with codeHashes.
+func (dh DataHandler) EnableTotp(ctx context.Context, login entities.UserLogin, step int64, codeHashes []string) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		err := tx.Model(entities.UserLogin{}).Where("user_login_id = ?", login.UserLoginID).UpdateColumns(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error
+
+		if err == nil {
+			err = replaceRecoveryCodes(tx, login.FkUserID, codeHashes)
+		}
+
+		return err
+	})
+}
+
+// DisableTotp disables TOTP for the user login and deletes the user's
+// recovery codes.
+func (dh DataHandler) DisableTotp(ctx context.Context, login entities.UserLogin) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		err := tx.Model(entities.UserLogin{}).Where("user_login_id = ?", login.UserLoginID).UpdateColumns(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error
+
+		if err == nil {
+			err = tx.Where("fk_user_id = ?", login.FkUserID).Delete(entities.RecoveryCode{}).Error
+		}
+
+		return err
+	})
+}
+
+// UseTotpStep marks the TOTP time step step as used by the user login with the
+// id userLoginID. It returns false if that step, or a later one, has already
+// been used.
+func (dh DataHandler) UseTotpStep(ctx context.Context, userLoginID string, step int64) (bool, error) {
+	db := dh.db(ctx).Model(entities.UserLogin{}).Where("user_login_id = ? AND totp_last_step < ?", userLoginID, step).UpdateColumn("totp_last_step", step)
+
+	return db.RowsAffected == 1, db.Error
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of the user with the id
+// userID with codeHashes.
+func (dh DataHandler) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	return dh.transaction(ctx, func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// UseRecoveryCode marks the unused recovery code with the hash codeHash of the
+// user with the id userID as used. It returns false if there is no such code.
+func (dh DataHandler) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	db := dh.db(ctx).Model(entities.RecoveryCode{}).Where("fk_user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).UpdateColumn("used_at", time.Now())
+
+	return db.RowsAffected == 1, db.Error
+}
+
+// CreateLoginChallenge creates a login challenge. Any other challenge for the
+// same login, and any that have expired, are deleted.
+func (dh DataHandler) CreateLoginChallenge(ctx context.Context, createMe *entities.LoginChallenge) error {
+	db := dh.db(ctx).Where("fk_user_login_id = ? OR expires_at < ?", createMe.FkUserLoginID, time.Now()).Delete(entities.LoginChallenge{})
+
+	if db.Error != nil {
+		return db.Error
+	}
+
+	return dh.db(ctx).Create(createMe).Error
+}
+
+// GetLoginChallenge gets the login challenge with the id challengeID.
+func (dh DataHandler) GetLoginChallenge(ctx context.Context, challengeID string) (entities.LoginChallenge, error) {
+	findMe := entities.LoginChallenge{}
+
+	db := dh.db(ctx).Where("login_challenge_id = ?", challengeID).First(&findMe)
+
+	return findMe, db.Error
+}
+
+// RecordFailedChallenge adds one to the attempts of the login challenge with
+// the id challengeID and returns the new count.
+func (dh DataHandler) RecordFailedChallenge(ctx context.Context, challengeID string) (int, error) {
+	db := dh.db(ctx).Model(entities.LoginChallenge{}).Where("login_challenge_id = ?", challengeID).UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+
+	if db.Error != nil {
+		return 0, db.Error
+	}
+
+	challenge, err := dh.GetLoginChallenge(ctx, challengeID)
+
+	return challenge.Attempts, err
+}
+
+// DeleteLoginChallenge deletes the login challenge with the id challengeID.
+func (dh DataHandler) DeleteLoginChallenge(ctx context.Context, challengeID string) error {
+	return dh.db(ctx).Where("login_challenge_id = ?", challengeID).Delete(entities.LoginChallenge{}).Error
+}
+
+// SetEventRequireTwoFactor sets whether the admins of the event with the id
+// eventID need two factor authentication enabled to manage it.
+func (dh DataHandler) SetEventRequireTwoFactor(ctx context.Context, eventID string, required bool) error {
+	return dh.db(ctx).Model(entities.Event{}).Where("event_id = ?", eventID).UpdateColumn("require_two_factor", required).Error
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user with the id
+// userID and creates ones with codeHashes in the transaction tx.
+func replaceRecoveryCodes(tx *gorm.DB, userID string, codeHashes []string) error {
+	if err := tx.Where("fk_user_id = ?", userID).Delete(entities.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if err := tx.Create(&entities.RecoveryCode{FkUserID: userID, CodeHash: hash}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
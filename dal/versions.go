@@ -0,0 +1,32 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// BumpEventVersion adds one to the version of the event with the id eventID.
+// If version isn't 0 the event has to be at that version, and false is
+// returned if it isn't.
+func (dh DataHandler) BumpEventVersion(ctx context.Context, eventID string, version int) (bool, error) {
+	return bumpVersion(dh.db(ctx).Model(entities.Event{}).Where("event_id = ?", eventID), version)
+}
+
+// BumpInviteeVersion adds one to the version of the invitee with the id
+// inviteeID. If version isn't 0 the invitee has to be at that version, and
+// false is returned if it isn't.
+func (dh DataHandler) BumpInviteeVersion(ctx context.Context, inviteeID string, version int) (bool, error) {
+	return bumpVersion(dh.db(ctx).Model(entities.Invitee{}).Where("invitee_id = ?", inviteeID), version)
+}
+
+func bumpVersion(db *gorm.DB, version int) (bool, error) {
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+
+	db = db.UpdateColumn("version", gorm.Expr("version + 1"))
+
+	return db.RowsAffected == 1, db.Error
+}
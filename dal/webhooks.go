@@ -0,0 +1,169 @@
+package dal
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// GetWebhooksForEvent gets the webhooks of the event with the id eventID,
+// oldest first.
+func (dh DataHandler) GetWebhooksForEvent(ctx context.Context, eventID string) ([]entities.Webhook, error) {
+	webhooks := []entities.Webhook{}
+
+	db := dh.db(ctx).Where("fk_event_id = ?", eventID).Order("created_at, webhook_id").Find(&webhooks)
+
+	return webhooks, db.Error
+}
+
+// GetWebhook gets the webhook with the id webhookID. It is empty if there is
+// no such webhook.
+func (dh DataHandler) GetWebhook(ctx context.Context, webhookID string) (entities.Webhook, error) {
+	found := []entities.Webhook{}
+
+	// compare as text so an id that isn't a uuid finds nothing rather than
+	// being an error
+	db := dh.db(ctx).Where("webhook_id::text = ?", webhookID).Limit(1).Find(&found)
+
+	if db.Error != nil || len(found) == 0 {
+		return entities.Webhook{}, db.Error
+	}
+
+	return found[0], nil
+}
+
+// CreateWebhook creates webhook.
+func (dh DataHandler) CreateWebhook(ctx context.Context, webhook *entities.Webhook) error {
+	return dh.db(ctx).Create(webhook).Error
+}
+
+// DeleteWebhook deletes the webhook with the id webhookID of the event with
+// the id eventID, and its deliveries. It is false if there is no such
+// webhook.
+func (dh DataHandler) DeleteWebhook(ctx context.Context, eventID string, webhookID string) (bool, error) {
+	db := dh.db(ctx).Where("fk_event_id = ? AND webhook_id::text = ?", eventID, webhookID).Delete(entities.Webhook{})
+
+	return db.RowsAffected == 1, db.Error
+}
+
+// CreateWebhookDeliveries records a delivery of payload to each webhook of the
+// event with the id eventID that asked for eventType. It returns the ids of
+// the deliveries.
+func (dh DataHandler) CreateWebhookDeliveries(ctx context.Context, eventID string, eventType string, payload entities.JSON) ([]string, error) {
+	rows, err := dh.sqlDB(ctx).QueryContext(ctx, `INSERT INTO webhook_deliveries (fk_webhook_id, fk_event_id, event_type, payload)
+		SELECT webhook_id, fk_event_id, $2, $3 FROM webhooks
+		WHERE fk_event_id = $1 AND ' ' || event_types || ' ' LIKE '% ' || $2 || ' %'
+		RETURNING webhook_delivery_id`, eventID, eventType, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// GetWebhookDelivery gets the webhook delivery with the id deliveryID. It is
+// empty if there is no such delivery.
+func (dh DataHandler) GetWebhookDelivery(ctx context.Context, deliveryID string) (entities.WebhookDelivery, error) {
+	found := []entities.WebhookDelivery{}
+
+	db := dh.db(ctx).Where("webhook_delivery_id::text = ?", deliveryID).Limit(1).Find(&found)
+
+	if db.Error != nil || len(found) == 0 {
+		return entities.WebhookDelivery{}, db.Error
+	}
+
+	return found[0], nil
+}
+
+// RecordWebhookAttempt records an attempt at the delivery with the id
+// deliveryID: the status it left the delivery in, the response to it and why
+// it failed. Deliveries that were delivered are marked delivered now.
+func (dh DataHandler) RecordWebhookAttempt(ctx context.Context, deliveryID string, status string, responseStatus int, responseBody string, reason string) error {
+	_, err := dh.conn.DB().ExecContext(ctx, `UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, response_status = $3, response_body = $4, error = $5,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END
+		WHERE webhook_delivery_id = $1`, deliveryID, status, responseStatus, responseBody, reason)
+
+	return err
+}
+
+// ResetWebhookDelivery marks the delivery with the id deliveryID as pending
+// again, to be redelivered.
+func (dh DataHandler) ResetWebhookDelivery(ctx context.Context, deliveryID string) error {
+	_, err := dh.conn.DB().ExecContext(ctx, `UPDATE webhook_deliveries SET status = 'pending', error = '' WHERE webhook_delivery_id = $1`, deliveryID)
+
+	return err
+}
+
+// webhook deliveries are listed newest first
+var webhookDeliveryKeyset = keyset{id: "webhook_delivery_id", keys: []sortKey{{expr: "created_at", desc: true}}}
+
+// GetWebhookDeliveries gets a page of the deliveries to the webhook with the
+// id webhookID that match filter, newest first.
+func (dh DataHandler) GetWebhookDeliveries(ctx context.Context, webhookID string, filter entities.WebhookDeliveryFilter, page entities.Page) ([]entities.WebhookDelivery, entities.PageBounds, error) {
+	ids, bounds, err := webhookDeliveryKeyset.page(filterWebhookDeliveries(dh.db(ctx).Model(entities.WebhookDelivery{}), webhookID, filter), page)
+
+	if err != nil || len(ids) == 0 {
+		return []entities.WebhookDelivery{}, bounds, err
+	}
+
+	found := []entities.WebhookDelivery{}
+
+	if db := dh.db(ctx).Where("webhook_delivery_id IN (?)", ids).Find(&found); db.Error != nil {
+		return []entities.WebhookDelivery{}, bounds, db.Error
+	}
+
+	byID := make(map[string]entities.WebhookDelivery, len(found))
+
+	for _, delivery := range found {
+		byID[delivery.WebhookDeliveryID] = delivery
+	}
+
+	deliveries := make([]entities.WebhookDelivery, 0, len(ids))
+
+	for _, id := range ids {
+		deliveries = append(deliveries, byID[id])
+	}
+
+	return deliveries, bounds, nil
+}
+
+// GetNumberOfWebhookDeliveries counts the deliveries to the webhook with the
+// id webhookID that match filter.
+func (dh DataHandler) GetNumberOfWebhookDeliveries(ctx context.Context, webhookID string, filter entities.WebhookDeliveryFilter) (int, error) {
+	var count int
+
+	db := filterWebhookDeliveries(dh.db(ctx).Model(entities.WebhookDelivery{}), webhookID, filter).Count(&count)
+
+	return count, db.Error
+}
+
+func filterWebhookDeliveries(db *gorm.DB, webhookID string, filter entities.WebhookDeliveryFilter) *gorm.DB {
+	db = db.Where("fk_webhook_id = ?", webhookID)
+
+	if filter.EventType != "" {
+		db = db.Where("event_type = ?", filter.EventType)
+	}
+
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+
+	return db
+}
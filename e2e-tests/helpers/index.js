@@ -53,6 +53,39 @@ export function validJWTWithInvalidUser(secret) {
   });
 }
 
+/**
+ * get the ETag of an invitee, to send in If-Match when editting it
+ * @param  {object} api - the supertest agent for the API
+ * @param  {string} inviteeID - the id of the invitee
+ * @param  {function} cb - called with an error or the ETag
+ */
+export function getInviteeETag(api, inviteeID, cb) {
+  api.get(`/invitees/${inviteeID}`)
+  .expect(200)
+  .end((err, res) => cb(err, res && res.header.etag));
+}
+
+/**
+ * change the answer of an invitee with a merge patch of their `self`. Specs
+ * that change it put it back to pending with this when they are done, as the
+ * other specs expect.
+ * @param  {object} api - the supertest agent for the API
+ * @param  {string} inviteeID - the id of the invitee
+ * @param  {object} self - the fields of `self` to change
+ * @param  {function} cb - called with an error or the response
+ */
+export function patchSelf(api, inviteeID, self, cb) {
+  getInviteeETag(api, inviteeID, (err, etag) => {
+    if (err) return cb(err);
+
+    api.patch(`/invitees/${inviteeID}`)
+    .set('If-Match', etag)
+    .set('Content-Type', 'application/merge-patch+json')
+    .send(JSON.stringify({ self: self }))
+    .end(cb);
+  });
+}
+
 /**
  * given a uuid, validate that it is indeed a UUID and then pass back a string
  * to replace it. Throw an error if it's not a valid UUID
@@ -67,6 +100,20 @@ export function validateAndCleanUUID(uuid) {
   return 'FIXED_ID';
 }
 
+/**
+ * given a time that may not be set, validate that it is null or a time and then
+ * pass back a string to replace it. Throw an error if it's neither
+ * @param  {string} time - the time to validate
+ * @return {string} the fixed string to assign to replaced times
+ */
+export function validateAndCleanOptionalTime(time) {
+  if (time !== null && isNaN(Date.parse(time))) {
+    throw new Error("not a valid time")
+  }
+
+  return 'FIXED_TIME';
+}
+
 /**
  * given menu item choices, check that the attributes are valid UUIDs and then
  * set them to 'FIXED_ID' to aid in testing dynamic ids
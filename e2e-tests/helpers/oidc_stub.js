@@ -0,0 +1,90 @@
+/** @module capacious-e2e-oidc-stub */
+
+import crypto from 'crypto';
+import http from 'http';
+import url from 'url';
+import querystring from 'querystring';
+import jwt from 'jsonwebtoken';
+
+/**
+ * start a stub OpenID Connect provider. It approves every authorization
+ * request straight away, logging the user in as `user`, and checks the PKCE
+ * code verifier when the code is exchanged.
+ * @param  {number} port - the port to listen on
+ * @param  {object} user - the claims to put in ID tokens, e.g. sub and email
+ * @return {object} the provider, with `issuer`, `user`, which can be changed
+ * between logins, and `close()`
+ */
+export function startOIDCStub(port, user) {
+  const issuer = `http://localhost:${port}`;
+  const { privateKey, publicKey } = crypto.generateKeyPairSync('rsa', { modulusLength: 2048 });
+  const jwk = Object.assign(publicKey.export({ format: 'jwk' }), { kid: 'stub', use: 'sig', alg: 'RS256' });
+  const codes = {};
+  const stub = { issuer, user };
+
+  const server = http.createServer((req, res) => {
+    const { pathname, query } = url.parse(req.url, true);
+
+    const json = (status, body) => {
+      res.writeHead(status, { 'Content-Type': 'application/json' });
+      res.end(JSON.stringify(body));
+    };
+
+    if (pathname === '/.well-known/openid-configuration') {
+      return json(200, {
+        issuer,
+        authorization_endpoint: `${issuer}/authorize`,
+        token_endpoint: `${issuer}/token`,
+        jwks_uri: `${issuer}/jwks`,
+      });
+    }
+
+    if (pathname === '/jwks') {
+      return json(200, { keys: [jwk] });
+    }
+
+    if (pathname === '/authorize') {
+      const code = crypto.randomBytes(16).toString('hex');
+      codes[code] = { query, user: stub.user };
+
+      res.writeHead(302, { Location: `${query.redirect_uri}?code=${code}&state=${query.state}` });
+      return res.end();
+    }
+
+    if (pathname === '/token' && req.method === 'POST') {
+      let body = '';
+      req.on('data', (chunk) => { body += chunk; });
+      req.on('end', () => {
+        const form = querystring.parse(body);
+        const login = codes[form.code];
+        delete codes[form.code];
+
+        const challenge = form.code_verifier && crypto.createHash('sha256')
+          .update(form.code_verifier).digest('base64')
+          .replace(/=/g, '').replace(/\+/g, '-').replace(/\//g, '_');
+
+        if (!login || challenge !== login.query.code_challenge) {
+          return json(400, { error: 'invalid_grant' });
+        }
+
+        const idToken = jwt.sign(Object.assign({ nonce: login.query.nonce }, login.user), privateKey, {
+          algorithm: 'RS256',
+          keyid: 'stub',
+          issuer,
+          audience: login.query.client_id,
+          expiresIn: '5 minutes',
+        });
+
+        json(200, { access_token: 'stub', token_type: 'Bearer', id_token: idToken });
+      });
+      return;
+    }
+
+    json(404, {});
+  });
+
+  server.listen(port);
+  stub.close = () => server.close();
+
+  return stub;
+}
@@ -0,0 +1,75 @@
+/** @module capacious-e2e-smtp-stub */
+
+import net from 'net';
+
+/**
+ * start a stub SMTP server. It accepts every message without offering
+ * STARTTLS or asking for a password, so the API has to be started with
+ * SMTP_TLS=none to send to it.
+ * @param  {number} port - the port to listen on
+ * @return {object} the server, with `messages`, each with the `from`, `to`
+ * and raw `data` it was sent, and `close()`
+ */
+export function startSMTPStub(port) {
+  const stub = { messages: [] };
+
+  const server = net.createServer((socket) => {
+    let message = { to: [] };
+    let data = null;
+    let buffered = '';
+
+    const reply = (line) => socket.write(`${line}\r\n`);
+
+    reply('220 localhost stub');
+
+    socket.on('data', (chunk) => {
+      buffered += chunk.toString();
+
+      let end;
+
+      while ((end = buffered.indexOf('\r\n')) !== -1) {
+        const line = buffered.slice(0, end);
+        buffered = buffered.slice(end + 2);
+
+        if (data !== null) {
+          if (line === '.') {
+            message.data = data.join('\r\n');
+            stub.messages.push(message);
+            message = { to: [] };
+            data = null;
+            reply('250 ok');
+          } else {
+            data.push(line.startsWith('..') ? line.slice(1) : line);
+          }
+
+          continue;
+        }
+
+        const verb = line.split(' ')[0].toUpperCase();
+
+        if (verb === 'EHLO' || verb === 'HELO') {
+          reply('250 localhost');
+        } else if (verb === 'MAIL') {
+          message.from = line.replace(/^MAIL FROM:\s*<(.*)>.*$/i, '$1');
+          reply('250 ok');
+        } else if (verb === 'RCPT') {
+          message.to.push(line.replace(/^RCPT TO:\s*<(.*)>.*$/i, '$1'));
+          reply('250 ok');
+        } else if (verb === 'DATA') {
+          data = [];
+          reply('354 go ahead');
+        } else if (verb === 'QUIT') {
+          reply('221 bye');
+          socket.end();
+        } else {
+          reply('250 ok');
+        }
+      }
+    });
+  });
+
+  server.listen(port);
+  stub.close = () => server.close();
+
+  return stub;
+}
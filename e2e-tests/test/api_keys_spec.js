@@ -0,0 +1,128 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { validJWT } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('api keys', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let other_event_id = "81e6d338-7917-11e5-8b8e-a37beb0fdae8";
+  let apiKey;
+  let apiKeyID;
+
+  describe('creating', () => {
+    it('should return the key once and a 201', (done) => {
+      api.post(`/events/${working_event_id}/relationships/api_keys`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .send({ name: "CRM sync", scopes: ["invitees:read"] })
+      .expect(201)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.key).to.match(/^cap_/);
+        expect(res.body.key_id).to.equal(res.body.key.slice(0, res.body.key_id.length));
+        expect(res.body.scopes).to.deep.equal(["invitees:read"]);
+
+        apiKey = res.body.key;
+        apiKeyID = res.body.api_key_id;
+        done();
+      });
+    });
+
+    it('should return 400 for an unknown scope', (done) => {
+      api.post(`/events/${working_event_id}/relationships/api_keys`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .send({ name: "CRM sync", scopes: ["events:delete"] })
+      .expect(400, done);
+    });
+
+    it('should return 401 without a JWT', (done) => {
+      api.post(`/events/${working_event_id}/relationships/api_keys`)
+      .send({ name: "CRM sync", scopes: ["invitees:read"] })
+      .expect(401, done);
+    });
+  });
+
+  describe('listing', () => {
+    it('should not include the key', (done) => {
+      api.get(`/events/${working_event_id}/relationships/api_keys`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let listed = res.body.find((key) => key.api_key_id === apiKeyID);
+        expect(listed.name).to.equal("CRM sync");
+        expect(listed).to.not.have.property('key');
+        done();
+      });
+    });
+  });
+
+  describe('using', () => {
+    it('should return 200 for a route the key has the scope for', (done) => {
+      api.get(`/events/${working_event_id}/relationships/invitees`)
+      .set('Authorization', `Bearer ${apiKey}`)
+      .expect(200, done);
+    });
+
+    it('should return 403 for a route the key does not have the scope for', (done) => {
+      api.get(`/events/${working_event_id}/relationships/stats`)
+      .set('Authorization', `Bearer ${apiKey}`)
+      .expect(403, done);
+    });
+
+    it('should return 403 for another event', (done) => {
+      api.get(`/events/${other_event_id}/relationships/invitees`)
+      .set('Authorization', `Bearer ${apiKey}`)
+      .expect(403, done);
+    });
+
+    it('should return 403 for routes keys can not use', (done) => {
+      api.get('/token')
+      .set('Authorization', `Bearer ${apiKey}`)
+      .expect(403, done);
+    });
+
+    it('should return 401 for a wrong key', (done) => {
+      api.get(`/events/${working_event_id}/relationships/invitees`)
+      .set('Authorization', `Bearer ${apiKey}x`)
+      .expect(401, done);
+    });
+
+    it('should record when the key was last used', (done) => {
+      api.get(`/events/${working_event_id}/relationships/api_keys`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let listed = res.body.find((key) => key.api_key_id === apiKeyID);
+        expect(listed.last_used_at).to.be.a('string');
+        done();
+      });
+    });
+  });
+
+  describe('revoking', () => {
+    it('should return 204', (done) => {
+      api.delete(`/events/${working_event_id}/relationships/api_keys/${apiKeyID}`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(204, done);
+    });
+
+    it('should stop the key working', (done) => {
+      api.get(`/events/${working_event_id}/relationships/invitees`)
+      .set('Authorization', `Bearer ${apiKey}`)
+      .expect(401, done);
+    });
+
+    it('should return 404 when revoking it again', (done) => {
+      api.delete(`/events/${working_event_id}/relationships/api_keys/${apiKeyID}`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(404, done);
+    });
+  });
+});
@@ -0,0 +1,99 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { getInviteeETag, validJWT, validJWTWithInvalidUser } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('audit log', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let guest_id = "81e6d338-7917-11e5-8b8e-a37beb0fdae8";
+
+  let rsvp = (attending) => ({
+    email: "soldier@mann.co",
+    self: {
+      guest_id: guest_id,
+      first_name: "Soldier",
+      last_name: "",
+      attending: attending
+    }
+  });
+
+  before((done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.patch(`/invitees/${invitee_id}`)
+      .set('If-Match', etag)
+      .send(rsvp(false))
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        api.patch(`/invitees/${invitee_id}`)
+        .set('If-Match', res.header.etag)
+        .send(rsvp(true))
+        .expect(200, done);
+      });
+    });
+  });
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    let reset = rsvp(false);
+    reset.self.rsvp_status = 'pending';
+
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .send(reset)
+    .expect(200, done);
+  });
+
+  it('should record who changed an invitee and what changed', (done) => {
+    api.get(`/events/${working_event_id}/relationships/audit?filter[invitee]=${invitee_id}&filter[entity]=invitee&page[size]=100`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let entries = res.body.data;
+      let last = entries[entries.length - 1];
+
+      expect(last.actor_type).to.equal('invitee');
+      expect(last.actor_id).to.equal(invitee_id);
+      expect(last.guest_id).to.equal(guest_id);
+      expect(last.action).to.equal('update');
+      expect(last.diff['self.attending']).to.deep.equal({ from: false, to: true });
+      expect(last.before.self.attending).to.equal(false);
+      expect(last.after.self.attending).to.equal(true);
+      expect(last.request_id).to.be.a('string');
+      done();
+    });
+  });
+
+  it('should filter by guest', (done) => {
+    api.get(`/events/${working_event_id}/relationships/audit?filter[guest]=${guest_id}`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body.data).to.not.be.empty;
+      res.body.data.forEach((entry) => expect(entry.guest_id).to.equal(guest_id));
+      done();
+    });
+  });
+
+  it('should return 403 for users who are not an admin of the event', (done) => {
+    api.get(`/events/${working_event_id}/relationships/audit`)
+    .set('Authorization', `Bearer ${validJWTWithInvalidUser(secret)}`)
+    .expect(403, done);
+  });
+
+  it('should return 401 without a JWT', (done) => {
+    api.get(`/events/${working_event_id}/relationships/audit`)
+    .expect(401, done);
+  });
+});
@@ -20,7 +20,7 @@ describe('auth', () => {
   });
 
   describe('invalid JWTs', () => {
-    it('should return 500 on bad JWT secret', (done) => {
+    it('should return 401 on bad JWT secret', (done) => {
       let token = jwt.sign({ sub: "user_id" }, "this_is_not_the_right_secret", {
         algorithm: "HS512",
         expiresIn: "2 days",
@@ -28,7 +28,7 @@ describe('auth', () => {
 
       api.get('/events/cd7bc650-2e71-11e5-a390-675459d99309')
       .set('Authorization', `Bearer ${token}`)
-      .expect(500, done);
+      .expect(401, done);
     });
 
     it('should return 400 on invalid header', (done) => {
@@ -123,7 +123,7 @@ describe('auth', () => {
             throw new Error("token is not undefined!")
           };
         })
-        .expect(500, done);
+        .expect(401, done);
       });
     });
   });
@@ -0,0 +1,74 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { patchSelf } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+
+describe('calendars', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+
+  // the unfolded lines of a calendar
+  let lines = (text) => text.replace(/\r\n[ \t]/g, '').split('\r\n');
+
+  after((done) => {
+    patchSelf(api, invitee_id, { rsvp_status: "pending" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      done();
+    });
+  });
+
+  describe('of an event', () => {
+    it('should get the event as a calendar to publish', (done) => {
+      api.get(`/events/${working_event_id}/calendar.ics`)
+      .expect(200)
+      .expect('Content-Type', 'text/calendar; charset=utf-8')
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let ics = lines(res.text);
+        expect(ics[0]).to.equal('BEGIN:VCALENDAR');
+        expect(ics).to.include('METHOD:PUBLISH');
+        expect(ics).to.include(`UID:${working_event_id}@capacious`);
+        expect(ics).to.include('SUMMARY:Picnic');
+        expect(ics).to.include('LOCATION:The Park');
+        expect(ics).to.include('DTSTART:20151215T170000Z');
+        expect(ics.some((l) => /^SEQUENCE:\d+$/.test(l))).to.equal(true);
+        done();
+      });
+    });
+
+    it('should 404 for an event that does not exist', (done) => {
+      api.get(`/events/00000000-0000-0000-0000-000000000000/calendar.ics`)
+      .expect(404, done);
+    });
+  });
+
+  describe('of an invitee', () => {
+    it('should invite them with their answer', (done) => {
+      patchSelf(api, invitee_id, { rsvp_status: "maybe" }, (err, res) => {
+        if (err) return done(err);
+
+        expect(res.status).to.equal(200);
+
+        api.get(`/invitees/${invitee_id}/calendar.ics`)
+        .expect(200)
+        .end((err, res) => {
+          if (err) return done(err);
+
+          let ics = lines(res.text);
+          expect(ics).to.include('METHOD:REQUEST');
+          expect(ics.some((l) => l.startsWith('ORGANIZER'))).to.equal(true);
+
+          let attendee = ics.find((l) => l.startsWith('ATTENDEE'));
+          expect(attendee).to.contain('PARTSTAT=TENTATIVE');
+          expect(attendee).to.contain('RSVP=TRUE');
+          done();
+        });
+      });
+    });
+  });
+});
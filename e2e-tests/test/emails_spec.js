@@ -0,0 +1,207 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { patchSelf, validJWT, validJWTWithInvalidUser } from '../helpers';
+import { startSMTPStub } from '../helpers/smtp_stub';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+// with SMTP_STUB_PORT set these need the server started with
+// MAIL_TRANSPORT=smtp, SMTP_ADDR set to localhost:$SMTP_STUB_PORT and
+// SMTP_TLS=none
+let describeSMTP = process.env.SMTP_STUB_PORT ? describe : describe.skip;
+
+describe('emails', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+
+  let admin = (req) => req.set('Authorization', `Bearer ${validJWT(secret)}`);
+  let templates = `/events/${working_event_id}/relationships/email_templates`;
+  let emails = `/events/${working_event_id}/relationships/emails`;
+
+  // wait for the deliveries matching query to all have been handled
+  let handled = (query, cb, tries = 20) => {
+    admin(api.get(`${emails}?${query}`))
+    .expect(200)
+    .end((err, res) => {
+      if (err) return cb(err);
+
+      if (res.body.data.some((delivery) => delivery.status === 'queued') && tries > 0) {
+        return setTimeout(() => handled(query, cb, tries - 1), 100);
+      }
+
+      cb(null, res.body.data);
+    });
+  };
+
+  // every email sent while the specs run goes to it
+  let stub;
+
+  before(() => {
+    if (process.env.SMTP_STUB_PORT) {
+      stub = startSMTPStub(Number(process.env.SMTP_STUB_PORT));
+    }
+  });
+
+  // put the reminder template and the answer back
+  after((done) => {
+    admin(api.delete(`${templates}/reminder`))
+    .expect(204)
+    .end((err) => {
+      if (err) return done(err);
+
+      patchSelf(api, invitee_id, { rsvp_status: "pending" }, (err, res) => {
+        if (err) return done(err);
+
+        expect(res.status).to.equal(200);
+        done();
+      });
+    });
+  });
+
+  after(() => stub && stub.close());
+
+  describe('templates', () => {
+    it('should list a default template for every kind', (done) => {
+      admin(api.get(templates))
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.map((t) => t.kind)).to.deep.equal(["invitation", "rsvp_confirmation", "menu_confirmation", "reminder"]);
+        res.body.forEach((t) => expect(t.default).to.equal(true));
+        done();
+      });
+    });
+
+    it('should replace a template', (done) => {
+      admin(api.put(`${templates}/reminder`))
+      .send({ subject: "Don't forget {{.Event.Name}}", body: "Hi {{.FirstName}}, answer at {{.RSVPLink}}" })
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.kind).to.equal("reminder");
+        expect(res.body.default).to.equal(false);
+
+        admin(api.get(templates))
+        .expect(200)
+        .end((err, res) => {
+          if (err) return done(err);
+
+          let reminder = res.body.find((t) => t.kind === "reminder");
+          expect(reminder.subject).to.equal("Don't forget {{.Event.Name}}");
+          expect(reminder.default).to.equal(false);
+          done();
+        });
+      });
+    });
+
+    it('should 422 for a template that does not render', (done) => {
+      admin(api.put(`${templates}/reminder`))
+      .send({ subject: "Hi", body: "{{.Nope}}" })
+      .expect(422, done);
+    });
+
+    it('should 404 for a kind of email there is not', (done) => {
+      admin(api.put(`${templates}/newsletter`))
+      .send({ subject: "Hi", body: "Hi" })
+      .expect(404, done);
+    });
+
+    it('should return 403 for someone who is not an admin of the event', (done) => {
+      api.get(templates)
+      .set('Authorization', `Bearer ${validJWTWithInvalidUser(secret)}`)
+      .expect(403, done);
+    });
+  });
+
+  describe('sending', () => {
+    it('should send to one invitee and track the delivery', (done) => {
+      admin(api.post(emails))
+      .send({ kind: "invitation", invitee_id: invitee_id })
+      .expect(202)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.length).to.equal(1);
+        expect(res.body[0].invitee_id).to.equal(invitee_id);
+        expect(res.body[0].to).to.equal("soldier@mann.co");
+        expect(res.body[0].status).to.equal("queued");
+
+        handled(`filter[invitee]=${invitee_id}&filter[kind]=invitation`, (err, deliveries) => {
+          if (err) return done(err);
+
+          expect(deliveries[0].email_delivery_id).to.equal(res.body[0].email_delivery_id);
+          expect(deliveries[0].status).to.equal("sent");
+          done();
+        });
+      });
+    });
+
+    it('should send to the invitees matching a filter', (done) => {
+      admin(api.post(`${emails}?filter[search]=soldier`))
+      .send({ kind: "reminder" })
+      .expect(202)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.map((delivery) => delivery.invitee_id)).to.deep.equal([invitee_id]);
+        expect(res.body[0].subject).to.match(/^Don't forget /);
+        done();
+      });
+    });
+
+    it('should confirm an RSVP', (done) => {
+      patchSelf(api, invitee_id, { rsvp_status: "maybe" }, (err, res) => {
+        if (err) return done(err);
+
+        expect(res.status).to.equal(200);
+
+        handled(`filter[invitee]=${invitee_id}&filter[kind]=rsvp_confirmation`, (err, deliveries) => {
+          if (err) return done(err);
+
+          expect(deliveries.length).to.be.at.least(1);
+          done();
+        });
+      });
+    });
+
+    it('should 422 for a kind of email there is not', (done) => {
+      admin(api.post(emails))
+      .send({ kind: "newsletter", invitee_id: invitee_id })
+      .expect(422, done);
+    });
+
+    it('should 404 for an invitee of another event', (done) => {
+      admin(api.post(emails))
+      .send({ kind: "invitation", invitee_id: "00000000-0000-0000-0000-000000000000" })
+      .expect(404, done);
+    });
+  });
+
+  describeSMTP('through an SMTP server', () => {
+    it('should hand the email to the server', (done) => {
+      admin(api.post(emails))
+      .send({ kind: "invitation", invitee_id: invitee_id })
+      .expect(202)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        handled(`filter[invitee]=${invitee_id}&filter[kind]=invitation`, (err, deliveries) => {
+          if (err) return done(err);
+
+          let delivery = deliveries.find((d) => d.email_delivery_id === res.body[0].email_delivery_id);
+          expect(delivery.status).to.equal("sent");
+
+          let message = stub.messages.find((m) => m.data.includes(`Subject: ${delivery.subject}`));
+          expect(message).to.exist;
+          expect(message.to).to.deep.equal(["soldier@mann.co"]);
+          expect(message.data).to.match(/^To: .*<soldier@mann.co>$/m);
+          done();
+        });
+      });
+    });
+  });
+});
@@ -0,0 +1,137 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { getInviteeETag } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+
+describe('conditional requests', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let guest_id = "81e6d338-7917-11e5-8b8e-a37beb0fdae8";
+
+  let rsvp = {
+    email: "soldier@mann.co",
+    self: {
+      guest_id: guest_id,
+      first_name: "Soldier",
+      last_name: "",
+      attending: true
+    }
+  };
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .send(Object.assign({}, rsvp, { self: Object.assign({}, rsvp.self, { attending: false, rsvp_status: 'pending' }) }))
+    .expect(200)
+    .end((err) => {
+      if (err) return done(err);
+
+      api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+      .send({ note_body: "" })
+      .expect(200, done);
+    });
+  });
+
+  it('should send an ETag with an event and a 304 when it has not changed', (done) => {
+    api.get(`/events/${working_event_id}`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let etag = res.header.etag;
+      expect(etag).to.match(/^"\d+"$/);
+
+      api.get(`/events/${working_event_id}`)
+      .set('If-None-Match', etag)
+      .expect('ETag', etag)
+      .expect(304, done);
+    });
+  });
+
+  it('should send a 304 for an invitee that has not changed', (done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.get(`/invitees/${invitee_id}`)
+      .set('If-None-Match', etag)
+      .expect(304, done);
+    });
+  });
+
+  it('should return 428 for an edit without If-Match', (done) => {
+    api.patch(`/invitees/${invitee_id}`)
+    .send(rsvp)
+    .expect(428, done);
+  });
+
+  it('should return 412 for an edit made from an old version', (done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.patch(`/invitees/${invitee_id}`)
+      .set('If-Match', etag)
+      .send(rsvp)
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.header.etag).to.not.equal(etag);
+
+        // the other phone still has the old version
+        api.patch(`/invitees/${invitee_id}`)
+        .set('If-Match', etag)
+        .send(rsvp)
+        .expect(412, done);
+      });
+    });
+  });
+
+  it('should keep the version of an invitee when an edit fails', (done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      // invitees can't change their guest id
+      api.patch(`/invitees/${invitee_id}`)
+      .set('If-Match', etag)
+      .set('Content-Type', 'application/merge-patch+json')
+      .send(JSON.stringify({ self: { guest_id: "81e6d338-7917-11e5-8b8e-a37beb0fdab8" } }))
+      .expect(422)
+      .end((err) => {
+        if (err) return done(err);
+
+        api.patch(`/invitees/${invitee_id}`)
+        .set('If-Match', etag)
+        .set('Content-Type', 'application/json-patch+json')
+        .send(JSON.stringify([{ op: "test", path: "/email", value: "nobody@mann.co" }]))
+        .expect(409)
+        .end((err) => {
+          if (err) return done(err);
+
+          api.get(`/invitees/${invitee_id}`)
+          .set('If-None-Match', etag)
+          .expect(304, done);
+        });
+      });
+    });
+  });
+
+  it('should change the ETag of an invitee when its menu note changes', (done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+      .send({ note_body: "no mushrooms" })
+      .expect(200)
+      .end((err) => {
+        if (err) return done(err);
+
+        api.get(`/invitees/${invitee_id}`)
+        .set('If-None-Match', etag)
+        .expect(200, done);
+      });
+    });
+  });
+});
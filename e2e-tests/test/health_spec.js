@@ -0,0 +1,62 @@
+import { should } from 'chai';
+import supertest from 'supertest';
+
+import { validJWT } from '../helpers';
+
+let root = supertest(`http://localhost:${process.env.PORT}`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('health', () => {
+  describe('liveness', () => {
+    it('should return 200 without a JWT', (done) => {
+      root.get('/healthz')
+      .expect('Content-Type', /json/)
+      .expect(200, { status: "ok" }, done);
+    });
+
+    it('should ignore a bad Authorization header', (done) => {
+      root.get('/healthz')
+      .set('Authorization', 'Bearer')
+      .expect(200, done);
+    });
+  });
+
+  describe('readiness', () => {
+    it('should report each check separately', (done) => {
+      root.get('/readyz')
+      .expect('Content-Type', /json/)
+      .expect((res) => {
+        ["database", "migrations", "encryption_key"].forEach((check) => {
+          if (res.body.checks[check].status !== "ok") {
+            throw new Error(`${check} check did not pass`);
+          }
+        });
+      })
+      .expect(200, done);
+    });
+  });
+
+  describe('version', () => {
+    it('should return the build and schema versions', (done) => {
+      root.get('/version')
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect((res) => {
+        if (res.body.commit === undefined) {
+          throw new Error("commit is undefined!");
+        }
+
+        if (res.body.schema_version !== res.body.database_schema_version) {
+          throw new Error("database schema version does not match!");
+        }
+      })
+      .expect(200, done);
+    });
+  });
+
+  describe('mounting', () => {
+    it('should not be mounted under the api prefix', (done) => {
+      root.get('/api/v1/healthz')
+      .expect(404, done);
+    });
+  });
+});
@@ -0,0 +1,118 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { getInviteeETag, validJWT, validJWTWithInvalidUser } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('rsvp history', () => {
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let guest_id = "81e6d338-7917-11e5-8b8e-a37beb0fdae8";
+
+  let rsvp = (attending) => ({
+    email: "soldier@mann.co",
+    self: {
+      guest_id: guest_id,
+      first_name: "Soldier",
+      last_name: "",
+      attending: attending
+    }
+  });
+
+  before((done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.patch(`/invitees/${invitee_id}`)
+      .set('If-Match', etag)
+      .send(rsvp(true))
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        api.patch(`/invitees/${invitee_id}`)
+        .set('If-Match', res.header.etag)
+        .send(rsvp(false))
+        .expect(200, done);
+      });
+    });
+  });
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    let reset = rsvp(false);
+    reset.self.rsvp_status = 'pending';
+
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .send(reset)
+    .expect(200, done);
+  });
+
+  it('should list the steps of the rsvp, oldest first', (done) => {
+    api.get(`/invitees/${invitee_id}/relationships/history`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let kinds = res.body.map((item) => item.kind);
+
+      expect(kinds.slice(-2)).to.deep.equal(['accepted', 'declined']);
+      expect(res.body[res.body.length - 1].summary).to.equal('Declined the invitation');
+      expect(res.body[res.body.length - 1].by).to.equal('invitee');
+      expect(res.body[res.body.length - 1]).to.not.have.property('actor_id');
+      done();
+    });
+  });
+
+  it('should let admins restore an earlier step', (done) => {
+    api.get(`/invitees/${invitee_id}/relationships/history`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let accepted = res.body[res.body.length - 2];
+
+      api.post(`/invitees/${invitee_id}/restore`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .send({ audit_entry_id: accepted.audit_entry_id })
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.self.attending).to.equal(true);
+
+        api.get(`/invitees/${invitee_id}/relationships/history`)
+        .expect(200)
+        .end((err, res) => {
+          if (err) return done(err);
+
+          expect(res.body[res.body.length - 1].kind).to.equal('restored');
+          expect(res.body[res.body.length - 1].by).to.equal('user');
+          done();
+        });
+      });
+    });
+  });
+
+  it('should return 404 for a step from another invitee', (done) => {
+    api.post(`/invitees/${invitee_id}/restore`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .send({ audit_entry_id: "not-a-step" })
+    .expect(404, done);
+  });
+
+  it('should return 403 for users who are not an admin of the event', (done) => {
+    api.post(`/invitees/${invitee_id}/restore`)
+    .set('Authorization', `Bearer ${validJWTWithInvalidUser(secret)}`)
+    .send({ audit_entry_id: "not-a-step" })
+    .expect(403, done);
+  });
+
+  it('should return 401 without a JWT', (done) => {
+    api.post(`/invitees/${invitee_id}/restore`)
+    .send({ audit_entry_id: "not-a-step" })
+    .expect(401, done);
+  });
+});
@@ -0,0 +1,121 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { validJWT } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('idempotency keys', () => {
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let key = `idempotency-spec-${Date.now()}`;
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+    .send({ note_body: "" })
+    .expect(200, done);
+  });
+
+  it('should replay the response to a retried request', (done) => {
+    api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+    .set('Idempotency-Key', key)
+    .send({ note_body: "No shellfish" })
+    .expect(200)
+    .end((err, first) => {
+      if (err) return done(err);
+
+      expect(first.header['idempotent-replayed']).to.be.undefined;
+
+      api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+      .set('Idempotency-Key', key)
+      .send({ note_body: "No shellfish" })
+      .expect('Idempotent-Replayed', 'true')
+      .expect(200)
+      .end((err, retry) => {
+        if (err) return done(err);
+
+        expect(retry.body).to.deep.equal(first.body);
+        done();
+      });
+    });
+  });
+
+  it('should turn away a retry with a different body', (done) => {
+    api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+    .set('Idempotency-Key', key)
+    .send({ note_body: "No shellfish, please" })
+    .expect(422, done);
+  });
+
+  it('should turn away a key that is too long', (done) => {
+    api.post(`/invitees/${invitee_id}/relationships/menu_note`)
+    .set('Idempotency-Key', 'k'.repeat(256))
+    .send({ note_body: "" })
+    .expect(400, done);
+  });
+
+  describe('creating things twice', () => {
+    let event_id;
+
+    it('should only create one event', (done) => {
+      let create = () => api.post('/events')
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .set('Idempotency-Key', `${key}-event`)
+      .send({ name: `Idempotency Party ${key}` })
+      .expect(201);
+
+      create().end((err, first) => {
+        if (err) return done(err);
+
+        event_id = first.body.event_id;
+
+        create()
+        .expect('Idempotent-Replayed', 'true')
+        .end((err, retry) => {
+          if (err) return done(err);
+
+          expect(retry.body.event_id).to.equal(event_id);
+          done();
+        });
+      });
+    });
+
+    it('should only add one friend', (done) => {
+      api.post(`/events/${event_id}/relationships/invitees`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .send({ email: `${key}@example.com`, self: { first_name: "Miss", last_name: "Pauling" } })
+      .expect(201)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let friends = `/invitees/${res.body.invitee_id}/relationships/friends`;
+        let create = () => api.post(friends)
+        .set('Idempotency-Key', `${key}-friend`)
+        .send({ self: { first_name: "Friend", last_name: "" } })
+        .expect(201);
+
+        create().end((err, first) => {
+          if (err) return done(err);
+
+          create()
+          .expect('Idempotent-Replayed', 'true')
+          .end((err, retry) => {
+            if (err) return done(err);
+
+            expect(retry.body.invitee_friend_id).to.equal(first.body.invitee_friend_id);
+
+            api.get(`/invitees/${res.body.invitee_id}`)
+            .expect(200)
+            .end((err, invitee) => {
+              if (err) return done(err);
+
+              expect(invitee.body.friends.length).to.equal(1);
+              done();
+            });
+          });
+        });
+      });
+    });
+  });
+});
@@ -1,12 +1,14 @@
-import { should } from 'chai';
+import { expect, should } from 'chai';
 import supertest from 'supertest';
 
 import {
+  getInviteeETag,
   isStringValidUUID as validUUID,
   isDateLessThanASecondOld as validDate,
   validJWT,
   validJWTWithInvalidUser,
   validateAndCleanMenuChoicesUUIDs,
+  validateAndCleanOptionalTime,
   validateAndCleanUUID,
   validateAndCleanSeatingRequestUUIDs
 } from '../helpers';
@@ -49,6 +51,7 @@ describe('events', () => {
             start_time: "0001-01-01T00:00:00Z",
             end_time: "0001-01-01T00:00:00Z",
             respond_by: "0001-01-01T00:00:00Z",
+            time_zone: "UTC",
             allowed_friends: 0,
           })
           .expect('Content-Type', 'application/json', done);
@@ -240,11 +243,13 @@ describe('events', () => {
             res.body = res.body.map((item) => {
               // take care of menu choices
               item.self.menu_choices = validateAndCleanMenuChoicesUUIDs(item.self.menu_choices);
+              item.self.rsvp_status_at = validateAndCleanOptionalTime(item.self.rsvp_status_at);
 
               item.friends = item.friends.map((friend) => {
                 friend.invitee_friend_id = validateAndCleanUUID(friend.invitee_friend_id);
                 friend.self.guest_id = validateAndCleanUUID(friend.self.guest_id);
                 friend.self.menu_choices = validateAndCleanMenuChoicesUUIDs(friend.self.menu_choices);
+                friend.self.rsvp_status_at = validateAndCleanOptionalTime(friend.self.rsvp_status_at);
 
                 return friend;
               });
@@ -263,6 +268,8 @@ describe('events', () => {
                 "first_name": "Saxton",
                 "last_name": "Hale",
                 "attending": false,
+                "rsvp_status": "pending",
+                "rsvp_status_at": "FIXED_TIME",
                 "menu_choices": [
                   {
                     "menu_choice_id": "FIXED_ID",
@@ -290,6 +297,8 @@ describe('events', () => {
                     "first_name": "Helen",
                     "last_name": "",
                     "attending": false,
+                    "rsvp_status": "pending",
+                    "rsvp_status_at": "FIXED_TIME",
                     "menu_choices": [
                       {
                         "menu_choice_id": "FIXED_ID",
@@ -328,6 +337,8 @@ describe('events', () => {
                 "first_name": "Soldier",
                 "last_name": "",
                 "attending": false,
+                "rsvp_status": "pending",
+                "rsvp_status_at": "FIXED_TIME",
                 "menu_choices": [],
                 "menu_note": ""
               },
@@ -441,6 +452,8 @@ describe('invitees', () => {
         .expect('Content-Type', 'application/json')
         .expect((res) => {
           res.body.seating_request = validateAndCleanSeatingRequestUUIDs(res.body.seating_request, false);
+          res.body.self.rsvp_status_at = validateAndCleanOptionalTime(res.body.self.rsvp_status_at);
+          res.body.friends[0].self.rsvp_status_at = validateAndCleanOptionalTime(res.body.friends[0].self.rsvp_status_at);
         })
         .expect(
           {
@@ -451,6 +464,8 @@ describe('invitees', () => {
               first_name: "Saxton",
               last_name: "Hale",
               attending: false,
+              rsvp_status: "pending",
+              rsvp_status_at: "FIXED_TIME",
               menu_choices: [
                 {
                   menu_choice_id: "e8b849dc-9548-11e5-bea3-fbb30297c5f4",
@@ -478,6 +493,8 @@ describe('invitees', () => {
                   first_name: "Helen",
                   last_name: "",
                   attending: false,
+                  rsvp_status: "pending",
+                  rsvp_status_at: "FIXED_TIME",
                   menu_choices: [
                     {
                       menu_choice_id: "e8b86a48-9548-11e5-bea3-83652079016b",
@@ -516,35 +533,36 @@ describe('invitees', () => {
   describe('editting', () => {
     describe('with a valid invitee id', () => {
       it('should return a valid, updated object', (done) => {
-        api.patch('/invitees/fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068')
-        .set('Accept', 'application/json')
-        .send({
-          email: "shale@mann.co",
-          self: {
-            guest_id: "24669e54-5ee2-11e5-a379-7b2796b289b2",
-            first_name: "Saxton",
-            last_name: "Hale",
-            attending: true
-          }
-        })
-        .expect(200)
-        .expect('Content-Type', 'application/json')
-        .expect(
-          {
-            invitee_id: "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068",
+        getInviteeETag(api, 'fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068', (err, etag) => {
+          if (err) return done(err);
+
+          api.patch('/invitees/fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068')
+          .set('Accept', 'application/json')
+          .set('If-Match', etag)
+          .send({
             email: "shale@mann.co",
             self: {
               guest_id: "24669e54-5ee2-11e5-a379-7b2796b289b2",
               first_name: "Saxton",
               last_name: "Hale",
-              attending: true,
-              menu_choices: null,
-              menu_note: ""
-            },
-            friends: null,
-            seating_request: null
-          },
-        done);
+              attending: true
+            }
+          })
+          .expect(200)
+          .expect('Content-Type', 'application/json')
+          .expect((res) => {
+            expect(res.body).to.have.property('invitee_id', "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068");
+            expect(res.body).to.have.property('email', "shale@mann.co");
+            expect(res.body.self).to.have.property('first_name', "Saxton");
+            expect(res.body.self).to.have.property('last_name', "Hale");
+            expect(res.body.self).to.have.property('attending', true);
+            // what wasn't sent is left alone
+            expect(res.body.self).to.have.property('menu_note', "Could I have some wine with the cheese and crackers?");
+            expect(res.body.self.menu_choices).to.have.length(3);
+            expect(res.body.friends).to.have.length(1);
+          })
+          .end(done);
+        });
       });
     });
   });
@@ -575,6 +593,7 @@ describe('invitees', () => {
           throw new Error("self.guest_id is not a UUID")
         }
         res.body.self.guest_id = 'FIXED_ID';
+        res.body.self.rsvp_status_at = validateAndCleanOptionalTime(res.body.self.rsvp_status_at);
       })
       .expect(
         {
@@ -584,6 +603,8 @@ describe('invitees', () => {
             first_name: "Friend",
             last_name: "",
             attending: true,
+            rsvp_status: "attending",
+            rsvp_status_at: "FIXED_TIME",
             menu_choices: null,
             menu_note: ""
           }
@@ -594,31 +615,32 @@ describe('invitees', () => {
 
   describe('editting invitee friend', () => {
     it('should return a valid, updated object', (done) => {
-      api.patch('/invitees/fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068/relationships/friends/e6afb5b0-7b64-11e5-b861-1f0fc9657754')
-      .set('Accept', 'application/json')
-      .send({
-        self: {
-          guest_id: "81e6d338-7917-11e5-8b8e-a37beb0fdab8",
-          first_name: "Helen 2",
-          last_name: "",
-          attending: false
-        }
-      })
-      .expect(200)
-      .expect('Content-Type', 'application/json')
-      .expect(
-        {
-          invitee_friend_id: "e6afb5b0-7b64-11e5-b861-1f0fc9657754",
+      getInviteeETag(api, 'fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068', (err, etag) => {
+        if (err) return done(err);
+
+        api.patch('/invitees/fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068/relationships/friends/e6afb5b0-7b64-11e5-b861-1f0fc9657754')
+        .set('Accept', 'application/json')
+        .set('If-Match', etag)
+        .send({
           self: {
             guest_id: "81e6d338-7917-11e5-8b8e-a37beb0fdab8",
             first_name: "Helen 2",
             last_name: "",
-            attending: false,
-            menu_choices: null,
-            menu_note: ""
+            attending: false
           }
-        },
-      done);
+        })
+        .expect(200)
+        .expect('Content-Type', 'application/json')
+        .expect((res) => {
+          expect(res.body).to.have.property('invitee_friend_id', "e6afb5b0-7b64-11e5-b861-1f0fc9657754");
+          expect(res.body.self).to.have.property('guest_id', "81e6d338-7917-11e5-8b8e-a37beb0fdab8");
+          expect(res.body.self).to.have.property('first_name', "Helen 2");
+          expect(res.body.self).to.have.property('last_name', "");
+          expect(res.body.self).to.have.property('attending', false);
+          expect(res.body.self.menu_choices).to.have.length(3);
+        })
+        .end(done);
+      });
     });
   });
 
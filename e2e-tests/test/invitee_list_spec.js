@@ -0,0 +1,120 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { validJWT } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('listing invitees', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let soldier_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let saxton_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068";
+
+  let list = (query) => api.get(`/events/${working_event_id}/relationships/invitees?${query}`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`);
+
+  let ids = (res) => res.body.data.map((invitee) => invitee.invitee_id);
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    api.post(`/invitees/${soldier_id}/relationships/tags`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .send([])
+    .expect(200, done);
+  });
+
+  it('should only list the invitees of the event, sorted by email', (done) => {
+    list('')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([saxton_id, soldier_id]);
+      expect(res.body.pagination.total_items).to.equal(2);
+      done();
+    });
+  });
+
+  it('should sort invitees by name, descending', (done) => {
+    list('sort=-name')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([soldier_id, saxton_id]);
+      done();
+    });
+  });
+
+  it('should find invitees by the start of their friends\' names', (done) => {
+    list('filter[search]=hel')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([saxton_id]);
+      expect(res.body.pagination.total_items).to.equal(1);
+      done();
+    });
+  });
+
+  it('should filter invitees by whether they have a meal note', (done) => {
+    list('filter[has_menu_note]=true')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([saxton_id]);
+      done();
+    });
+  });
+
+  it('should filter invitees by their number of friends', (done) => {
+    list('filter[friends]=0')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([soldier_id]);
+      done();
+    });
+  });
+
+  it('should filter invitees by their tags', (done) => {
+    api.post(`/invitees/${soldier_id}/relationships/tags`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .send([" VIP ", "team-red", "vip"])
+    .expect(200, ["team-red", "vip"])
+    .end((err) => {
+      if (err) return done(err);
+
+      list('filter[tag]=vip,blu')
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(ids(res)).to.deep.equal([soldier_id]);
+        expect(res.body.data[0].tags).to.deep.equal(["team-red", "vip"]);
+        done();
+      });
+    });
+  });
+
+  it('should not show invitees their tags', (done) => {
+    api.get(`/invitees/${soldier_id}`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body).to.not.have.property('tags');
+      done();
+    });
+  });
+
+  it('should turn away filters it does not understand', (done) => {
+    list('filter[rsvp]=perhaps').expect(400, () => {
+      list('sort=age').expect(400, done);
+    });
+  });
+});
@@ -0,0 +1,119 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { getInviteeETag } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+
+describe('JSON:API documents', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let guest_id = "81e6d338-7917-11e5-8b8e-a37beb0fdae8";
+  let mediaType = 'application/vnd.api+json';
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .set('Content-Type', 'application/merge-patch+json')
+    .send(JSON.stringify({ email: "soldier@mann.co", self: { first_name: "Soldier", last_name: "", attending: false, rsvp_status: "pending" } }))
+    .expect(200, done);
+  });
+
+  it('should send plain JSON to clients that do not ask for JSON:API', (done) => {
+    api.get(`/invitees/${invitee_id}`)
+    .expect('Content-Type', 'application/json')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body.invitee_id).to.equal(invitee_id);
+      done();
+    });
+  });
+
+  it('should send an event as a resource', (done) => {
+    api.get(`/events/${working_event_id}`)
+    .set('Accept', mediaType)
+    .expect('Content-Type', mediaType)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let body = JSON.parse(res.text);
+      expect(body.jsonapi.version).to.equal("1.1");
+      expect(body.data.type).to.equal("events");
+      expect(body.data.id).to.equal(working_event_id);
+      expect(body.data.attributes).to.have.property("name");
+      expect(body.data.links.self).to.equal(`/api/v1/events/${working_event_id}`);
+      done();
+    });
+  });
+
+  it('should include related resources and only send the fields asked for', (done) => {
+    api.get(`/invitees/${invitee_id}?include=self.menu_choices,friends&fields[invitees]=email,self`)
+    .set('Accept', mediaType)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let body = JSON.parse(res.text);
+      expect(body.data.type).to.equal("invitees");
+      expect(Object.keys(body.data.attributes)).to.deep.equal(["email"]);
+      expect(Object.keys(body.data.relationships)).to.deep.equal(["self"]);
+      expect(body.data.relationships.self.data).to.deep.equal({ type: "guests", id: guest_id });
+
+      let guest = body.included.find((r) => r.type === "guests" && r.id === guest_id);
+      expect(guest.attributes.first_name).to.equal("Soldier");
+      done();
+    });
+  });
+
+  it('should turn away includes that are not relationships', (done) => {
+    api.get(`/invitees/${invitee_id}?include=event`)
+    .set('Accept', mediaType)
+    .expect(400, done);
+  });
+
+  it('should turn away clients that only accept JSON:API with parameters', (done) => {
+    api.get(`/invitees/${invitee_id}`)
+    .set('Accept', `${mediaType}; ext="https://example.com/ext"`)
+    .expect(406, done);
+  });
+
+  it('should turn away bodies sent as JSON:API with parameters', (done) => {
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .set('Content-Type', `${mediaType}; ext="https://example.com/ext"`)
+    .send(JSON.stringify({ data: { type: "invitees", attributes: {} } }))
+    .expect(415, done);
+  });
+
+  it('should patch an invitee with a JSON:API document', (done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.patch(`/invitees/${invitee_id}?include=self`)
+      .set('If-Match', etag)
+      .set('Accept', mediaType)
+      .set('Content-Type', mediaType)
+      .send(JSON.stringify({ data: { type: "invitees", id: invitee_id, attributes: { self: { attending: true } } } }))
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let body = JSON.parse(res.text);
+        expect(body.included[0].attributes.attending).to.equal(true);
+        done();
+      });
+    });
+  });
+
+  it('should turn away a JSON:API document for another resource', (done) => {
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .set('Content-Type', mediaType)
+    .send(JSON.stringify({ data: { type: "events", attributes: {} } }))
+    .expect(409, done);
+  });
+});
@@ -0,0 +1,34 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+
+describe('limits', () => {
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let note = `/invitees/${invitee_id}/relationships/menu_note`;
+
+  // put the note back the way the other specs expect it
+  after((done) => {
+    api.post(note)
+    .send({ note_body: "" })
+    .expect(200, done);
+  });
+
+  it('should return 413 for a body over the route\'s limit', (done) => {
+    api.post(note)
+    .send({ note_body: "a".repeat(65 << 10) })
+    .expect(413)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.header['content-type']).to.match(/json/);
+      done();
+    });
+  });
+
+  it('should accept a body under the route\'s limit', (done) => {
+    api.post(note)
+    .send({ note_body: "a".repeat(1 << 10) })
+    .expect(200, done);
+  });
+});
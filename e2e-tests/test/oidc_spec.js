@@ -0,0 +1,97 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+import url from 'url';
+import { startOIDCStub } from '../helpers/oidc_stub';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+
+// these need the server started with OIDC_ISSUER set to
+// http://localhost:$OIDC_STUB_PORT and OIDC_CLIENT_ID and OIDC_REDIRECT_URL set
+let describeOIDC = process.env.OIDC_STUB_PORT ? describe : describe.skip;
+
+describeOIDC('oidc login', () => {
+  let stub;
+
+  before(() => {
+    stub = startOIDCStub(Number(process.env.OIDC_STUB_PORT));
+  });
+
+  after(() => stub.close());
+
+  // start a login, have the stub approve it and return the code and state it
+  // redirected back with
+  function authorize(done) {
+    api.post('/oidc/authorize')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let authorization = url.parse(res.body.authorization_url, true);
+      expect(authorization.query.code_challenge_method).to.equal('S256');
+      expect(authorization.query.state).to.equal(res.body.state);
+
+      supertest(stub.issuer).get(authorization.path)
+      .expect(302)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        done(null, url.parse(res.headers.location, true).query);
+      });
+    });
+  }
+
+  it('should return a token for a user with a verified email', (done) => {
+    stub.user = { sub: 'chell', email: '1498@aperturescience.com', email_verified: true };
+
+    authorize((err, callback) => {
+      if (err) return done(err);
+
+      api.post('/oidc/token')
+      .send(callback)
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        expect(res.body.token).to.be.a('string');
+        done();
+      });
+    });
+  });
+
+  it('should not let a state be used twice', (done) => {
+    stub.user = { sub: 'chell', email: '1498@aperturescience.com', email_verified: true };
+
+    authorize((err, callback) => {
+      if (err) return done(err);
+
+      api.post('/oidc/token')
+      .send(callback)
+      .expect(200)
+      .end((err) => {
+        if (err) return done(err);
+
+        api.post('/oidc/token')
+        .send(callback)
+        .expect(401, done);
+      });
+    });
+  });
+
+  it('should return 401 for an unknown state', (done) => {
+    api.post('/oidc/token')
+    .send({ code: 'code', state: 'not_a_state' })
+    .expect(401, done);
+  });
+
+  it('should return 403 for an unverified email', (done) => {
+    stub.user = { sub: 'someone_else', email: '1498@aperturescience.com', email_verified: false };
+
+    authorize((err, callback) => {
+      if (err) return done(err);
+
+      api.post('/oidc/token')
+      .send(callback)
+      .expect(403, done);
+    });
+  });
+});
@@ -0,0 +1,134 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { validJWT } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('pagination', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let soldier_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let saxton_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b068";
+
+  let list = (query) => api.get(`/events/${working_event_id}/relationships/invitees?${query}`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`);
+
+  let ids = (res) => res.body.data.map((invitee) => invitee.invitee_id);
+
+  it('should link to the first and last numbered pages', (done) => {
+    list('page[number]=1&page[size]=10')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.headers.link).to.contain('rel="first"');
+      expect(res.headers.link).to.contain('rel="last"');
+      expect(res.headers.link).not.to.contain('rel="next"');
+      expect(res.body.pagination.current_page).to.equal(1);
+      expect(res.body.pagination.total_pages).to.equal(1);
+      done();
+    });
+  });
+
+  it('should have a first page when there is nothing to list', (done) => {
+    list('filter[search]=nobodyatall')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body.data).to.deep.equal([]);
+      expect(res.body.pagination.total_items).to.equal(0);
+      expect(res.body.pagination.total_pages).to.equal(1);
+      done();
+    });
+  });
+
+  it('should page from the start with an empty page[after]', (done) => {
+    list('page[after]=')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([saxton_id, soldier_id]);
+      expect(res.body.pagination).not.to.have.property('current_page');
+      expect(res.headers.link).to.contain('page%5Bafter%5D=');
+      done();
+    });
+  });
+
+  it('should page from the end with an empty page[before]', (done) => {
+    list('page[before]=&sort=-name')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(ids(res)).to.deep.equal([soldier_id, saxton_id]);
+      expect(res.body.pagination).not.to.have.property('prev_cursor');
+      expect(res.body.pagination).not.to.have.property('next_cursor');
+      done();
+    });
+  });
+
+  it('should 400 for a cursor that can\'t be read', (done) => {
+    list('page[after]=notacursor')
+    .expect(400, done);
+  });
+
+  it('should 400 for both cursors at once', (done) => {
+    list('page[after]=&page[before]=')
+    .expect(400, done);
+  });
+
+  it('should page the events with a Link header', (done) => {
+    api.get('/events?page[after]=')
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body).to.be.an('array');
+      expect(res.headers.link).to.contain('rel="first"');
+      done();
+    });
+  });
+
+  describe('events without a page[size]', () => {
+    let created = [];
+
+    // more events than the smallest page holds
+    before((done) => {
+      let create = (n) => {
+        if (n === 0) return done();
+
+        api.post('/events')
+        .send({ name: `Paged Party ${n} ${Date.now()}` })
+        .set('Authorization', `Bearer ${validJWT(secret)}`)
+        .expect(201)
+        .end((err, res) => {
+          if (err) return done(err);
+
+          created.push(res.body.event_id);
+          create(n - 1);
+        });
+      };
+
+      create(11);
+    });
+
+    it('should list them all on the one page', (done) => {
+      api.get('/events')
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let listed = res.body.map((event) => event.event_id);
+        expect(listed.length).to.be.above(10);
+        expect(listed).to.include.members(created);
+        expect(res.headers.link).not.to.contain('rel="next"');
+        done();
+      });
+    });
+  });
+});
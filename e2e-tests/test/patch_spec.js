@@ -0,0 +1,116 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { getInviteeETag } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+
+describe('patching invitees', () => {
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let guest_id = "81e6d338-7917-11e5-8b8e-a37beb0fdae8";
+
+  let patchInvitee = (contentType, body, cb) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return cb(err);
+
+      api.patch(`/invitees/${invitee_id}`)
+      .set('If-Match', etag)
+      .set('Content-Type', contentType)
+      .send(JSON.stringify(body))
+      .end(cb);
+    });
+  };
+
+  // put the invitee back the way the other specs expect it
+  after((done) => {
+    api.patch(`/invitees/${invitee_id}`)
+    .set('If-Match', '*')
+    .set('Content-Type', 'application/merge-patch+json')
+    .send(JSON.stringify({ email: "soldier@mann.co", self: { first_name: "Soldier", last_name: "", attending: false, rsvp_status: "pending" } }))
+    .expect(200, done);
+  });
+
+  it('should only change the fields in a merge patch', (done) => {
+    patchInvitee('application/merge-patch+json', { self: { attending: true } }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      expect(res.body.self).to.include({
+        guest_id: guest_id,
+        first_name: "Soldier",
+        last_name: "",
+        attending: true
+      });
+      expect(res.body.email).to.equal("soldier@mann.co");
+      done();
+    });
+  });
+
+  it('should apply a JSON patch', (done) => {
+    patchInvitee('application/json-patch+json', [
+      { op: "test", path: "/self/first_name", value: "Soldier" },
+      { op: "replace", path: "/self/last_name", value: "Doe" }
+    ], (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      expect(res.body.self.first_name).to.equal("Soldier");
+      expect(res.body.self.last_name).to.equal("Doe");
+      done();
+    });
+  });
+
+  it('should return 409 when a JSON patch test does not match', (done) => {
+    patchInvitee('application/json-patch+json', [
+      { op: "test", path: "/self/first_name", value: "Scout" },
+      { op: "replace", path: "/self/first_name", value: "Demoman" }
+    ], (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(409);
+      done();
+    });
+  });
+
+  it('should return 422 for fields invitees can not change', (done) => {
+    patchInvitee('application/merge-patch+json', { self: { guest_id: "81e6d338-7917-11e5-8b8e-a37beb0fdab8" } }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(422);
+      done();
+    });
+  });
+
+  it('should return 422 for menu choices, they have their own route', (done) => {
+    patchInvitee('application/json-patch+json', [
+      { op: "add", path: "/self/menu_choices/-", value: { menu_item_id: "f167eb18-864e-11e5-a016-6b70107c9bc3" } }
+    ], (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(422);
+      done();
+    });
+  });
+
+  it('should return 415 for bodies that are not patches', (done) => {
+    patchInvitee('text/plain', { self: { attending: true } }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(415);
+      expect(res.header['accept-patch']).to.include('application/merge-patch+json');
+      done();
+    });
+  });
+
+  it('should return 404 for a friend of another invitee', (done) => {
+    getInviteeETag(api, invitee_id, (err, etag) => {
+      if (err) return done(err);
+
+      api.patch(`/invitees/${invitee_id}/relationships/friends/e6afb5b0-7b64-11e5-b861-1f0fc9657754`)
+      .set('If-Match', etag)
+      .set('Content-Type', 'application/merge-patch+json')
+      .send(JSON.stringify({ self: { attending: true } }))
+      .expect(404, done);
+    });
+  });
+});
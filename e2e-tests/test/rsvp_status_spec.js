@@ -0,0 +1,77 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { patchSelf, validJWT } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('rsvp status', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+
+  let list = (query) => api.get(`/events/${working_event_id}/relationships/invitees?${query}`)
+    .set('Authorization', `Bearer ${validJWT(secret)}`);
+
+  after((done) => {
+    patchSelf(api, invitee_id, { rsvp_status: "pending" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      done();
+    });
+  });
+
+  it('should set the status and when it was set', (done) => {
+    patchSelf(api, invitee_id, { rsvp_status: "maybe" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      expect(res.body.self.rsvp_status).to.equal("maybe");
+      expect(res.body.self.attending).to.equal(false);
+      expect(Date.parse(res.body.self.rsvp_status_at)).to.not.be.NaN;
+      done();
+    });
+  });
+
+  it('should filter the invitee list by status', (done) => {
+    list('filter[rsvp]=maybe')
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body.data.map((invitee) => invitee.invitee_id)).to.deep.equal([invitee_id]);
+      done();
+    });
+  });
+
+  it('should mark guests attending when only attending is sent', (done) => {
+    patchSelf(api, invitee_id, { attending: true }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      expect(res.body.self.rsvp_status).to.equal("attending");
+      done();
+    });
+  });
+
+  it('should keep attending in step with the status', (done) => {
+    patchSelf(api, invitee_id, { rsvp_status: "declined" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      expect(res.body.self.rsvp_status).to.equal("declined");
+      expect(res.body.self.attending).to.equal(false);
+      done();
+    });
+  });
+
+  it('should 422 for a status it does not know', (done) => {
+    patchSelf(api, invitee_id, { rsvp_status: "perhaps" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(422);
+      done();
+    });
+  });
+});
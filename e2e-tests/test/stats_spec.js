@@ -0,0 +1,65 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { validJWT, validJWTWithInvalidUser } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('event stats', () => {
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+
+  let stats = (jwt) => api.get(`/events/${working_event_id}/relationships/stats`)
+    .set('Authorization', `Bearer ${jwt}`);
+
+  it('should count the guests by status', (done) => {
+    stats(validJWT(secret))
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let s = res.body;
+      expect(s.num_guests).to.equal(s.num_pending + s.num_attending + s.num_declined + s.num_maybe);
+      expect(s.num_guests).to.equal(s.num_invitees + s.num_friends);
+      expect(s.num_invitees).to.equal(s.invitees.pending + s.invitees.attending + s.invitees.declined + s.invitees.maybe);
+      expect(s.response_rate).to.be.within(0, 1);
+      expect(s.projected_headcount).to.be.at.least(s.num_attending);
+      done();
+    });
+  });
+
+  it('should count menu completion and seating requests', (done) => {
+    stats(validJWT(secret))
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let menu = res.body.menu;
+      expect(menu.complete + menu.partial + menu.none).to.equal(res.body.num_attending);
+      expect(menu.completion_rate).to.be.within(0, 1);
+      expect(res.body.seating_requests.invitees).to.be.at.most(res.body.seating_requests.total);
+      done();
+    });
+  });
+
+  it('should give a running total of answers by day', (done) => {
+    stats(validJWT(secret))
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      let responded = 0;
+      res.body.timeline.forEach((day) => {
+        expect(day.date).to.match(/^\d{4}-\d{2}-\d{2}$/);
+        responded += day.attending + day.declined + day.maybe;
+        expect(day.responded).to.equal(responded);
+      });
+      done();
+    });
+  });
+
+  it('should return 403 for someone who is not an admin of the event', (done) => {
+    stats(validJWTWithInvalidUser(secret))
+    .expect(403, done);
+  });
+});
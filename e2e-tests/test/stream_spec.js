@@ -0,0 +1,171 @@
+import http from 'http';
+
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { patchSelf, validJWT, validJWTWithInvalidUser } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+describe('live feed', function () {
+  this.timeout(10000);
+
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let path = `/api/v1/events/${working_event_id}/stream`;
+
+  // open the stream as whoever jwt is for. onEvent is called with each event
+  // sent on it.
+  let open = (jwt, onResponse, onEvent) => {
+    let req = http.get({
+      port: process.env.PORT,
+      path: path,
+      headers: { 'Authorization': `Bearer ${jwt}`, 'Accept': 'text/event-stream' }
+    }, (res) => {
+      let buffer = '';
+
+      res.setEncoding('utf8');
+      res.on('data', (chunk) => {
+        buffer += chunk;
+
+        let parts = buffer.split('\n\n');
+        buffer = parts.pop();
+
+        parts.forEach((part) => {
+          let event = {};
+
+          part.split('\n').forEach((line) => {
+            if (line.startsWith('event: ')) event.name = line.slice(7);
+            if (line.startsWith('data: ')) event.data = JSON.parse(line.slice(6));
+          });
+
+          if (event.name) onEvent(event);
+        });
+      });
+
+      onResponse(res);
+    });
+
+    req.on('error', () => {});
+
+    return req;
+  };
+
+  after((done) => {
+    patchSelf(api, invitee_id, { rsvp_status: "pending" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      done();
+    });
+  });
+
+  it('should turn away users who are not admins of the event', (done) => {
+    let req = open(validJWTWithInvalidUser(secret), (res) => {
+      expect(res.statusCode).to.equal(403);
+      req.abort();
+      done();
+    }, () => {});
+  });
+
+  it('should send the stats and then the changes to invitees', (done) => {
+    let events = [];
+    let req;
+
+    let finish = (err) => {
+      req.abort();
+      done(err);
+    };
+
+    req = open(validJWT(secret), (res) => {
+      expect(res.statusCode).to.equal(200);
+      expect(res.headers['content-type']).to.equal('text/event-stream');
+    }, (event) => {
+      events.push(event);
+
+      // change the invitee once the stream has started
+      if (events.length === 1) {
+        expect(event.name).to.equal('stats');
+        expect(event.data.num_invitees).to.be.above(0);
+
+        return patchSelf(api, invitee_id, { rsvp_status: "maybe" }, (err, res) => {
+          if (err) return finish(err);
+
+          expect(res.status).to.equal(200);
+        });
+      }
+
+      if (event.name === 'invitee') {
+        try {
+          expect(event.data.invitee_id).to.equal(invitee_id);
+          expect(event.data.self.rsvp_status).to.equal("maybe");
+        } catch (e) {
+          return finish(e);
+        }
+      }
+
+      if (event.name === 'stats' && events.some((e) => e.name === 'invitee')) {
+        finish();
+      }
+    });
+  });
+
+  describe('with an API key', () => {
+    let key, keyID;
+
+    before((done) => {
+      api.post(`/events/${working_event_id}/relationships/api_keys`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .send({ name: "dashboard", scopes: ["reports:read"] })
+      .expect(201)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        key = res.body.key;
+        keyID = res.body.api_key_id;
+        done();
+      });
+    });
+
+    after((done) => {
+      api.delete(`/events/${working_event_id}/relationships/api_keys/${keyID}`)
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(204, done);
+    });
+
+    it('should only send the stats to a key that can not read invitees', (done) => {
+      let events = [];
+      let req;
+
+      let finish = (err) => {
+        req.abort();
+        done(err);
+      };
+
+      req = open(key, (res) => {
+        expect(res.statusCode).to.equal(200);
+      }, (event) => {
+        events.push(event);
+
+        if (events.length === 1) {
+          return patchSelf(api, invitee_id, { rsvp_status: "declined" }, (err, res) => {
+            if (err) return finish(err);
+
+            expect(res.status).to.equal(200);
+          });
+        }
+
+        // the stats after the change
+        try {
+          expect(event.name).to.equal('stats');
+          expect(events.map((e) => e.name)).to.not.include('invitee');
+        } catch (e) {
+          return finish(e);
+        }
+
+        finish();
+      });
+    });
+  });
+});
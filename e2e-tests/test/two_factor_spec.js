@@ -0,0 +1,240 @@
+import { expect } from 'chai';
+import supertest from 'supertest';
+import jwt from 'jsonwebtoken';
+import crypto from 'crypto';
+import { validJWT, validJWTWithInvalidUser } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+
+let login = {
+  email: "1498@aperturescience.com",
+  password: "GLaDOS",
+};
+
+// decode an unpadded base32 string, as TOTP secrets are sent
+function base32Decode(s) {
+  let alphabet = 'ABCDEFGHIJKLMNOPQRSTUVWXYZ234567';
+  let bits = '';
+
+  for (let c of s.toUpperCase()) {
+    bits += alphabet.indexOf(c).toString(2).padStart(5, '0');
+  }
+
+  let bytes = [];
+
+  for (let i = 0; i + 8 <= bits.length; i += 8) {
+    bytes.push(parseInt(bits.slice(i, i + 8), 2));
+  }
+
+  return Buffer.from(bytes);
+}
+
+// the RFC 6238 code of key for the 30 second step
+function totp(key, step) {
+  let counter = Buffer.alloc(8);
+  counter.writeUInt32BE(Math.floor(step / 0x100000000), 0);
+  counter.writeUInt32BE(step % 0x100000000, 4);
+
+  let hmac = crypto.createHmac('sha1', base32Decode(key)).update(counter).digest();
+  let offset = hmac[hmac.length - 1] & 0xf;
+  let code = (hmac.readUInt32BE(offset) & 0x7fffffff) % 1000000;
+
+  return String(code).padStart(6, '0');
+}
+
+describe('two factor authentication', () => {
+  let eventID;
+  let totpSecret;
+  let recoveryCodes = [];
+  // a step can only be used once, so each code is for a later step than the
+  // last
+  let lastStep = 0;
+
+  function nextCode() {
+    lastStep = Math.max(Math.floor(Date.now() / 30000), lastStep + 1);
+    return totp(totpSecret, lastStep);
+  }
+
+  function requireTwoFactor(required, token) {
+    return api.post(`/events/${eventID}/relationships/require_two_factor`)
+    .send({ required: required })
+    .set('Authorization', `Bearer ${token || validJWT(secret)}`);
+  }
+
+  // log in with the password and get the challenge to answer
+  function challenge(done) {
+    api.post('/token')
+    .send(login)
+    .expect(200)
+    .end((err, res) => {
+      if (err) return done(err);
+
+      expect(res.body.token).to.be.undefined;
+      expect(res.body.challenge).to.be.a('string');
+      done(null, res.body.challenge);
+    });
+  }
+
+  before((done) => {
+    api.post('/events')
+    .send({ name: `Two Factor Party ${Date.now()}` })
+    .set('Authorization', `Bearer ${validJWT(secret)}`)
+    .expect(201)
+    .end((err, res) => {
+      eventID = res && res.body.event_id;
+      done(err);
+    });
+  });
+
+  // leave the user without two factor authentication for the other specs,
+  // even if a spec failed part way through
+  after((done) => {
+    requireTwoFactor(false)
+    .end(() => {
+      api.delete('/two_factor')
+      .send({ code: recoveryCodes[recoveryCodes.length - 1] || '' })
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .end(() => done());
+    });
+  });
+
+  describe('requiring it of an event', () => {
+    it('should return a 409 when the owner has not enabled it', (done) => {
+      requireTwoFactor(true)
+      .expect(409, done);
+    });
+
+    it('should return a 403 when the user is not the owner', (done) => {
+      requireTwoFactor(true, validJWTWithInvalidUser(secret))
+      .expect(403, done);
+    });
+  });
+
+  describe('enrolment', () => {
+    it('should return a secret and an otpauth URI', (done) => {
+      api.post('/two_factor')
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(200)
+      .expect((res) => {
+        expect(res.body.secret).to.match(/^[A-Z2-7]+$/);
+        expect(res.body.uri).to.contain('otpauth://totp/');
+        expect(res.body.uri).to.contain(`secret=${res.body.secret}`);
+        totpSecret = res.body.secret;
+      })
+      .end(done);
+    });
+
+    it('should return the recovery codes once confirmed with a code', (done) => {
+      api.post('/two_factor/confirm')
+      .send({ code: nextCode() })
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(200)
+      .expect((res) => {
+        expect(res.body.recovery_codes).to.have.length(10);
+        recoveryCodes = res.body.recovery_codes;
+      })
+      .end(done);
+    });
+  });
+
+  describe('logging in', () => {
+    let current;
+
+    before((done) => {
+      challenge((err, c) => {
+        current = c;
+        done(err);
+      });
+    });
+
+    it('should return a 401 on a wrong code', (done) => {
+      api.post('/token')
+      .send({ challenge: current, code: 'aaaaa-aaaaa' })
+      .expect((res) => {
+        expect(res.body.token).to.be.undefined;
+      })
+      .expect(401, done);
+    });
+
+    it('should return a token for a recovery code', (done) => {
+      api.post('/token')
+      .send({ challenge: current, code: recoveryCodes[0] })
+      .expect(200)
+      .expect((res) => {
+        jwt.verify(res.body.token, secret, {
+          algorithms: ["HS512"]
+        });
+      })
+      .end(done);
+    });
+
+    describe('with a used recovery code', () => {
+      before((done) => {
+        challenge((err, c) => {
+          current = c;
+          done(err);
+        });
+      });
+
+      it('should return a 401', (done) => {
+        api.post('/token')
+        .send({ challenge: current, code: recoveryCodes[0] })
+        .expect(401, done);
+      });
+
+      it('should still take a code from the authenticator app', (done) => {
+        api.post('/token')
+        .send({ challenge: current, code: nextCode() })
+        .expect(200)
+        .expect((res) => {
+          expect(res.body.token).to.be.a('string');
+        })
+        .end(done);
+      });
+    });
+
+    describe('with a used code from the authenticator app', () => {
+      before((done) => {
+        challenge((err, c) => {
+          current = c;
+          done(err);
+        });
+      });
+
+      it('should return a 401', (done) => {
+        api.post('/token')
+        .send({ challenge: current, code: totp(totpSecret, lastStep) })
+        .expect(401, done);
+      });
+    });
+  });
+
+  describe('once the owner has enabled it', () => {
+    it('should be required of the event', (done) => {
+      requireTwoFactor(true)
+      .expect(200)
+      .expect({ required: true }, done);
+    });
+
+    it('should return a 403 to admins without it', (done) => {
+      api.delete('/two_factor')
+      .send({ code: recoveryCodes[1] })
+      .set('Authorization', `Bearer ${validJWT(secret)}`)
+      .expect(204)
+      .end((err) => {
+        if (err) return done(err);
+
+        api.get(`/events/${eventID}/relationships/audit`)
+        .set('Authorization', `Bearer ${validJWT(secret)}`)
+        .expect(403, done);
+      });
+    });
+
+    it('should let the owner stop requiring it without it', (done) => {
+      requireTwoFactor(false)
+      .expect(200)
+      .expect({ required: false }, done);
+    });
+  });
+});
@@ -0,0 +1,244 @@
+import crypto from 'crypto';
+import http from 'http';
+
+import { expect } from 'chai';
+import supertest from 'supertest';
+
+import { patchSelf, validJWT, validJWTWithInvalidUser } from '../helpers';
+
+let api = supertest(`http://localhost:${process.env.PORT}/api/v1`);
+let secret = String(process.env.GO_JWT_MIDDLEWARE_KEY);
+let receiverPort = Number(process.env.WEBHOOK_RECEIVER_PORT || 8099);
+
+describe('webhooks', function () {
+  // the requests are sent by background workers, which look for jobs every
+  // second
+  this.timeout(10000);
+
+  let working_event_id = "cd7bc650-2e71-11e5-a390-675459d99309";
+  let invitee_id = "fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078";
+  let admin = (req) => req.set('Authorization', `Bearer ${validJWT(secret)}`);
+  let webhooks = `/events/${working_event_id}/relationships/webhooks`;
+
+  // a local receiver standing in for the systems webhooks are sent to. It
+  // answers with status and keeps every request it gets. Webhooks are only
+  // sent to it if the server was started with
+  // -webhook-allowed-networks 127.0.0.0/8.
+  let received = [];
+  let status = 200;
+  let receiver = http.createServer((req, res) => {
+    let chunks = [];
+
+    req.on('data', (chunk) => chunks.push(chunk));
+    req.on('end', () => {
+      received.push({ headers: req.headers, body: Buffer.concat(chunks).toString() });
+      res.writeHead(status);
+      res.end(status === 200 ? 'ok' : 'nope');
+    });
+  });
+
+  let webhook;
+
+  // wait for the receiver to have got count requests
+  let receive = (count, cb, tries = 50) => {
+    if (received.length >= count || tries === 0) {
+      return cb(received.slice(0, count));
+    }
+
+    setTimeout(() => receive(count, cb, tries - 1), 100);
+  };
+
+  // wait for the delivery with the id id to be done, as done tells
+  let waitForDelivery = (id, done, cb, tries = 50) => {
+    admin(api.get(`${webhooks}/${webhook.webhook_id}/deliveries`))
+    .expect(200)
+    .end((err, res) => {
+      if (err) return cb(err);
+
+      let found = res.body.data.find((d) => d.webhook_delivery_id === id);
+
+      if ((!found || !done(found)) && tries > 0) {
+        return setTimeout(() => waitForDelivery(id, done, cb, tries - 1), 100);
+      }
+
+      cb(null, found);
+    });
+  };
+  let settled = (d) => d.status !== 'pending';
+  let attempted = (d) => d.attempts > 0;
+
+  before((done) => receiver.listen(receiverPort, done));
+
+  after((done) => {
+    receiver.close();
+
+    patchSelf(api, invitee_id, { rsvp_status: "pending" }, (err, res) => {
+      if (err) return done(err);
+
+      expect(res.status).to.equal(200);
+      done();
+    });
+  });
+
+  describe('creating', () => {
+    it('should turn away users who are not admins of the event', (done) => {
+      api.post(webhooks)
+      .set('Authorization', `Bearer ${validJWTWithInvalidUser(secret)}`)
+      .send({ url: `http://localhost:${receiverPort}/hook`, event_types: ["rsvp.changed"] })
+      .expect(403, done);
+    });
+
+    it('should turn away unknown event types', (done) => {
+      admin(api.post(webhooks))
+      .send({ url: `http://localhost:${receiverPort}/hook`, event_types: ["rsvp.eaten"] })
+      .expect(422, done);
+    });
+
+    it('should turn away private addresses', (done) => {
+      admin(api.post(webhooks))
+      .send({ url: "http://169.254.169.254/latest/meta-data/", event_types: ["rsvp.changed"] })
+      .expect(422, done);
+    });
+
+    it('should turn away urls that are not http', (done) => {
+      admin(api.post(webhooks))
+      .send({ url: "ftp://example.com/hook", event_types: ["rsvp.changed"] })
+      .expect(422, done);
+    });
+
+    it('should create a webhook and show its secret once', (done) => {
+      admin(api.post(webhooks))
+      .send({ url: `http://localhost:${receiverPort}/hook`, event_types: ["rsvp.changed", "menu_choice.set"] })
+      .expect(201)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        webhook = res.body;
+        expect(webhook.secret).to.match(/^whsec_/);
+        expect(webhook.event_types).to.deep.equal(["rsvp.changed", "menu_choice.set"]);
+
+        admin(api.get(webhooks))
+        .expect(200)
+        .end((err, res) => {
+          if (err) return done(err);
+
+          let listed = res.body.find((w) => w.webhook_id === webhook.webhook_id);
+          expect(listed.url).to.equal(webhook.url);
+          expect(listed.secret).to.equal(undefined);
+          done();
+        });
+      });
+    });
+  });
+
+  describe('delivering', () => {
+    it('should send a signed request when an RSVP changes', (done) => {
+      patchSelf(api, invitee_id, { rsvp_status: "maybe" }, (err, res) => {
+        if (err) return done(err);
+
+        expect(res.status).to.equal(200);
+
+        receive(1, ([req]) => {
+          expect(req).to.not.equal(undefined);
+          expect(req.headers['capacious-event']).to.equal("rsvp.changed");
+
+          let [, t, sig] = req.headers['capacious-signature'].match(/^t=(\d+),v1=([0-9a-f]+)$/);
+          let expected = crypto.createHmac('sha256', webhook.secret).update(`${t}.${req.body}`).digest('hex');
+          expect(sig).to.equal(expected);
+
+          let body = JSON.parse(req.body);
+          expect(body.type).to.equal("rsvp.changed");
+          expect(body.event_id).to.equal(working_event_id);
+          expect(body.data.invitee.invitee_id).to.equal(invitee_id);
+          expect(body.data.rsvp_status).to.equal("maybe");
+          expect(body.data.previous_rsvp_status).to.equal("pending");
+
+          waitForDelivery(req.headers['capacious-delivery'], settled, (err, delivery) => {
+            if (err) return done(err);
+
+            expect(delivery.status).to.equal("delivered");
+            expect(delivery.response_status).to.equal(200);
+            done();
+          });
+        });
+      });
+    });
+
+    it('should only send the event types the webhook asked for', (done) => {
+      received = [];
+
+      // set the invitee's seating requests to what they already are
+      api.get(`/invitees/${invitee_id}`)
+      .expect(200)
+      .end((err, res) => {
+        if (err) return done(err);
+
+        let requests = (res.body.seating_request || []).map((r) => ({ invitee_request_id: r.invitee_request_id }));
+
+        api.post(`/invitees/${invitee_id}/relationships/seating_requests`)
+        .send(requests)
+        .expect(200)
+        .end((err) => {
+          if (err) return done(err);
+
+          setTimeout(() => {
+            expect(received.length).to.equal(0);
+            done();
+          }, 1500);
+        });
+      });
+    });
+
+    it('should keep a failed delivery pending and redeliver it', (done) => {
+      received = [];
+      status = 500;
+
+      patchSelf(api, invitee_id, { rsvp_status: "attending" }, (err, res) => {
+        if (err) return done(err);
+
+        expect(res.status).to.equal(200);
+
+        receive(1, ([req]) => {
+          let id = req.headers['capacious-delivery'];
+
+          waitForDelivery(id, attempted, (err, delivery) => {
+            if (err) return done(err);
+
+            expect(delivery.status).to.equal("pending");
+            expect(delivery.response_status).to.equal(500);
+            expect(delivery.error).to.match(/500/);
+
+            status = 200;
+
+            admin(api.post(`${webhooks}/${webhook.webhook_id}/deliveries/${id}/redeliver`))
+            .expect(202)
+            .end((err) => {
+              if (err) return done(err);
+
+              waitForDelivery(id, settled, (err, delivery) => {
+                if (err) return done(err);
+
+                expect(delivery.status).to.equal("delivered");
+                expect(delivery.attempts).to.be.at.least(2);
+                done();
+              });
+            });
+          });
+        });
+      });
+    });
+  });
+
+  describe('deleting', () => {
+    it('should delete a webhook', (done) => {
+      admin(api.delete(`${webhooks}/${webhook.webhook_id}`))
+      .expect(204)
+      .end((err) => {
+        if (err) return done(err);
+
+        admin(api.get(`${webhooks}/${webhook.webhook_id}/deliveries`))
+        .expect(404, done);
+      });
+    });
+  });
+});
@@ -1,33 +1,73 @@
 package entities
 
-import "time"
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
 
 // Event represents an object that contains details about a specific event.
+// When RequireTwoFactor is set, admins without two factor authentication
+// enabled can't manage the event. Version goes up by one with every change to
+// the event. RSVPsLockedAt is when invitees stopped being able to change their
+// RSVPs, which happens at RespondBy; it is nil while they still can.
+// TimeZone is the IANA time zone the event's times are shown in, and
+// CalendarSequence goes up whenever its time or place changes so calendars
+// take the new entry over the one they have.
 type Event struct {
-	EventID        string    `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"event_id"`
-	Name           string    `json:"name"`
-	Description    string    `json:"description"`
-	Location       string    `json:"location"`
-	StartTime      time.Time `json:"start_time"`
-	EndTime        time.Time `json:"end_time"`
-	RespondBy      time.Time `json:"respond_by"`
-	AllowedFriends int       `json:"allowed_friends"`
-	CreatedAt      time.Time `json:"-"`
-	UpdatedAt      time.Time `json:"-"`
+	EventID          string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"event_id"`
+	Name             string     `json:"name"`
+	Description      string     `json:"description"`
+	Location         string     `json:"location"`
+	StartTime        time.Time  `json:"start_time"`
+	EndTime          time.Time  `json:"end_time"`
+	RespondBy        time.Time  `json:"respond_by"`
+	TimeZone         string     `sql:"DEFAULT:'UTC'" json:"time_zone"`
+	AllowedFriends   int        `json:"allowed_friends"`
+	RequireTwoFactor bool       `json:"require_two_factor"`
+	RSVPsLockedAt    *time.Time `gorm:"column:rsvps_locked_at" json:"rsvps_locked_at,omitempty"`
+	Version          int        `sql:"DEFAULT:1" json:"-"`
+	CalendarSequence int        `json:"-"`
+	CreatedAt        time.Time  `json:"-"`
+	UpdatedAt        time.Time  `json:"-"`
 }
 
 // Guest represents an object that contains details about a specific guest.
 // A guest is always referenced from the self attribute of an Invitee or
 // InviteeFriend
+// RSVPStatus is the guest's answer to the invitation and RSVPStatusAt when it
+// was last changed. Attending is kept for clients that only know whether a
+// guest is coming; it is true exactly when RSVPStatus is RSVPAttending.
 type Guest struct {
-	GuestID     string       `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"guest_id"`
-	FirstName   string       `json:"first_name"`
-	LastName    string       `json:"last_name"`
-	Attending   bool         `json:"attending"`
-	MenuChoices []MenuChoice `json:"menu_choices"`
-	MenuNote    string       `sql:"-" json:"menu_note"`
-	CreatedAt   time.Time    `json:"-"`
-	UpdatedAt   time.Time    `json:"-"`
+	GuestID      string       `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"guest_id"`
+	FirstName    string       `json:"first_name"`
+	LastName     string       `json:"last_name"`
+	Attending    bool         `json:"attending"`
+	RSVPStatus   string       `gorm:"column:rsvp_status" sql:"DEFAULT:'pending'" json:"rsvp_status"`
+	RSVPStatusAt *time.Time   `gorm:"column:rsvp_status_at" json:"rsvp_status_at"`
+	MenuChoices  []MenuChoice `json:"menu_choices"`
+	MenuNote     string       `sql:"-" json:"menu_note"`
+	CreatedAt    time.Time    `json:"-"`
+	UpdatedAt    time.Time    `json:"-"`
+}
+
+// the answers a guest can give to an invitation
+const (
+	RSVPPending   = "pending"
+	RSVPAttending = "attending"
+	RSVPDeclined  = "declined"
+	RSVPMaybe     = "maybe"
+)
+
+// IsRSVPStatus tells if status is one of the answers a guest can give.
+func IsRSVPStatus(status string) bool {
+	switch status {
+	case RSVPPending, RSVPAttending, RSVPDeclined, RSVPMaybe:
+		return true
+	}
+
+	return false
 }
 
 // Invitee represents an object that contains details about a specific invitee.
@@ -36,6 +76,10 @@ type Guest struct {
 // bringing, and other invitees the invitee would like to be seated near.
 // The Invitee object also holds db keys for the event it relates to as well as
 // the guest it relates to.
+// Version goes up by one with every change to the invitee, its friends, their
+// menu choices and notes, or its seating requests. Tags are labels admins give
+// invitees to find them by; invitees don't see them. RespondedAt is when the
+// invitee last answered the invitation, nil if they haven't.
 type Invitee struct {
 	InviteeID       string                  `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"invitee_id"`
 	FkEventID       string                  `json:"-"`
@@ -44,10 +88,43 @@ type Invitee struct {
 	Self            Guest                   `json:"self"`
 	Friends         []InviteeFriend         `json:"friends"`
 	SeatingRequests []InviteeSeatingRequest `json:"seating_request"`
+	Tags            []string                `sql:"-" json:"tags,omitempty"`
+	RespondedAt     *time.Time              `json:"-"`
+	Version         int                     `sql:"DEFAULT:1" json:"-"`
 	CreatedAt       time.Time               `json:"-"`
 	UpdatedAt       time.Time               `json:"-"`
 }
 
+// the fields invitees can be sorted by
+const (
+	InviteeSortName        = "name"
+	InviteeSortEmail       = "email"
+	InviteeSortRespondedAt = "responded_at"
+	InviteeSortUpdatedAt   = "updated_at"
+)
+
+// InviteeFilter narrows down and orders a list of invitees. Empty fields
+// match everything. RSVP matches the RSVP status of the invitee themselves.
+// Tags matches invitees with any of the tags. Search matches the starts of
+// the words in the names of an invitee and their friends and in their email.
+type InviteeFilter struct {
+	RSVP           string
+	HasMenuChoices *bool
+	HasMenuNote    *bool
+	Friends        *int
+	MinFriends     *int
+	MaxFriends     *int
+	Tags           []string
+	Search         string
+	Sort           []InviteeSort
+}
+
+// InviteeSort is a field to sort invitees by.
+type InviteeSort struct {
+	Field      string
+	Descending bool
+}
+
 // InviteeFriend represents an object that contains details about a specific
 // InviteeFriend.
 // An InviteeFriend simply holds a guest object for info about the friend.
@@ -158,20 +235,388 @@ type User struct {
 }
 
 // UserLogin holds the details necessary to authenticate a user with the system.
+// FailedLogins counts the failed logins since the last successful one and
+// LockedUntil is when the login can be used again after too many of them.
+// TotpSecret is the encrypted TOTP secret. It is set when enrolment starts but
+// only used once TotpEnabled is set. TotpLastStep is the time step of the last
+// TOTP code used, so a code can't be used twice.
 type UserLogin struct {
-	UserLoginID string    `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"-"`
-	FkUserID    string    `json:"-"`
-	Salt        string    `json:"-"`
-	Password    string    `json:"-"`
-	CreatedAt   time.Time `json:"-"`
-	UpdatedAt   time.Time `json:"-"`
+	UserLoginID  string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"-"`
+	FkUserID     string     `json:"-"`
+	Salt         string     `json:"-"`
+	Password     string     `json:"-"`
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+	TotpSecret   string     `json:"-"`
+	TotpEnabled  bool       `json:"-"`
+	TotpLastStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"-"`
+	UpdatedAt    time.Time  `json:"-"`
 }
 
-// EventAdmin holds the db key of a user and an event they are an admin of
+// RecoveryCode is a one time code a user can log in with in place of a TOTP
+// code. Only a hash of the code is kept.
+type RecoveryCode struct {
+	RecoveryCodeID string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"-"`
+	FkUserID       string     `json:"-"`
+	CodeHash       string     `json:"-"`
+	UsedAt         *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"-"`
+	UpdatedAt      time.Time  `json:"-"`
+}
+
+// LoginChallenge is a login that has passed the password check and is
+// waiting on a second factor.
+type LoginChallenge struct {
+	LoginChallengeID string    `gorm:"primary_key" json:"-"`
+	FkUserLoginID    string    `json:"-"`
+	Attempts         int       `json:"-"`
+	ExpiresAt        time.Time `json:"-"`
+	CreatedAt        time.Time `json:"-"`
+	UpdatedAt        time.Time `json:"-"`
+}
+
+// OidcLoginState is a login that has been sent to the OIDC provider. It holds
+// the PKCE code verifier and ID token nonce to check the login against when
+// it comes back.
+type OidcLoginState struct {
+	State        string    `gorm:"primary_key" json:"-"`
+	CodeVerifier string    `json:"-"`
+	Nonce        string    `json:"-"`
+	ExpiresAt    time.Time `json:"-"`
+	CreatedAt    time.Time `json:"-"`
+	UpdatedAt    time.Time `json:"-"`
+}
+
+// UserIdentity links a user to their account with an OIDC provider.
+type UserIdentity struct {
+	UserIdentityID string    `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"-"`
+	FkUserID       string    `json:"-"`
+	Issuer         string    `json:"-"`
+	Subject        string    `json:"-"`
+	CreatedAt      time.Time `json:"-"`
+	UpdatedAt      time.Time `json:"-"`
+}
+
+// EventAdmin holds the db key of a user and an event they are an admin of.
+// Owner is set for the admin who created the event.
 type EventAdmin struct {
 	EventAdminID string    `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"-"`
 	FkUserID     string    `json:"-"`
 	FkEventID    string    `json:"-"`
+	Owner        bool      `json:"-"`
 	CreatedAt    time.Time `json:"-"`
 	UpdatedAt    time.Time `json:"-"`
 }
+
+// APIKey lets another system act for the admin who created it, but only on
+// one event and only for its scopes. Only a hash of the key is kept, KeyID is
+// the start of the key that can be shown to tell keys apart.
+type APIKey struct {
+	APIKeyID   string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"api_key_id"`
+	FkEventID  string     `json:"-"`
+	FkUserID   string     `json:"-"`
+	Name       string     `json:"name"`
+	KeyID      string     `json:"key_id"`
+	KeyHash    string     `json:"-"`
+	Scopes     StringList `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+}
+
+// StringList is a list of strings kept in one space separated column.
+type StringList []string
+
+// Value joins the list for storing.
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, " "), nil
+}
+
+// Scan splits a stored list.
+func (l *StringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*l = strings.Fields(v)
+	case []byte:
+		*l = strings.Fields(string(v))
+	case nil:
+		*l = nil
+	default:
+		return errors.New("cannot scan a string list from a non string column")
+	}
+
+	return nil
+}
+
+// AuditEntry records one change to an event or an invitee's RSVP. Before and
+// After are the JSON of what changed and Diff the fields that differ between
+// them. InviteeID and GuestID are set for changes to an invitee, to find the
+// changes to one guest. Entries are never updated or deleted.
+type AuditEntry struct {
+	AuditEntryID string    `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"audit_entry_id"`
+	FkEventID    string    `json:"-"`
+	ActorType    string    `json:"actor_type"`
+	ActorID      string    `json:"actor_id"`
+	EntityType   string    `json:"entity_type"`
+	EntityID     string    `json:"entity_id"`
+	InviteeID    string    `json:"invitee_id"`
+	GuestID      string    `json:"guest_id"`
+	Action       string    `json:"action"`
+	Before       JSON      `json:"before"`
+	After        JSON      `json:"after"`
+	Diff         JSON      `json:"diff"`
+	RequestID    string    `json:"request_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows down a list of audit entries. Empty fields match
+// everything.
+type AuditFilter struct {
+	InviteeID  string
+	GuestID    string
+	EntityType string
+	EntityID   string
+}
+
+// EmailTemplate is what an email of one kind to the invitees of an event
+// says. Subject and Body are text/template templates.
+type EmailTemplate struct {
+	FkEventID string    `gorm:"primary_key" json:"-"`
+	Kind      string    `gorm:"primary_key" json:"kind"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// the kinds of emails invitees are sent
+const (
+	EmailInvitation       = "invitation"
+	EmailRSVPConfirmation = "rsvp_confirmation"
+	EmailMenuConfirmation = "menu_confirmation"
+	EmailReminder         = "reminder"
+)
+
+// EmailKinds are the kinds of emails invitees are sent.
+var EmailKinds = []string{EmailInvitation, EmailRSVPConfirmation, EmailMenuConfirmation, EmailReminder}
+
+// IsEmailKind tells if kind is a kind of email invitees are sent.
+func IsEmailKind(kind string) bool {
+	for _, k := range EmailKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EmailDelivery is an email sent to an invitee. It is EmailQueued until the
+// transport has taken it or failed to, and Error says why it failed.
+type EmailDelivery struct {
+	EmailDeliveryID string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"email_delivery_id"`
+	FkEventID       string     `json:"-"`
+	FkInviteeID     string     `json:"invitee_id"`
+	Kind            string     `json:"kind"`
+	ToAddress       string     `json:"to"`
+	Subject         string     `json:"subject"`
+	Status          string     `sql:"DEFAULT:'queued'" json:"status"`
+	Error           string     `json:"error,omitempty"`
+	SentAt          *time.Time `json:"sent_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// how the delivery of an email went
+const (
+	EmailQueued = "queued"
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
+
+// EmailDeliveryFilter narrows down a list of email deliveries. Empty fields
+// match everything.
+type EmailDeliveryFilter struct {
+	InviteeID string
+	Kind      string
+	Status    string
+}
+
+// Webhook is an endpoint of another system that is sent the changes to an
+// event of the EventTypes it asks for. The requests are signed with Secret,
+// which is only shown when the webhook is created.
+type Webhook struct {
+	WebhookID  string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"webhook_id"`
+	FkEventID  string     `json:"-"`
+	URL        string     `gorm:"column:url" json:"url"`
+	Secret     string     `json:"secret,omitempty"`
+	EventTypes StringList `json:"event_types"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+}
+
+// the changes webhooks can be sent
+const (
+	WebhookInviteeCreated    = "invitee.created"
+	WebhookRSVPChanged       = "rsvp.changed"
+	WebhookMenuChoiceSet     = "menu_choice.set"
+	WebhookSeatingRequestSet = "seating_request.set"
+	WebhookFriendAdded       = "friend.added"
+)
+
+// WebhookEventTypes are the changes webhooks can be sent.
+var WebhookEventTypes = []string{WebhookInviteeCreated, WebhookRSVPChanged, WebhookMenuChoiceSet, WebhookSeatingRequestSet, WebhookFriendAdded}
+
+// IsWebhookEventType tells if eventType is a change webhooks can be sent.
+func IsWebhookEventType(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery is a change sent to a webhook. It is WebhookPending until
+// the webhook answers with a 2xx, which it is tried until it does or runs out
+// of attempts. ResponseStatus and ResponseBody are of the last attempt, and
+// Error says why it failed.
+type WebhookDelivery struct {
+	WebhookDeliveryID string     `gorm:"primary_key" sql:"DEFAULT:uuid_generate_v1mc()" json:"webhook_delivery_id"`
+	FkWebhookID       string     `json:"webhook_id"`
+	FkEventID         string     `json:"-"`
+	EventType         string     `json:"event_type"`
+	Payload           JSON       `json:"payload"`
+	Status            string     `sql:"DEFAULT:'pending'" json:"status"`
+	Attempts          int        `json:"attempts"`
+	ResponseStatus    int        `json:"response_status,omitempty"`
+	ResponseBody      string     `json:"response_body,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	DeliveredAt       *time.Time `json:"delivered_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// how the delivery of a change to a webhook went
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookDeliveryFilter narrows down a list of webhook deliveries. Empty
+// fields match everything.
+type WebhookDeliveryFilter struct {
+	EventType string
+	Status    string
+}
+
+// ErrBadCursor is returned for a page cursor that can't be read, or that was
+// made for a list sorted another way.
+var ErrBadCursor = errors.New("the page cursor is not valid for this list")
+
+// Page is the part of a list to get. Without Keyset it is Length items from
+// Start. With Keyset it is the Length items after the one Cursor was made
+// for, or before it if Backwards; an empty Cursor means the start of the
+// list, or the end if Backwards.
+type Page struct {
+	Start     int
+	Length    int
+	Keyset    bool
+	Cursor    string
+	Backwards bool
+}
+
+// PageBounds are the cursors for the first and last items of a page that was
+// got, and whether the list has more items before and after it.
+type PageBounds struct {
+	First   string
+	Last    string
+	HasPrev bool
+	HasNext bool
+}
+
+// RSVPCounts counts guests by RSVP status.
+type RSVPCounts struct {
+	Pending   int `json:"pending"`
+	Attending int `json:"attending"`
+	Declined  int `json:"declined"`
+	Maybe     int `json:"maybe"`
+}
+
+// Total gets the number of guests counted.
+func (c RSVPCounts) Total() int {
+	return c.Pending + c.Attending + c.Declined + c.Maybe
+}
+
+// EventCounts are what the stats of an event are worked out from.
+// InviteeRSVPs counts the invitees themselves and GuestRSVPs them and their
+// friends. The menu counts are of the guests attending: those with a choice
+// for every menu item, for some and for none.
+type EventCounts struct {
+	InviteeRSVPs                RSVPCounts
+	GuestRSVPs                  RSVPCounts
+	Friends                     int
+	MenuItems                   int
+	MenuComplete                int
+	MenuPartial                 int
+	MenuNone                    int
+	SeatingRequests             int
+	InviteesWithSeatingRequests int
+}
+
+// ResponseDay counts the invitees whose answer was set on Date, a day in the
+// event's time zone as YYYY-MM-DD, by their answer.
+type ResponseDay struct {
+	Date      string `json:"date"`
+	Attending int    `json:"attending"`
+	Declined  int    `json:"declined"`
+	Maybe     int    `json:"maybe"`
+}
+
+// JSON is a JSON document kept in a jsonb column.
+type JSON []byte
+
+// MarshalJSON writes the document as is, or null if it is empty.
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+
+	return j, nil
+}
+
+// UnmarshalJSON keeps a copy of the document.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[0:0], data...)
+
+	return nil
+}
+
+// Value gets the document for storing.
+func (j JSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+
+	return string(j), nil
+}
+
+// Scan reads a stored document.
+func (j *JSON) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*j = JSON(v)
+	case []byte:
+		*j = append((*j)[0:0], v...)
+	case nil:
+		*j = nil
+	default:
+		return errors.New("cannot scan JSON from a non string column")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package idempotency keeps the responses to requests sent with an
+// Idempotency-Key header so retries of them can be answered with the same
+// response rather than being handled again. Records are kept in a Store so
+// they can be shared between processes.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Header is the request header clients send their key in.
+const Header = "Idempotency-Key"
+
+// MaxKeyLength is the longest key a client can send.
+const MaxKeyLength = 255
+
+// ReplayedHeader is set on responses that are replayed.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// the response headers that are kept and replayed along with the body
+var keptHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Response is a response kept to be replayed.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Record is what is kept for a key. Response is nil while the first request
+// with the key is still being handled.
+type Record struct {
+	RequestHash string
+	Response    *Response
+}
+
+// Store keeps records.
+type Store interface {
+	// Begin claims key for a request with the hash requestHash until
+	// expires, returning true. If the key has already been claimed and hasn't
+	// expired, its record is returned instead.
+	Begin(ctx context.Context, key string, requestHash string, expires time.Time) (existing Record, claimed bool, err error)
+	// Complete keeps res as the response for a key claimed with Begin.
+	Complete(ctx context.Context, key string, res Response) error
+	// Release forgets a key claimed with Begin so the request can be tried
+	// again.
+	Release(ctx context.Context, key string) error
+}
+
+// Key gets the key a client's key is kept under. scope is who sent it, and
+// where to, so clients can't see each other's responses by using the same
+// key.
+func Key(scope string, key string) string {
+	return hash([]byte(scope + "\n" + key))
+}
+
+// RequestHash gets the hash of a request body, to tell whether a retry is
+// the same request.
+func RequestHash(body []byte) string {
+	return hash(body)
+}
+
+// KeepHeaders copies the headers of h that are replayed.
+func KeepHeaders(h http.Header) http.Header {
+	kept := make(http.Header)
+
+	for _, name := range keptHeaders {
+		if v, ok := h[name]; ok {
+			kept[name] = append([]string(nil), v...)
+		}
+	}
+
+	return kept
+}
+
+func hash(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
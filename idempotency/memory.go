@@ -0,0 +1,87 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// how many keys a MemoryStore claims between sweeps for expired records
+const sweepEvery = 1000
+
+// MemoryStore keeps records in memory. Records are not shared between
+// processes, so a retry that goes to another one is handled again.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+	begins  int
+}
+
+type memoryRecord struct {
+	Record
+	expires time.Time
+}
+
+// NewMemoryStore builds an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]memoryRecord),
+	}
+}
+
+// Begin implements Store.
+func (ms *MemoryStore) Begin(ctx context.Context, key string, requestHash string, expires time.Time) (Record, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+
+	if rec, ok := ms.records[key]; ok && rec.expires.After(now) {
+		return rec.Record, false, nil
+	}
+
+	ms.records[key] = memoryRecord{Record: Record{RequestHash: requestHash}, expires: expires}
+
+	ms.begins++
+
+	if ms.begins >= sweepEvery {
+		ms.begins = 0
+		ms.sweep(now)
+	}
+
+	return Record{}, true, nil
+}
+
+// Complete implements Store.
+func (ms *MemoryStore) Complete(ctx context.Context, key string, res Response) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if rec, ok := ms.records[key]; ok {
+		rec.Response = &res
+		ms.records[key] = rec
+	}
+
+	return nil
+}
+
+// Release implements Store. Keys that have been completed are kept.
+func (ms *MemoryStore) Release(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if rec, ok := ms.records[key]; ok && rec.Response == nil {
+		delete(ms.records, key)
+	}
+
+	return nil
+}
+
+// sweep forgets records that have expired by now.
+func (ms *MemoryStore) sweep(now time.Time) {
+	for key, rec := range ms.records {
+		if !rec.expires.After(now) {
+			delete(ms.records, key)
+		}
+	}
+}
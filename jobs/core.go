@@ -0,0 +1,108 @@
+// Package jobs runs background jobs from a durable queue: workers claim jobs,
+// retry the ones that fail with backoff and give up on them after too many
+// attempts, and a scheduler enqueues jobs as time passes.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrNoJob is returned by Store.Claim when there is no job to run.
+var ErrNoJob = errors.New("no job to run")
+
+// Job is a job claimed to be run. Attempt counts from 1.
+type Job struct {
+	ID          string
+	Kind        string
+	Payload     json.RawMessage
+	Attempt     int
+	MaxAttempts int
+}
+
+// Decode reads the job's payload into v.
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// NewJob is a job to enqueue. A job with a UniqueKey is only enqueued once,
+// however many times it is asked to be. MaxAttempts of 0 uses
+// DefaultMaxAttempts and a zero RunAt runs the job straight away.
+type NewJob struct {
+	Kind        string
+	Payload     interface{}
+	UniqueKey   string
+	RunAt       time.Time
+	MaxAttempts int
+}
+
+// DefaultMaxAttempts is how many times a job is tried before it is dead.
+const DefaultMaxAttempts = 10
+
+// Store keeps the queue of jobs.
+type Store interface {
+	// Enqueue adds job to the queue. It is false if a job with the same
+	// UniqueKey was already enqueued.
+	Enqueue(ctx context.Context, job NewJob) (bool, error)
+	// Claim takes the next job that is due for lease. Nothing else claims it
+	// until the lease runs out. It returns ErrNoJob if no job is due.
+	Claim(ctx context.Context, lease time.Duration) (Job, error)
+	// Complete marks a claimed job as done.
+	Complete(ctx context.Context, jobID string) error
+	// Retry puts a claimed job that failed with reason back on the queue to
+	// run at runAt.
+	Retry(ctx context.Context, jobID string, reason string, runAt time.Time) error
+	// Bury marks a claimed job that failed with reason as dead.
+	Bury(ctx context.Context, jobID string, reason string) error
+}
+
+// Enqueuer adds jobs to a queue.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, job NewJob) (bool, error)
+}
+
+// Handler runs a job. A job whose handler returns an error is tried again
+// later, unless the error is Permanent.
+type Handler func(ctx context.Context, job Job) error
+
+type permanent struct {
+	err error
+}
+
+func (p permanent) Error() string { return p.err.Error() }
+func (p permanent) Unwrap() error { return p.err }
+
+// Permanent wraps err to say that trying the job again won't help, so it is
+// dead straight away.
+func Permanent(err error) error {
+	return permanent{err: err}
+}
+
+// IsPermanent tells if err says a job can't succeed.
+func IsPermanent(err error) bool {
+	var p permanent
+
+	return errors.As(err, &p)
+}
+
+// Backoff is how long to wait before trying a job again after its attempt
+// failed: 10s doubled for every attempt up to an hour, give or take a fifth
+// so jobs that failed together don't all come back together.
+func Backoff(attempt int) time.Duration {
+	d := 10 * time.Second
+
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+
+	if d > time.Hour {
+		d = time.Hour
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(d)/5*2+1)) - d/5
+
+	return d + jitter
+}
@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/grounded042/capacious/logging"
+)
+
+// Task enqueues the jobs that are due at now. It is run again and again, so
+// it should enqueue jobs with a UniqueKey to only enqueue them once. Several
+// schedulers can run at the same time for the same reason.
+type Task func(ctx context.Context, now time.Time) error
+
+// Scheduler runs tasks every so often.
+type Scheduler struct {
+	tasks map[string]Task
+
+	// Every is how often the tasks are run.
+	Every time.Duration
+}
+
+// NewScheduler builds a Scheduler that runs tasks, by name, every minute.
+func NewScheduler(tasks map[string]Task) *Scheduler {
+	return &Scheduler{tasks: tasks, Every: time.Minute}
+}
+
+// Run runs the tasks straight away and then every s.Every until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Every)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	logger := logging.FromContext(ctx)
+
+	for name, task := range s.tasks {
+		if err := task(ctx, now); err != nil && ctx.Err() == nil {
+			logger.Error("scheduled task failed", "task", name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/metrics"
+)
+
+var (
+	jobsRun = metrics.NewCounterVec(
+		"capacious_jobs_run_total",
+		"Number of job attempts, by kind and what came of them: done, retry or dead.",
+		"kind", "result",
+	)
+	jobDuration = metrics.NewHistogramVec(
+		"capacious_job_duration_seconds",
+		"Time taken to run jobs, by kind.",
+		metrics.DefBuckets,
+		"kind",
+	)
+)
+
+// Worker runs the jobs it has handlers for.
+type Worker struct {
+	store    Store
+	handlers map[string]Handler
+
+	// Concurrency is how many jobs are run at once.
+	Concurrency int
+	// PollInterval is how long to wait before looking for jobs again when
+	// there were none.
+	PollInterval time.Duration
+	// Lease is how long a job has to run. A job still running after it may
+	// be claimed by another worker.
+	Lease time.Duration
+}
+
+// NewWorker builds a Worker that runs the jobs in store with handlers, by
+// kind.
+func NewWorker(store Store, handlers map[string]Handler) *Worker {
+	return &Worker{
+		store:        store,
+		handlers:     handlers,
+		Concurrency:  2,
+		PollInterval: time.Second,
+		Lease:        5 * time.Minute,
+	}
+}
+
+// Run runs jobs until ctx is done, then waits for the jobs it is running to
+// finish. Those are not cancelled by ctx; they have until their lease runs
+// out.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	for ctx.Err() == nil {
+		job, err := w.store.Claim(ctx, w.Lease)
+
+		if err == nil {
+			w.run(context.WithoutCancel(ctx), job)
+			continue
+		}
+
+		if !errors.Is(err, ErrNoJob) && ctx.Err() == nil {
+			logger.Error("could not claim a job", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+// run runs job and records what came of it.
+func (w *Worker) run(ctx context.Context, job Job) {
+	logger := logging.FromContext(ctx).With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempt)
+	ctx = logging.NewContext(ctx, logger)
+
+	ctx, cancel := context.WithTimeout(ctx, w.Lease)
+	defer cancel()
+
+	start := time.Now()
+	err := w.handle(ctx, job)
+	jobDuration.Observe(time.Since(start).Seconds(), job.Kind)
+
+	// record the result even if the job ran out of time
+	ctx = context.WithoutCancel(ctx)
+
+	var result string
+
+	switch {
+	case err == nil:
+		result = "done"
+		err = w.store.Complete(ctx, job.ID)
+	case IsPermanent(err) || job.Attempt >= job.MaxAttempts:
+		result = "dead"
+		logger.Error("job failed for good", "error", err)
+		err = w.store.Bury(ctx, job.ID, err.Error())
+	default:
+		result = "retry"
+		retryIn := Backoff(job.Attempt)
+		logger.Warn("job failed, trying again later", "error", err, "retry_in", retryIn.String())
+		err = w.store.Retry(ctx, job.ID, err.Error(), time.Now().Add(retryIn))
+	}
+
+	jobsRun.Inc(job.Kind, result)
+
+	if err != nil {
+		logger.Error("could not record the result of a job", "result", result, "error", err)
+	}
+}
+
+// handle runs job with its handler, turning panics into errors so one bad job
+// doesn't take the worker down.
+func (w *Worker) handle(ctx context.Context, job Job) (err error) {
+	handler, ok := w.handlers[job.Kind]
+
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for jobs of kind %q", job.Kind))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return handler(ctx, job)
+}
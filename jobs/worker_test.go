@@ -0,0 +1,255 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is a Store that keeps jobs in memory and records what was done with
+// them.
+type memStore struct {
+	mu      sync.Mutex
+	queue   []Job
+	done    []string
+	buried  map[string]string
+	retried map[string]time.Time
+}
+
+func newMemStore(jobs ...Job) *memStore {
+	return &memStore{
+		queue:   jobs,
+		buried:  map[string]string{},
+		retried: map[string]time.Time{},
+	}
+}
+
+func (s *memStore) Enqueue(ctx context.Context, job NewJob) (bool, error) {
+	return false, errors.New("not supported")
+}
+
+func (s *memStore) Claim(ctx context.Context, lease time.Duration) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return Job{}, ErrNoJob
+	}
+
+	job := s.queue[0]
+	s.queue = s.queue[1:]
+
+	return job, nil
+}
+
+func (s *memStore) Complete(ctx context.Context, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done = append(s.done, jobID)
+
+	return nil
+}
+
+func (s *memStore) Retry(ctx context.Context, jobID string, reason string, runAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retried[jobID] = runAt
+
+	return nil
+}
+
+func (s *memStore) Bury(ctx context.Context, jobID string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buried[jobID] = reason
+
+	return nil
+}
+
+func TestWorkerRecordsResults(t *testing.T) {
+	store := newMemStore()
+
+	w := NewWorker(store, map[string]Handler{
+		"ok":        func(ctx context.Context, job Job) error { return nil },
+		"fail":      func(ctx context.Context, job Job) error { return errors.New("boom") },
+		"permanent": func(ctx context.Context, job Job) error { return Permanent(errors.New("bad payload")) },
+		"panic":     func(ctx context.Context, job Job) error { panic("oh no") },
+	})
+
+	jobs := []Job{
+		{ID: "1", Kind: "ok", Attempt: 1, MaxAttempts: 3},
+		{ID: "2", Kind: "fail", Attempt: 1, MaxAttempts: 3},
+		{ID: "3", Kind: "fail", Attempt: 3, MaxAttempts: 3},
+		{ID: "4", Kind: "permanent", Attempt: 1, MaxAttempts: 3},
+		{ID: "5", Kind: "unknown", Attempt: 1, MaxAttempts: 3},
+		{ID: "6", Kind: "panic", Attempt: 1, MaxAttempts: 3},
+	}
+
+	before := time.Now()
+
+	for _, job := range jobs {
+		w.run(context.Background(), job)
+	}
+
+	if len(store.done) != 1 || store.done[0] != "1" {
+		t.Errorf("done = %v, want [1]", store.done)
+	}
+
+	for _, id := range []string{"3", "4", "5"} {
+		if _, ok := store.buried[id]; !ok {
+			t.Errorf("job %s wasn't buried", id)
+		}
+	}
+
+	if len(store.buried) != 3 {
+		t.Errorf("buried = %v, want jobs 3, 4 and 5", store.buried)
+	}
+
+	for _, id := range []string{"2", "6"} {
+		runAt, ok := store.retried[id]
+
+		if !ok {
+			t.Errorf("job %s wasn't retried", id)
+			continue
+		}
+
+		// the first retry is 10s give or take a fifth
+		if d := runAt.Sub(before); d < 8*time.Second || d > 13*time.Second {
+			t.Errorf("job %s retried in %s, want about 10s", id, d)
+		}
+	}
+
+	if len(store.retried) != 2 {
+		t.Errorf("retried = %v, want jobs 2 and 6", store.retried)
+	}
+}
+
+func TestWorkerFinishesRunningJobsOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handlerErr error
+
+	store := newMemStore(Job{ID: "1", Kind: "slow", Attempt: 1, MaxAttempts: 3})
+
+	w := NewWorker(store, map[string]Handler{
+		"slow": func(ctx context.Context, job Job) error {
+			close(started)
+			<-release
+			handlerErr = ctx.Err()
+
+			return nil
+		},
+	})
+	w.Concurrency = 1
+	w.PollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case <-stopped:
+		t.Fatal("Run returned before the running job finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the running job finished")
+	}
+
+	if handlerErr != nil {
+		t.Errorf("the running job's context was done on shutdown: %v", handlerErr)
+	}
+
+	if len(store.done) != 1 {
+		t.Errorf("done = %v, want the running job", store.done)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := Backoff(tt.attempt)
+
+			if got < tt.want-tt.want/5 || got > tt.want+tt.want/5 {
+				t.Errorf("Backoff(%d) = %s, want %s give or take a fifth", tt.attempt, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	err := errors.New("bad payload")
+
+	if IsPermanent(err) {
+		t.Error("IsPermanent of a plain error is true")
+	}
+
+	wrapped := Permanent(err)
+
+	if !IsPermanent(wrapped) {
+		t.Error("IsPermanent of a Permanent error is false")
+	}
+
+	if !errors.Is(wrapped, err) {
+		t.Error("a Permanent error doesn't unwrap to the error it wraps")
+	}
+}
+
+func TestSchedulerTickRunsEveryTask(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ran := map[string]time.Time{}
+
+	s := NewScheduler(map[string]Task{
+		"a": func(ctx context.Context, at time.Time) error {
+			ran["a"] = at
+			return nil
+		},
+		// a failing task doesn't stop the others
+		"b": func(ctx context.Context, at time.Time) error {
+			ran["b"] = at
+			return errors.New("boom")
+		},
+		"c": func(ctx context.Context, at time.Time) error {
+			ran["c"] = at
+			return nil
+		},
+	})
+
+	s.tick(context.Background(), now)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if at, ok := ran[name]; !ok || !at.Equal(now) {
+			t.Errorf("task %s ran at %v, want %v", name, at, now)
+		}
+	}
+}
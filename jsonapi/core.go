@@ -0,0 +1,132 @@
+// Package jsonapi builds JSON:API (https://jsonapi.org) documents: resources
+// with their relationships, compound documents with included resources and
+// sparse fieldsets, and the media type negotiation the spec asks for. It
+// knows nothing of the resources themselves; they are built by the caller.
+package jsonapi
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MediaType is the JSON:API media type.
+const MediaType = "application/vnd.api+json"
+
+// Version is the version of the spec documents are written to.
+const Version = "1.1"
+
+var (
+	// ErrTypeMismatch is returned by ReadResource for a resource of the
+	// wrong type.
+	ErrTypeMismatch = errors.New("jsonapi: the resource is not of the expected type")
+	// ErrIDMismatch is returned by ReadResource for a resource whose id
+	// isn't the one being changed.
+	ErrIDMismatch = errors.New("jsonapi: the resource's id does not match the one being changed")
+)
+
+// media type parameters that can be sent with MediaType. profile can be
+// ignored by servers, and q is the weight of an Accept entry.
+var allowedParams = map[string]bool{"profile": true, "q": true}
+
+// Negotiate checks the media types of r, returning the status to send if
+// they can't be served, or 0 if they can. Bodies sent as MediaType with
+// parameters, e.g. extensions, get a 415. Requests that accept MediaType only
+// with parameters get a 406.
+func Negotiate(r *http.Request) int {
+	if mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == MediaType && !allowed(params) {
+		return http.StatusUnsupportedMediaType
+	}
+
+	found, acceptable := accepts(r)
+
+	if found && !acceptable {
+		return http.StatusNotAcceptable
+	}
+
+	return 0
+}
+
+// Accepts reports whether the client wants JSON:API documents back.
+func Accepts(r *http.Request) bool {
+	_, acceptable := accepts(r)
+
+	return acceptable
+}
+
+// IsDocument reports whether the body of r is a JSON:API document.
+func IsDocument(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	return err == nil && mediaType == MediaType
+}
+
+// accepts looks for MediaType in the Accept headers of r. found is set if it
+// is there at all, and acceptable if it is there without parameters that
+// can't be served.
+func accepts(r *http.Request) (found bool, acceptable bool) {
+	for _, header := range r.Header.Values("Accept") {
+		for _, entry := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+
+			if err != nil || mediaType != MediaType {
+				continue
+			}
+
+			found = true
+
+			if allowed(params) {
+				acceptable = true
+			}
+		}
+	}
+
+	return found, acceptable
+}
+
+func allowed(params map[string]string) bool {
+	for name := range params {
+		if !allowedParams[name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReadResource reads the resource in a document sent as a request body and
+// gets its attributes. The resource has to be of type typ and, if id isn't
+// empty and the resource has one, have the id id.
+func ReadResource(body []byte, typ string, id string) (json.RawMessage, error) {
+	var doc struct {
+		Data *struct {
+			Type       string          `json:"type"`
+			ID         string          `json:"id"`
+			Attributes json.RawMessage `json:"attributes"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Data == nil {
+		return nil, errors.New("jsonapi: the document has no resource")
+	}
+
+	if doc.Data.Type != typ {
+		return nil, ErrTypeMismatch
+	}
+
+	if id != "" && doc.Data.ID != "" && doc.Data.ID != id {
+		return nil, ErrIDMismatch
+	}
+
+	if len(doc.Data.Attributes) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+
+	return doc.Data.Attributes, nil
+}
@@ -0,0 +1,218 @@
+package jsonapi
+
+import "encoding/json"
+
+// Links are the links of a document, resource or relationship, by name.
+type Links map[string]string
+
+// Resource is a resource to be sent in a document. Relationships keep the
+// related resources so they can be included.
+type Resource struct {
+	Type          string
+	ID            string
+	Attributes    map[string]interface{}
+	Relationships map[string]*Relationship
+	Links         Links
+}
+
+// NewResource builds a resource of type typ with the id id and no fields.
+func NewResource(typ string, id string) *Resource {
+	return &Resource{
+		Type:          typ,
+		ID:            id,
+		Attributes:    make(map[string]interface{}),
+		Relationships: make(map[string]*Relationship),
+	}
+}
+
+// Relationship is a relationship from one resource to others.
+type Relationship struct {
+	Links   Links
+	hasData bool
+	toMany  bool
+	related []*Resource
+}
+
+// ToOne builds a to-one relationship with related, which can be nil.
+func ToOne(related *Resource) *Relationship {
+	rel := &Relationship{hasData: true}
+
+	if related != nil {
+		rel.related = []*Resource{related}
+	}
+
+	return rel
+}
+
+// ToMany builds a to-many relationship with related.
+func ToMany(related []*Resource) *Relationship {
+	return &Relationship{hasData: true, toMany: true, related: related}
+}
+
+// LinksOnly builds a relationship that only links to the related resources.
+func LinksOnly(links Links) *Relationship {
+	return &Relationship{Links: links}
+}
+
+// Document is a JSON:API document with one or many primary resources.
+type Document struct {
+	Links Links
+	Meta  map[string]interface{}
+
+	data     interface{}
+	included []resourceObject
+}
+
+// One builds a document for the primary resource res, with the resources q
+// asks to include.
+func (q Query) One(res *Resource) *Document {
+	doc := &Document{}
+
+	if res == nil {
+		return doc
+	}
+
+	doc.data = q.object(res)
+	doc.included = q.includes([]*Resource{res})
+
+	return doc
+}
+
+// Many builds a document for the primary resources res, with the resources q
+// asks to include.
+func (q Query) Many(res []*Resource) *Document {
+	data := make([]resourceObject, len(res))
+
+	for i, r := range res {
+		data[i] = q.object(r)
+	}
+
+	return &Document{data: data, included: q.includes(res)}
+}
+
+// MarshalJSON writes the document.
+func (d *Document) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		JSONAPI  map[string]string      `json:"jsonapi"`
+		Data     interface{}            `json:"data"`
+		Included []resourceObject       `json:"included,omitempty"`
+		Links    Links                  `json:"links,omitempty"`
+		Meta     map[string]interface{} `json:"meta,omitempty"`
+	}{
+		JSONAPI:  map[string]string{"version": Version},
+		Data:     d.data,
+		Included: d.included,
+		Links:    d.Links,
+		Meta:     d.Meta,
+	})
+}
+
+// includes gets the resources related to primary along q's include paths.
+// Every resource is only included once, and never if it is a primary one.
+func (q Query) includes(primary []*Resource) []resourceObject {
+	var included []resourceObject
+
+	seen := make(map[string]bool)
+
+	for _, r := range primary {
+		seen[r.Type+"/"+r.ID] = true
+	}
+
+	for _, path := range q.Include {
+		level := primary
+
+		for _, name := range path {
+			var next []*Resource
+
+			for _, r := range level {
+				if rel, ok := r.Relationships[name]; ok {
+					next = append(next, rel.related...)
+				}
+			}
+
+			for _, r := range next {
+				key := r.Type + "/" + r.ID
+
+				if !seen[key] {
+					seen[key] = true
+					included = append(included, q.object(r))
+				}
+			}
+
+			level = next
+		}
+	}
+
+	return included
+}
+
+type resourceObject struct {
+	Type          string                        `json:"type"`
+	ID            string                        `json:"id"`
+	Attributes    map[string]interface{}        `json:"attributes,omitempty"`
+	Relationships map[string]relationshipObject `json:"relationships,omitempty"`
+	Links         Links                         `json:"links,omitempty"`
+}
+
+type relationshipObject struct {
+	Links Links           `json:"links,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+type identifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// object gets the object res is sent as, with only the fields q asks for.
+func (q Query) object(res *Resource) resourceObject {
+	fields, sparse := q.Fields[res.Type]
+
+	obj := resourceObject{
+		Type:          res.Type,
+		ID:            res.ID,
+		Attributes:    make(map[string]interface{}),
+		Relationships: make(map[string]relationshipObject),
+		Links:         res.Links,
+	}
+
+	for name, value := range res.Attributes {
+		if !sparse || fields[name] {
+			obj.Attributes[name] = value
+		}
+	}
+
+	for name, rel := range res.Relationships {
+		if !sparse || fields[name] {
+			obj.Relationships[name] = rel.object()
+		}
+	}
+
+	return obj
+}
+
+func (rel *Relationship) object() relationshipObject {
+	obj := relationshipObject{Links: rel.Links}
+
+	if !rel.hasData {
+		return obj
+	}
+
+	ids := make([]identifier, len(rel.related))
+
+	for i, r := range rel.related {
+		ids[i] = identifier{Type: r.Type, ID: r.ID}
+	}
+
+	// the ids are plain strings, so this can't fail
+	switch {
+	case rel.toMany:
+		obj.Data, _ = json.Marshal(ids)
+	case len(ids) == 0:
+		obj.Data = json.RawMessage("null")
+	default:
+		obj.Data, _ = json.Marshal(ids[0])
+	}
+
+	return obj
+}
@@ -0,0 +1,98 @@
+package jsonapi
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Query is what a request asks to have in its document: the related
+// resources to include and the fields of each type of resource to send.
+type Query struct {
+	// Include holds the relationship paths to include, e.g.
+	// [["self", "menu_choices"]] for include=self.menu_choices.
+	Include [][]string
+	// Fields holds the fields to send for each type of resource. Types
+	// without an entry get all their fields.
+	Fields map[string]map[string]bool
+}
+
+// ParseQuery reads the include and fields[TYPE] parameters from v. Only the
+// relationship paths in includable, dot separated, can be included.
+func ParseQuery(v url.Values, includable ...string) (Query, error) {
+	q := Query{Fields: make(map[string]map[string]bool)}
+
+	allowedPaths := make(map[string]bool, len(includable))
+
+	for _, path := range includable {
+		allowedPaths[path] = true
+	}
+
+	if include := v.Get("include"); include != "" {
+		for _, path := range strings.Split(include, ",") {
+			if !allowedPaths[path] {
+				return Query{}, fmt.Errorf("jsonapi: %q can't be included", path)
+			}
+
+			q.Include = append(q.Include, strings.Split(path, "."))
+		}
+	}
+
+	for param, values := range v {
+		if !strings.HasPrefix(param, "fields[") || !strings.HasSuffix(param, "]") {
+			continue
+		}
+
+		typ := param[len("fields[") : len(param)-1]
+
+		if typ == "" {
+			return Query{}, fmt.Errorf("jsonapi: %s has no type", param)
+		}
+
+		fields := make(map[string]bool)
+
+		for _, value := range values {
+			for _, field := range strings.Split(value, ",") {
+				if field != "" {
+					fields[field] = true
+				}
+			}
+		}
+
+		q.Fields[typ] = fields
+	}
+
+	return q, nil
+}
+
+// PageLink gets u with its page[number] and page[size] parameters set to
+// number and size, keeping any other parameters but the page cursors.
+func PageLink(u *url.URL, number int, size int) string {
+	v := u.Query()
+	v.Del("page[after]")
+	v.Del("page[before]")
+	v.Set("page[number]", fmt.Sprint(number))
+	v.Set("page[size]", fmt.Sprint(size))
+
+	link := *u
+	link.RawQuery = v.Encode()
+
+	return link.RequestURI()
+}
+
+// CursorLink gets u with its page[size] parameter set to size and the page
+// cursor param, page[after] or page[before], set to cursor, keeping any other
+// parameters but the page number and the other cursor.
+func CursorLink(u *url.URL, param string, cursor string, size int) string {
+	v := u.Query()
+	v.Del("page[number]")
+	v.Del("page[after]")
+	v.Del("page[before]")
+	v.Set("page[size]", fmt.Sprint(size))
+	v.Set(param, cursor)
+
+	link := *u
+	link.RawQuery = v.Encode()
+
+	return link.RequestURI()
+}
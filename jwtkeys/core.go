@@ -0,0 +1,305 @@
+// Package jwtkeys holds the keys tokens are signed and verified with. Keys are
+// kept in a key set file so they can be rotated: the newest key that is active
+// and hasn't been retired signs new tokens, and every key in the file verifies
+// them until it is pruned. The public halves are published as a JWKS so other
+// services can verify tokens without holding a signing key. A rotated key is
+// published before it signs anything, so services that cache the JWKS have
+// it by the time tokens signed with it turn up.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+// the algorithms keys can be generated for
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// the size of generated RSA keys
+const rsaKeyBits = 2048
+
+// JWKSMaxAge is how long services can cache the JWKS for, and so how long a
+// rotated key has to be published for before it signs tokens.
+const JWKSMaxAge = 5 * time.Minute
+
+// Key is a signing key in a Set.
+type Key struct {
+	ID        string
+	Alg       string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	// ActiveAt is when the key starts signing tokens. It is published and
+	// verifies tokens from when it is created.
+	ActiveAt time.Time
+	// RetiredAt is when the key stops signing tokens, or nil if it hasn't
+	// been replaced.
+	RetiredAt *time.Time
+}
+
+// Public gets the public half of the key.
+func (k Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// Set is a set of keys, as kept in a key set file.
+type Set struct {
+	Keys []Key
+}
+
+// the layout of a key set file
+type setFile struct {
+	Keys []keyFile `json:"keys"`
+}
+
+type keyFile struct {
+	ID         string     `json:"kid"`
+	Alg        string     `json:"alg"`
+	PrivateKey string     `json:"private_key"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ActiveAt   *time.Time `json:"active_at,omitempty"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`
+}
+
+// Load reads the key set file at path.
+func Load(path string) (*Set, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f setFile
+
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+
+	s := &Set{}
+
+	for _, kf := range f.Keys {
+		block, _ := pem.Decode([]byte(kf.PrivateKey))
+
+		if block == nil {
+			return nil, errors.New("key " + kf.ID + " is not PEM encoded")
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		private, ok := parsed.(crypto.Signer)
+
+		if !ok || !matchesAlg(private, kf.Alg) {
+			return nil, errors.New("key " + kf.ID + " is not a " + kf.Alg + " key")
+		}
+
+		k := Key{
+			ID:        kf.ID,
+			Alg:       kf.Alg,
+			Private:   private,
+			CreatedAt: kf.CreatedAt,
+			RetiredAt: kf.RetiredAt,
+		}
+
+		// keys written before keys waited to sign were active from the start
+		if kf.ActiveAt != nil {
+			k.ActiveAt = *kf.ActiveAt
+		}
+
+		s.Keys = append(s.Keys, k)
+	}
+
+	return s, nil
+}
+
+// Save writes s to the key set file at path. Only the owner of the file can
+// read it since it holds private keys.
+func (s *Set) Save(path string) error {
+	var f setFile
+
+	for _, k := range s.Keys {
+		der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+
+		if err != nil {
+			return err
+		}
+
+		activeAt := k.ActiveAt
+
+		f.Keys = append(f.Keys, keyFile{
+			ID:         k.ID,
+			Alg:        k.Alg,
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			CreatedAt:  k.CreatedAt,
+			ActiveAt:   &activeAt,
+			RetiredAt:  k.RetiredAt,
+		})
+	}
+
+	b, err := json.MarshalIndent(f, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	// write the new file next to the old one and swap it in, so a server
+	// reloading the file never sees half of it
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Signing gets the key tokens are signed with at now: the newest key that is
+// active and hasn't been retired.
+func (s *Set) Signing(now time.Time) (Key, bool) {
+	var signing Key
+	found := false
+
+	for _, k := range s.Keys {
+		if k.signsAt(now) && (!found || k.CreatedAt.After(signing.CreatedAt)) {
+			signing, found = k, true
+		}
+	}
+
+	return signing, found
+}
+
+// signsAt gets whether k signs tokens at now.
+func (k Key) signsAt(now time.Time) bool {
+	return !now.Before(k.ActiveAt) && (k.RetiredAt == nil || now.Before(*k.RetiredAt))
+}
+
+// Get gets the key with the id kid.
+func (s *Set) Get(kid string) (Key, bool) {
+	for _, k := range s.Keys {
+		if k.ID == kid {
+			return k, true
+		}
+	}
+
+	return Key{}, false
+}
+
+// Rotate generates a new alg key to sign tokens with after delay, when the key
+// signing them until then is retired. delay should be at least JWKSMaxAge, so
+// the new key is in every cached JWKS by the time it signs. A set without a
+// key to sign with gets one that signs straight away. The retired key still
+// verifies tokens until it is pruned.
+func (s *Set) Rotate(alg string, now time.Time, delay time.Duration) (Key, error) {
+	k, err := generate(alg, now)
+
+	if err != nil {
+		return Key{}, err
+	}
+
+	if _, ok := s.Signing(now); !ok {
+		delay = 0
+	}
+
+	k.ActiveAt = k.CreatedAt.Add(delay)
+
+	for i := range s.Keys {
+		if s.Keys[i].RetiredAt == nil || s.Keys[i].RetiredAt.After(k.ActiveAt) {
+			retired := k.ActiveAt
+			s.Keys[i].RetiredAt = &retired
+		}
+	}
+
+	s.Keys = append(s.Keys, k)
+
+	return k, nil
+}
+
+// Prune removes the keys that were retired before before. It returns the keys
+// that were removed.
+func (s *Set) Prune(before time.Time) []Key {
+	var kept, pruned []Key
+
+	for _, k := range s.Keys {
+		if k.RetiredAt != nil && k.RetiredAt.Before(before) {
+			pruned = append(pruned, k)
+		} else {
+			kept = append(kept, k)
+		}
+	}
+
+	s.Keys = kept
+
+	return pruned
+}
+
+// Sorted gets the keys in the order they were created.
+func (s *Set) Sorted() []Key {
+	keys := make([]Key, len(s.Keys))
+	copy(keys, s.Keys)
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// generate generates a new alg key.
+func generate(alg string, now time.Time) (Key, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case ES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return Key{}, errors.New("unsupported algorithm: " + alg)
+	}
+
+	if err != nil {
+		return Key{}, err
+	}
+
+	id := make([]byte, 8)
+
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+
+	return Key{
+		ID:        hex.EncodeToString(id),
+		Alg:       alg,
+		Private:   private,
+		CreatedAt: now.UTC(),
+	}, nil
+}
+
+// matchesAlg checks that private is the kind of key alg needs.
+func matchesAlg(private crypto.Signer, alg string) bool {
+	switch p := private.(type) {
+	case *rsa.PrivateKey:
+		return alg == RS256
+	case *ecdsa.PrivateKey:
+		return alg == ES256 && p.Curve == elliptic.P256()
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// publicKey is what the public keys of the standard library have.
+type publicKey interface {
+	Equal(x crypto.PublicKey) bool
+}
+
+func TestRotateSignsOnceTheKeyIsPublished(t *testing.T) {
+	set := &Set{}
+
+	// the first key has nothing to wait for
+	first, err := set.Rotate(ES256, now, JWKSMaxAge)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k, ok := set.Signing(now); !ok || k.ID != first.ID {
+		t.Fatalf("the first key doesn't sign straight away: %+v", k)
+	}
+
+	second, err := set.Rotate(RS256, now.Add(time.Hour), JWKSMaxAge)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// it is published straight away
+	if jwks := set.JWKS(); len(jwks.Keys) != 2 || jwks.Keys[1].Kid != second.ID {
+		t.Errorf("JWKS after rotating = %+v, want both keys", jwks)
+	}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{now.Add(time.Hour), first.ID},
+		{now.Add(time.Hour + JWKSMaxAge - time.Second), first.ID},
+		{now.Add(time.Hour + JWKSMaxAge), second.ID},
+		{now.Add(48 * time.Hour), second.ID},
+	}
+
+	for _, tt := range tests {
+		if k, ok := set.Signing(tt.at); !ok || k.ID != tt.want {
+			t.Errorf("signing at %s: got %s, want %s", tt.at, k.ID, tt.want)
+		}
+	}
+
+	if k, _ := set.Get(first.ID); k.RetiredAt == nil || !k.RetiredAt.Equal(second.ActiveAt) {
+		t.Errorf("the first key retires at %v, want %s", k.RetiredAt, second.ActiveAt)
+	}
+}
+
+func TestRotateAgainBeforeTheLastKeySigns(t *testing.T) {
+	set := &Set{}
+	first, _ := set.Rotate(ES256, now, JWKSMaxAge)
+	second, _ := set.Rotate(ES256, now.Add(time.Minute), JWKSMaxAge)
+	third, _ := set.Rotate(ES256, now.Add(2*time.Minute), JWKSMaxAge)
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{now.Add(2 * time.Minute), first.ID},
+		{second.ActiveAt, second.ID},
+		{third.ActiveAt, third.ID},
+	}
+
+	for _, tt := range tests {
+		if k, ok := set.Signing(tt.at); !ok || k.ID != tt.want {
+			t.Errorf("signing at %s: got %s, want %s", tt.at, k.ID, tt.want)
+		}
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	set := &Set{}
+
+	for i, alg := range []string{ES256, RS256} {
+		if _, err := set.Rotate(alg, now.Add(time.Duration(i)*time.Hour), JWKSMaxAge); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := set.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Keys) != len(set.Keys) {
+		t.Fatalf("loaded %d keys, want %d", len(loaded.Keys), len(set.Keys))
+	}
+
+	for i, want := range set.Keys {
+		got := loaded.Keys[i]
+
+		if got.ID != want.ID || got.Alg != want.Alg || !got.CreatedAt.Equal(want.CreatedAt) || !got.ActiveAt.Equal(want.ActiveAt) {
+			t.Errorf("key %d: got %+v, want %+v", i, got, want)
+		}
+
+		if (got.RetiredAt == nil) != (want.RetiredAt == nil) || (got.RetiredAt != nil && !got.RetiredAt.Equal(*want.RetiredAt)) {
+			t.Errorf("key %d retires at %v, want %v", i, got.RetiredAt, want.RetiredAt)
+		}
+
+		if !got.Public().(publicKey).Equal(want.Public()) {
+			t.Errorf("key %d's private key changed", i)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	set := &Set{}
+	first, _ := set.Rotate(ES256, now, JWKSMaxAge)
+	second, _ := set.Rotate(ES256, now, JWKSMaxAge)
+
+	// the first key retires when the second signs
+	if pruned := set.Prune(second.ActiveAt); len(pruned) != 0 {
+		t.Errorf("pruned %v before the key was retired", pruned)
+	}
+
+	pruned := set.Prune(second.ActiveAt.Add(time.Second))
+
+	if len(pruned) != 1 || pruned[0].ID != first.ID {
+		t.Errorf("pruned %v, want the first key", pruned)
+	}
+
+	if len(set.Keys) != 1 || set.Keys[0].ID != second.ID {
+		t.Errorf("kept %v, want the second key", set.Keys)
+	}
+}
@@ -0,0 +1,127 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+// JWKS is a JSON Web Key Set, RFC 7517.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is the public half of a key as a JSON Web Key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS gets the public halves of the keys in s.
+func (s *Set) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, k := range s.Sorted() {
+		jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Alg}
+
+		switch pub := k.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeInt(pub.N, 0)
+			jwk.E = encodeInt(big.NewInt(int64(pub.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = encodeInt(pub.X, size)
+			jwk.Y = encodeInt(pub.Y, size)
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+// encodeInt base64url encodes the big endian bytes of i, left padded with
+// zeros to size bytes.
+func encodeInt(i *big.Int, size int) string {
+	b := i.Bytes()
+
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PublicKey gets the key jwk describes. Only RSA keys and EC keys on P-256 are
+// supported.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent is too large")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, errors.New("unsupported curve: " + jwk.Crv)
+		}
+
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("unsupported key type: " + jwk.Kty)
+}
+
+// decodeInt decodes a base64url encoded big endian integer.
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,79 @@
+package jwtkeys
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJWKS(t *testing.T) {
+	set := &Set{}
+
+	for _, alg := range []string{ES256, RS256} {
+		if _, err := set.Rotate(alg, now, JWKSMaxAge); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := json.Marshal(set.JWKS())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what other services see
+	var jwks JWKS
+
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(jwks.Keys))
+	}
+
+	want := map[string]JWK{
+		ES256: {Kty: "EC", Use: "sig", Alg: ES256, Crv: "P-256"},
+		RS256: {Kty: "RSA", Use: "sig", Alg: RS256},
+	}
+
+	for _, jwk := range jwks.Keys {
+		k, ok := set.Get(jwk.Kid)
+
+		if !ok {
+			t.Errorf("JWK %s isn't a key in the set", jwk.Kid)
+			continue
+		}
+
+		w := want[k.Alg]
+
+		if jwk.Kty != w.Kty || jwk.Use != w.Use || jwk.Alg != w.Alg || jwk.Crv != w.Crv {
+			t.Errorf("JWK %s = %+v, want %+v", jwk.Kid, jwk, w)
+		}
+
+		public, err := jwk.PublicKey()
+
+		if err != nil {
+			t.Errorf("JWK %s: %v", jwk.Kid, err)
+		} else if !k.Public().(publicKey).Equal(public) {
+			t.Errorf("JWK %s isn't the key's public half", jwk.Kid)
+		}
+	}
+}
+
+func TestJWKPublicKeyRejectsBadKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+	}{
+		{"unknown type", JWK{Kty: "oct"}},
+		{"unsupported curve", JWK{Kty: "EC", Crv: "P-384", X: "AQ", Y: "AQ"}},
+		{"point off the curve", JWK{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}},
+		{"bad encoding", JWK{Kty: "RSA", N: "!!", E: "AQAB"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.jwk.PublicKey(); err == nil {
+			t.Errorf("%s: got a key", tt.name)
+		}
+	}
+}
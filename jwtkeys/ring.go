@@ -0,0 +1,126 @@
+package jwtkeys
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Ring signs and verifies tokens with the keys in a key set file. The file can
+// be reloaded while the server is running to pick up rotated keys.
+type Ring struct {
+	path string
+	set  atomic.Pointer[Set]
+	// hmacKey is only set for a ring that signs with a shared secret, for
+	// installs that don't have a key set file yet
+	hmacKey []byte
+}
+
+// NewRing builds a Ring from the key set file at path.
+func NewRing(path string) (*Ring, error) {
+	r := &Ring{path: path}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// NewHMACRing builds a Ring that signs and verifies HS512 tokens with secret.
+// Its JWKS is empty since the secret can't be published.
+func NewHMACRing(secret []byte) *Ring {
+	r := &Ring{hmacKey: secret}
+	r.set.Store(&Set{})
+
+	return r
+}
+
+// Reload reads the key set file again. The keys in use are only replaced if
+// the file can be read and has a key to sign with.
+func (r *Ring) Reload() error {
+	if r.path == "" {
+		return nil
+	}
+
+	s, err := Load(r.path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.Signing(time.Now()); !ok {
+		return errors.New("the key set has no key to sign with")
+	}
+
+	r.set.Store(s)
+
+	return nil
+}
+
+// Sign signs a token with claims using the key that signs tokens now. The
+// key's id is put in the kid header.
+func (r *Ring) Sign(claims map[string]interface{}) (string, error) {
+	if r.hmacKey != nil {
+		token := jwt.New(jwt.SigningMethodHS512)
+		token.Claims = claims
+
+		return token.SignedString(r.hmacKey)
+	}
+
+	k, ok := r.set.Load().Signing(time.Now())
+
+	if !ok {
+		return "", errors.New("there is no key to sign with")
+	}
+
+	token := jwt.New(jwt.GetSigningMethod(k.Alg))
+	token.Header["kid"] = k.ID
+	token.Claims = claims
+
+	return token.SignedString(k.Private)
+}
+
+// Parse parses and verifies a token. Only the algorithm of the key named by
+// the token's kid is accepted, so a token can't pick how it is verified.
+func (r *Ring) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, r.keyFunc)
+}
+
+func (r *Ring) keyFunc(token *jwt.Token) (interface{}, error) {
+	if r.hmacKey != nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return r.hmacKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	k, ok := r.set.Load().Get(kid)
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+
+	if token.Method.Alg() != k.Alg {
+		return nil, fmt.Errorf("unexpected signing method %v for key %v", token.Header["alg"], kid)
+	}
+
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return pub, nil
+	}
+
+	return nil, errors.New("unsupported key type for key " + kid)
+}
+
+// JWKS gets the public halves of the keys that verify tokens.
+func (r *Ring) JWKS() JWKS {
+	return r.set.Load().JWKS()
+}
@@ -0,0 +1,187 @@
+package jwtkeys
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// newTestRing builds a ring from a key set file with a key of each alg, the
+// last of which signs.
+func newTestRing(t *testing.T, algs ...string) (*Ring, *Set) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	set := &Set{}
+	created := time.Now().Add(-time.Hour)
+
+	for i, alg := range algs {
+		if _, err := set.Rotate(alg, created.Add(time.Duration(i)*time.Minute), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := set.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRing(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, set
+}
+
+func TestRingSignsAndVerifies(t *testing.T) {
+	for _, alg := range []string{ES256, RS256} {
+		r, set := newTestRing(t, alg)
+		signing, _ := set.Signing(time.Now())
+
+		signed, err := r.Sign(map[string]interface{}{"sub": "1"})
+
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		token, err := r.Parse(signed)
+
+		if err != nil || !token.Valid {
+			t.Fatalf("%s: the token doesn't verify: %v", alg, err)
+		}
+
+		if token.Header["kid"] != signing.ID || token.Header["alg"] != alg {
+			t.Errorf("%s: header = %v, want kid %s", alg, token.Header, signing.ID)
+		}
+
+		if token.Claims["sub"] != "1" {
+			t.Errorf("%s: claims = %v", alg, token.Claims)
+		}
+	}
+}
+
+func TestRingVerifiesTokensOfRetiredKeys(t *testing.T) {
+	r, set := newTestRing(t, RS256)
+
+	old, err := r.Sign(map[string]interface{}{"sub": "1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate to a key that signs straight away and pick it up
+	if _, err := set.Rotate(ES256, time.Now().Add(-time.Second), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := set.Save(r.path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Parse(old); err != nil {
+		t.Errorf("a token of the retired key doesn't verify: %v", err)
+	}
+
+	signed, _ := r.Sign(map[string]interface{}{"sub": "1"})
+
+	if token, err := r.Parse(signed); err != nil || token.Header["alg"] != ES256 {
+		t.Errorf("new tokens aren't signed with the new key: %v, %v", token.Header, err)
+	}
+
+	// once the retired key is pruned its tokens don't verify
+	set.Prune(time.Now())
+
+	if err := set.Save(r.path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Parse(old); err == nil {
+		t.Error("a token of a pruned key still verifies")
+	}
+}
+
+func TestRingRejectsTokensThatPickHowTheyAreVerified(t *testing.T) {
+	r, set := newTestRing(t, RS256)
+	k, _ := set.Signing(time.Now())
+	claims := map[string]interface{}{"sub": "1"}
+
+	// signed with the published key as an HMAC secret
+	public, err := x509.MarshalPKIXPublicKey(k.Public().(*rsa.PublicKey))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmac := jwt.New(jwt.SigningMethodHS256)
+	hmac.Header["kid"] = k.ID
+	hmac.Claims = claims
+
+	// signed by someone else with a kid nobody has
+	other, _ := (&Set{}).Rotate(RS256, time.Now(), 0)
+	unknown := jwt.New(jwt.SigningMethodRS256)
+	unknown.Header["kid"] = "nobody"
+	unknown.Claims = claims
+
+	// signed by someone else naming the ring's key
+	forged := jwt.New(jwt.SigningMethodRS256)
+	forged.Header["kid"] = k.ID
+	forged.Claims = claims
+
+	tests := []struct {
+		name  string
+		token *jwt.Token
+		key   interface{}
+	}{
+		{"HMAC with the public key", hmac, public},
+		{"unknown kid", unknown, other.Private},
+		{"another key's signature", forged, other.Private},
+	}
+
+	for _, tt := range tests {
+		signed, err := tt.token.SignedString(tt.key)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if _, err := r.Parse(signed); err == nil {
+			t.Errorf("%s: the token verified", tt.name)
+		}
+	}
+}
+
+func TestHMACRing(t *testing.T) {
+	r := NewHMACRing([]byte("secret"))
+
+	signed, err := r.Sign(map[string]interface{}{"sub": "1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Parse(signed); err != nil {
+		t.Errorf("the token doesn't verify: %v", err)
+	}
+
+	if _, err := NewHMACRing([]byte("other")).Parse(signed); err == nil {
+		t.Error("the token verified with another secret")
+	}
+
+	// the secret can't be published
+	if jwks := r.JWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("JWKS = %+v, want no keys", jwks)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/grounded042/capacious/jwtkeys"
+	"github.com/grounded042/capacious/services"
+)
+
+const keysUsage = `usage: capacious keys <command> [flags]
+
+Manages the key set file tokens are signed with.
+
+commands:
+  generate  create a new key set file with one key
+  rotate    add a new key to sign tokens with once it has been published and retire the current one then
+  prune     remove keys that were retired long enough ago that no valid token is signed with them
+  list      list the keys
+
+Running servers pick up changes when sent a SIGHUP.
+`
+
+// keysCommand runs the keys command with args and returns the exit code.
+func keysCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	file := fs.String("file", os.Getenv("JWT_KEYS_FILE"), "The key set file. Defaults to JWT_KEYS_FILE.")
+	alg := fs.String("alg", jwtkeys.ES256, "The algorithm of new keys: ES256 or RS256.")
+	retiredFor := fs.Duration("retired-for", services.TokenLifetime, "How long a key has to have been retired for before it is pruned.")
+	delay := fs.Duration("delay", jwtkeys.JWKSMaxAge, "How long a rotated key is published for before it signs tokens. At least as long as the JWKS is cached for.")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "no key set file: set JWT_KEYS_FILE or pass -file")
+		return 2
+	}
+
+	var err error
+
+	switch args[0] {
+	case "generate":
+		err = generateKeys(*file, *alg)
+	case "rotate":
+		err = rotateKeys(*file, *alg, *delay)
+	case "prune":
+		err = pruneKeys(*file, *retiredFor)
+	case "list":
+		err = listKeys(*file)
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func generateKeys(file string, alg string) error {
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s already exists, use rotate to add a key to it", file)
+	}
+
+	set := &jwtkeys.Set{}
+	k, err := set.Rotate(alg, time.Now(), 0)
+
+	if err != nil {
+		return err
+	}
+
+	if err := set.Save(file); err != nil {
+		return err
+	}
+
+	fmt.Printf("generated %s key %s\n", k.Alg, k.ID)
+
+	return nil
+}
+
+func rotateKeys(file string, alg string, delay time.Duration) error {
+	set, err := jwtkeys.Load(file)
+
+	if err != nil {
+		return err
+	}
+
+	k, err := set.Rotate(alg, time.Now(), delay)
+
+	if err != nil {
+		return err
+	}
+
+	if err := set.Save(file); err != nil {
+		return err
+	}
+
+	fmt.Printf("generated %s key %s, it signs new tokens from %s\n", k.Alg, k.ID, k.ActiveAt.Format(time.RFC3339))
+
+	return nil
+}
+
+func pruneKeys(file string, retiredFor time.Duration) error {
+	set, err := jwtkeys.Load(file)
+
+	if err != nil {
+		return err
+	}
+
+	pruned := set.Prune(time.Now().Add(-retiredFor))
+
+	if err := set.Save(file); err != nil {
+		return err
+	}
+
+	for _, k := range pruned {
+		fmt.Printf("pruned %s key %s\n", k.Alg, k.ID)
+	}
+
+	return nil
+}
+
+func listKeys(file string) error {
+	set, err := jwtkeys.Load(file)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	signing, _ := set.Signing(now)
+
+	for _, k := range set.Sorted() {
+		status := "verifying"
+
+		if k.ID == signing.ID {
+			status = "signing"
+
+			if k.RetiredAt != nil {
+				status += " until " + k.RetiredAt.Format(time.RFC3339)
+			}
+		} else if now.Before(k.ActiveAt) {
+			status = "signing from " + k.ActiveAt.Format(time.RFC3339)
+		} else if k.RetiredAt != nil {
+			status = "retired " + k.RetiredAt.Format(time.RFC3339)
+		}
+
+		fmt.Printf("%s\t%s\tcreated %s\t%s\n", k.ID, k.Alg, k.CreatedAt.Format(time.RFC3339), status)
+	}
+
+	return nil
+}
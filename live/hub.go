@@ -0,0 +1,156 @@
+// Package live passes the changes made to events on to the admins watching
+// them as they happen.
+package live
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/grounded042/capacious/metrics"
+)
+
+// Change says that something about an event changed: the invitee with the id
+// InviteeID, or, when it is empty, anything, so whoever is watching should
+// read the whole event again.
+type Change struct {
+	EventID   string `json:"event_id"`
+	InviteeID string `json:"invitee_id"`
+}
+
+// the subscriptions open in every hub
+var open atomic.Int64
+
+func init() {
+	metrics.NewGaugeFunc(
+		"capacious_live_subscriptions",
+		"Number of subscriptions to the changes to events that are open.",
+		func() float64 { return float64(open.Load()) },
+	)
+}
+
+// how many changes a subscription holds before it is dropped for falling
+// behind
+const subscriptionBuffer = 64
+
+// Hub hands the changes published to it to the subscriptions to their event.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[string]map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub builds an empty Hub.
+func NewHub() *Hub {
+	return &Hub{subs: map[string]map[*Subscription]struct{}{}}
+}
+
+// Subscription gets the changes to one event on C. C is closed when the
+// subscription ends, which happens when it is closed, when it falls too far
+// behind to keep up or when the hub is closed; whoever was watching should
+// read the whole event again if they subscribe again.
+type Subscription struct {
+	C <-chan Change
+
+	c       chan Change
+	hub     *Hub
+	eventID string
+}
+
+// Subscribe subscribes to the changes to the event with the id eventID.
+func (h *Hub) Subscribe(eventID string) *Subscription {
+	c := make(chan Change, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, hub: h, eventID: eventID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(c)
+		return sub
+	}
+
+	if h.subs[eventID] == nil {
+		h.subs[eventID] = map[*Subscription]struct{}{}
+	}
+
+	h.subs[eventID][sub] = struct{}{}
+	open.Add(1)
+
+	return sub
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// Publish hands change to the subscriptions to its event. Subscriptions too
+// far behind to take it are ended rather than holding up the others.
+func (h *Hub) Publish(change Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[change.EventID] {
+		select {
+		case sub.c <- change:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Resync tells every subscription that anything may have changed, for when
+// changes may have been missed.
+func (h *Hub) Resync() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for eventID, subs := range h.subs {
+		for sub := range subs {
+			select {
+			case sub.c <- Change{EventID: eventID}:
+			default:
+				h.remove(sub)
+			}
+		}
+	}
+}
+
+// Close ends every subscription; later ones end straight away.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+
+	h.closed = true
+}
+
+// remove ends sub. h.mu must be held.
+func (h *Hub) remove(sub *Subscription) {
+	subs, ok := h.subs[sub.eventID]
+
+	if !ok {
+		return
+	}
+
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub.c)
+
+	if len(subs) == 0 {
+		delete(h.subs, sub.eventID)
+	}
+
+	open.Add(-1)
+}
@@ -0,0 +1,76 @@
+// Package logging sets up the structured logger and carries the request
+// scoped logger and request id through a context.Context.
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// New builds a logger that writes to w. format is either json or logfmt and
+// level is one of debug, info, warn or error.
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var l slog.Level
+
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, errors.New("unknown log level: " + level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "logfmt", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+
+	return nil, errors.New("unknown log format: " + format)
+}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext gets the logger carried by ctx. If there isn't one, the default
+// logger is returned so callers never have to check.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+			return l
+		}
+	}
+
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of ctx that carries the request id id and a
+// logger that adds it to every line.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+
+	return NewContext(ctx, FromContext(ctx).With("request_id", id))
+}
+
+// RequestID gets the request id carried by ctx, or an empty string if there
+// isn't one.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDKey).(string)
+
+	return id
+}
@@ -0,0 +1,154 @@
+// Package mail sends emails through a pluggable transport: an SMTP server, or
+// files or the log for development.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"os"
+	"strings"
+)
+
+// Message is an email to send. Body is plain text.
+type Message struct {
+	From        netmail.Address
+	To          netmail.Address
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent with a message. ContentType can have parameters,
+// such as the method of a calendar invitation.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// Transport sends messages.
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config says how to send emails and what goes in them.
+type Config struct {
+	// Transport is smtp, file or log.
+	Transport string
+	From      netmail.Address
+	// RSVPURL is the link invitees answer their invitation at. {invitee_id}
+	// in it is replaced by the invitee's id, which is also their credential.
+	RSVPURL string
+
+	// for the smtp transport
+	SMTP SMTPConfig
+	// for the file transport, the directory messages are written to
+	Dir string
+}
+
+// ConfigFromEnv reads the mail config from the environment. Without
+// MAIL_TRANSPORT emails are only logged.
+func ConfigFromEnv() (Config, error) {
+	conf := Config{
+		Transport: envOr("MAIL_TRANSPORT", "log"),
+		RSVPURL:   os.Getenv("MAIL_RSVP_URL"),
+		Dir:       envOr("MAIL_DIR", "mail"),
+		SMTP: SMTPConfig{
+			Addr:     envOr("SMTP_ADDR", "localhost:25"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			TLS:      envOr("SMTP_TLS", TLSStartTLS),
+		},
+	}
+
+	from, err := netmail.ParseAddress(envOr("MAIL_FROM", "Capacious <capacious@localhost>"))
+
+	if err != nil {
+		return Config{}, fmt.Errorf("MAIL_FROM is not an email address: %w", err)
+	}
+
+	conf.From = *from
+
+	switch conf.SMTP.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return Config{}, errors.New("SMTP_TLS must be starttls, tls or none")
+	}
+
+	return conf, nil
+}
+
+// NewTransport builds the transport conf asks for.
+func NewTransport(conf Config) (Transport, error) {
+	switch conf.Transport {
+	case "smtp":
+		return SMTP{conf: conf.SMTP}, nil
+	case "file":
+		if err := os.MkdirAll(conf.Dir, 0o750); err != nil {
+			return nil, err
+		}
+
+		return File{Dir: conf.Dir}, nil
+	case "log":
+		return Log{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mail transport %q", conf.Transport)
+}
+
+// Mailer sends messages from one address through a transport.
+type Mailer struct {
+	transport Transport
+	from      netmail.Address
+	rsvpURL   string
+}
+
+// New builds a Mailer from conf.
+func New(conf Config) (*Mailer, error) {
+	transport, err := NewTransport(conf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMailer(transport, conf), nil
+}
+
+// NewMailer builds a Mailer that sends through transport.
+func NewMailer(transport Transport, conf Config) *Mailer {
+	return &Mailer{transport: transport, from: conf.From, rsvpURL: conf.RSVPURL}
+}
+
+// Send sends subject and body to to, with any attachments.
+func (m *Mailer) Send(ctx context.Context, to netmail.Address, subject string, body string, attachments ...Attachment) error {
+	return m.transport.Send(ctx, Message{From: m.from, To: to, Subject: subject, Body: body, Attachments: attachments})
+}
+
+// From gets the address messages are sent from.
+func (m *Mailer) From() netmail.Address {
+	return m.from
+}
+
+// RSVPLink gets the link the invitee with the id inviteeID answers their
+// invitation at. Without an RSVP URL it is only the id.
+func (m *Mailer) RSVPLink(inviteeID string) string {
+	if m.rsvpURL == "" {
+		return inviteeID
+	}
+
+	if strings.Contains(m.rsvpURL, "{invitee_id}") {
+		return strings.ReplaceAll(m.rsvpURL, "{invitee_id}", inviteeID)
+	}
+
+	return strings.TrimSuffix(m.rsvpURL, "/") + "/" + inviteeID
+}
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return fallback
+}
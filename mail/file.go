@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grounded042/capacious/logging"
+)
+
+// File writes each message to its own .eml file in Dir, for development.
+type File struct {
+	Dir string
+}
+
+// Send implements Transport.
+func (f File) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Bytes()
+
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(b) + ".eml"
+
+	return os.WriteFile(filepath.Join(f.Dir, name), data, 0o640)
+}
+
+// Log only logs the messages it is given, for development.
+type Log struct{}
+
+// Send implements Transport. The body is logged at debug level.
+func (Log) Send(ctx context.Context, msg Message) error {
+	logger := logging.FromContext(ctx)
+
+	logger.Info("email", "to", msg.To.Address, "subject", msg.Subject, "attachments", len(msg.Attachments))
+	logger.Debug("email body", "to", msg.To.Address, "body", msg.Body)
+
+	return nil
+}
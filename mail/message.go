@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Bytes gets msg as an RFC 5322 message, with its body quoted-printable. A
+// message with attachments is multipart/mixed, the body first and then each
+// attachment in base64.
+func (msg Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	header := func(name string, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", msg.From.String())
+	header("To", msg.To.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", oneLine(msg.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(msg.From.Address))
+	header("MIME-Version", "1.0")
+
+	if len(msg.Attachments) == 0 {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(&buf, msg.Body); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+
+	header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+
+	body, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeQuotedPrintable(body, msg.Body); err != nil {
+		return nil, err
+	}
+
+	for _, a := range msg.Attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeBase64(part, a.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+
+	if _, err := qw.Write([]byte(crlf(s))); err != nil {
+		return err
+	}
+
+	return qw.Close()
+}
+
+// writeBase64 writes data in base64 in lines of 76 characters, as MIME wants.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+
+		encoded = encoded[n:]
+	}
+
+	return nil
+}
+
+// messageID makes a unique Message-ID in the domain of from.
+func messageID(from string) string {
+	domain := "localhost"
+
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// oneLine keeps a header value from starting new headers.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// crlf ends every line of s with CRLF, as SMTP wants.
+func crlf(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+// how the connection to the SMTP server is secured
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS, failing if the
+	// server doesn't offer it
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS from the start, as port 465 wants
+	TLSImplicit = "tls"
+	// TLSNone never uses TLS, for local SMTP stand-ins. It is the only mode
+	// that sends in the clear
+	TLSNone = "none"
+)
+
+// SMTPConfig says which SMTP server to send through.
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	TLS      string
+}
+
+// SMTP sends messages through an SMTP server, one connection per message.
+type SMTP struct {
+	conf SMTPConfig
+}
+
+// Send implements Transport. The whole exchange has to finish before ctx is
+// done.
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Bytes()
+
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.conf.Addr)
+
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.conf.Addr)
+
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if s.conf.TLS == TLSImplicit {
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+
+	c, err := smtp.NewClient(conn, host)
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	defer c.Close()
+
+	if s.conf.TLS == TLSStartTLS {
+		// falling back to plaintext would let anyone in the middle strip
+		// STARTTLS and read the messages and password
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("the SMTP server doesn't offer STARTTLS, set SMTP_TLS to none to send without TLS")
+		}
+
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if s.conf.Username != "" {
+		// PlainAuth won't send the password in the clear unless the server is
+		// on localhost
+		if err := c.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(msg.From.Address); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(msg.To.Address); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := c.Quit(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	return nil
+}
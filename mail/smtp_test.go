@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"context"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveSMTP runs an SMTP server on a random port that doesn't offer STARTTLS
+// and sends the data of each message it gets on the returned channel.
+func serveSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+	received := make(chan string, 1)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				c := textproto.NewConn(conn)
+				c.PrintfLine("220 localhost")
+
+				for {
+					line, err := c.ReadLine()
+
+					if err != nil {
+						return
+					}
+
+					switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+					case "EHLO", "HELO":
+						c.PrintfLine("250-localhost")
+						c.PrintfLine("250 8BITMIME")
+					case "DATA":
+						c.PrintfLine("354 go ahead")
+
+						data, err := c.ReadDotBytes()
+
+						if err != nil {
+							return
+						}
+
+						received <- string(data)
+						c.PrintfLine("250 ok")
+					case "QUIT":
+						c.PrintfLine("221 bye")
+						return
+					default:
+						c.PrintfLine("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), received
+}
+
+func testMessage() Message {
+	return Message{
+		From:    netmail.Address{Name: "Capacious", Address: "capacious@localhost"},
+		To:      netmail.Address{Address: "soldier@mann.co"},
+		Subject: "Hi",
+		Body:    "Hello",
+	}
+}
+
+func TestSMTPSendsWithoutTLSOnlyWhenToldTo(t *testing.T) {
+	addr, received := serveSMTP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := (SMTP{conf: SMTPConfig{Addr: addr, TLS: TLSStartTLS}}).Send(ctx, testMessage()); err == nil {
+		t.Fatal("sent in the clear to a server without STARTTLS")
+	}
+
+	select {
+	case <-received:
+		t.Fatal("the server got the message")
+	default:
+	}
+
+	if err := (SMTP{conf: SMTPConfig{Addr: addr, TLS: TLSNone}}).Send(ctx, testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "To: <soldier@mann.co>") || !strings.Contains(data, "Subject: Hi") {
+			t.Errorf("the server got %q", data)
+		}
+	case <-ctx.Done():
+		t.Fatal("the server didn't get the message")
+	}
+}
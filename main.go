@@ -1,46 +1,266 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/grounded042/capacious/controllers"
 	"github.com/grounded042/capacious/dal"
+	"github.com/grounded042/capacious/jwtkeys"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/mail"
 	"github.com/grounded042/capacious/middleware"
+	"github.com/grounded042/capacious/oidc"
 	"github.com/grounded042/capacious/routes"
 	"github.com/grounded042/capacious/services"
 	"github.com/zenazn/goji"
+	"github.com/zenazn/goji/graceful"
+	"github.com/zenazn/goji/web"
+	gojimw "github.com/zenazn/goji/web/middleware"
 )
 
 type appContext struct {
+	Services    services.Coordinator
 	Controllers controllers.List
+	Data        dal.DataHandler
+	Keys        *jwtkeys.Ring
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "keys":
+			os.Exit(keysCommand(os.Args[2:]))
+		case "worker":
+			os.Exit(workerCommand(os.Args[2:]))
+		case "jobs":
+			os.Exit(jobsCommand(os.Args[2:]))
+		}
+	}
+
 	var prefix = flag.String("prefix", "/api/v1", "The prefix for all calls.")
+	var logFormat = flag.String("log-format", "json", "The log format: json or logfmt.")
+	var logLevel = flag.String("log-level", "info", "The log level: debug, info, warn or error.")
+	var requestTimeout = flag.Duration("request-timeout", routes.DefaultTimeout, "How long a request has to be handled unless its route says otherwise.")
+	var maxBodySize = flag.Int64("max-body-size", routes.DefaultMaxBodySize, "The largest request body, in bytes, a route accepts unless it says otherwise.")
+	var rateLimitStore = flag.String("rate-limit-store", "memory", "Where rate limit buckets are kept: memory, or postgres to share them between processes.")
+	var idempotencyStore = flag.String("idempotency-store", "memory", "Where responses for Idempotency-Key retries are kept: memory, or postgres to share them between processes.")
+	var idempotencyTTL = flag.Duration("idempotency-ttl", routes.IdempotencyTTL, "How long the response to a request sent with an Idempotency-Key is replayed for.")
+	var trustProxy = flag.Bool("trust-proxy", false, "Take the client's IP address from the X-Forwarded-For or X-Real-IP headers. Only set this behind a proxy that sets them.")
+	var workers = flag.Int("workers", 2, "How many background jobs, such as sending emails, the server runs at once. 0 leaves them to capacious worker processes.")
+	var scheduler = flag.Bool("scheduler", false, "Also enqueue reminders, digests and RSVP locks as they come due.")
+	addScheduleFlags(flag.CommandLine)
+	addWebhookFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	capaciousAPIServer := goji.DefaultMux
+	logger, err := logging.New(os.Stderr, *logFormat, *logLevel)
+
+	if err != nil {
+		slog.Error("could not set up logging", "error", err)
+		os.Exit(2)
+	}
+
+	// this also sends anything logged with the log package through logger
+	slog.SetDefault(logger)
+
+	// swap goji's request id, logger and recoverer for ones that log
+	// structured lines tagged with the request id
+	goji.Insert(middleware.RequestID, gojimw.RequestID)
+	goji.Abandon(gojimw.RequestID)
+	goji.Insert(middleware.AccessLog, gojimw.Logger)
+	goji.Abandon(gojimw.Logger)
+	goji.Insert(middleware.Recoverer, gojimw.Recoverer)
+	goji.Abandon(gojimw.Recoverer)
+
+	if *trustProxy {
+		goji.Insert(gojimw.RealIP, middleware.RequestID)
+	}
+
+	routes.DefaultTimeout = *requestTimeout
+	dal.StatementTimeout = *requestTimeout
+	routes.DefaultMaxBodySize = *maxBodySize
+	routes.IdempotencyTTL = *idempotencyTTL
+	controllers.LinkPrefix = *prefix
+
 	ac := getAppContext()
 
-	// apply the middleware
-	goji.Use(middleware.ContentTypeHeader)
-	goji.Use(middleware.JWTMiddleware)
-	goji.Use(middleware.CORS)
+	switch *rateLimitStore {
+	case "memory":
+	case "postgres":
+		routes.RateLimitStore = ac.Data.RateLimitStore(24 * time.Hour)
+	default:
+		slog.Error("unknown rate limit store", "store", *rateLimitStore)
+		os.Exit(2)
+	}
+
+	switch *idempotencyStore {
+	case "memory":
+	case "postgres":
+		routes.IdempotencyStore = ac.Data.IdempotencyStore()
+	default:
+		slog.Error("unknown idempotency store", "store", *idempotencyStore)
+		os.Exit(2)
+	}
+
+	// the health and metrics routes live outside of the prefix and skip the api
+	// middleware
+	routes.BuildRoutes(goji.DefaultMux, routes.HealthRoutes(ac.Controllers), "")
+	routes.BuildRoutes(goji.DefaultMux, routes.MetricsRoutes(), "")
+	routes.BuildRoutes(goji.DefaultMux, routes.WellKnownRoutes(ac.Controllers), "")
+
+	reloadKeysOnHangup(ac.Keys)
+
+	publicCORS, err := middleware.CORSConfigFromEnv("CORS_PUBLIC", middleware.PublicCORSDefaults)
+
+	if err != nil {
+		slog.Error("could not read the public CORS policy", "error", err)
+		os.Exit(2)
+	}
+
+	adminCORS, err := middleware.CORSConfigFromEnv("CORS_ADMIN", middleware.AdminCORSDefaults)
+
+	if err != nil {
+		slog.Error("could not read the admin CORS policy", "error", err)
+		os.Exit(2)
+	}
+
+	var apiRoutes []routes.Route
+	apiRoutes = append(apiRoutes, routes.EventRoutes(ac.Controllers)...)
+	apiRoutes = append(apiRoutes, routes.InviteeRoutes(ac.Controllers)...)
+	apiRoutes = append(apiRoutes, routes.AuthRoutes(ac.Controllers)...)
+
+	capaciousAPIServer := web.New()
+	goji.Handle(*prefix+"/*", capaciousAPIServer)
+
+	// apply the middleware. CORS goes first so that preflight requests and
+	// responses rejected by later middleware still get the CORS headers.
+	capaciousAPIServer.Use(middleware.CORS(publicCORS, adminCORS, routes.PublicPatterns(apiRoutes, *prefix)))
+	capaciousAPIServer.Use(middleware.ContentTypeHeader)
+	capaciousAPIServer.Use(middleware.JSONAPI)
+	capaciousAPIServer.Use(middleware.APIKeys(ac.Services))
+	capaciousAPIServer.Use(middleware.JWTMiddleware(ac.Keys))
+
+	routes.BuildRoutes(capaciousAPIServer, apiRoutes, *prefix)
+
+	// the jobs that are running when the server is stopped are finished after
+	// it stops taking requests
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	waitForJobs := startJobs(jobsCtx, ac.Services, ac.Data, *workers, *scheduler)
+
+	graceful.PostHook(func() {
+		stopJobs()
+		waitForJobs()
+	})
+
+	// changes made through any instance of the server reach the admins
+	// following them on this one through the database
+	go func() {
+		if err := ac.Services.ListenForChanges(jobsCtx); err != nil {
+			slog.Error("could not listen for changes to events", "error", err)
+		}
+	}()
 
-	routes.BuildRoutes(capaciousAPIServer, routes.EventRoutes(ac.Controllers), *prefix)
-	routes.BuildRoutes(capaciousAPIServer, routes.InviteeRoutes(ac.Controllers), *prefix)
-	routes.BuildRoutes(capaciousAPIServer, routes.AuthRoutes(ac.Controllers), *prefix)
+	// streams would otherwise hold the server open until their clients leave
+	graceful.PreHook(ac.Services.CloseSubscriptions)
 
 	goji.Serve()
 }
 
 func getAppContext() appContext {
+	keys := getKeys()
 	da := dal.NewDal()
-	co := services.NewCoordinator(da)
+	co := services.NewCoordinator(da, keys, getOIDCProvider(), getMailer())
 	cl := controllers.NewControllersList(co)
 
 	return appContext{
+		Services:    co,
 		Controllers: cl,
+		Data:        da,
+		Keys:        keys,
 	}
 }
+
+// getKeys loads the keys tokens are signed with from the key set file named by
+// JWT_KEYS_FILE. Without one, tokens are signed with the shared
+// GO_JWT_MIDDLEWARE_KEY secret as they used to be.
+func getKeys() *jwtkeys.Ring {
+	path := os.Getenv("JWT_KEYS_FILE")
+
+	if path == "" {
+		slog.Warn("JWT_KEYS_FILE is not set, signing tokens with GO_JWT_MIDDLEWARE_KEY")
+		return jwtkeys.NewHMACRing([]byte(os.Getenv("GO_JWT_MIDDLEWARE_KEY")))
+	}
+
+	keys, err := jwtkeys.NewRing(path)
+
+	if err != nil {
+		slog.Error("could not load the JWT keys", "path", path, "error", err)
+		os.Exit(2)
+	}
+
+	return keys
+}
+
+// getOIDCProvider builds the OIDC provider admins can log in with from the
+// environment. It is nil if OIDC_ISSUER isn't set.
+func getOIDCProvider() *oidc.Provider {
+	conf, ok, err := oidc.ConfigFromEnv()
+
+	if err != nil {
+		slog.Error("could not configure OIDC login", "error", err)
+		os.Exit(2)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return oidc.NewProvider(conf)
+}
+
+// getMailer builds what emails to invitees are sent with from the
+// environment. Without MAIL_TRANSPORT they are only logged.
+func getMailer() *mail.Mailer {
+	if os.Getenv("MAIL_TRANSPORT") == "" {
+		slog.Warn("MAIL_TRANSPORT is not set, emails will only be logged")
+	}
+
+	conf, err := mail.ConfigFromEnv()
+
+	if err != nil {
+		slog.Error("could not read the mail config", "error", err)
+		os.Exit(2)
+	}
+
+	mailer, err := mail.New(conf)
+
+	if err != nil {
+		slog.Error("could not set up sending emails", "error", err)
+		os.Exit(2)
+	}
+
+	return mailer
+}
+
+// reloadKeysOnHangup reloads keys from the key set file whenever the process
+// gets a SIGHUP, so rotated keys can be picked up without a restart.
+func reloadKeysOnHangup(keys *jwtkeys.Ring) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := keys.Reload(); err != nil {
+				slog.Error("could not reload the JWT keys", "error", err)
+			} else {
+				slog.Info("reloaded the JWT keys")
+			}
+		}
+	}()
+}
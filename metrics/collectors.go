@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"sort"
+	"sync"
+)
+
+// CounterVec is a counter partitioned by a set of labels.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+// NewCounterVec creates and registers a counter with the given label names.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{n: name, help: help, typ: "counter", labels: labels},
+		values: map[string]float64{},
+		keys:   map[string][]string{},
+	}
+
+	defaultRegistry.register(c)
+
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the given label values. Counters only go up so
+// negative values are ignored.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+
+	k := c.labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[k] += v
+	c.keys[k] = labelValues
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+
+	for _, k := range sortedKeys(c.keys) {
+		w.WriteString(c.n + c.formatLabels(c.keys[k]) + " " + formatFloat(c.values[k]) + "\n")
+	}
+}
+
+// HistogramVec is a histogram partitioned by a set of labels.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+	keys    map[string][]string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper
+// bucket bounds and label names. DefBuckets is used when buckets is empty.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+
+	h := &HistogramVec{
+		desc:    desc{n: name, help: help, typ: "histogram", labels: labels},
+		buckets: b,
+		series:  map[string]*histogram{},
+		keys:    map[string][]string{},
+	}
+
+	defaultRegistry.register(h)
+
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := h.labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[k]
+
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+		h.keys[k] = labelValues
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+
+	for _, k := range sortedKeys(h.keys) {
+		s := h.series[k]
+		values := h.keys[k]
+
+		for i, upper := range h.buckets {
+			w.WriteString(h.n + "_bucket" + h.formatLabels(values, "le", formatFloat(upper)) + " " + formatFloat(float64(s.counts[i])) + "\n")
+		}
+
+		w.WriteString(h.n + "_bucket" + h.formatLabels(values, "le", "+Inf") + " " + formatFloat(float64(s.count)) + "\n")
+		w.WriteString(h.n + "_sum" + h.formatLabels(values) + " " + formatFloat(s.sum) + "\n")
+		w.WriteString(h.n + "_count" + h.formatLabels(values) + " " + formatFloat(float64(s.count)) + "\n")
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are scraped.
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge that calls fn on every scrape.
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{n: name, help: help, typ: "gauge"},
+		fn:   fn,
+	}
+
+	defaultRegistry.register(g)
+
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	v := g.fn()
+
+	if math.IsNaN(v) {
+		return
+	}
+
+	g.writeHeader(w)
+	w.WriteString(g.n + " " + formatFloat(v) + "\n")
+}
@@ -0,0 +1,145 @@
+// Package metrics holds the counters, histograms and gauges the api exposes
+// and renders them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default latency buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is anything that can write itself out in the text format.
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+type registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+var defaultRegistry = &registry{}
+
+func (r *registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	sort.Sort(byName(collectors))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+
+	for _, c := range collectors {
+		c.write(bw)
+	}
+
+	bw.Flush()
+}
+
+// Handler returns an http.Handler that renders every registered metric.
+func Handler() http.Handler {
+	return defaultRegistry
+}
+
+type byName []collector
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].name() < b[j].name() }
+
+// desc holds what every metric has in common.
+type desc struct {
+	n      string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) name() string {
+	return d.n
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + d.n + " " + escapeHelp(d.help) + "\n")
+	w.WriteString("# TYPE " + d.n + " " + d.typ + "\n")
+}
+
+// labelKey joins label values so they can be used as a map key.
+func (d desc) labelKey(values []string) string {
+	if len(values) != len(d.labels) {
+		panic("metrics: " + d.n + " expects " + strconv.Itoa(len(d.labels)) + " label values")
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels renders the label pairs for values along with any extra pairs,
+// e.g. `{route="/events",le="0.5"}`.
+func (d desc) formatLabels(values []string, extra ...string) string {
+	var pairs []string
+
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys returns the keys of a series map in a stable order so scrapes
+// are easy to diff.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,234 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sample is one line of a scrape that isn't a comment.
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var (
+	metricName = `[a-zA-Z_:][a-zA-Z0-9_:]*`
+	helpLine   = regexp.MustCompile(`^# HELP (` + metricName + `) (.*)$`)
+	typeLine   = regexp.MustCompile(`^# TYPE (` + metricName + `) (counter|gauge|histogram|summary|untyped)$`)
+	sampleLine = regexp.MustCompile(`^(` + metricName + `)(\{.*\})? (\S+)$`)
+	labelPair  = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\["\\n])*)"(,|$)`)
+)
+
+var labelUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\"`, `"`)
+
+// parse checks a scrape is in the text exposition format, as a Prometheus
+// server would read it, and returns its samples along with the type of each
+// family.
+func parse(t *testing.T, text string) ([]sample, map[string]string) {
+	t.Helper()
+
+	var samples []sample
+	types := map[string]string{}
+	family := ""
+
+	s := bufio.NewScanner(strings.NewReader(text))
+
+	for s.Scan() {
+		line := s.Text()
+
+		if m := helpLine.FindStringSubmatch(line); m != nil {
+			family = m[1]
+			continue
+		}
+
+		if m := typeLine.FindStringSubmatch(line); m != nil {
+			if _, ok := types[m[1]]; ok {
+				t.Fatalf("%s is typed twice", m[1])
+			}
+
+			family = m[1]
+			types[family] = m[2]
+			continue
+		}
+
+		m := sampleLine.FindStringSubmatch(line)
+
+		if m == nil {
+			t.Fatalf("line %q isn't a sample", line)
+		}
+
+		// a family's samples have to follow its TYPE line, with a suffix for
+		// histograms
+		if base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(m[1], "_bucket"), "_sum"), "_count"); m[1] != family && !(base == family && types[family] == "histogram") {
+			t.Fatalf("sample %q isn't in the family %s it follows", line, family)
+		}
+
+		labels := map[string]string{}
+
+		if m[2] != "" {
+			rest := m[2][1 : len(m[2])-1]
+
+			for rest != "" {
+				pair := labelPair.FindStringSubmatch(rest)
+
+				if pair == nil {
+					t.Fatalf("bad labels in %q", line)
+				}
+
+				labels[pair[1]] = labelUnescaper.Replace(pair[2])
+				rest = rest[len(pair[0]):]
+			}
+		}
+
+		value, err := strconv.ParseFloat(m[3], 64)
+
+		if err != nil {
+			t.Fatalf("bad value in %q: %v", line, err)
+		}
+
+		samples = append(samples, sample{name: m[1], labels: labels, value: value})
+	}
+
+	return samples, types
+}
+
+func scrape(t *testing.T) ([]sample, map[string]string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	return parse(t, w.Body.String())
+}
+
+func find(samples []sample, name string, labels map[string]string) (float64, bool) {
+	for _, s := range samples {
+		if s.name != name || len(s.labels) != len(labels) {
+			continue
+		}
+
+		match := true
+
+		for k, v := range labels {
+			if s.labels[k] != v {
+				match = false
+			}
+		}
+
+		if match {
+			return s.value, true
+		}
+	}
+
+	return 0, false
+}
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "A counter\nwith a \\ in its help.", "route", "status")
+	odd := `/a "quoted" \ path` + "\n"
+
+	c.Inc("/events/:id", "200")
+	c.Inc("/events/:id", "200")
+	c.Add(2.5, odd, "500")
+	c.Add(-1, "/events/:id", "200")
+
+	samples, types := scrape(t)
+
+	if types["test_counter_total"] != "counter" {
+		t.Errorf("type = %q, want counter", types["test_counter_total"])
+	}
+
+	tests := []struct {
+		route, status string
+		want          float64
+	}{
+		{"/events/:id", "200", 2},
+		{odd, "500", 2.5},
+	}
+
+	for _, tt := range tests {
+		if got, ok := find(samples, "test_counter_total", map[string]string{"route": tt.route, "status": tt.status}); !ok || got != tt.want {
+			t.Errorf("%q %s = %v (found %t), want %v", tt.route, tt.status, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "A histogram.", []float64{1, 0.1, 0.5}, "route")
+
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		h.Observe(v, "/events")
+	}
+
+	samples, types := scrape(t)
+
+	if types["test_duration_seconds"] != "histogram" {
+		t.Errorf("type = %q, want histogram", types["test_duration_seconds"])
+	}
+
+	// buckets are cumulative and sorted, whatever order they were given in
+	buckets := []struct {
+		le   string
+		want float64
+	}{
+		{"0.1", 2},
+		{"0.5", 3},
+		{"1", 3},
+		{"+Inf", 4},
+	}
+
+	for _, b := range buckets {
+		if got, ok := find(samples, "test_duration_seconds_bucket", map[string]string{"route": "/events", "le": b.le}); !ok || got != b.want {
+			t.Errorf("bucket le=%s = %v (found %t), want %v", b.le, got, ok, b.want)
+		}
+	}
+
+	if got, _ := find(samples, "test_duration_seconds_count", map[string]string{"route": "/events"}); got != 4 {
+		t.Errorf("count = %v, want 4", got)
+	}
+
+	if got, _ := find(samples, "test_duration_seconds_sum", map[string]string{"route": "/events"}); math.Abs(got-2.45) > 1e-9 {
+		t.Errorf("sum = %v, want 2.45", got)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	v := 3.0
+	NewGaugeFunc("test_gauge", "A gauge.", func() float64 { return v })
+
+	samples, types := scrape(t)
+
+	if got, ok := find(samples, "test_gauge", map[string]string{}); !ok || got != 3 || types["test_gauge"] != "gauge" {
+		t.Errorf("test_gauge = %v (found %t, type %q), want a gauge of 3", got, ok, types["test_gauge"])
+	}
+
+	// a gauge that can't be read is left out altogether
+	v = math.NaN()
+	samples, types = scrape(t)
+
+	if _, ok := find(samples, "test_gauge", map[string]string{}); ok || types["test_gauge"] != "" {
+		t.Error("a NaN gauge was written")
+	}
+}
+
+func TestRegisteringTwicePanics(t *testing.T) {
+	NewCounterVec("test_twice_total", "Registered twice.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a metric name twice didn't panic")
+		}
+	}()
+
+	NewCounterVec("test_twice_total", "Registered twice.")
+}
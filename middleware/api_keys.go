@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/audit"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/utils"
+	"github.com/zenazn/goji/web"
+)
+
+// APIKeyAuthenticator checks API keys.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (apikeys.Principal, utils.Error)
+}
+
+// APIKeys accepts API keys sent as bearer tokens in place of a JWT. Requests
+// with a valid key get the `UserID` of the admin who created it, as a JWT
+// would give them, and carry the key's principal in their context so the
+// event and scopes it is limited to can be checked and changes made with it
+// audited. Invalid or revoked keys get a 401. Requests without a key are
+// passed on to the JWT middleware, which has to come after this one.
+func APIKeys(auth APIKeyAuthenticator) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := bearerAPIKey(r)
+
+			if key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			p, err := auth.AuthenticateAPIKey(r.Context(), key)
+
+			if err != nil {
+				logging.FromContext(r.Context()).Debug("could not authenticate API key", "error", err)
+				w.WriteHeader(err.Code())
+				return
+			}
+
+			c.Env["UserID"] = p.UserID
+			ctx := apikeys.NewContext(r.Context(), p)
+			ctx = audit.NewContext(ctx, audit.Actor{Type: audit.ActorAPIKey, ID: p.KeyID})
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireAPIKeyScope only lets requests made with an API key through to h if
+// the key has scope. Routes that don't name a scope can't be used with a key
+// at all, unless they are public. Requests made without a key are always let
+// through.
+func RequireAPIKeyScope(scope apikeys.Scope, public bool, h web.Handler) web.Handler {
+	if public {
+		return h
+	}
+
+	msg, _ := json.Marshal("This API key is not allowed to do this.")
+
+	return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		if p, ok := apikeys.FromContext(r.Context()); ok && (scope == "" || !p.Has(scope)) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(msg)
+			return
+		}
+
+		h.ServeHTTPC(c, w, r)
+	})
+}
+
+// bearerAPIKey gets the API key from the Authorization header, if that is
+// what it holds.
+func bearerAPIKey(r *http.Request) string {
+	parts := strings.Fields(r.Header.Get("Authorization"))
+
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || !apikeys.IsKey(parts[1]) {
+		return ""
+	}
+
+	return parts[1]
+}
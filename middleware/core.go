@@ -3,7 +3,6 @@ package middleware
 import (
 	"net/http"
 
-	"github.com/rs/cors"
 	"github.com/zenazn/goji/web"
 )
 
@@ -16,14 +15,3 @@ func ContentTypeHeader(c *web.C, h http.Handler) http.Handler {
 
 	return http.HandlerFunc(fn)
 }
-
-func CORS(c *web.C, h http.Handler) http.Handler {
-	cors := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedHeaders: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PATCH"},
-		Debug:          true,
-	})
-
-	return cors.Handler(h)
-}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/cors"
+	"github.com/zenazn/goji/web"
+)
+
+// CORSConfig holds the CORS policy for a group of routes.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// PublicCORSDefaults is the policy for the routes invitees use to RSVP. They
+// don't need a token, so credentials are not allowed.
+var PublicCORSDefaults = CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PATCH"},
+	AllowedHeaders: []string{"Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", RequestIDHeader},
+	ExposedHeaders: []string{"ETag", "Idempotent-Replayed", "Link", RequestIDHeader},
+	MaxAge:         600,
+}
+
+// AdminCORSDefaults is the policy for every route that is not public.
+var AdminCORSDefaults = CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+	AllowedHeaders: []string{"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", RequestIDHeader},
+	ExposedHeaders: []string{"ETag", "Idempotent-Replayed", "Link", RequestIDHeader},
+	MaxAge:         600,
+}
+
+// CORSConfigFromEnv reads a CORS policy from the environment, falling back to
+// defaults for anything that is not set. The variables are prefixed with
+// prefix, e.g. for a prefix of CORS_ADMIN:
+//
+//	CORS_ADMIN_ALLOWED_ORIGINS=https://admin.example.com,https://*.example.com
+//	CORS_ADMIN_ALLOWED_METHODS=GET,POST
+//	CORS_ADMIN_ALLOWED_HEADERS=Authorization,Content-Type
+//	CORS_ADMIN_EXPOSED_HEADERS=X-Request-ID
+//	CORS_ADMIN_ALLOW_CREDENTIALS=true
+//	CORS_ADMIN_MAX_AGE=600
+func CORSConfigFromEnv(prefix string, defaults CORSConfig) (CORSConfig, error) {
+	conf := defaults
+
+	if v, ok := os.LookupEnv(prefix + "_ALLOWED_ORIGINS"); ok {
+		conf.AllowedOrigins = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv(prefix + "_ALLOWED_METHODS"); ok {
+		conf.AllowedMethods = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv(prefix + "_ALLOWED_HEADERS"); ok {
+		conf.AllowedHeaders = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv(prefix + "_EXPOSED_HEADERS"); ok {
+		conf.ExposedHeaders = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv(prefix + "_ALLOW_CREDENTIALS"); ok {
+		b, err := strconv.ParseBool(v)
+
+		if err != nil {
+			return CORSConfig{}, errors.New(prefix + "_ALLOW_CREDENTIALS must be true or false")
+		}
+
+		conf.AllowCredentials = b
+	}
+
+	if v, ok := os.LookupEnv(prefix + "_MAX_AGE"); ok {
+		i, err := strconv.Atoi(v)
+
+		if err != nil || i < 0 {
+			return CORSConfig{}, errors.New(prefix + "_MAX_AGE must be a number of seconds")
+		}
+
+		conf.MaxAge = i
+	}
+
+	// browsers refuse credentialed responses for a wildcard origin, and
+	// echoing any origin back with credentials would let any site act as
+	// the user
+	if conf.AllowCredentials {
+		for _, o := range conf.AllowedOrigins {
+			if o == "*" {
+				return CORSConfig{}, errors.New(prefix + "_ALLOWED_ORIGINS can't be * when credentials are allowed")
+			}
+		}
+	}
+
+	return conf, nil
+}
+
+func (conf CORSConfig) build() *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins:   conf.AllowedOrigins,
+		AllowedMethods:   conf.AllowedMethods,
+		AllowedHeaders:   conf.AllowedHeaders,
+		ExposedHeaders:   conf.ExposedHeaders,
+		AllowCredentials: conf.AllowCredentials,
+		MaxAge:           conf.MaxAge,
+	})
+}
+
+// CORS builds middleware that applies the public policy to requests for a
+// path matched by one of publicPatterns and the admin policy to everything
+// else. The path is used rather than the route since preflight requests are
+// OPTIONS requests, which don't match a route. Both policies are built once,
+// here, rather than on every request.
+func CORS(public CORSConfig, admin CORSConfig, publicPatterns []string) func(*web.C, http.Handler) http.Handler {
+	publicCORS := public.build()
+	adminCORS := admin.build()
+
+	var patterns []web.Pattern
+
+	for _, p := range publicPatterns {
+		patterns = append(patterns, web.ParsePattern(p))
+	}
+
+	return func(c *web.C, h http.Handler) http.Handler {
+		publicHandler := publicCORS.Handler(h)
+		adminHandler := adminCORS.Handler(h)
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range patterns {
+				if p.Match(r, nil) {
+					publicHandler.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			adminHandler.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+func splitList(v string) []string {
+	var list []string
+
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/zenazn/goji/web"
+)
+
+// serveCORS runs a request through the CORS middleware built from public and
+// admin, with the invitee routes public. It returns the response and whether
+// the request got through to the handler.
+func serveCORS(public CORSConfig, admin CORSConfig, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	served := false
+
+	h := CORS(public, admin, []string{"/api/v1/invitees/:invitee_id"})(&web.C{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w, served
+}
+
+func preflight(path string, origin string, method string, headers string) *http.Request {
+	r := httptest.NewRequest("OPTIONS", path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+
+	return r
+}
+
+func TestCORSPreflightUsesThePolicyOfThePath(t *testing.T) {
+	public := "/api/v1/invitees/fb3c11f8-7917-11e5-8b8e-b3a0b1b9b078"
+	admin := "/api/v1/events/cd7bc650-2e71-11e5-a390-675459d99309"
+
+	tests := []struct {
+		name    string
+		path    string
+		method  string
+		headers string
+		allowed bool
+	}{
+		{"public route", public, "PATCH", "Content-Type, If-Match", true},
+		{"method only admins can use", public, "DELETE", "", false},
+		{"token on a public route", public, "PATCH", "Authorization", false},
+		{"admin route", admin, "DELETE", "Authorization, X-Request-ID", true},
+		{"header no one sends", admin, "GET", "X-Not-Allowed", false},
+	}
+
+	for _, tt := range tests {
+		w, served := serveCORS(PublicCORSDefaults, AdminCORSDefaults, preflight(tt.path, "https://rsvp.example.com", tt.method, tt.headers))
+
+		if served {
+			t.Errorf("%s: the preflight got through to the handler", tt.name)
+		}
+
+		got := w.Header().Get("Access-Control-Allow-Origin")
+
+		if tt.allowed {
+			if got != "https://rsvp.example.com" || w.Header().Get("Access-Control-Allow-Methods") != tt.method || w.Header().Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("%s: got headers %v, want the preflight allowed", tt.name, w.Header())
+			}
+		} else if got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want the preflight turned away", tt.name, got)
+		}
+	}
+}
+
+func TestCORSTurnsAwayOriginsThatArentAllowed(t *testing.T) {
+	admin := AdminCORSDefaults
+	admin.AllowedOrigins = []string{"https://admin.example.com", "https://*.example.org"}
+	admin.AllowCredentials = true
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://admin.example.com", true},
+		{"https://staging.example.org", true},
+		{"https://evil.example.net", false},
+		{"http://admin.example.com", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/events", nil)
+		r.Header.Set("Origin", tt.origin)
+
+		w, served := serveCORS(PublicCORSDefaults, admin, r)
+
+		// the browser is what stops the response being read, so the request
+		// is still handled
+		if !served {
+			t.Errorf("%s: the request wasn't handled", tt.origin)
+		}
+
+		got := w.Header().Get("Access-Control-Allow-Origin")
+
+		if tt.allowed {
+			if got != tt.origin || w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") == "" {
+				t.Errorf("%s: got headers %v, want it allowed with credentials", tt.origin, w.Header())
+			}
+		} else if got != "" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s: got headers %v, want no CORS headers", tt.origin, w.Header())
+		}
+
+		// the public routes don't allow credentials, whatever the origin
+		r = httptest.NewRequest("GET", "/api/v1/invitees/1", nil)
+		r.Header.Set("Origin", tt.origin)
+
+		if w, _ := serveCORS(PublicCORSDefaults, admin, r); w.Header().Get("Access-Control-Allow-Origin") != tt.origin || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s: public route got headers %v", tt.origin, w.Header())
+		}
+	}
+}
+
+func TestCORSConfigFromEnv(t *testing.T) {
+	t.Setenv("TEST_CORS_ALLOWED_ORIGINS", " https://a.example.com, ,https://b.example.com ")
+	t.Setenv("TEST_CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("TEST_CORS_MAX_AGE", "60")
+
+	conf, err := CORSConfigFromEnv("TEST_CORS", AdminCORSDefaults)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(conf.AllowedOrigins, want) {
+		t.Errorf("AllowedOrigins = %q, want %q", conf.AllowedOrigins, want)
+	}
+
+	if !conf.AllowCredentials || conf.MaxAge != 60 {
+		t.Errorf("got credentials %t and max age %d", conf.AllowCredentials, conf.MaxAge)
+	}
+
+	// what isn't set keeps its default
+	if !reflect.DeepEqual(conf.AllowedMethods, AdminCORSDefaults.AllowedMethods) {
+		t.Errorf("AllowedMethods = %q, want the defaults", conf.AllowedMethods)
+	}
+
+	bad := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"credentials for any origin", "TEST_CORS_ALLOWED_ORIGINS", "*"},
+		{"credentials not a bool", "TEST_CORS_ALLOW_CREDENTIALS", "yes please"},
+		{"negative max age", "TEST_CORS_MAX_AGE", "-1"},
+	}
+
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+
+			if _, err := CORSConfigFromEnv("TEST_CORS", AdminCORSDefaults); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
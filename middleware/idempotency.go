@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/idempotency"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/metrics"
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+var idempotentReplays = metrics.NewCounterVec(
+	"capacious_idempotent_requests_total",
+	"Number of requests sent with an Idempotency-Key that weren't handled again, by outcome.",
+	"outcome",
+)
+
+// Idempotency lets clients safely retry requests by sending an
+// Idempotency-Key header. The response to the first request with a key is
+// kept for ttl and replayed, with an Idempotent-Replayed header, to any
+// retry with the same key and body. A retry with a different body gets a 422
+// and one sent while the first request is still being handled gets a 409.
+// Keys are scoped to the user, API key and path they were sent with. Requests
+// that fail with a 5xx aren't kept so they can be retried. If the store can't
+// be reached the request is handled as if it had no key.
+func Idempotency(store idempotency.Store, ttl time.Duration, h web.Handler) web.Handler {
+	tooLong, _ := json.Marshal(fmt.Sprintf("The %s header can't be longer than %d characters.", idempotency.Header, idempotency.MaxKeyLength))
+	mismatch, _ := json.Marshal(fmt.Sprintf("The %s header has already been used for a different request.", idempotency.Header))
+	inFlight, _ := json.Marshal(fmt.Sprintf("A request with this %s header is still being handled, try again later.", idempotency.Header))
+
+	return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get(idempotency.Header)
+
+		if clientKey == "" {
+			h.ServeHTTPC(c, w, r)
+			return
+		}
+
+		log := logging.FromContext(r.Context())
+
+		if len(clientKey) > idempotency.MaxKeyLength {
+			writeJSON(w, http.StatusBadRequest, tooLong)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+
+			if errors.As(err, &tooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := idempotency.Key(idempotencyScope(c, r), clientKey)
+		requestHash := idempotency.RequestHash(body)
+
+		existing, claimed, err := store.Begin(r.Context(), key, requestHash, time.Now().Add(ttl))
+
+		if err != nil {
+			log.Error("could not check idempotency key", "error", err)
+			h.ServeHTTPC(c, w, r)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case existing.RequestHash != requestHash:
+				idempotentReplays.Inc("mismatch")
+				writeJSON(w, http.StatusUnprocessableEntity, mismatch)
+			case existing.Response == nil:
+				idempotentReplays.Inc("in_flight")
+				w.Header().Set("Retry-After", "1")
+				writeJSON(w, http.StatusConflict, inFlight)
+			default:
+				idempotentReplays.Inc("replayed")
+				log.Debug("replaying response for idempotency key", "status", existing.Response.Status)
+				replay(w, *existing.Response)
+			}
+
+			return
+		}
+
+		// the request context may have been cancelled by the time the
+		// response is kept, e.g. by a timeout, but the key still needs to be
+		// completed or released
+		ctx := context.WithoutCancel(r.Context())
+		completed := false
+
+		defer func() {
+			if completed {
+				return
+			}
+
+			if err := store.Release(ctx, key); err != nil {
+				log.Error("could not release idempotency key", "error", err)
+			}
+		}()
+
+		var buf bytes.Buffer
+		ww := mutil.WrapWriter(w)
+		ww.Tee(&buf)
+
+		h.ServeHTTPC(c, ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status >= http.StatusInternalServerError {
+			return
+		}
+
+		res := idempotency.Response{
+			Status: status,
+			Header: idempotency.KeepHeaders(ww.Header()),
+			Body:   buf.Bytes(),
+		}
+
+		if err := store.Complete(ctx, key, res); err != nil {
+			log.Error("could not keep response for idempotency key", "error", err)
+			return
+		}
+
+		completed = true
+	})
+}
+
+// idempotencyScope gets who sent r, and where to, so the same key sent by
+// different clients or to different routes doesn't clash.
+func idempotencyScope(c web.C, r *http.Request) string {
+	var keyID string
+
+	if p, ok := apikeys.FromContext(r.Context()); ok {
+		keyID = p.KeyID
+	}
+
+	return fmt.Sprintf("%v\n%s\n%s %s", c.Env["UserID"], keyID, r.Method, r.URL.Path)
+}
+
+// replay writes a kept response.
+func replay(w http.ResponseWriter, res idempotency.Response) {
+	for name, values := range res.Header {
+		w.Header()[name] = values
+	}
+
+	w.Header().Set(idempotency.ReplayedHeader, "true")
+	w.WriteHeader(res.Status)
+	w.Write(res.Body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, msg []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(msg)
+}
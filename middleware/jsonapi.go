@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grounded042/capacious/jsonapi"
+	"github.com/zenazn/goji/web"
+)
+
+// JSONAPI turns away requests whose JSON:API media types can't be served, as
+// the spec asks: a 415 for bodies sent with media type parameters and a 406
+// for requests that only accept the media type with them. Responses vary by
+// Accept, as clients that accept JSON:API get documents rather than plain
+// JSON.
+func JSONAPI(c *web.C, h http.Handler) http.Handler {
+	msgs := map[int][]byte{}
+	msgs[http.StatusUnsupportedMediaType], _ = json.Marshal("The " + jsonapi.MediaType + " media type can't be sent with parameters.")
+	msgs[http.StatusNotAcceptable], _ = json.Marshal("The " + jsonapi.MediaType + " media type can only be accepted without parameters.")
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		if code := jsonapi.Negotiate(r); code != 0 {
+			w.WriteHeader(code)
+			w.Write(msgs[code])
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -1,13 +1,14 @@
 package middleware
 
 import (
-	"fmt"
 	"net/http"
-	"os"
 
 	gjm "github.com/auth0/go-jwt-middleware"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/grounded042/capacious/utils"
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/audit"
+	"github.com/grounded042/capacious/jwtkeys"
+	"github.com/grounded042/capacious/logging"
 	"github.com/zenazn/goji/web"
 )
 
@@ -21,53 +22,62 @@ import (
 //  lets the request continue on unhindered.
 // It is up to the handlers to act upon the absence or existence of the
 // `UserId` variable which represents valid auth.
-func JWTMiddleware(c *web.C, h http.Handler) http.Handler {
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("GO_JWT_MIDDLEWARE_KEY")), nil
-	}
-
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		// get the token from the header
-		authToken, err := gjm.FromAuthHeader(r)
+// Tokens are verified with keys, so only tokens signed by one of its keys are
+// accepted. Requests already authenticated with an API key are let through.
+// The user is also put in the request's context so the changes they make can
+// be audited.
+func JWTMiddleware(keys *jwtkeys.Ring) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := apikeys.FromContext(r.Context()); ok {
+				h.ServeHTTP(w, r)
+				return
+			}
 
-		if err != nil {
-			// return bad request if the token is invalid
-			w.WriteHeader(http.StatusBadRequest)
-			utils.CheckErr(err, "bad request")
-		} else if authToken == "" {
-			// we still want to process the request, so we are going to serve http,
-			// but note that we have not set the `UserId` variable in the context. We
-			// still process the request because controllers choose what to do based
-			// on the existence or absence of the `UserId` context variable.
-			h.ServeHTTP(w, r)
-		} else {
-			token, err := jwt.Parse(authToken, keyFunc)
+			// get the token from the header
+			authToken, err := gjm.FromAuthHeader(r)
 
 			if err != nil {
-				if ve, ok := err.(*jwt.ValidationError); ok {
-					if ve.Errors&jwt.ValidationErrorMalformed != 0 {
-						w.WriteHeader(http.StatusBadRequest)
-					} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
-						// Token is either expired or not active yet
-						w.WriteHeader(http.StatusUnauthorized)
-					} else {
-						w.WriteHeader(http.StatusInternalServerError)
-						utils.CheckErr(err, "something bad happened")
-					}
-				}
-			} else if token.Valid {
-				// token is valid, set the user id so other things can use it
-				c.Env["UserID"] = token.Claims["sub"]
+				// return bad request if the token is invalid
+				w.WriteHeader(http.StatusBadRequest)
+				logging.FromContext(r.Context()).Debug("bad authorization header", "error", err)
+			} else if authToken == "" {
+				// we still want to process the request, so we are going to serve http,
+				// but note that we have not set the `UserId` variable in the context. We
+				// still process the request because controllers choose what to do based
+				// on the existence or absence of the `UserId` context variable.
 				h.ServeHTTP(w, r)
 			} else {
-				w.WriteHeader(http.StatusInternalServerError)
+				token, err := keys.Parse(authToken)
+
+				if err != nil {
+					if ve, ok := err.(*jwt.ValidationError); ok {
+						if ve.Errors&jwt.ValidationErrorMalformed != 0 {
+							w.WriteHeader(http.StatusBadRequest)
+						} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+							// Token is either expired or not active yet
+							w.WriteHeader(http.StatusUnauthorized)
+						} else if ve.Errors&(jwt.ValidationErrorUnverifiable|jwt.ValidationErrorSignatureInvalid) != 0 {
+							// signed with a key we don't know, e.g. one that has
+							// been pruned, or tampered with
+							w.WriteHeader(http.StatusUnauthorized)
+							logging.FromContext(r.Context()).Debug("could not verify token", "error", err)
+						} else {
+							w.WriteHeader(http.StatusInternalServerError)
+							logging.FromContext(r.Context()).Error("could not validate token", "error", err)
+						}
+					}
+				} else if token.Valid {
+					// token is valid, set the user id so other things can use it
+					c.Env["UserID"] = token.Claims["sub"]
+					userID, _ := token.Claims["sub"].(string)
+					h.ServeHTTP(w, r.WithContext(audit.NewContext(r.Context(), audit.Actor{Type: audit.ActorUser, ID: userID})))
+				} else {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
 			}
 		}
-	}
 
-	return http.HandlerFunc(fn)
+		return http.HandlerFunc(fn)
+	}
 }
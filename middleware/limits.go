@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+// LimitBody caps the size of the request body h can read at n bytes. Reading
+// past the limit returns an *http.MaxBytesError. A limit of 0 or less means
+// no limit.
+func LimitBody(n int64, h web.Handler) web.Handler {
+	if n <= 0 {
+		return h
+	}
+
+	return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		h.ServeHTTPC(c, w, r)
+	})
+}
+
+// Timeout gives h d to handle a request. The request context is cancelled
+// once d is up, or as soon as the client goes away, which stops any queries
+// still to be made for it; one already running is stopped by the database's
+// statement timeout. If h hasn't written a response by then the client is
+// sent a 503. A d of 0 or less means no timeout.
+func Timeout(d time.Duration, h web.Handler) web.Handler {
+	if d <= 0 {
+		return h
+	}
+
+	msg, _ := json.Marshal("Your request took too long to handle.")
+
+	return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the handler runs on its own goroutine, so hold on to the stack
+			// of any panic before it's passed back to this one
+			defer func() {
+				if p := recover(); p != nil {
+					if _, ok := p.(panicWithStack); !ok && p != http.ErrAbortHandler {
+						p = panicWithStack{value: p, stack: debug.Stack()}
+					}
+
+					panic(p)
+				}
+			}()
+
+			h.ServeHTTPC(c, w, r)
+		})
+
+		http.TimeoutHandler(inner, d, string(msg)).ServeHTTP(w, r)
+	})
+}
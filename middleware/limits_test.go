@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+// serve runs h, behind Recoverer like every route, for a request with body.
+func serve(h web.Handler, body string) *httptest.ResponseRecorder {
+	var c web.C
+
+	handler := Recoverer(&c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTPC(c, w, r)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+
+	return w
+}
+
+func TestLimitBody(t *testing.T) {
+	h := LimitBody(8, web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var tooLarge *http.MaxBytesError
+
+		if _, err := io.ReadAll(r.Body); errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	if w := serve(h, "12345678"); w.Code != http.StatusOK {
+		t.Errorf("body at the limit: got %d, want 200", w.Code)
+	}
+
+	if w := serve(h, "123456789"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit: got %d, want 413", w.Code)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan error, 1)
+
+	h := Timeout(10*time.Millisecond, web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			done <- r.Context().Err()
+		case <-time.After(time.Second):
+			done <- nil
+		}
+	}))
+
+	w := serve(h, "")
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503", w.Code)
+	}
+
+	var msg string
+
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil || msg == "" {
+		t.Errorf("body %q isn't a JSON message: %v", w.Body.String(), err)
+	}
+
+	// the handler is told to stop, which stops its queries
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("the handler's context ended with %v, want the deadline", err)
+	}
+}
+
+func TestPanicsAre500s(t *testing.T) {
+	panics := web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		panic("oh no")
+	})
+
+	tests := []struct {
+		name string
+		h    web.Handler
+	}{
+		{"without a timeout", panics},
+		// the handler runs on its own goroutine
+		{"with a timeout", Timeout(time.Second, panics)},
+	}
+
+	for _, tt := range tests {
+		w := serve(tt.h, "")
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: got %d, want 500", tt.name, w.Code)
+		}
+
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q, want application/json", tt.name, ct)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/grounded042/capacious/logging"
+	"github.com/zenazn/goji/web"
+	gojimw "github.com/zenazn/goji/web/middleware"
+	"github.com/zenazn/goji/web/mutil"
+)
+
+// RequestIDHeader is the header a request id is read from and echoed back in.
+const RequestIDHeader = "X-Request-ID"
+
+// the longest request id we will accept from a client
+const maxRequestIDLength = 128
+
+// RequestID propagates the `X-Request-ID` header of the request, or assigns a
+// new id if there isn't a usable one, and echoes it back in the response. The
+// id is put in the request context along with a logger that adds it to every
+// line, so anything that logs with `logging.FromContext` is tagged with it.
+func RequestID(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		if c.Env == nil {
+			c.Env = make(map[interface{}]interface{})
+		}
+
+		// goji's own middleware looks for the id here
+		c.Env[gojimw.RequestIDKey] = id
+
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// AccessLog logs every request once it has been handled.
+func AccessLog(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := mutil.WrapWriter(w)
+
+		h.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		logging.FromContext(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration_ms", float64(time.Since(start).Nanoseconds())/1e6,
+			"remote_addr", r.RemoteAddr,
+		)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// validRequestID makes sure a client supplied id is safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, ch := range id {
+		if ch < 0x21 || ch > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/grounded042/capacious/logging"
+	"github.com/zenazn/goji/web"
+	gojimw "github.com/zenazn/goji/web/middleware"
+)
+
+func TestRequestID(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"sent by the client", "req-123", true},
+		{"longest allowed", strings.Repeat("a", maxRequestIDLength), true},
+		{"missing", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"with a space", "req 123", false},
+		{"with a new line", "req\n123", false},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		var c web.C
+		var inContext, inEnv string
+
+		h := RequestID(&c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inContext = logging.RequestID(r.Context())
+			inEnv, _ = c.Env[gojimw.RequestIDKey].(string)
+			logging.FromContext(r.Context()).Info("handled")
+		}))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(logging.NewContext(r.Context(), slog.New(slog.NewJSONHandler(&buf, nil))))
+
+		if tt.header != "" {
+			r.Header.Set(RequestIDHeader, tt.header)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		id := w.Header().Get(RequestIDHeader)
+
+		if tt.keep && id != tt.header {
+			t.Errorf("%s: echoed %q, want %q", tt.name, id, tt.header)
+		} else if !tt.keep && !generated.MatchString(id) {
+			t.Errorf("%s: echoed %q, want a new id", tt.name, id)
+		}
+
+		if inContext != id || inEnv != id {
+			t.Errorf("%s: the handler got %q in its context and %q in c.Env, want %q", tt.name, inContext, inEnv, id)
+		}
+
+		var line struct {
+			RequestID string `json:"request_id"`
+		}
+
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil || line.RequestID != id {
+			t.Errorf("%s: logged %q, want it tagged with %q", tt.name, buf.String(), id)
+		}
+	}
+}
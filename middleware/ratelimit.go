@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/metrics"
+	"github.com/grounded042/capacious/ratelimit"
+	"github.com/zenazn/goji/web"
+)
+
+var rateLimited = metrics.NewCounterVec(
+	"capacious_rate_limited_requests_total",
+	"Number of requests turned away by a rate limit, by rule.",
+	"rule",
+)
+
+// RateLimit checks a request against every rule before passing it on to h.
+// If any of the rules' buckets is empty the client is sent a 429 with a
+// Retry-After header saying how long until it can try again. If the store
+// can't be reached the request is let through rather than turning everyone
+// away.
+func RateLimit(store ratelimit.Store, rules []ratelimit.Rule, h web.Handler) web.Handler {
+	if len(rules) == 0 {
+		return h
+	}
+
+	msg, _ := json.Marshal("Too many requests, try again later.")
+
+	return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+
+		for _, rule := range rules {
+			key := rule.Key(r)
+
+			if key == "" {
+				continue
+			}
+
+			ok, retryAfter, err := ratelimit.Take(r.Context(), store, rule.Name+":"+key, rule.Limit, now)
+
+			if err != nil {
+				logging.FromContext(r.Context()).Error("could not check rate limit", "rule", rule.Name, "error", err)
+				continue
+			}
+
+			if !ok {
+				rateLimited.Inc(rule.Name)
+				logging.FromContext(r.Context()).Info("rate limited", "rule", rule.Name, "retry_after", retryAfter.String())
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", strconv.Itoa(RetryAfterSeconds(retryAfter)))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write(msg)
+				return
+			}
+		}
+
+		h.ServeHTTPC(c, w, r)
+	})
+}
+
+// RetryAfterSeconds rounds d up to the whole number of seconds sent in a
+// Retry-After header.
+func RetryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
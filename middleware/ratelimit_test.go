@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grounded042/capacious/ratelimit"
+	"github.com/zenazn/goji/web"
+)
+
+// failingStore is a ratelimit.Store that can't be reached.
+type failingStore struct{}
+
+func (failingStore) Update(ctx context.Context, key string, fn func(b ratelimit.Bucket, found bool) ratelimit.Bucket) error {
+	return errors.New("unreachable")
+}
+
+func serveRateLimited(store ratelimit.Store, rules []ratelimit.Rule) func(remoteAddr string) *httptest.ResponseRecorder {
+	h := RateLimit(store, rules, web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) {}))
+
+	return func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		h.ServeHTTPC(web.C{}, w, r)
+
+		return w
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	serve := serveRateLimited(ratelimit.NewMemoryStore(time.Hour), []ratelimit.Rule{
+		{Name: "ip", Key: ratelimit.ByIP, Limit: ratelimit.Per(2, time.Minute)},
+	})
+
+	for i := 0; i < 2; i++ {
+		if w := serve("10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d, want 200", i, w.Code)
+		}
+	}
+
+	w := serve("10.0.0.1:5678")
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429", w.Code)
+	}
+
+	// a token comes back every 30s
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+
+	var msg string
+
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil || msg == "" {
+		t.Errorf("body %q isn't a JSON message: %v", w.Body.String(), err)
+	}
+
+	// other clients aren't limited by it
+	if w := serve("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client: got %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitLetsRequestsThroughWithoutAStore(t *testing.T) {
+	serve := serveRateLimited(failingStore{}, []ratelimit.Rule{
+		{Name: "ip", Key: ratelimit.ByIP, Limit: ratelimit.Per(1, time.Minute)},
+	})
+
+	for i := 0; i < 3; i++ {
+		if w := serve("10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Errorf("request %d: got %d, want 200", i, w.Code)
+		}
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+
+	for _, tt := range tests {
+		if got := RetryAfterSeconds(tt.d); got != tt.want {
+			t.Errorf("RetryAfterSeconds(%s) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/grounded042/capacious/logging"
+	"github.com/zenazn/goji/web"
+)
+
+// panicWithStack carries a panic, and the stack it happened on, to a
+// different goroutine so Recoverer can log where it really happened.
+type panicWithStack struct {
+	value interface{}
+	stack []byte
+}
+
+// Recoverer recovers from panics in the handlers it wraps. The panic is
+// logged along with a stack trace and the client is sent a 500.
+func Recoverer(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// http.ErrAbortHandler is how a handler says to drop the
+				// connection, so let net/http deal with it
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				stack := debug.Stack()
+
+				if p, ok := err.(panicWithStack); ok {
+					err, stack = p.value, p.stack
+				}
+
+				logging.FromContext(r.Context()).Error("recovered from panic",
+					"panic", err,
+					"stack", string(stack),
+				)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode("Something went wrong handling your request.")
+			}
+		}()
+
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,109 @@
+// Package oidc logs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config says which provider to use and how to map the claims of its ID
+// tokens onto a user.
+type Config struct {
+	// Issuer is the provider's issuer URL. Its discovery document is read
+	// from Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the user back to with the
+	// code. It is the page of the admin UI that finishes the login.
+	RedirectURL string
+	Scopes      []string
+
+	// the claims the user's details are read from
+	EmailClaim     string
+	FirstNameClaim string
+	LastNameClaim  string
+
+	// RequireVerifiedEmail refuses ID tokens whose email_verified claim
+	// isn't true.
+	RequireVerifiedEmail bool
+	// AllowedDomains, when set, only lets users whose email is in one of
+	// these domains log in.
+	AllowedDomains []string
+	// Provision creates users that don't exist yet. Otherwise only existing
+	// users can log in.
+	Provision bool
+}
+
+// ConfigFromEnv reads the provider config from the environment. ok is false
+// if OIDC_ISSUER isn't set, which turns OIDC login off.
+func ConfigFromEnv() (conf Config, ok bool, err error) {
+	conf = Config{
+		Issuer:               strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:             os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:         os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:          os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:               []string{"openid", "email", "profile"},
+		EmailClaim:           envOr("OIDC_EMAIL_CLAIM", "email"),
+		FirstNameClaim:       envOr("OIDC_FIRST_NAME_CLAIM", "given_name"),
+		LastNameClaim:        envOr("OIDC_LAST_NAME_CLAIM", "family_name"),
+		RequireVerifiedEmail: true,
+	}
+
+	if conf.Issuer == "" {
+		return Config{}, false, nil
+	}
+
+	if conf.ClientID == "" || conf.RedirectURL == "" {
+		return Config{}, false, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC_ISSUER is")
+	}
+
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		conf.Scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+
+	if v := os.Getenv("OIDC_ALLOWED_DOMAINS"); v != "" {
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+				conf.AllowedDomains = append(conf.AllowedDomains, d)
+			}
+		}
+	}
+
+	if conf.RequireVerifiedEmail, err = envBool("OIDC_REQUIRE_VERIFIED_EMAIL", true); err != nil {
+		return Config{}, false, err
+	}
+
+	if conf.Provision, err = envBool("OIDC_PROVISION_USERS", false); err != nil {
+		return Config{}, false, err
+	}
+
+	return conf, true, nil
+}
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+func envBool(name string, fallback bool) (bool, error) {
+	v := os.Getenv(name)
+
+	if v == "" {
+		return fallback, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+
+	if err != nil {
+		return false, errors.New(name + " must be true or false")
+	}
+
+	return b, nil
+}
@@ -0,0 +1,408 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/grounded042/capacious/jwtkeys"
+)
+
+// how often the provider's keys can be fetched again when a token is signed
+// with a key we don't know
+const minKeyRefresh = time.Minute
+
+// the most of a response from the provider that is read
+const maxResponseSize = 1 << 20
+
+// ErrProvider is wrapped by errors that are the provider's fault, rather than
+// the token's or the user's.
+var ErrProvider = errors.New("oidc provider error")
+
+// Identity is who an ID token says the user is.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// discovery is the part of the discovery document we use.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to an OpenID Connect provider. Its discovery document and
+// keys are fetched when first needed and cached.
+type Provider struct {
+	conf   Config
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *discovery
+	keys        map[string]jwtkeys.JWK
+	keysFetched time.Time
+}
+
+// NewProvider builds a Provider for conf.
+func NewProvider(conf Config) *Provider {
+	return &Provider{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Config gets the config p was built with.
+func (p *Provider) Config() Config {
+	return p.conf
+}
+
+// NewSecret generates a random string for a state, nonce or PKCE code
+// verifier.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL builds the URL to send the user to so they can log in with the
+// provider. The code challenge is derived from verifier, which has to be sent
+// along with the code to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+
+	if err != nil {
+		return "", fmt.Errorf("%w: bad authorization endpoint: %v", ErrProvider, err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.conf.ClientID)
+	q.Set("redirect_uri", p.conf.RedirectURL)
+	q.Set("scope", strings.Join(p.conf.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange swaps code for an ID token, which it verifies against nonce.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Identity, error) {
+	d, err := p.getDiscovery(ctx)
+
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.conf.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	status, err := p.do(req, &tokens)
+
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if status != http.StatusOK {
+		// a code that is wrong, used or expired is the client's problem
+		if tokens.Error == "invalid_grant" {
+			return Identity{}, errors.New("the provider refused the code: " + tokens.ErrorDescription)
+		}
+
+		return Identity{}, fmt.Errorf("%w: token endpoint returned %d: %s %s", ErrProvider, status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return Identity{}, fmt.Errorf("%w: no id_token in the token response", ErrProvider)
+	}
+
+	return p.verify(ctx, d, tokens.IDToken, nonce)
+}
+
+// verify checks the signature and claims of an ID token and gets the identity
+// from it.
+func (p *Provider) verify(ctx context.Context, d *discovery, rawIDToken string, nonce string) (Identity, error) {
+	token, err := jwt.Parse(rawIDToken, func(token *jwt.Token) (interface{}, error) {
+		return p.getKey(ctx, token)
+	})
+
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	claims := token.Claims
+
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return Identity{}, fmt.Errorf("invalid id token: unexpected issuer %q", iss)
+	}
+
+	if !hasAudience(claims["aud"], p.conf.ClientID) {
+		return Identity{}, errors.New("invalid id token: not issued for this client")
+	}
+
+	if azp, ok := claims["azp"].(string); ok && azp != p.conf.ClientID {
+		return Identity{}, errors.New("invalid id token: authorized party is another client")
+	}
+
+	if _, ok := claims["exp"].(float64); !ok {
+		return Identity{}, errors.New("invalid id token: no expiry")
+	}
+
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return Identity{}, errors.New("invalid id token: nonce does not match")
+	}
+
+	id := Identity{
+		Issuer:        d.Issuer,
+		EmailVerified: isTrue(claims["email_verified"]),
+	}
+
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims[p.conf.EmailClaim].(string)
+	id.FirstName, _ = claims[p.conf.FirstNameClaim].(string)
+	id.LastName, _ = claims[p.conf.LastNameClaim].(string)
+	id.Email = strings.ToLower(strings.TrimSpace(id.Email))
+
+	if id.Subject == "" {
+		return Identity{}, errors.New("invalid id token: no subject")
+	}
+
+	return id, nil
+}
+
+// getKey gets the provider key that signed token. The keys are fetched again
+// if the token names one we don't know, in case the provider rotated them.
+func (p *Provider) getKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwtkeys.RS256, jwtkeys.ES256:
+	default:
+		return nil, fmt.Errorf("unsupported signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	jwk, ok := p.findKey(kid)
+	stale := time.Since(p.keysFetched) > minKeyRefresh
+	p.mu.Unlock()
+
+	if !ok && stale {
+		if err := p.fetchKeys(ctx); err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		jwk, ok = p.findKey(kid)
+		p.mu.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+
+	if jwk.Alg != "" && jwk.Alg != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %v", token.Header["alg"], kid)
+	}
+
+	return jwk.PublicKey()
+}
+
+// findKey finds the key with the id kid. If kid is empty and the provider only
+// has one key, that key is used. p.mu must be held.
+func (p *Provider) findKey(kid string) (jwtkeys.JWK, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, jwk := range p.keys {
+			return jwk, true
+		}
+	}
+
+	jwk, ok := p.keys[kid]
+
+	return jwk, ok
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	d, err := p.getDiscovery(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", d.JWKSURI, nil)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+
+	var jwks jwtkeys.JWKS
+
+	if status, err := p.do(req, &jwks); err != nil {
+		return err
+	} else if status != http.StatusOK {
+		return fmt.Errorf("%w: jwks endpoint returned %d", ErrProvider, status)
+	}
+
+	keys := make(map[string]jwtkeys.JWK)
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use == "" || jwk.Use == "sig" {
+			keys[jwk.Kid] = jwk
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+
+	return nil
+}
+
+// getDiscovery gets the provider's discovery document, fetching it the first
+// time.
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+
+	if d != nil {
+		return d, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.conf.Issuer+"/.well-known/openid-configuration", nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+
+	d = &discovery{}
+
+	if status, err := p.do(req, d); err != nil {
+		return nil, err
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: discovery returned %d", ErrProvider, status)
+	}
+
+	// the issuer in the document has to be the one we asked, otherwise
+	// tokens could be accepted from someone else
+	if strings.TrimSuffix(d.Issuer, "/") != p.conf.Issuer {
+		return nil, fmt.Errorf("%w: discovery document is for issuer %q", ErrProvider, d.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing endpoints", ErrProvider)
+	}
+
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+
+	return d, nil
+}
+
+// do sends req and decodes the JSON response into v. Decoding failures are
+// only reported for successful responses.
+func (p *Provider) do(req *http.Request, v interface{}) (int, error) {
+	res, err := p.client.Do(req)
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil && res.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("%w: bad response from %s: %v", ErrProvider, req.URL.Path, err)
+	}
+
+	return res.StatusCode, nil
+}
+
+// hasAudience checks whether the aud claim, a string or list of strings,
+// includes clientID.
+func hasAudience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isTrue reads a boolean claim. Some providers send them as strings.
+func isTrue(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+
+	return false
+}
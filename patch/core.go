@@ -0,0 +1,88 @@
+// Package patch applies partial updates to JSON documents, written either as
+// a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902).
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+)
+
+// the media types of the patches Parse understands
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+	// JSONType bodies are treated as merge patches, which is what a partial
+	// JSON document sent to be merged in is.
+	JSONType = "application/json"
+)
+
+var (
+	// ErrUnsupportedType is returned by Parse for media types it doesn't
+	// understand.
+	ErrUnsupportedType = errors.New("patch: unsupported media type")
+	// ErrTestFailed is returned when a JSON Patch test operation doesn't
+	// match the document.
+	ErrTestFailed = errors.New("patch: test failed")
+)
+
+// Patch is a change to a JSON document.
+type Patch interface {
+	// Apply applies the patch to doc and returns the patched document. doc
+	// is not changed.
+	Apply(doc []byte) ([]byte, error)
+}
+
+// Parse reads a patch of the media type contentType from body. Bodies without
+// a media type are taken to be JSON.
+func Parse(contentType string, body []byte) (Patch, error) {
+	if contentType == "" {
+		contentType = JSONType
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	switch mediaType {
+	case MergePatchType, JSONType:
+		var p mergePatch
+
+		if err := json.Unmarshal(body, &p.patch); err != nil {
+			return nil, fmt.Errorf("patch: invalid merge patch: %v", err)
+		}
+
+		return p, nil
+	case JSONPatchType:
+		var p jsonPatch
+
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, fmt.Errorf("patch: invalid JSON patch: %v", err)
+		}
+
+		for _, op := range p {
+			if err := op.validate(); err != nil {
+				return nil, err
+			}
+		}
+
+		return p, nil
+	}
+
+	return nil, ErrUnsupportedType
+}
+
+// decode decodes a document into the generic maps, slices and values it
+// is made of.
+func decode(doc []byte) (interface{}, error) {
+	var v interface{}
+
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, fmt.Errorf("patch: invalid document: %v", err)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,314 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPatch is a JSON Patch, a list of operations applied in order. If any
+// fails none of them are applied.
+type jsonPatch []operation
+
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (op operation) validate() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		// null is a value, only a missing one has no bytes
+		if len(op.Value) == 0 {
+			return fmt.Errorf("patch: %s at %q needs a value", op.Op, op.Path)
+		}
+	case "remove":
+	case "move", "copy":
+		if _, err := pointer(op.From); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("patch: unknown operation %q", op.Op)
+	}
+
+	_, err := pointer(op.Path)
+
+	return err
+}
+
+func (p jsonPatch) Apply(doc []byte) ([]byte, error) {
+	target, err := decode(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range p {
+		if target, err = op.apply(target); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	path, _ := pointer(op.Path)
+	from, _ := pointer(op.From)
+
+	var value interface{}
+
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+
+		if len(path) == 0 {
+			return value, nil
+		}
+
+		doc, err := remove(doc, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+	case "move":
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("patch: can't move %q into itself", op.From)
+		}
+
+		value, err := get(doc, from)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+	case "copy":
+		value, err := get(doc, from)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// the copy mustn't share maps or slices with the original
+		if value, err = clone(value); err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+	case "test":
+		actual, err := get(doc, path)
+
+		if err != nil || !reflect.DeepEqual(actual, value) {
+			return nil, ErrTestFailed
+		}
+
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("patch: unknown operation %q", op.Op)
+}
+
+// pointer splits a JSON Pointer (RFC 6901) into its unescaped tokens.
+func pointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("patch: invalid path %q", s)
+	}
+
+	tokens := strings.Split(s[1:], "/")
+
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// get gets the value at path in doc.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+
+			if !ok {
+				return nil, fmt.Errorf("patch: %q does not exist", t)
+			}
+
+			doc = v
+		case []interface{}:
+			i, err := index(t, len(d)-1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("patch: %q does not exist", t)
+		}
+	}
+
+	return doc, nil
+}
+
+// add adds value at path in doc, inserting it if the parent is an array.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	last := path[len(path)-1]
+
+	return update(doc, path[:len(path)-1], func(parent interface{}) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[last] = value
+
+			return p, nil
+		case []interface{}:
+			i := len(p)
+
+			if last != "-" {
+				var err error
+
+				if i, err = index(last, len(p)); err != nil {
+					return nil, err
+				}
+			}
+
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+
+			return p, nil
+		}
+
+		return nil, fmt.Errorf("patch: can't add %q to a value", last)
+	})
+}
+
+// remove removes the value at path in doc.
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("patch: can't remove the whole document")
+	}
+
+	last := path[len(path)-1]
+
+	return update(doc, path[:len(path)-1], func(parent interface{}) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[last]; !ok {
+				return nil, fmt.Errorf("patch: %q does not exist", last)
+			}
+
+			delete(p, last)
+
+			return p, nil
+		case []interface{}:
+			i, err := index(last, len(p)-1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return append(p[:i], p[i+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("patch: %q does not exist", last)
+	})
+}
+
+// update replaces the value at path in doc with what change makes of it.
+func update(doc interface{}, path []string, change func(interface{}) (interface{}, error)) (interface{}, error) {
+	if len(path) == 0 {
+		return change(doc)
+	}
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		v, ok := d[path[0]]
+
+		if !ok {
+			return nil, fmt.Errorf("patch: %q does not exist", path[0])
+		}
+
+		v, err := update(v, path[1:], change)
+
+		if err != nil {
+			return nil, err
+		}
+
+		d[path[0]] = v
+
+		return d, nil
+	case []interface{}:
+		i, err := index(path[0], len(d)-1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := update(d[i], path[1:], change)
+
+		if err != nil {
+			return nil, err
+		}
+
+		d[i] = v
+
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("patch: %q does not exist", path[0])
+}
+
+// index parses the array index t, which can be at most max.
+func index(t string, max int) (int, error) {
+	i, err := strconv.Atoi(t)
+
+	// leading zeros and signs aren't allowed
+	if err != nil || i < 0 || i > max || strconv.Itoa(i) != t {
+		return 0, fmt.Errorf("patch: invalid array index %q", t)
+	}
+
+	return i, nil
+}
+
+// clone makes a deep copy of v.
+func clone(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(b)
+}
@@ -0,0 +1,44 @@
+package patch
+
+import "encoding/json"
+
+// mergePatch is a JSON Merge Patch. Objects in it are merged into the
+// document, nulls remove members and anything else replaces what is there.
+type mergePatch struct {
+	patch interface{}
+}
+
+func (p mergePatch) Apply(doc []byte) ([]byte, error) {
+	target, err := decode(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, p.patch))
+}
+
+// merge is the MergePatch function of RFC 7396.
+func merge(target interface{}, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+
+	if !ok {
+		return patch
+	}
+
+	tm, ok := target.(map[string]interface{})
+
+	if !ok {
+		tm = make(map[string]interface{})
+	}
+
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = merge(tm[k], v)
+		}
+	}
+
+	return tm
+}
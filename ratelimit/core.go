@@ -0,0 +1,143 @@
+// Package ratelimit limits how often something can be done with token
+// buckets. Buckets are kept in a Store so they can be shared between
+// processes.
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limit is how quickly a bucket refills and how many tokens it can hold.
+type Limit struct {
+	// Rate is the number of tokens added to the bucket every second.
+	Rate float64
+	// Burst is the most tokens the bucket can hold, and so the most requests
+	// that can be made at once.
+	Burst int
+}
+
+// Per builds a Limit that allows n requests every d, all of which can be made
+// at once.
+func Per(n int, d time.Duration) Limit {
+	return Limit{Rate: float64(n) / d.Seconds(), Burst: n}
+}
+
+// Bucket is the state of a token bucket as it is kept in a Store.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Store keeps token buckets.
+type Store interface {
+	// Update calls fn with the bucket for key, and whether there was one, and
+	// stores the bucket it returns. Updates to the same key must not
+	// interleave.
+	Update(ctx context.Context, key string, fn func(b Bucket, found bool) Bucket) error
+}
+
+// Take takes a token from the bucket for key. If there isn't one to take, ok
+// is false and retryAfter is how long until there will be.
+func Take(ctx context.Context, s Store, key string, l Limit, now time.Time) (ok bool, retryAfter time.Duration, err error) {
+	err = s.Update(ctx, key, func(b Bucket, found bool) Bucket {
+		if !found {
+			b = Bucket{Tokens: float64(l.Burst), Updated: now}
+		}
+
+		if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+			b.Tokens = math.Min(float64(l.Burst), b.Tokens+elapsed*l.Rate)
+		}
+
+		b.Updated = now
+
+		if b.Tokens >= 1 {
+			b.Tokens--
+			ok = true
+		} else {
+			retryAfter = time.Duration((1 - b.Tokens) / l.Rate * float64(time.Second))
+		}
+
+		return b
+	})
+
+	return ok, retryAfter, err
+}
+
+// KeyFunc gets the key a request is limited by. An empty key means the
+// request isn't limited.
+type KeyFunc func(r *http.Request) string
+
+// Rule limits requests that share a key to Limit. Name keeps the keys of
+// different rules apart.
+type Rule struct {
+	Name  string
+	Key   KeyFunc
+	Limit Limit
+}
+
+// ByIP keys requests by the IP address of the client. When running behind a
+// proxy, RemoteAddr must be set from the forwarding headers before this is
+// reached.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ByJSONField keys requests by the string field name of the JSON object in the
+// request body, lower cased. The body is put back so it can be read again. If
+// it couldn't be read, reading it again fails with the same error, so a body
+// over the route's size limit still gets a 413.
+func ByJSONField(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+
+		body, err := io.ReadAll(r.Body)
+
+		if err != nil {
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+			return ""
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]interface{}
+
+		if json.Unmarshal(body, &fields) != nil {
+			return ""
+		}
+
+		// match the field the way encoding/json does when decoding into a
+		// struct
+		for k, v := range fields {
+			if s, ok := v.(string); ok && strings.EqualFold(k, name) {
+				return strings.ToLower(s)
+			}
+		}
+
+		return ""
+	}
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (er errReader) Read(p []byte) (int, error) {
+	return 0, er.err
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	limit := Per(3, time.Minute)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// the whole burst can be taken at once
+	for i := 0; i < 3; i++ {
+		if ok, _, err := Take(ctx, store, "k", limit, now); err != nil || !ok {
+			t.Fatalf("take %d: got %t, %v", i, ok, err)
+		}
+	}
+
+	// a token comes back every 20s
+	ok, retryAfter, err := Take(ctx, store, "k", limit, now)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if ok || retryAfter != 20*time.Second {
+		t.Errorf("empty bucket: got %t, retry after %s, want false and 20s", ok, retryAfter)
+	}
+
+	if ok, retryAfter, _ := Take(ctx, store, "k", limit, now.Add(15*time.Second)); ok || retryAfter != 5*time.Second {
+		t.Errorf("15s later: got %t, retry after %s, want false and 5s", ok, retryAfter)
+	}
+
+	if ok, _, _ := Take(ctx, store, "k", limit, now.Add(20*time.Second)); !ok {
+		t.Error("20s later: no token to take")
+	}
+
+	// other keys have their own buckets
+	if ok, _, _ := Take(ctx, store, "other", limit, now); !ok {
+		t.Error("another key was limited")
+	}
+
+	// a bucket doesn't fill past its burst
+	later := now.Add(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		Take(ctx, store, "k", limit, later)
+	}
+
+	if ok, _, _ := Take(ctx, store, "k", limit, later); ok {
+		t.Error("took more than the burst after an hour idle")
+	}
+}
+
+func TestByJSONField(t *testing.T) {
+	key := ByJSONField("email")
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"email": "Sam@Example.com", "password": "x"}`, "sam@example.com"},
+		// fields are matched the way encoding/json matches them
+		{`{"Email": "sam@example.com"}`, "sam@example.com"},
+		{`{"email": 1}`, ""},
+		{`{"password": "x"}`, ""},
+		{`not json`, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+
+		if got := key(r); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.body, got, tt.want)
+		}
+
+		// the body can still be read by the handler
+		if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+			t.Errorf("%s: body read again was %q", tt.body, body)
+		}
+	}
+}
+
+func TestByJSONFieldKeepsReadErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"email": "sam@example.com"}`))
+	r.Body = http.MaxBytesReader(w, r.Body, 8)
+
+	if got := ByJSONField("email")(r); got != "" {
+		t.Errorf("got %q for a body over the limit", got)
+	}
+
+	// the handler sees the body was too large, not cut off JSON
+	var tooLarge *http.MaxBytesError
+
+	if _, err := io.ReadAll(r.Body); !errors.As(err, &tooLarge) {
+		t.Errorf("reading the body again failed with %v, want an *http.MaxBytesError", err)
+	}
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// how many updates a MemoryStore makes between sweeps for idle buckets
+const sweepEvery = 1000
+
+// MemoryStore keeps buckets in memory. Buckets are not shared between
+// processes, so each one allows the full limit.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]Bucket
+	idle    time.Duration
+	updates int
+}
+
+// NewMemoryStore builds a MemoryStore that forgets buckets which haven't been
+// updated for idle. idle should be longer than any bucket takes to refill.
+func NewMemoryStore(idle time.Duration) *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]Bucket),
+		idle:    idle,
+	}
+}
+
+// Update implements Store.
+func (ms *MemoryStore) Update(ctx context.Context, key string, fn func(b Bucket, found bool) Bucket) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	b, found := ms.buckets[key]
+	b = fn(b, found)
+	ms.buckets[key] = b
+
+	ms.updates++
+
+	if ms.updates >= sweepEvery {
+		ms.updates = 0
+		ms.sweep(b.Updated)
+	}
+
+	return nil
+}
+
+// sweep forgets buckets that have been idle since before now.
+func (ms *MemoryStore) sweep(now time.Time) {
+	for key, b := range ms.buckets {
+		if now.Sub(b.Updated) > ms.idle {
+			delete(ms.buckets, key)
+		}
+	}
+}
@@ -1,6 +1,28 @@
 package routes
 
-import "github.com/grounded042/capacious/controllers"
+import (
+	"time"
+
+	"github.com/grounded042/capacious/controllers"
+	"github.com/grounded042/capacious/ratelimit"
+)
+
+// slow down password guessing, both from one client and against one account
+var loginRateLimits = []ratelimit.Rule{
+	{Name: "login_ip", Key: ratelimit.ByIP, Limit: ratelimit.Per(10, time.Minute)},
+	{Name: "login_email", Key: ratelimit.ByJSONField("email"), Limit: ratelimit.Per(5, time.Minute)},
+}
+
+// the two factor routes take codes, so slow down guessing them too
+var twoFactorRateLimits = []ratelimit.Rule{
+	{Name: "two_factor_ip", Key: ratelimit.ByIP, Limit: ratelimit.Per(10, time.Minute)},
+}
+
+// starting and finishing OIDC logins hits the provider, so don't let one
+// client do it too often
+var oidcRateLimits = []ratelimit.Rule{
+	{Name: "oidc_ip", Key: ratelimit.ByIP, Limit: ratelimit.Per(10, time.Minute)},
+}
 
 func AuthRoutes(cl controllers.List) []Route {
 	return []Route{
@@ -10,14 +32,62 @@ func AuthRoutes(cl controllers.List) []Route {
 			Handler: cl.Auth.RefreshToken,
 		},
 		Route{
-			Method:  "post",
-			Pattern: "/token",
-			Handler: cl.Auth.Login,
+			Method:        "post",
+			Pattern:       "/token",
+			Handler:       cl.Auth.Login,
+			MaxBodySize:   4 << 10,
+			RateLimits:    loginRateLimits,
+			NoIdempotency: true,
+		},
+		Route{
+			Method:        "post",
+			Pattern:       "/oidc/authorize",
+			Handler:       cl.Auth.BeginOIDCLogin,
+			RateLimits:    oidcRateLimits,
+			NoIdempotency: true,
+		},
+		Route{
+			Method:        "post",
+			Pattern:       "/oidc/token",
+			Handler:       cl.Auth.CompleteOIDCLogin,
+			MaxBodySize:   4 << 10,
+			RateLimits:    oidcRateLimits,
+			NoIdempotency: true,
 		},
 		Route{
 			Method:  "delete",
 			Pattern: "/token",
 			Handler: cl.Auth.Logout,
 		},
+		Route{
+			Method:        "post",
+			Pattern:       "/two_factor",
+			Handler:       cl.Auth.BeginTwoFactorEnrolment,
+			MaxBodySize:   4 << 10,
+			NoIdempotency: true,
+		},
+		Route{
+			Method:        "post",
+			Pattern:       "/two_factor/confirm",
+			Handler:       cl.Auth.ConfirmTwoFactor,
+			MaxBodySize:   4 << 10,
+			RateLimits:    twoFactorRateLimits,
+			NoIdempotency: true,
+		},
+		Route{
+			Method:      "delete",
+			Pattern:     "/two_factor",
+			Handler:     cl.Auth.DisableTwoFactor,
+			MaxBodySize: 4 << 10,
+			RateLimits:  twoFactorRateLimits,
+		},
+		Route{
+			Method:        "post",
+			Pattern:       "/two_factor/recovery_codes",
+			Handler:       cl.Auth.RegenerateRecoveryCodes,
+			MaxBodySize:   4 << 10,
+			RateLimits:    twoFactorRateLimits,
+			NoIdempotency: true,
+		},
 	}
 }
@@ -3,17 +3,82 @@ package routes
 import (
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/idempotency"
+	"github.com/grounded042/capacious/metrics"
+	"github.com/grounded042/capacious/middleware"
+	"github.com/grounded042/capacious/ratelimit"
 	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/mutil"
 )
 
+var (
+	httpRequests = metrics.NewCounterVec(
+		"capacious_http_requests_total",
+		"Number of HTTP requests handled, by route pattern, method and status.",
+		"route", "method", "status",
+	)
+	httpDuration = metrics.NewHistogramVec(
+		"capacious_http_request_duration_seconds",
+		"Time taken to handle HTTP requests, by route pattern and method.",
+		metrics.DefBuckets,
+		"route", "method",
+	)
+)
+
+// DefaultMaxBodySize is the most a route will read of a request body unless
+// it sets its own MaxBodySize.
+var DefaultMaxBodySize int64 = 1 << 20
+
+// DefaultTimeout is how long a route has to handle a request unless it sets
+// its own Timeout.
+var DefaultTimeout = 30 * time.Second
+
+// RateLimitStore keeps the buckets for every route's RateLimits. It must be
+// set before the routes are built.
+var RateLimitStore ratelimit.Store = ratelimit.NewMemoryStore(time.Hour)
+
+// IdempotencyStore keeps the responses to POST requests sent with an
+// Idempotency-Key. It must be set before the routes are built.
+var IdempotencyStore idempotency.Store = idempotency.NewMemoryStore()
+
+// IdempotencyTTL is how long the response to a request sent with an
+// Idempotency-Key is replayed for.
+var IdempotencyTTL = 24 * time.Hour
+
+// NoTimeout can be used as a route's Timeout for routes that need to hold on
+// to the connection, e.g. streams.
+const NoTimeout time.Duration = -1
+
 // the struct all routes need to use
 type Route struct {
 	Method      string
 	Pattern     string
 	Description string
 	Handler     web.HandlerType
+	// MaxBodySize caps the size of the request body in bytes. 0 uses
+	// DefaultMaxBodySize.
+	MaxBodySize int64
+	// Timeout is how long the route has to handle a request. 0 uses
+	// DefaultTimeout.
+	Timeout time.Duration
+	// Public routes are the ones invitees use to RSVP. They get the public
+	// CORS policy rather than the admin one.
+	Public bool
+	// RateLimits are checked, in order, before the handler is called.
+	RateLimits []ratelimit.Rule
+	// APIKeyScope is the scope an API key needs to use the route. Routes
+	// without one can't be used with API keys unless they are Public.
+	APIKeyScope apikeys.Scope
+	// NoIdempotency turns off Idempotency-Key handling for POST routes whose
+	// responses hold secrets, like tokens, that mustn't be kept.
+	NoIdempotency bool
 }
 
 // apply the prefix to each route in the routes array and add the
@@ -24,27 +89,127 @@ func BuildRoutes(wm *web.Mux, routes []Route, prefix string) {
 		err := getHandler(wm, &r)
 
 		if err != nil {
-			fmt.Println(err)
+			slog.Error("could not build route", "pattern", r.Pattern, "error", err)
+		}
+	}
+}
+
+// PublicPatterns gets the prefixed patterns of the public routes in routes.
+func PublicPatterns(routes []Route, prefix string) []string {
+	var patterns []string
+
+	for _, r := range routes {
+		if r.Public {
+			patterns = append(patterns, prefix+r.Pattern)
 		}
 	}
+
+	return patterns
 }
 
 // attach r.Handler and r.Method to the correct verb function
 func getHandler(wm *web.Mux, r *Route) error {
+	h, err := instrument(*r)
+
+	if err != nil {
+		return err
+	}
+
 	switch strings.ToLower(r.Method) {
 	case "get":
-		wm.Get(r.Pattern, r.Handler)
+		wm.Get(r.Pattern, h)
 	case "post":
-		wm.Post(r.Pattern, r.Handler)
+		wm.Post(r.Pattern, h)
 	case "put":
-		wm.Put(r.Pattern, r.Handler)
+		wm.Put(r.Pattern, h)
 	case "patch":
-		wm.Patch(r.Pattern, r.Handler)
+		wm.Patch(r.Pattern, h)
 	case "delete":
-		wm.Delete(r.Pattern, r.Handler)
+		wm.Delete(r.Pattern, h)
 	default:
 		return errors.New("unsupported method: " + r.Method)
 	}
 
 	return nil
 }
+
+// instrument wraps r.Handler so that its body size, timeout, rate limits, API
+// key scope and, for POST routes, idempotency keys are enforced and requests
+// are counted and timed against r.Pattern rather than the raw path, which
+// would give every invitee its own series.
+func instrument(r Route) (web.HandlerFunc, error) {
+	h, err := toHandler(r.Handler)
+
+	if err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(r.Method)
+
+	if method == http.MethodPost && !r.NoIdempotency {
+		h = middleware.Idempotency(IdempotencyStore, IdempotencyTTL, h)
+	}
+
+	h = middleware.RequireAPIKeyScope(r.APIKeyScope, r.Public, h)
+	h = middleware.Timeout(r.timeout(), middleware.LimitBody(r.maxBodySize(), middleware.RateLimit(RateLimitStore, r.RateLimits, h)))
+
+	return func(c web.C, w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		ww := mutil.WrapWriter(w)
+
+		// deferred so a handler that panics is counted too, as the 500 the
+		// recoverer further out turns it into
+		defer func() {
+			p := recover()
+
+			status := ww.Status()
+			if p != nil {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				// nothing was written, so net/http will send a 200
+				status = http.StatusOK
+			}
+
+			httpRequests.Inc(r.Pattern, method, strconv.Itoa(status))
+			httpDuration.Observe(time.Since(start).Seconds(), r.Pattern, method)
+
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		h.ServeHTTPC(c, ww, req)
+	}, nil
+}
+
+// toHandler turns any of the handler types goji accepts into a web.Handler.
+func toHandler(h web.HandlerType) (web.Handler, error) {
+	switch f := h.(type) {
+	case func(web.C, http.ResponseWriter, *http.Request):
+		return web.HandlerFunc(f), nil
+	case func(http.ResponseWriter, *http.Request):
+		return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) { f(w, r) }), nil
+	case web.Handler:
+		return f, nil
+	case http.Handler:
+		return web.HandlerFunc(func(c web.C, w http.ResponseWriter, r *http.Request) { f.ServeHTTP(w, r) }), nil
+	default:
+		return nil, fmt.Errorf("unsupported handler type: %T", h)
+	}
+}
+
+func (r Route) maxBodySize() int64 {
+	if r.MaxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+
+	return r.MaxBodySize
+}
+
+func (r Route) timeout() time.Duration {
+	if r.Timeout == 0 {
+		return DefaultTimeout
+	}
+
+	return r.Timeout
+}
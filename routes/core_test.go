@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grounded042/capacious/metrics"
+	"github.com/zenazn/goji/web"
+)
+
+// scraped gets the line of the scrape of every metric that starts with
+// prefix.
+func scraped(t *testing.T, prefix string) []string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	var lines []string
+
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func TestInstrumentCountsByRoutePattern(t *testing.T) {
+	h, err := instrument(Route{
+		Method:  "get",
+		Pattern: "/test/invitees/:invitee_id",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "missing") {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/test/invitees/1", "/test/invitees/2", "/test/invitees/missing"} {
+		h.ServeHTTPC(web.C{}, httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	requests := scraped(t, `capacious_http_requests_total{route="/test/invitees/:invitee_id"`)
+	want := []string{
+		`capacious_http_requests_total{route="/test/invitees/:invitee_id",method="GET",status="200"} 2`,
+		`capacious_http_requests_total{route="/test/invitees/:invitee_id",method="GET",status="404"} 1`,
+	}
+
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the raw paths don't get series of their own
+	if raw := scraped(t, `capacious_http_requests_total{route="/test/invitees/1"`); len(raw) != 0 {
+		t.Errorf("raw path counted: %v", raw)
+	}
+
+	count := scraped(t, `capacious_http_request_duration_seconds_count{route="/test/invitees/:invitee_id",method="GET"}`)
+
+	if len(count) != 1 || !strings.HasSuffix(count[0], " 3") {
+		t.Errorf("duration count = %v, want 3", count)
+	}
+}
+
+func TestInstrumentCountsPanicsAs500s(t *testing.T) {
+	h, err := instrument(Route{
+		Method:  "get",
+		Pattern: "/test/panics",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			panic("oops")
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		// the panic is left for the recoverer further out
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic didn't get past instrument")
+			}
+		}()
+
+		h.ServeHTTPC(web.C{}, httptest.NewRecorder(), httptest.NewRequest("GET", "/test/panics", nil))
+	}()
+
+	requests := scraped(t, `capacious_http_requests_total{route="/test/panics"`)
+	want := `capacious_http_requests_total{route="/test/panics",method="GET",status="500"} 1`
+
+	if len(requests) != 1 || requests[0] != want {
+		t.Errorf("requests = %v, want %s", requests, want)
+	}
+
+	count := scraped(t, `capacious_http_request_duration_seconds_count{route="/test/panics",method="GET"}`)
+
+	if len(count) != 1 || !strings.HasSuffix(count[0], " 1") {
+		t.Errorf("duration count = %v, want 1", count)
+	}
+}
@@ -1,6 +1,9 @@
 package routes
 
-import "github.com/grounded042/capacious/controllers"
+import (
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/controllers"
+)
 
 func EventRoutes(cl controllers.List) []Route {
 	return []Route{
@@ -18,31 +21,132 @@ func EventRoutes(cl controllers.List) []Route {
 			Method:  "get",
 			Pattern: "/events/:id",
 			Handler: cl.Events.GetEventInfo,
+			Public:  true,
 		},
 		Route{
 			Method:  "get",
-			Pattern: "/events/:id/relationships/invitees",
-			Handler: cl.Invitees.GetInviteesForEvent,
+			Pattern: "/events/:id/calendar.ics",
+			Handler: cl.Calendar.GetEventCalendar,
+			Public:  true,
+		},
+		Route{
+			Method:      "get",
+			Pattern:     "/events/:id/relationships/invitees",
+			Handler:     cl.Invitees.GetInviteesForEvent,
+			APIKeyScope: apikeys.ReadInvitees,
+		},
+		Route{
+			Method:      "post",
+			Pattern:     "/events/:id/relationships/invitees",
+			Handler:     cl.Invitees.CreateInviteeForEvent,
+			APIKeyScope: apikeys.WriteInvitees,
+		},
+		Route{
+			Method:      "get",
+			Pattern:     "/events/:id/relationships/stats",
+			Handler:     cl.Events.GetEventStats,
+			APIKeyScope: apikeys.ReadReports,
+		},
+		Route{
+			Method:      "get",
+			Pattern:     "/events/:id/stream",
+			Handler:     cl.Stream.StreamEvent,
+			APIKeyScope: apikeys.ReadReports,
+			Timeout:     NoTimeout,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/events/:id/relationships/audit",
+			Handler: cl.Events.GetAuditLog,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/events/:id/relationships/api_keys",
+			Handler: cl.APIKeys.GetAPIKeysForEvent,
+		},
+		Route{
+			Method:        "post",
+			Pattern:       "/events/:id/relationships/api_keys",
+			Handler:       cl.APIKeys.CreateAPIKey,
+			MaxBodySize:   4 << 10,
+			NoIdempotency: true,
+		},
+		Route{
+			Method:  "delete",
+			Pattern: "/events/:id/relationships/api_keys/:api_key_id",
+			Handler: cl.APIKeys.RevokeAPIKey,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/events/:id/relationships/email_templates",
+			Handler: cl.Emails.GetEmailTemplates,
+		},
+		Route{
+			Method:      "put",
+			Pattern:     "/events/:id/relationships/email_templates/:kind",
+			Handler:     cl.Emails.SaveEmailTemplate,
+			MaxBodySize: 64 << 10,
+		},
+		Route{
+			Method:  "delete",
+			Pattern: "/events/:id/relationships/email_templates/:kind",
+			Handler: cl.Emails.ResetEmailTemplate,
 		},
-		// Route{
-		// 	Method:  "post",
-		// 	Pattern: "/events/:id/relationships/invitees",
-		// 	Handler: cl.Invitees.CreateInviteeForEvent,
-		// },
 		Route{
 			Method:  "get",
-			Pattern: "/events/:id/relationships/stats",
-			Handler: cl.Events.GetEventStats,
+			Pattern: "/events/:id/relationships/emails",
+			Handler: cl.Emails.GetEmailDeliveries,
+		},
+		Route{
+			Method:      "post",
+			Pattern:     "/events/:id/relationships/emails",
+			Handler:     cl.Emails.SendEmails,
+			MaxBodySize: 4 << 10,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/events/:id/relationships/webhooks",
+			Handler: cl.Webhooks.GetWebhooks,
+		},
+		Route{
+			Method:        "post",
+			Pattern:       "/events/:id/relationships/webhooks",
+			Handler:       cl.Webhooks.CreateWebhook,
+			MaxBodySize:   4 << 10,
+			NoIdempotency: true,
+		},
+		Route{
+			Method:  "delete",
+			Pattern: "/events/:id/relationships/webhooks/:webhook_id",
+			Handler: cl.Webhooks.DeleteWebhook,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/events/:id/relationships/webhooks/:webhook_id/deliveries",
+			Handler: cl.Webhooks.GetWebhookDeliveries,
+		},
+		Route{
+			Method:  "post",
+			Pattern: "/events/:id/relationships/webhooks/:webhook_id/deliveries/:delivery_id/redeliver",
+			Handler: cl.Webhooks.RedeliverWebhook,
+		},
+		Route{
+			Method:      "post",
+			Pattern:     "/events/:id/relationships/require_two_factor",
+			Handler:     cl.Events.SetRequireTwoFactor,
+			MaxBodySize: 4 << 10,
 		},
 		Route{
 			Method:  "get",
 			Pattern: "/events/:id/relationships/menu_items",
 			Handler: cl.Events.GetMenuItemsForEvent,
+			Public:  true,
 		},
 		Route{
 			Method:  "get",
 			Pattern: "/events/:id/relationships/seating_request_choices",
 			Handler: cl.Events.GetListOfSeatingRequestChoices,
+			Public:  true,
 		},
 	}
 }
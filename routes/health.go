@@ -0,0 +1,25 @@
+package routes
+
+import "github.com/grounded042/capacious/controllers"
+
+// HealthRoutes are mounted outside of the api prefix and skip the api
+// middleware so load balancers can reach them without a token.
+func HealthRoutes(cl controllers.List) []Route {
+	return []Route{
+		Route{
+			Method:  "get",
+			Pattern: "/healthz",
+			Handler: cl.Health.Healthz,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/readyz",
+			Handler: cl.Health.Readyz,
+		},
+		Route{
+			Method:  "get",
+			Pattern: "/version",
+			Handler: cl.Health.Version,
+		},
+	}
+}
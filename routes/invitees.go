@@ -1,55 +1,116 @@
 package routes
 
-import "github.com/grounded042/capacious/controllers"
+import (
+	"time"
+
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/controllers"
+	"github.com/grounded042/capacious/ratelimit"
+)
+
+// invitees can reach these routes without a token, so only accept small bodies
+const inviteeMaxBodySize = 64 << 10
+
+// invitee ids are all that protects an invitee's details, so make them
+// expensive to guess
+var inviteeLookupRateLimits = []ratelimit.Rule{
+	{Name: "invitee_lookup_ip", Key: ratelimit.ByIP, Limit: ratelimit.Per(30, time.Minute)},
+}
 
 func InviteeRoutes(cl controllers.List) []Route {
 	return []Route{
 		Route{
-			Method:  "get",
-			Pattern: "/invitees/:id",
-			Handler: cl.Invitees.GetInvitee,
+			Method:     "get",
+			Pattern:    "/invitees/:id",
+			Handler:    cl.Invitees.GetInvitee,
+			Public:     true,
+			RateLimits: inviteeLookupRateLimits,
+		},
+		Route{
+			Method:     "get",
+			Pattern:    "/invitees/:id/calendar.ics",
+			Handler:    cl.Calendar.GetInviteeCalendar,
+			Public:     true,
+			RateLimits: inviteeLookupRateLimits,
+		},
+		Route{
+			Method:      "patch",
+			Pattern:     "/invitees/:id",
+			Handler:     cl.Invitees.EditInvitee,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
+		},
+		Route{
+			Method:      "patch",
+			Pattern:     "/invitees/:invitee_id/relationships/friends/:friend_id",
+			Handler:     cl.Invitees.EditInviteeFriend,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
+		},
+		Route{
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/friends",
+			Handler:     cl.Invitees.CreateInviteeFriend,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
 		},
 		Route{
-			Method:  "patch",
-			Pattern: "/invitees/:id",
-			Handler: cl.Invitees.EditInvitee,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/menu_choices",
+			Handler:     cl.Invitees.SetInviteeMenuChoices,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
 		},
 		Route{
-			Method:  "patch",
-			Pattern: "/invitees/:invitee_id/relationships/friends/:friend_id",
-			Handler: cl.Invitees.EditInviteeFriend,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/menu_note",
+			Handler:     cl.Invitees.SetInviteeMenuNote,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
 		},
 		Route{
-			Method:  "post",
-			Pattern: "/invitees/:invitee_id/relationships/friends",
-			Handler: cl.Invitees.CreateInviteeFriend,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/seating_requests",
+			Handler:     cl.Invitees.SetInviteeSeatingRequests,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
 		},
 		Route{
-			Method:  "post",
-			Pattern: "/invitees/:invitee_id/relationships/menu_choices",
-			Handler: cl.Invitees.SetInviteeMenuChoices,
+			Method:     "get",
+			Pattern:    "/invitees/:invitee_id/relationships/history",
+			Handler:    cl.Invitees.GetInviteeHistory,
+			Public:     true,
+			RateLimits: inviteeLookupRateLimits,
 		},
 		Route{
-			Method:  "post",
-			Pattern: "/invitees/:invitee_id/relationships/menu_note",
-			Handler: cl.Invitees.SetInviteeMenuNote,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/restore",
+			Handler:     cl.Invitees.RestoreInvitee,
+			MaxBodySize: 4 << 10,
+			APIKeyScope: apikeys.WriteInvitees,
 		},
 		Route{
-			Method:  "post",
-			Pattern: "/invitees/:invitee_id/relationships/seating_requests",
-			Handler: cl.Invitees.SetInviteeSeatingRequests,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/tags",
+			Handler:     cl.Invitees.SetInviteeTags,
+			MaxBodySize: 4 << 10,
+			APIKeyScope: apikeys.WriteInvitees,
 		},
 		// TODO: this might need to be moved into a better controller
 		// maybe a guest controller
 		Route{
-			Method:  "post",
-			Pattern: "/invitees/:invitee_id/relationships/friends/:friend_id/relationships/menu_choices",
-			Handler: cl.Invitees.SetGuestMenuChoices,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/friends/:friend_id/relationships/menu_choices",
+			Handler:     cl.Invitees.SetGuestMenuChoices,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
 		},
 		Route{
-			Method:  "post",
-			Pattern: "/invitees/:invitee_id/relationships/friends/:friend_id/relationships/menu_note",
-			Handler: cl.Invitees.SetInviteeFriendMenuNote,
+			Method:      "post",
+			Pattern:     "/invitees/:invitee_id/relationships/friends/:friend_id/relationships/menu_note",
+			Handler:     cl.Invitees.SetInviteeFriendMenuNote,
+			MaxBodySize: inviteeMaxBodySize,
+			Public:      true,
 		},
 	}
 }
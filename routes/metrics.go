@@ -0,0 +1,15 @@
+package routes
+
+import "github.com/grounded042/capacious/metrics"
+
+// MetricsRoutes are mounted outside of the api prefix and skip the api
+// middleware so they can be scraped without a token.
+func MetricsRoutes() []Route {
+	return []Route{
+		Route{
+			Method:  "get",
+			Pattern: "/metrics",
+			Handler: metrics.Handler(),
+		},
+	}
+}
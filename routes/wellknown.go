@@ -0,0 +1,15 @@
+package routes
+
+import "github.com/grounded042/capacious/controllers"
+
+// WellKnownRoutes are mounted outside of the api prefix, at the paths other
+// services expect to find them.
+func WellKnownRoutes(cl controllers.List) []Route {
+	return []Route{
+		Route{
+			Method:  "get",
+			Pattern: "/.well-known/jwks.json",
+			Handler: cl.Auth.JWKS,
+		},
+	}
+}
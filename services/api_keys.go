@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/utils"
+)
+
+// last used times are only written this often, so busy keys don't write on
+// every request
+const apiKeyTouchInterval = time.Minute
+
+type apiKeysGateway interface {
+	// CreateAPIKey saves a new API key
+	CreateAPIKey(context.Context, *entities.APIKey) error
+	// GetAPIKeysForEvent gets the API keys of the event with the passed in id
+	GetAPIKeysForEvent(context.Context, string) ([]entities.APIKey, error)
+	// GetAPIKeyFromKeyID gets the API key with the passed in key id
+	GetAPIKeyFromKeyID(context.Context, string) (entities.APIKey, error)
+	// RevokeAPIKey revokes an API key of an event
+	RevokeAPIKey(ctx context.Context, eventID string, apiKeyID string) error
+	// TouchAPIKey sets when an API key was last used
+	TouchAPIKey(context.Context, string, time.Time) error
+}
+
+type apiKeysService struct {
+	da apiKeysGateway
+}
+
+// NewAPIKey is what an API key is created from.
+type NewAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreatedAPIKey is a newly created API key along with the key itself, which is
+// only ever shown this once.
+type CreatedAPIKey struct {
+	entities.APIKey
+	Key string `json:"key"`
+}
+
+func newAPIKeysService(newDa apiKeysGateway) apiKeysService {
+	return apiKeysService{
+		da: newDa,
+	}
+}
+
+// CreateAPIKey creates a key for the event with the id eventID that acts for
+// the user with the id userID.
+func (aks apiKeysService) CreateAPIKey(ctx context.Context, eventID string, userID string, newKey NewAPIKey) (CreatedAPIKey, utils.Error) {
+	name := strings.TrimSpace(newKey.Name)
+
+	if name == "" || len(name) > 255 {
+		return CreatedAPIKey{}, utils.NewApiError(400, "An API key needs a name of up to 255 characters.")
+	}
+
+	if len(newKey.Scopes) == 0 {
+		return CreatedAPIKey{}, utils.NewApiError(400, "An API key needs at least one scope.")
+	}
+
+	var scopes entities.StringList
+
+	for _, s := range newKey.Scopes {
+		if !apikeys.Scope(s).Valid() {
+			return CreatedAPIKey{}, utils.NewApiError(400, "Unknown scope: "+s)
+		}
+
+		if !contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	key, keyID, hash, err := apikeys.Generate()
+
+	if err != nil {
+		return CreatedAPIKey{}, utils.NewApiError(500, err.Error())
+	}
+
+	created := CreatedAPIKey{
+		APIKey: entities.APIKey{
+			FkEventID: eventID,
+			FkUserID:  userID,
+			Name:      name,
+			KeyID:     keyID,
+			KeyHash:   hash,
+			Scopes:    scopes,
+		},
+		Key: key,
+	}
+
+	if err := aks.da.CreateAPIKey(ctx, &created.APIKey); err != nil {
+		return CreatedAPIKey{}, utils.NewApiError(500, err.Error())
+	}
+
+	return created, nil
+}
+
+// GetAPIKeysForEvent gets the API keys of the event with the id eventID.
+func (aks apiKeysService) GetAPIKeysForEvent(ctx context.Context, eventID string) ([]entities.APIKey, utils.Error) {
+	keys, err := aks.da.GetAPIKeysForEvent(ctx, eventID)
+
+	if err != nil {
+		return []entities.APIKey{}, utils.NewApiError(500, err.Error())
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes the API key with the id apiKeyID of the event with the
+// id eventID. It can't be used again.
+func (aks apiKeysService) RevokeAPIKey(ctx context.Context, eventID string, apiKeyID string) utils.Error {
+	err := aks.da.RevokeAPIKey(ctx, eventID, apiKeyID)
+
+	if err != nil && err.Error() == "record not found" {
+		return utils.NewApiError(404, "Could not find an API key to revoke.")
+	} else if err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
+
+// Authenticate checks key and gets who requests made with it act as.
+func (aks apiKeysService) Authenticate(ctx context.Context, key string) (apikeys.Principal, utils.Error) {
+	keyID, ok := apikeys.ID(key)
+
+	if !ok {
+		return apikeys.Principal{}, utils.NewApiError(401, "Malformed API key.")
+	}
+
+	dbKey, err := aks.da.GetAPIKeyFromKeyID(ctx, keyID)
+
+	if err != nil && err.Error() == "record not found" {
+		return apikeys.Principal{}, utils.NewApiError(401, "Unknown API key.")
+	} else if err != nil {
+		return apikeys.Principal{}, utils.NewApiError(500, err.Error())
+	}
+
+	if !apikeys.Matches(key, dbKey.KeyHash) {
+		return apikeys.Principal{}, utils.NewApiError(401, "Unknown API key.")
+	}
+
+	if dbKey.RevokedAt != nil {
+		return apikeys.Principal{}, utils.NewApiError(401, "The API key has been revoked.")
+	}
+
+	now := time.Now()
+
+	if dbKey.LastUsedAt == nil || now.Sub(*dbKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err := aks.da.TouchAPIKey(ctx, dbKey.APIKeyID, now); err != nil {
+			return apikeys.Principal{}, utils.NewApiError(500, err.Error())
+		}
+	}
+
+	p := apikeys.Principal{
+		KeyID:   dbKey.APIKeyID,
+		UserID:  dbKey.FkUserID,
+		EventID: dbKey.FkEventID,
+	}
+
+	for _, s := range dbKey.Scopes {
+		p.Scopes = append(p.Scopes, apikeys.Scope(s))
+	}
+
+	return p, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/grounded042/capacious/audit"
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/utils"
+)
+
+// the kinds of things audit entries are about
+const (
+	auditEvent           = "event"
+	auditInvitee         = "invitee"
+	auditInviteeFriend   = "invitee_friend"
+	auditMenuChoices     = "menu_choices"
+	auditMenuNote        = "menu_note"
+	auditSeatingRequests = "seating_requests"
+	auditInviteeTags     = "invitee_tags"
+)
+
+// what was done to them
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditRestore = "restore"
+)
+
+type auditGateway interface {
+	// CreateAuditEntry appends an entry to the audit log
+	CreateAuditEntry(context.Context, *entities.AuditEntry) error
+	// GetAuditEntriesForEvent gets a page of the audit entries of an event
+	// that match a filter
+	GetAuditEntriesForEvent(ctx context.Context, eventID string, filter entities.AuditFilter, page entities.Page) ([]entities.AuditEntry, entities.PageBounds, error)
+	// GetNumberOfAuditEntriesForEvent counts the audit entries of an event
+	// that match a filter
+	GetNumberOfAuditEntriesForEvent(ctx context.Context, eventID string, filter entities.AuditFilter) (int, error)
+}
+
+type auditService struct {
+	da auditGateway
+}
+
+// auditChange describes a change to record. Before is nil for things that
+// were created.
+type auditChange struct {
+	EventID    string
+	EntityType string
+	EntityID   string
+	InviteeID  string
+	GuestID    string
+	Action     string
+	Before     interface{}
+	After      interface{}
+}
+
+func newAuditService(newDa auditGateway) auditService {
+	return auditService{
+		da: newDa,
+	}
+}
+
+// Record appends change to the audit log. It is recorded in the transaction
+// ctx carries, the one the change is made in, so a change that can't be
+// recorded isn't made.
+func (as auditService) Record(ctx context.Context, change auditChange) utils.Error {
+	if err := as.record(ctx, change); err != nil {
+		logging.FromContext(ctx).Error("could not record audit entry", "entity_type", change.EntityType, "entity_id", change.EntityID, "error", err)
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
+
+func (as auditService) record(ctx context.Context, change auditChange) error {
+	actor, ok := audit.FromContext(ctx)
+
+	// invitees don't log in, the id they were sent is their credential
+	if !ok && change.InviteeID != "" {
+		actor = audit.Actor{Type: audit.ActorInvitee, ID: change.InviteeID}
+	}
+
+	diff, err := audit.Diff(change.Before, change.After)
+
+	if err != nil {
+		return err
+	}
+
+	entry := entities.AuditEntry{
+		FkEventID:  change.EventID,
+		ActorType:  string(actor.Type),
+		ActorID:    actor.ID,
+		EntityType: change.EntityType,
+		EntityID:   change.EntityID,
+		InviteeID:  change.InviteeID,
+		GuestID:    change.GuestID,
+		Action:     change.Action,
+		RequestID:  logging.RequestID(ctx),
+	}
+
+	if entry.Before, err = marshalAudited(change.Before); err != nil {
+		return err
+	}
+
+	if entry.After, err = marshalAudited(change.After); err != nil {
+		return err
+	}
+
+	if entry.Diff, err = json.Marshal(diff); err != nil {
+		return err
+	}
+
+	return as.da.CreateAuditEntry(ctx, &entry)
+}
+
+// GetAuditLog gets a page of the audit entries of the event with the id
+// eventID that match filter.
+func (as auditService) GetAuditLog(ctx context.Context, eventID string, filter entities.AuditFilter, p *PaginationService) ([]entities.AuditEntry, utils.Error) {
+	count, err := as.da.GetNumberOfAuditEntriesForEvent(ctx, eventID, filter)
+
+	if err != nil {
+		return []entities.AuditEntry{}, utils.NewApiError(500, err.Error())
+	}
+
+	p.SetNumItems(count)
+
+	entries, bounds, err := as.da.GetAuditEntriesForEvent(ctx, eventID, filter, p.GetPage())
+
+	if err != nil {
+		return []entities.AuditEntry{}, pageError(err)
+	}
+
+	p.SetBounds(bounds)
+
+	return entries, nil
+}
+
+// marshalAudited gets the JSON of v, or nothing if v is nil.
+func marshalAudited(v interface{}) (entities.JSON, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(v)
+}
@@ -1,67 +1,249 @@
 package services
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
-	"os"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jwtkeys"
+	"github.com/grounded042/capacious/oidc"
 	"github.com/grounded042/capacious/utils"
 )
 
 // LoginUser is used to represent login details for a user. It's an object to
 // hold user data for auth.
+// Challenge and Code are used for the second step of a login with two factor
+// authentication.
 type LoginUser struct {
-	UserID   string
-	Email    string
-	Password string
-	Token    string
+	UserID    string
+	Email     string
+	Password  string
+	Token     string
+	Challenge string
+	Code      string
 }
 
+// LoginResult is the outcome of a successful login step. Either Token is set,
+// or Challenge is when a second factor is needed.
+type LoginResult struct {
+	Token     string
+	Challenge string
+}
+
+// TokenLifetime is how long a token is valid for after it is generated.
+const TokenLifetime = 72 * time.Hour
+
+// GetJWKS gets the public keys tokens can be verified with
+func (as authService) GetJWKS() jwtkeys.JWKS {
+	return as.keys.JWKS()
+}
+
+// after maxFailedLogins failed logins in a row a login is locked for
+// lockoutBase, doubling with each further failure up to lockoutMax
+const (
+	maxFailedLogins = 5
+	lockoutBase     = time.Minute
+	lockoutMax      = 24 * time.Hour
+)
+
 type authGateway interface {
 	// GetUserLoginFromEmail gets a user login object from the db that has the
 	// passed in email address
-	GetUserLoginFromEmail(string) (entities.UserLogin, error)
+	GetUserLoginFromEmail(context.Context, string) (entities.UserLogin, error)
+	// RecordFailedLogin adds one to the failed logins of a user login and
+	// returns the new count
+	RecordFailedLogin(context.Context, string) (int, error)
+	// LockUserLogin stops a user login being used until the passed in time
+	LockUserLogin(context.Context, string, time.Time) error
+	// ResetFailedLogins clears the failed logins and lock of a user login
+	ResetFailedLogins(context.Context, string) error
+	// GetUserFromID gets the user with the passed in id
+	GetUserFromID(context.Context, string) (entities.User, error)
+	// GetUserLoginFromUserID gets the user login of the user with the passed
+	// in id
+	GetUserLoginFromUserID(context.Context, string) (entities.UserLogin, error)
+	// EnsureUserLogin gets the user login of the user with the passed in id,
+	// creating one without a password if they don't have one
+	EnsureUserLogin(context.Context, string) (entities.UserLogin, error)
+	// GetUserLoginFromID gets the user login with the passed in id
+	GetUserLoginFromID(context.Context, string) (entities.UserLogin, error)
+	// SetTotpSecret sets the encrypted TOTP secret of a user login
+	SetTotpSecret(context.Context, string, string) error
+	// EnableTotp enables TOTP for a user login, marking the passed in step as
+	// used, and replaces the user's recovery codes with the passed in hashes
+	EnableTotp(context.Context, entities.UserLogin, int64, []string) error
+	// DisableTotp disables TOTP for a user login and deletes the user's
+	// recovery codes
+	DisableTotp(context.Context, entities.UserLogin) error
+	// UseTotpStep marks a TOTP time step as used by a user login, returning
+	// false if it, or a later one, already has been
+	UseTotpStep(context.Context, string, int64) (bool, error)
+	// ReplaceRecoveryCodes replaces a user's recovery codes with the passed
+	// in hashes
+	ReplaceRecoveryCodes(context.Context, string, []string) error
+	// UseRecoveryCode marks an unused recovery code of a user as used,
+	// returning false if there is no such code
+	UseRecoveryCode(context.Context, string, string) (bool, error)
+	// CreateLoginChallenge creates a login challenge, deleting any others for
+	// the same login
+	CreateLoginChallenge(context.Context, *entities.LoginChallenge) error
+	// GetLoginChallenge gets the login challenge with the passed in id
+	GetLoginChallenge(context.Context, string) (entities.LoginChallenge, error)
+	// RecordFailedChallenge adds one to the attempts of a login challenge
+	// and returns the new count
+	RecordFailedChallenge(context.Context, string) (int, error)
+	// DeleteLoginChallenge deletes the login challenge with the passed in id
+	DeleteLoginChallenge(context.Context, string) error
+	// CreateOidcLoginState saves a login that has been sent to the OIDC
+	// provider
+	CreateOidcLoginState(context.Context, *entities.OidcLoginState) error
+	// TakeOidcLoginState gets and deletes the login state with the passed in
+	// state
+	TakeOidcLoginState(context.Context, string) (entities.OidcLoginState, error)
+	// GetUserIdentity gets the identity with the passed in issuer and subject
+	GetUserIdentity(context.Context, string, string) (entities.UserIdentity, error)
+	// CreateUserIdentity links a user to their account with an OIDC provider
+	CreateUserIdentity(context.Context, *entities.UserIdentity) error
+	// GetUserFromEmail gets the user with the passed in email address
+	GetUserFromEmail(context.Context, string) (entities.User, error)
+	// CreateUserWithIdentity creates a user and links them to their account
+	// with an OIDC provider
+	CreateUserWithIdentity(context.Context, *entities.User, *entities.UserIdentity) error
 }
 
+// oidc is nil when OIDC login isn't configured
 type authService struct {
-	da authGateway
+	da   authGateway
+	keys *jwtkeys.Ring
+	oidc *oidc.Provider
 }
 
-func newAuthService(newDa authGateway) authService {
+func newAuthService(newDa authGateway, newKeys *jwtkeys.Ring, newOIDC *oidc.Provider) authService {
 	return authService{
-		da: newDa,
+		da:   newDa,
+		keys: newKeys,
+		oidc: newOIDC,
 	}
 }
 
-// Login will authenticate login credentials from the lUser object
-func (as authService) Login(lUser LoginUser) (string, utils.Error) {
+// Login will authenticate login credentials from the lUser object. If the user
+// has two factor authentication enabled, a challenge is returned in place of a
+// token, which has to be sent back with a code in a second call.
+func (as authService) Login(ctx context.Context, lUser LoginUser) (LoginResult, utils.Error) {
+	if lUser.Challenge != "" {
+		return as.answerChallenge(ctx, lUser)
+	}
+
 	lUser.Email = strings.ToLower(lUser.Email)
 
 	// get the userlogin object based on the email
-	dbUser, err := as.da.GetUserLoginFromEmail(lUser.Email)
-	if err != nil {
-		return "", utils.NewApiError(401, "Could not find user.")
+	// users who only log in with an OIDC provider have a login without a
+	// password, to keep their TOTP state in
+	dbUser, err := as.da.GetUserLoginFromEmail(ctx, lUser.Email)
+	if err != nil || dbUser.Password == "" {
+		logins.Inc("failure")
+		return LoginResult{}, utils.NewApiError(401, "Could not find user.")
+	}
+
+	// don't even check the password of a locked login, otherwise guessing
+	// could carry on through the lock
+	now := time.Now()
+	if uErr := checkLocked(dbUser, now); uErr != nil {
+		return LoginResult{}, uErr
 	}
 
 	// see if the user login creds are valid
 	success := as.authenticate(lUser, dbUser)
 	if !success {
-		return "", utils.NewApiError(401, "Authentication failed.")
+		logins.Inc("failure")
+		return LoginResult{}, as.recordFailedLogin(ctx, dbUser, now)
+	}
+
+	// failed logins aren't reset until the second factor is passed too, so
+	// knowing the password doesn't allow unlimited guesses at codes
+	if dbUser.TotpEnabled {
+		challenge, uErr := as.createChallenge(ctx, dbUser, now)
+		if uErr != nil {
+			return LoginResult{}, uErr
+		}
+
+		logins.Inc("challenge")
+
+		return LoginResult{Challenge: challenge}, nil
+	}
+
+	return as.completeLogin(ctx, dbUser)
+}
+
+// completeLogin clears dbUser's failed logins and generates them a token.
+func (as authService) completeLogin(ctx context.Context, dbUser entities.UserLogin) (LoginResult, utils.Error) {
+	if dbUser.FailedLogins > 0 || dbUser.LockedUntil != nil {
+		if err := as.da.ResetFailedLogins(ctx, dbUser.UserLoginID); err != nil {
+			return LoginResult{}, utils.NewApiError(500, err.Error())
+		}
 	}
 
 	// generate a token
 	token, err := as.GenerateToken(dbUser.FkUserID)
 	if err != nil {
-		return "", utils.NewApiError(500, err.Error())
+		return LoginResult{}, utils.NewApiError(500, err.Error())
+	}
+
+	logins.Inc("success")
+
+	return LoginResult{Token: token}, nil
+}
+
+// checkLocked returns an error saying when dbUser can try again if it is
+// locked.
+func checkLocked(dbUser entities.UserLogin, now time.Time) utils.Error {
+	if dbUser.LockedUntil != nil && now.Before(*dbUser.LockedUntil) {
+		logins.Inc("locked")
+		return utils.NewRetryAfterError(429, "Too many failed logins.", dbUser.LockedUntil.Sub(now))
+	}
+
+	return nil
+}
+
+// recordFailedLogin counts a failed login against dbUser and locks it if there
+// have been too many. It returns the error to send the client.
+func (as authService) recordFailedLogin(ctx context.Context, dbUser entities.UserLogin, now time.Time) utils.Error {
+	failures, err := as.da.RecordFailedLogin(ctx, dbUser.UserLoginID)
+	if err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	if failures < maxFailedLogins {
+		return utils.NewApiError(401, "Authentication failed.")
+	}
+
+	lockout := lockoutFor(failures)
+	if err := as.da.LockUserLogin(ctx, dbUser.UserLoginID, now.Add(lockout)); err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return utils.NewRetryAfterError(429, "Too many failed logins.", lockout)
+}
+
+// lockoutFor gets how long a login is locked for after failures failed logins
+// in a row.
+func lockoutFor(failures int) time.Duration {
+	lockout := lockoutBase
+
+	for i := maxFailedLogins; i < failures && lockout < lockoutMax; i++ {
+		lockout *= 2
+	}
+
+	if lockout > lockoutMax {
+		return lockoutMax
 	}
 
-	return token, nil
+	return lockout
 }
 
 // authenticate will hash and then compare the password from the authUser with
@@ -80,11 +262,11 @@ func (as authService) hashPasswordWithSalt(password string, salt string) string
 
 // GenerateToken will generate a new token for the provided user id
 func (as authService) GenerateToken(userID string) (string, utils.Error) {
-	token := jwt.New(jwt.SigningMethodHS512)
-	token.Claims["exp"] = time.Now().Add(time.Hour * time.Duration(72)).Unix()
-	token.Claims["iat"] = time.Now().Unix()
-	token.Claims["sub"] = userID
-	tokenString, err := token.SignedString([]byte(os.Getenv("GO_JWT_MIDDLEWARE_KEY")))
+	tokenString, err := as.keys.Sign(map[string]interface{}{
+		"exp": time.Now().Add(TokenLifetime).Unix(),
+		"iat": time.Now().Unix(),
+		"sub": userID,
+	})
 	if err != nil {
 		return "", utils.NewApiError(500, err.Error())
 	}
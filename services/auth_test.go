@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jwtkeys"
+	"github.com/grounded042/capacious/utils"
+)
+
+// fakeLoginGateway keeps one user login and its failed logins.
+type fakeLoginGateway struct {
+	authGateway
+	login entities.UserLogin
+}
+
+func (f *fakeLoginGateway) GetUserLoginFromEmail(ctx context.Context, email string) (entities.UserLogin, error) {
+	return f.login, nil
+}
+
+func (f *fakeLoginGateway) RecordFailedLogin(ctx context.Context, userLoginID string) (int, error) {
+	f.login.FailedLogins++
+	return f.login.FailedLogins, nil
+}
+
+func (f *fakeLoginGateway) LockUserLogin(ctx context.Context, userLoginID string, until time.Time) error {
+	f.login.LockedUntil = &until
+	return nil
+}
+
+func (f *fakeLoginGateway) ResetFailedLogins(ctx context.Context, userLoginID string) error {
+	f.login.FailedLogins, f.login.LockedUntil = 0, nil
+	return nil
+}
+
+// unlock moves the lock on the login into the past, as if it had run out.
+func (f *fakeLoginGateway) unlock() {
+	past := time.Now().Add(-time.Second)
+	f.login.LockedUntil = &past
+}
+
+func retryAfter(err utils.Error) time.Duration {
+	if ra, ok := err.(retryAfterer); ok {
+		return ra.RetryAfter()
+	}
+
+	return 0
+}
+
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+func TestLockoutFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{10, 32 * time.Minute},
+		{15, 1024 * time.Minute},
+		{16, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := lockoutFor(tt.failures); got != tt.want {
+			t.Errorf("lockoutFor(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLocksAfterFailedLogins(t *testing.T) {
+	as := newAuthService(nil, jwtkeys.NewHMACRing([]byte("secret")), nil)
+	da := &fakeLoginGateway{login: entities.UserLogin{UserLoginID: "1", FkUserID: "2", Salt: "salt"}}
+	da.login.Password = as.hashPasswordWithSalt("right", "salt")
+	as.da = da
+
+	ctx := context.Background()
+	wrong := LoginUser{Email: "sam@example.com", Password: "wrong"}
+	right := LoginUser{Email: "sam@example.com", Password: "right"}
+
+	for i := 1; i < maxFailedLogins; i++ {
+		if _, err := as.Login(ctx, wrong); errCode(err) != 401 {
+			t.Fatalf("failed login %d: got %v, want a 401", i, err)
+		}
+	}
+
+	_, err := as.Login(ctx, wrong)
+
+	if errCode(err) != 429 || retryAfter(err) != time.Minute {
+		t.Fatalf("failed login %d: got %v retrying after %s, want a 429 after 1m", maxFailedLogins, err, retryAfter(err))
+	}
+
+	// the password isn't even checked while it is locked
+	if _, err := as.Login(ctx, right); errCode(err) != 429 || retryAfter(err) <= 0 {
+		t.Errorf("right password while locked: got %v, want a 429", err)
+	}
+
+	if da.login.FailedLogins != maxFailedLogins {
+		t.Errorf("a login while locked was counted: %d failed logins", da.login.FailedLogins)
+	}
+
+	// once the lock runs out, the next failure locks it for twice as long
+	da.unlock()
+
+	if _, err := as.Login(ctx, wrong); errCode(err) != 429 || retryAfter(err) != 2*time.Minute {
+		t.Errorf("failure after the lock: got %v retrying after %s, want a 429 after 2m", err, retryAfter(err))
+	}
+
+	// and the right password then starts again from nothing
+	da.unlock()
+
+	result, err := as.Login(ctx, right)
+
+	if err != nil || result.Token == "" {
+		t.Fatalf("right password after the lock: got %+v, %v", result, err)
+	}
+
+	if da.login.FailedLogins != 0 || da.login.LockedUntil != nil {
+		t.Errorf("failed logins weren't reset: %d, locked until %v", da.login.FailedLogins, da.login.LockedUntil)
+	}
+}
+
+func TestLoginWithoutAPassword(t *testing.T) {
+	// users who only log in with an OIDC provider have a login without one
+	as := newAuthService(&fakeLoginGateway{login: entities.UserLogin{UserLoginID: "1", FkUserID: "2"}}, jwtkeys.NewHMACRing([]byte("secret")), nil)
+
+	for _, password := range []string{"", "anything"} {
+		if _, err := as.Login(context.Background(), LoginUser{Email: "sam@example.com", Password: password}); errCode(err) != 401 {
+			t.Errorf("password %q: got %v, want a 401", password, err)
+		}
+	}
+}
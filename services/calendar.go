@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/grounded042/capacious/calendar"
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/mail"
+	"github.com/grounded042/capacious/utils"
+)
+
+// what calendars call the answers guests can give
+var calendarStatuses = map[string]string{
+	entities.RSVPPending:   calendar.NeedsAction,
+	entities.RSVPAttending: calendar.Accepted,
+	entities.RSVPDeclined:  calendar.Declined,
+	entities.RSVPMaybe:     calendar.Tentative,
+}
+
+// eventZone gets the time zone event's times are shown in.
+func eventZone(event entities.Event) *time.Location {
+	// time zones are checked when events are created, so one that doesn't
+	// load was set some other way; UTC is still the right time
+	zone, err := calendar.LoadZone(event.TimeZone)
+
+	if err != nil {
+		return time.UTC
+	}
+
+	return zone
+}
+
+// calendarEvent gets event as an entry in a calendar. The entry has the same
+// UID wherever it is sent, so calendars update the one they have.
+func calendarEvent(event entities.Event) calendar.Event {
+	zone := eventZone(event)
+
+	return calendar.Event{
+		UID:         event.EventID + "@capacious",
+		Sequence:    event.CalendarSequence,
+		Summary:     event.Name,
+		Description: event.Description,
+		Location:    event.Location,
+		Start:       event.StartTime,
+		End:         event.EndTime,
+		Zone:        zone,
+	}
+}
+
+// inviteeCalendar gets the invitation to event for invitee, from organizer,
+// with their answer. link is where they change it.
+func inviteeCalendar(event entities.Event, invitee entities.Invitee, organizer netmail.Address, link string) calendar.Calendar {
+	entry := calendarEvent(event)
+	entry.Organizer = &calendar.Person{Name: organizer.Name, Email: organizer.Address}
+
+	// without an RSVP URL the link is only the invitee's id
+	if strings.Contains(link, "://") {
+		entry.URL = link
+	}
+
+	if invitee.Email != "" {
+		entry.Attendees = []calendar.Attendee{{
+			Person: calendar.Person{Name: strings.TrimSpace(invitee.Self.FirstName + " " + invitee.Self.LastName), Email: invitee.Email},
+			Status: calendarStatuses[invitee.Self.RSVPStatus],
+			RSVP:   true,
+		}}
+	}
+
+	return calendar.Calendar{Method: calendar.MethodRequest, Events: []calendar.Event{entry}}
+}
+
+// invite gets the calendar invitation sent to invitee along with emails about
+// event.
+func (ms mailService) invite(event entities.Event, invitee entities.Invitee) mail.Attachment {
+	cal := inviteeCalendar(event, invitee, ms.mailer.From(), ms.mailer.RSVPLink(invitee.InviteeID))
+
+	return mail.Attachment{
+		Filename:    "invite.ics",
+		ContentType: calendar.ContentType + "; method=" + calendar.MethodRequest,
+		Data:        cal.Bytes(),
+	}
+}
+
+// GetEventCalendar gets the calendar with the event with the id eventID in it,
+// for anyone to add to theirs.
+func (c Coordinator) GetEventCalendar(ctx context.Context, eventID string) ([]byte, utils.Error) {
+	event, err := c.events.GetEventInfo(ctx, eventID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cal := calendar.Calendar{
+		Method: calendar.MethodPublish,
+		Name:   event.Name,
+		Events: []calendar.Event{calendarEvent(event)},
+	}
+
+	return cal.Bytes(), nil
+}
+
+// GetInviteeCalendar gets the invitation to their event for the invitee with
+// the id inviteeID, with their answer.
+func (c Coordinator) GetInviteeCalendar(ctx context.Context, inviteeID string) ([]byte, utils.Error) {
+	invitee, err := c.invitees.GetInviteeFromID(ctx, inviteeID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := c.events.GetEventInfo(ctx, invitee.FkEventID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cal := inviteeCalendar(event, invitee, c.mail.mailer.From(), c.mail.mailer.RSVPLink(invitee.InviteeID))
+
+	return cal.Bytes(), nil
+}
@@ -1,13 +1,21 @@
 package services
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/base64"
 	"os"
 
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/audit"
 	"github.com/grounded042/capacious/dal"
 	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jwtkeys"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/mail"
+	"github.com/grounded042/capacious/oidc"
+	"github.com/grounded042/capacious/patch"
 	"github.com/grounded042/capacious/utils"
 )
 
@@ -24,57 +32,302 @@ type Coordinator struct {
 	events   eventsService
 	invitees inviteeService
 	auth     authService
+	apiKeys  apiKeysService
+	audit    auditService
+	history  historyService
+	health   healthService
+	stats    statsService
+	mail     mailService
+	schedule scheduleService
+	webhooks webhooksService
+	live     liveService
+	tx       transactor
 }
 
-func NewCoordinator(newDa dal.DataHandler) Coordinator {
+// transactor runs changes in transactions.
+type transactor interface {
+	// InTransaction runs a function in a transaction carried by the ctx it is
+	// given, committing it if the function returns nil
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// NewCoordinator builds a Coordinator. provider is nil when OIDC login isn't
+// configured. Emails are sent with mailer, by jobs enqueued in the database.
+func NewCoordinator(newDa dal.DataHandler, keys *jwtkeys.Ring, provider *oidc.Provider, mailer *mail.Mailer) Coordinator {
+	jobStore := newDa.JobStore()
+
 	return Coordinator{
 		events:   newEventsService(newDa),
 		invitees: newInviteeService(newDa),
-		auth:     newAuthService(newDa),
+		auth:     newAuthService(newDa, keys, provider),
+		apiKeys:  newAPIKeysService(newDa),
+		audit:    newAuditService(newDa),
+		history:  newHistoryService(newDa),
+		health:   newHealthService(newDa),
+		stats:    newStatsService(newDa),
+		mail:     newMailService(newDa, mailer, jobStore),
+		schedule: newScheduleService(newDa, mailer, jobStore),
+		webhooks: newWebhooksService(newDa, jobStore),
+		live:     newLiveService(newDa),
+		tx:       newDa,
+	}
+}
+
+// inTransaction runs fn in a transaction. Everything fn does with the ctx it
+// is given is committed if it returns nil and rolled back otherwise.
+func (c Coordinator) inTransaction(ctx context.Context, fn func(ctx context.Context) utils.Error) utils.Error {
+	var fnErr utils.Error
+
+	err := c.tx.InTransaction(ctx, func(ctx context.Context) error {
+		if fnErr = fn(ctx); fnErr != nil {
+			return fnErr
+		}
+
+		return nil
+	})
+
+	if fnErr != nil {
+		return fnErr
+	} else if err != nil {
+		return utils.NewApiError(500, err.Error())
 	}
+
+	return nil
 }
 
 // events coordination
 
-// GetEvents gets all events that the specified userID is an admin of
-func (c Coordinator) GetEvents(userID string) ([]entities.Event, utils.Error) {
-	return c.events.GetEvents(userID)
+// GetEvents gets the page p asks for of the events that the specified userID
+// is an admin of
+func (c Coordinator) GetEvents(ctx context.Context, userID string, p *PaginationService) ([]entities.Event, utils.Error) {
+	return c.events.GetEvents(ctx, userID, p)
 }
 
-func (c Coordinator) GetEventInfo(eventId string) (entities.Event, utils.Error) {
-	return c.events.GetEventInfo(eventId)
+func (c Coordinator) GetEventInfo(ctx context.Context, eventId string) (entities.Event, utils.Error) {
+	return c.events.GetEventInfo(ctx, eventId)
 }
 
-func (c Coordinator) GetEventStats(eventID string, userID string) (EventStats, utils.Error) {
-	// make sure the user is an admin for this event
-	isAdmin, err := c.events.IsUserAnAdminForEvent(userID, eventID)
+// GetEventStats gets the stats of the event with the id eventID, for the
+// specified userID if they are an admin of it
+func (c Coordinator) GetEventStats(ctx context.Context, eventID string, userID string) (EventStats, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the stats for this event!")
 
 	if err != nil {
 		return EventStats{}, err
+	}
+
+	return c.stats.GetEventStats(ctx, eventID)
+}
+
+func (c Coordinator) CreateEvent(ctx context.Context, event *entities.Event, userID string) utils.Error {
+	if event.RequireTwoFactor {
+		if err := c.checkCanRequireTwoFactor(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	return c.inTransaction(ctx, func(ctx context.Context) utils.Error {
+		if err := c.events.CreateEvent(ctx, event, userID); err != nil {
+			return err
+		}
+
+		return c.audit.Record(ctx, auditChange{
+			EventID:    event.EventID,
+			EntityType: auditEvent,
+			EntityID:   event.EventID,
+			Action:     auditCreate,
+			After:      event,
+		})
+	})
+}
+
+// SetEventRequireTwoFactor sets whether the admins of the event with the id
+// eventID need two factor authentication enabled to manage it. Only the owner
+// of the event can change this, and only once they have it enabled
+// themselves. If version isn't 0 the event has to be at that version. It
+// returns the event's new version.
+func (c Coordinator) SetEventRequireTwoFactor(ctx context.Context, eventID string, userID string, required bool, version int) (int, utils.Error) {
+	isOwner, err := c.events.IsUserAnOwnerForEvent(ctx, userID, eventID)
+
+	if err != nil {
+		return 0, err
+	} else if !isOwner {
+		return 0, utils.NewApiError(403, "Only the owner of this event can change whether two factor authentication is required!")
+	}
+
+	if required {
+		if err := c.checkCanRequireTwoFactor(ctx, userID); err != nil {
+			return 0, err
+		}
+	}
+
+	after, err := c.changeEvent(ctx, eventID, version, func(ctx context.Context) utils.Error {
+		return c.events.SetRequireTwoFactor(ctx, eventID, required)
+	})
+
+	return after.Version, err
+}
+
+// changeEvent makes a change to the event with the id eventID with change. If
+// version isn't 0 the event has to be at that version. The change, the bump
+// of the event's version and checking it are made in one transaction, with
+// the ctx change is given, so a change that fails leaves the event as it was.
+// The change is recorded in the audit log in the same transaction. It returns
+// the event as it is after the change.
+func (c Coordinator) changeEvent(ctx context.Context, eventID string, version int, change func(ctx context.Context) utils.Error) (entities.Event, utils.Error) {
+	var before, after entities.Event
+
+	err := c.inTransaction(ctx, func(ctx context.Context) utils.Error {
+		// bump the version first, which holds the event until the transaction
+		// is over, so two changes made from the same version can't both go
+		// ahead
+		if err := c.events.BumpVersion(ctx, eventID, version); err != nil {
+			return err
+		}
+
+		var err utils.Error
+
+		if before, err = c.events.GetEventInfo(ctx, eventID); err != nil {
+			return err
+		}
+
+		// it was at the version before the bump
+		before.Version--
+
+		if err := change(ctx); err != nil {
+			return err
+		}
+
+		if after, err = c.events.GetEventInfo(ctx, eventID); err != nil {
+			return err
+		}
+
+		return c.audit.Record(ctx, auditChange{
+			EventID:    eventID,
+			EntityType: auditEvent,
+			EntityID:   eventID,
+			Action:     auditUpdate,
+			Before:     before,
+			After:      after,
+		})
+	})
+
+	if err != nil {
+		return entities.Event{}, err
+	}
+
+	return after, nil
+}
+
+// checkEventAdmin makes sure the user with the id userID can manage the event
+// with the id eventID. They need to be an admin of it and, if the event
+// requires it, have two factor authentication enabled. message is sent if
+// they aren't an admin. Requests made with an API key act as the admin who
+// created it, and the key has to be for the event.
+func (c Coordinator) checkEventAdmin(ctx context.Context, userID string, eventID string, message string) utils.Error {
+	if p, ok := apikeys.FromContext(ctx); ok && p.EventID != eventID {
+		return utils.NewApiError(403, "This API key can't be used for this event!")
+	}
+
+	isAdmin, err := c.events.IsUserAnAdminForEvent(ctx, userID, eventID)
+
+	if err != nil {
+		return err
 	} else if !isAdmin {
-		return EventStats{}, utils.NewApiError(403, "You are not authorized to view the list of invitees for this event!")
+		return utils.NewApiError(403, message)
 	}
 
-	stats := EventStats{}
+	event, err := c.events.GetEventInfo(ctx, eventID)
 
-	stats.NumAttending, err = c.events.GetNumAttendingForEvent(eventID)
 	if err != nil {
-		return EventStats{}, utils.NewApiError(500, "Error getting stats!")
+		return err
+	}
+
+	if !event.RequireTwoFactor {
+		return nil
+	}
+
+	hasTwoFactor, err := c.auth.HasTwoFactor(ctx, userID)
+
+	if err != nil {
+		return err
+	} else if !hasTwoFactor {
+		return utils.NewApiError(403, "This event requires two factor authentication. Enable it to manage the event!")
+	}
+
+	return nil
+}
+
+// checkRSVPsOpen makes sure the invitees of event can be changed by the
+// request carried by ctx. Once an event's RSVPs are locked only its admins can
+// change them.
+func (c Coordinator) checkRSVPsOpen(ctx context.Context, event entities.Event) utils.Error {
+	if event.RSVPsLockedAt == nil {
+		return nil
+	}
+
+	isAdmin, err := c.isActingAdmin(ctx, event.EventID)
+
+	if err != nil {
+		return err
+	} else if !isAdmin {
+		return utils.NewApiError(403, "RSVPs for this event are closed!")
+	}
+
+	return nil
+}
+
+// isActingAdmin checks whether the request carried by ctx was made by someone
+// who can manage the invitees of the event with the id eventID: one of its
+// admins with a token, or an API key for it that can write invitees. Routes
+// invitees use are public, so having a token or key isn't enough on its own.
+func (c Coordinator) isActingAdmin(ctx context.Context, eventID string) (bool, utils.Error) {
+	var userID string
+
+	if p, ok := apikeys.FromContext(ctx); ok {
+		if !p.Has(apikeys.WriteInvitees) {
+			return false, nil
+		}
+
+		userID = p.UserID
+	} else if a, ok := audit.FromContext(ctx); ok && a.Type == audit.ActorUser {
+		userID = a.ID
+	} else {
+		return false, nil
 	}
 
-	return stats, nil
+	err := c.checkEventAdmin(ctx, userID, eventID, "")
+
+	if err != nil && err.Code() == 403 {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
-func (c Coordinator) CreateEvent(event *entities.Event, userID string) utils.Error {
-	return c.events.CreateEvent(event, userID)
+// checkCanRequireTwoFactor makes sure the user with the id userID has two
+// factor authentication enabled before they require it of an event, so they
+// don't lock themselves out.
+func (c Coordinator) checkCanRequireTwoFactor(ctx context.Context, userID string) utils.Error {
+	hasTwoFactor, err := c.auth.HasTwoFactor(ctx, userID)
+
+	if err != nil {
+		return err
+	} else if !hasTwoFactor {
+		return utils.NewApiError(409, "Enable two factor authentication before requiring it of an event!")
+	}
+
+	return nil
 }
 
-func (c Coordinator) GetMenuItemsForEvent(eventID string) ([]entities.MenuItem, utils.Error) {
-	return c.events.GetMenuItemsForEvent(eventID)
+func (c Coordinator) GetMenuItemsForEvent(ctx context.Context, eventID string) ([]entities.MenuItem, utils.Error) {
+	return c.events.GetMenuItemsForEvent(ctx, eventID)
 }
 
-func (c Coordinator) GetListOfSeatingRequestChoices(eventID string) ([]entities.SeatingRequestChoice, utils.Error) {
-	iList, err := c.invitees.GetSeatingRequestInviteesForEvent(eventID)
+func (c Coordinator) GetListOfSeatingRequestChoices(ctx context.Context, eventID string) ([]entities.SeatingRequestChoice, utils.Error) {
+	iList, err := c.invitees.GetSeatingRequestInviteesForEvent(ctx, eventID)
 
 	if err != nil {
 		return []entities.SeatingRequestChoice{}, utils.NewApiError(500, err.Error())
@@ -135,88 +388,629 @@ func (c Coordinator) encryptFkInviteeRequestID(toEncrypt string) (string, utils.
 	return string(base64Text), nil
 }
 
+// CreateAPIKey creates an API key for the event with the id eventID that acts
+// for the user with the id userID. The key is only ever returned this once.
+func (c Coordinator) CreateAPIKey(ctx context.Context, eventID string, userID string, newKey NewAPIKey) (CreatedAPIKey, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to create API keys for this event!")
+
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+
+	return c.apiKeys.CreateAPIKey(ctx, eventID, userID, newKey)
+}
+
+// GetAPIKeysForEvent gets the API keys of the event with the id eventID
+func (c Coordinator) GetAPIKeysForEvent(ctx context.Context, eventID string, userID string) ([]entities.APIKey, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the API keys for this event!")
+
+	if err != nil {
+		return []entities.APIKey{}, err
+	}
+
+	return c.apiKeys.GetAPIKeysForEvent(ctx, eventID)
+}
+
+// RevokeAPIKey revokes the API key with the id apiKeyID of the event with the
+// id eventID
+func (c Coordinator) RevokeAPIKey(ctx context.Context, eventID string, apiKeyID string, userID string) utils.Error {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to revoke API keys for this event!")
+
+	if err != nil {
+		return err
+	}
+
+	return c.apiKeys.RevokeAPIKey(ctx, eventID, apiKeyID)
+}
+
+// GetAuditLog gets a page of the audit entries of the event with the id
+// eventID that match filter
+func (c Coordinator) GetAuditLog(ctx context.Context, eventID string, userID string, filter entities.AuditFilter, p *PaginationService) ([]entities.AuditEntry, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the audit log for this event!")
+
+	if err != nil {
+		return []entities.AuditEntry{}, err
+	}
+
+	return c.audit.GetAuditLog(ctx, eventID, filter, p)
+}
+
+// GetEmailTemplates gets the email templates of the event with the id
+// eventID, for the specified userID if they are an admin of it
+func (c Coordinator) GetEmailTemplates(ctx context.Context, eventID string, userID string) ([]EmailTemplate, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the emails for this event!")
+
+	if err != nil {
+		return []EmailTemplate{}, err
+	}
+
+	return c.mail.GetTemplates(ctx, eventID)
+}
+
+// SaveEmailTemplate replaces the email template of its kind of the event with
+// the id eventID
+func (c Coordinator) SaveEmailTemplate(ctx context.Context, eventID string, userID string, template entities.EmailTemplate) (EmailTemplate, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to change the emails for this event!")
+
+	if err != nil {
+		return EmailTemplate{}, err
+	}
+
+	event, err := c.events.GetEventInfo(ctx, eventID)
+
+	if err != nil {
+		return EmailTemplate{}, err
+	}
+
+	return c.mail.SaveTemplate(ctx, event, template)
+}
+
+// ResetEmailTemplate goes back to the default email template of the kind kind
+// for the event with the id eventID
+func (c Coordinator) ResetEmailTemplate(ctx context.Context, eventID string, kind string, userID string) utils.Error {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to change the emails for this event!")
+
+	if err != nil {
+		return err
+	}
+
+	return c.mail.ResetTemplate(ctx, eventID, kind)
+}
+
+// SendEmails sends the email of the kind kind of the event with the id eventID
+// to the invitee with the id inviteeID, or when that is empty to every
+// invitee of the event that matches filter. It returns the deliveries, which
+// are sent in the background.
+func (c Coordinator) SendEmails(ctx context.Context, eventID string, userID string, kind string, inviteeID string, filter entities.InviteeFilter) ([]entities.EmailDelivery, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to send emails for this event!")
+
+	if err != nil {
+		return []entities.EmailDelivery{}, err
+	}
+
+	var invitees []entities.Invitee
+
+	if inviteeID != "" {
+		invitee, err := c.invitees.GetInviteeFromID(ctx, inviteeID)
+
+		if err != nil || invitee.FkEventID != eventID {
+			return []entities.EmailDelivery{}, utils.NewApiError(404, "The invitee is not invited to this event!")
+		}
+
+		invitees = []entities.Invitee{invitee}
+	} else if invitees, err = c.mail.GetInviteesMatching(ctx, eventID, filter); err != nil {
+		return []entities.EmailDelivery{}, err
+	}
+
+	return c.sendEmails(ctx, eventID, kind, invitees)
+}
+
+func (c Coordinator) sendEmails(ctx context.Context, eventID string, kind string, invitees []entities.Invitee) ([]entities.EmailDelivery, utils.Error) {
+	event, err := c.events.GetEventInfo(ctx, eventID)
+
+	if err != nil {
+		return []entities.EmailDelivery{}, err
+	}
+
+	items, err := c.events.GetMenuItemsForEvent(ctx, eventID)
+
+	if err != nil {
+		return []entities.EmailDelivery{}, err
+	}
+
+	return c.mail.Send(ctx, event, items, kind, invitees)
+}
+
+// confirm sends invitee the email of the kind kind confirming a change they
+// made. The change has been made by then, so failing to send it is logged
+// rather than failing the request.
+func (c Coordinator) confirm(ctx context.Context, invitee entities.Invitee, kind string) {
+	if invitee.Email == "" {
+		return
+	}
+
+	if _, err := c.sendEmails(ctx, invitee.FkEventID, kind, []entities.Invitee{invitee}); err != nil {
+		logging.FromContext(ctx).Error("could not send confirmation email", "invitee_id", invitee.InviteeID, "kind", kind, "error", err)
+	}
+}
+
+// GetEmailDeliveries gets a page of the email deliveries of the event with
+// the id eventID that match filter
+func (c Coordinator) GetEmailDeliveries(ctx context.Context, eventID string, userID string, filter entities.EmailDeliveryFilter, p *PaginationService) ([]entities.EmailDelivery, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the emails for this event!")
+
+	if err != nil {
+		return []entities.EmailDelivery{}, err
+	}
+
+	return c.mail.GetDeliveries(ctx, eventID, filter, p)
+}
+
+// AuthenticateAPIKey checks an API key and gets who requests made with it act
+// as
+func (c Coordinator) AuthenticateAPIKey(ctx context.Context, key string) (apikeys.Principal, utils.Error) {
+	return c.apiKeys.Authenticate(ctx, key)
+}
+
 // end events coordination
 
 // invitee coordination
 
-func (c Coordinator) GetInviteesForEvent(eventID string, userID string, p *PaginationService) ([]entities.Invitee, utils.Error) {
+// GetInviteesForEvent gets the page p asks for of the invitees of the event
+// with the id eventID that match filter.
+func (c Coordinator) GetInviteesForEvent(ctx context.Context, eventID string, userID string, filter entities.InviteeFilter, p *PaginationService) ([]entities.Invitee, utils.Error) {
 	// make sure the user is an admin for this event
-
-	isAdmin, err := c.events.IsUserAnAdminForEvent(userID, eventID)
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the list of invitees for this event!")
 
 	if err != nil {
 		return []entities.Invitee{}, err
-	} else if !isAdmin {
-		return []entities.Invitee{}, utils.NewApiError(403, "You are not authorized to view the list of invitees for this event!")
 	}
 
-	return c.invitees.GetInviteesForEvent(eventID, p)
+	return c.invitees.GetInviteesForEvent(ctx, eventID, filter, p)
 }
 
-func (c Coordinator) CreateInviteeForEvent(invitee *entities.Invitee, event entities.Event) utils.Error {
-	return c.invitees.CreateInviteeForEvent(invitee, event)
+func (c Coordinator) CreateInviteeForEvent(ctx context.Context, invitee *entities.Invitee, event entities.Event, userID string) utils.Error {
+	// make sure the user is an admin for this event
+	err := c.checkEventAdmin(ctx, userID, event.EventID, "You are not authorized to add invitees to this event!")
+
+	if err != nil {
+		return err
+	}
+
+	return c.inTransaction(ctx, func(ctx context.Context) utils.Error {
+		if err := c.invitees.CreateInviteeForEvent(ctx, invitee, event); err != nil {
+			return err
+		}
+
+		after, err := c.invitees.GetInviteeFromID(ctx, invitee.InviteeID)
+
+		if err != nil {
+			return err
+		}
+
+		err = c.audit.Record(ctx, auditChange{
+			EventID:    event.EventID,
+			EntityType: auditInvitee,
+			EntityID:   invitee.InviteeID,
+			InviteeID:  invitee.InviteeID,
+			GuestID:    invitee.FkGuestID,
+			Action:     auditCreate,
+			After:      after,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return c.publish(ctx, entities.WebhookInviteeCreated, after, WebhookData{})
+	})
 }
 
-func (c Coordinator) GetInviteeFromID(id string) (entities.Invitee, utils.Error) {
-	invitee, err := c.invitees.GetInviteeFromID(id)
+func (c Coordinator) GetInviteeFromID(ctx context.Context, id string) (entities.Invitee, utils.Error) {
+	invitee, err := c.invitees.GetInviteeFromID(ctx, id)
 
 	if err != nil {
 		return entities.Invitee{}, err
 	}
 
+	return c.encryptInviteeSeatingRequests(invitee)
+}
+
+// encryptInviteeSeatingRequests gets a copy of invitee with the ids of the
+// invitees in its seating requests encrypted, the way it is sent to clients.
+// Tags are left out, as they are for admins only.
+func (c Coordinator) encryptInviteeSeatingRequests(invitee entities.Invitee) (entities.Invitee, utils.Error) {
+	requests := make([]entities.InviteeSeatingRequest, len(invitee.SeatingRequests))
+
 	for key, value := range invitee.SeatingRequests {
-		invitee.SeatingRequests[key].FkInviteeRequestID, err = c.encryptFkInviteeRequestID(value.FkInviteeRequestID)
+		var err utils.Error
+		requests[key] = value
+		requests[key].FkInviteeRequestID, err = c.encryptFkInviteeRequestID(value.FkInviteeRequestID)
 
 		if err != nil {
 			return entities.Invitee{}, err
 		}
 	}
 
+	invitee.SeatingRequests = requests
+	invitee.Tags = nil
+
 	return invitee, nil
 }
 
-func (c Coordinator) EditInvitee(updateMe entities.Invitee) utils.Error {
-	return c.invitees.EditInvitee(updateMe)
+// PatchInvitee applies p to the invitee with the id inviteeID. Only the fields
+// in inviteePatchable can be changed. If version isn't 0 the invitee has to
+// be at that version. It returns the patched invitee.
+func (c Coordinator) PatchInvitee(ctx context.Context, inviteeID string, p patch.Patch, version int) (entities.Invitee, utils.Error) {
+	answered := false
+	var previous string
+
+	after, err := c.changeInvitee(ctx, inviteeID, version, func(ctx context.Context, before entities.Invitee) (auditChange, utils.Error) {
+		change := auditChange{
+			EntityType: auditInvitee,
+			EntityID:   before.InviteeID,
+			GuestID:    before.FkGuestID,
+			Action:     auditUpdate,
+		}
+
+		// patch the invitee the way the client sees it
+		doc, err := c.encryptInviteeSeatingRequests(before)
+
+		if err != nil {
+			return change, err
+		}
+
+		var updateMe entities.Invitee
+
+		if err := applyPatch(doc, p, inviteePatchable, &updateMe); err != nil {
+			return change, err
+		}
+
+		if err := resolveRSVP(doc.Self, &updateMe.Self); err != nil {
+			return change, err
+		}
+
+		answered = updateMe.Self.RSVPStatus != doc.Self.RSVPStatus
+		previous = doc.Self.RSVPStatus
+
+		return change, c.invitees.EditInvitee(ctx, updateMe)
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		if !answered {
+			return nil
+		}
+
+		return []inviteeWebhook{{entities.WebhookRSVPChanged, WebhookData{
+			GuestID:            after.Self.GuestID,
+			RSVPStatus:         after.Self.RSVPStatus,
+			PreviousRSVPStatus: previous,
+		}}}
+	})
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	if answered {
+		c.confirm(ctx, after, entities.EmailRSVPConfirmation)
+	}
+
+	return c.encryptInviteeSeatingRequests(after)
 }
 
-func (c Coordinator) CreateInviteeFriend(updateMe *entities.InviteeFriend) utils.Error {
+func (c Coordinator) CreateInviteeFriend(ctx context.Context, updateMe *entities.InviteeFriend) utils.Error {
 	// TODO: make sure to constrain the number of friends here
-	return c.invitees.CreateInviteeFriend(updateMe)
+	_, err := c.changeInvitee(ctx, updateMe.FkInviteeID, 0, func(ctx context.Context, before entities.Invitee) (auditChange, utils.Error) {
+		err := c.invitees.CreateInviteeFriend(ctx, updateMe)
+
+		change := auditChange{
+			EntityType: auditInviteeFriend,
+			EntityID:   updateMe.InviteeFriendID,
+			GuestID:    updateMe.FkGuestID,
+			Action:     auditCreate,
+		}
+
+		return change, err
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		friend, ok := findInviteeFriend(after, updateMe.InviteeFriendID)
+
+		if !ok {
+			return nil
+		}
+
+		return []inviteeWebhook{{entities.WebhookFriendAdded, WebhookData{GuestID: friend.Self.GuestID, Friend: &friend}}}
+	})
+
+	return err
 }
 
-func (c Coordinator) EditInviteeFriend(updateMe entities.InviteeFriend) utils.Error {
+// PatchInviteeFriend applies p to the friend with the id friendID of the
+// invitee with the id inviteeID. Only the fields in inviteeFriendPatchable can
+// be changed. If version isn't 0 the invitee has to be at that version. It
+// returns the patched friend and the invitee's new version.
+func (c Coordinator) PatchInviteeFriend(ctx context.Context, inviteeID string, friendID string, p patch.Patch, version int) (entities.InviteeFriend, int, utils.Error) {
 	// TODO: make sure to constrain the number of friends here
-	return c.invitees.EditInviteeFriend(updateMe)
+	iFriend, err := c.invitees.GetInviteeFriendFromID(ctx, friendID)
+
+	if err != nil {
+		return entities.InviteeFriend{}, 0, err
+	} else if iFriend.InviteeFriendID == "" || iFriend.FkInviteeID != inviteeID {
+		return entities.InviteeFriend{}, 0, utils.NewApiError(404, "The invitee does not have that friend!")
+	}
+
+	answered := false
+	var previous string
+
+	after, err := c.changeInvitee(ctx, inviteeID, version, func(ctx context.Context, before entities.Invitee) (auditChange, utils.Error) {
+		change := auditChange{
+			EntityType: auditInviteeFriend,
+			EntityID:   iFriend.InviteeFriendID,
+			GuestID:    iFriend.FkGuestID,
+			Action:     auditUpdate,
+		}
+
+		friend, ok := findInviteeFriend(before, friendID)
+
+		if !ok {
+			return change, utils.NewApiError(404, "The invitee does not have that friend!")
+		}
+
+		var updateMe entities.InviteeFriend
+
+		if err := applyPatch(friend, p, inviteeFriendPatchable, &updateMe); err != nil {
+			return change, err
+		}
+
+		if err := resolveRSVP(friend.Self, &updateMe.Self); err != nil {
+			return change, err
+		}
+
+		answered = updateMe.Self.RSVPStatus != friend.Self.RSVPStatus
+		previous = friend.Self.RSVPStatus
+
+		return change, c.invitees.EditInviteeFriend(ctx, updateMe)
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		if !answered {
+			return nil
+		}
+
+		friend, _ := findInviteeFriend(after, friendID)
+
+		return []inviteeWebhook{{entities.WebhookRSVPChanged, WebhookData{
+			GuestID:            friend.Self.GuestID,
+			RSVPStatus:         friend.Self.RSVPStatus,
+			PreviousRSVPStatus: previous,
+		}}}
+	})
+
+	if err != nil {
+		return entities.InviteeFriend{}, 0, err
+	}
+
+	friend, _ := findInviteeFriend(after, friendID)
+
+	if answered {
+		c.confirm(ctx, after, entities.EmailRSVPConfirmation)
+	}
+
+	return friend, after.Version, nil
+}
+
+// GetInviteeHistory gets the RSVP history of the invitee with the id
+// inviteeID, oldest first.
+func (c Coordinator) GetInviteeHistory(ctx context.Context, inviteeID string) ([]HistoryItem, utils.Error) {
+	return c.history.GetHistory(ctx, inviteeID)
+}
+
+// RestoreInvitee puts the invitee with the id inviteeID back the way it was
+// after the change recorded by the audit entry with the id auditEntryID. Only
+// admins of the invitee's event can restore it.
+func (c Coordinator) RestoreInvitee(ctx context.Context, inviteeID string, auditEntryID string, userID string) (entities.Invitee, utils.Error) {
+	invitee, err := c.invitees.GetInviteeFromID(ctx, inviteeID)
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	err = c.checkEventAdmin(ctx, userID, invitee.FkEventID, "You are not authorized to restore invitees of this event!")
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	snapshot, err := c.history.GetSnapshot(ctx, inviteeID, auditEntryID)
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	_, err = c.changeInvitee(ctx, inviteeID, 0, func(ctx context.Context, before entities.Invitee) (auditChange, utils.Error) {
+		change := auditChange{
+			EntityType: auditInvitee,
+			EntityID:   before.InviteeID,
+			GuestID:    before.FkGuestID,
+			Action:     auditRestore,
+		}
+
+		return change, c.history.Restore(ctx, snapshot)
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		// answers put back by a restore are changed answers like any other
+		var changes []inviteeWebhook
+
+		for _, data := range changedRSVPs(before, after) {
+			changes = append(changes, inviteeWebhook{entities.WebhookRSVPChanged, data})
+		}
+
+		return changes
+	})
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	return c.GetInviteeFromID(ctx, inviteeID)
+}
+
+// changedRSVPs gets what to tell webhooks about each guest of an invitee whose
+// answer is different in after than in before. Friends who weren't there
+// before hadn't answered.
+func changedRSVPs(before entities.Invitee, after entities.Invitee) []WebhookData {
+	var changed []WebhookData
+
+	if before.Self.RSVPStatus != after.Self.RSVPStatus {
+		changed = append(changed, WebhookData{
+			GuestID:            after.Self.GuestID,
+			RSVPStatus:         after.Self.RSVPStatus,
+			PreviousRSVPStatus: before.Self.RSVPStatus,
+		})
+	}
+
+	for _, friend := range after.Friends {
+		previous := entities.RSVPPending
+
+		if was, ok := findInviteeFriend(before, friend.InviteeFriendID); ok {
+			previous = was.Self.RSVPStatus
+		}
+
+		if previous != friend.Self.RSVPStatus {
+			changed = append(changed, WebhookData{
+				GuestID:            friend.Self.GuestID,
+				RSVPStatus:         friend.Self.RSVPStatus,
+				PreviousRSVPStatus: previous,
+			})
+		}
+	}
+
+	return changed
+}
+
+// changeInvitee makes a change to the invitee with the id inviteeID with
+// change, which is given the invitee as it was before. If version isn't 0 the
+// invitee has to be at that version. The change, the bump of the invitee's
+// version and checking it are made in one transaction, with the ctx change is
+// given, so a change that fails leaves the invitee as it was. The change,
+// which returns what to record, is recorded in the audit log in the same
+// transaction, as are the deliveries to webhooks of what webhooks, if it isn't
+// nil, says changed. It returns the invitee as it is after the change.
+func (c Coordinator) changeInvitee(ctx context.Context, inviteeID string, version int, change func(ctx context.Context, before entities.Invitee) (auditChange, utils.Error), webhooks func(before entities.Invitee, after entities.Invitee) []inviteeWebhook) (entities.Invitee, utils.Error) {
+	invitee, err := c.invitees.GetInviteeFromID(ctx, inviteeID)
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	event, err := c.events.GetEventInfo(ctx, invitee.FkEventID)
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	if err := c.checkRSVPsOpen(ctx, event); err != nil {
+		return entities.Invitee{}, err
+	}
+
+	var before, after entities.Invitee
+	var record auditChange
+
+	err = c.inTransaction(ctx, func(ctx context.Context) utils.Error {
+		// bump the version first, which holds the invitee until the
+		// transaction is over, so two changes made from the same version
+		// can't both go ahead
+		if err := c.invitees.BumpVersion(ctx, inviteeID, version); err != nil {
+			return err
+		}
+
+		var err utils.Error
+
+		if before, err = c.invitees.GetInviteeFromID(ctx, inviteeID); err != nil {
+			return err
+		}
+
+		// it was at the version before the bump
+		before.Version--
+
+		if record, err = change(ctx, before); err != nil {
+			return err
+		}
+
+		if after, err = c.invitees.GetInviteeFromID(ctx, inviteeID); err != nil {
+			return err
+		}
+
+		record.EventID = before.FkEventID
+		record.InviteeID = before.InviteeID
+		record.Before = before
+		record.After = after
+
+		if err := c.audit.Record(ctx, record); err != nil || webhooks == nil {
+			return err
+		}
+
+		for _, w := range webhooks(before, after) {
+			if err := c.publish(ctx, w.Type, after, w.Data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return entities.Invitee{}, err
+	}
+
+	return after, nil
 }
 
-func (c Coordinator) SetInviteeMenuChoices(inviteeID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
-	invitee, err := c.invitees.GetInviteeFromID(inviteeID)
+func (c Coordinator) SetInviteeMenuChoices(ctx context.Context, inviteeID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
+	var updated []entities.MenuChoice
+
+	after, err := c.changeInvitee(ctx, inviteeID, 0, func(ctx context.Context, invitee entities.Invitee) (auditChange, utils.Error) {
+		var err utils.Error
+		updated, err = c.SetGuestMenuChoices(ctx, invitee.FkEventID, invitee.Self.GuestID, choices)
+
+		return auditChange{EntityType: auditMenuChoices, EntityID: invitee.Self.GuestID, GuestID: invitee.Self.GuestID, Action: auditUpdate}, err
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		return []inviteeWebhook{{entities.WebhookMenuChoiceSet, WebhookData{GuestID: after.Self.GuestID, MenuChoices: updated}}}
+	})
 
 	if err != nil {
 		return []entities.MenuChoice{}, err
 	}
 
-	return c.SetGuestMenuChoices(invitee.FkEventID, invitee.Self.GuestID, choices)
+	c.confirm(ctx, after, entities.EmailMenuConfirmation)
+
+	return updated, nil
 }
 
-func (c Coordinator) SetInviteeFriendMenuChoices(iFriendID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
-	iFriend, err := c.invitees.GetInviteeFriendFromID(iFriendID)
+func (c Coordinator) SetInviteeFriendMenuChoices(ctx context.Context, iFriendID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
+	iFriend, err := c.invitees.GetInviteeFriendFromID(ctx, iFriendID)
 
 	if err != nil {
 		return []entities.MenuChoice{}, err
 	}
 
-	invitee, err := c.invitees.GetInviteeFromID(iFriend.FkInviteeID)
+	var updated []entities.MenuChoice
+
+	after, err := c.changeInvitee(ctx, iFriend.FkInviteeID, 0, func(ctx context.Context, invitee entities.Invitee) (auditChange, utils.Error) {
+		var err utils.Error
+		updated, err = c.SetGuestMenuChoices(ctx, invitee.FkEventID, iFriend.FkGuestID, choices)
+
+		return auditChange{EntityType: auditMenuChoices, EntityID: iFriend.FkGuestID, GuestID: iFriend.FkGuestID, Action: auditUpdate}, err
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		return []inviteeWebhook{{entities.WebhookMenuChoiceSet, WebhookData{GuestID: iFriend.FkGuestID, MenuChoices: updated}}}
+	})
 
 	if err != nil {
 		return []entities.MenuChoice{}, err
 	}
 
-	return c.SetGuestMenuChoices(invitee.FkEventID, iFriend.FkGuestID, choices)
+	c.confirm(ctx, after, entities.EmailMenuConfirmation)
+
+	return updated, nil
 }
 
-func (c Coordinator) SetGuestMenuChoices(eventID string, guestID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
-	items, err := c.events.GetMenuItemsForEvent(eventID)
+func (c Coordinator) SetGuestMenuChoices(ctx context.Context, eventID string, guestID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
+	items, err := c.events.GetMenuItemsForEvent(ctx, eventID)
 
 	if err != nil {
 		return []entities.MenuChoice{}, err
@@ -226,41 +1020,68 @@ func (c Coordinator) SetGuestMenuChoices(eventID string, guestID string, choices
 		return []entities.MenuChoice{}, utils.NewApiError(400, "So yeah...you had an error in the list of menu choices you sent in. Rejected!")
 	}
 
-	return c.invitees.SetGuestMenuChoices(guestID, choices)
+	return c.invitees.SetGuestMenuChoices(ctx, guestID, choices)
 }
 
-func (c Coordinator) SetInviteeMenuNote(inviteeID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
-	invitee, err := c.invitees.GetInviteeFromID(inviteeID)
+func (c Coordinator) SetInviteeMenuNote(ctx context.Context, inviteeID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
+	var updated entities.MenuNote
+
+	_, err := c.changeInvitee(ctx, inviteeID, 0, func(ctx context.Context, invitee entities.Invitee) (auditChange, utils.Error) {
+		var err utils.Error
+		updated, err = c.SetGuestMenuNote(ctx, invitee.FkGuestID, note)
+
+		return auditChange{EntityType: auditMenuNote, EntityID: invitee.FkGuestID, GuestID: invitee.FkGuestID, Action: auditUpdate}, err
+	}, nil)
 
 	if err != nil {
 		return entities.MenuNote{}, err
 	}
 
-	return c.SetGuestMenuNote(invitee.FkGuestID, note)
+	return updated, nil
 }
 
-func (c Coordinator) SetInviteeFriendMenuNote(iFriendID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
-	iFriend, err := c.invitees.GetInviteeFriendFromID(iFriendID)
+func (c Coordinator) SetInviteeFriendMenuNote(ctx context.Context, iFriendID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
+	iFriend, err := c.invitees.GetInviteeFriendFromID(ctx, iFriendID)
+
+	if err != nil {
+		return entities.MenuNote{}, err
+	}
+
+	var updated entities.MenuNote
+
+	_, err = c.changeInvitee(ctx, iFriend.FkInviteeID, 0, func(ctx context.Context, invitee entities.Invitee) (auditChange, utils.Error) {
+		var err utils.Error
+		updated, err = c.SetGuestMenuNote(ctx, iFriend.FkGuestID, note)
+
+		return auditChange{EntityType: auditMenuNote, EntityID: iFriend.FkGuestID, GuestID: iFriend.FkGuestID, Action: auditUpdate}, err
+	}, nil)
 
 	if err != nil {
 		return entities.MenuNote{}, err
 	}
 
-	return c.SetGuestMenuNote(iFriend.FkGuestID, note)
+	return updated, nil
 }
 
-func (c Coordinator) SetGuestMenuNote(guestID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
-	return c.invitees.SetGuestMenuNote(guestID, note)
+func (c Coordinator) SetGuestMenuNote(ctx context.Context, guestID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
+	return c.invitees.SetGuestMenuNote(ctx, guestID, note)
 }
 
-func (c Coordinator) SetInviteeSeatingRequests(inviteeID string, requests []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, utils.Error) {
+func (c Coordinator) SetInviteeSeatingRequests(ctx context.Context, inviteeID string, requests []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, utils.Error) {
 	requests, err := c.decryptInviteeSeatingRequests(requests)
 
 	if err != nil {
 		return []entities.InviteeSeatingRequest{}, err
 	}
 
-	requests, err = c.invitees.SetInviteeSeatingRequests(inviteeID, requests)
+	_, err = c.changeInvitee(ctx, inviteeID, 0, func(ctx context.Context, invitee entities.Invitee) (auditChange, utils.Error) {
+		var err utils.Error
+		requests, err = c.invitees.SetInviteeSeatingRequests(ctx, inviteeID, requests)
+
+		return auditChange{EntityType: auditSeatingRequests, EntityID: inviteeID, GuestID: invitee.FkGuestID, Action: auditUpdate}, err
+	}, func(before entities.Invitee, after entities.Invitee) []inviteeWebhook {
+		return []inviteeWebhook{{entities.WebhookSeatingRequestSet, WebhookData{}}}
+	})
 
 	if err != nil {
 		return []entities.InviteeSeatingRequest{}, err
@@ -277,6 +1098,36 @@ func (c Coordinator) SetInviteeSeatingRequests(inviteeID string, requests []enti
 	return requests, nil
 }
 
+// SetInviteeTags replaces the tags of the invitee with the id inviteeID. Only
+// admins of the invitee's event can tag its invitees. It returns the tags as
+// they are kept.
+func (c Coordinator) SetInviteeTags(ctx context.Context, inviteeID string, tags []string, userID string) ([]string, utils.Error) {
+	invitee, err := c.invitees.GetInviteeFromID(ctx, inviteeID)
+
+	if err != nil {
+		return []string{}, err
+	}
+
+	err = c.checkEventAdmin(ctx, userID, invitee.FkEventID, "You are not authorized to tag invitees of this event!")
+
+	if err != nil {
+		return []string{}, err
+	}
+
+	_, err = c.changeInvitee(ctx, inviteeID, 0, func(ctx context.Context, before entities.Invitee) (auditChange, utils.Error) {
+		var err utils.Error
+		tags, err = c.invitees.SetTags(ctx, inviteeID, tags)
+
+		return auditChange{EntityType: auditInviteeTags, EntityID: inviteeID, Action: auditUpdate}, err
+	}, nil)
+
+	if err != nil {
+		return []string{}, err
+	}
+
+	return tags, nil
+}
+
 // validateMenuChoicesWithMenuItems validates that the supplied choices match
 // up with the supplied menu items. It returns a bool regarding the validity.
 // TODO: unit test this sucker
@@ -354,8 +1205,8 @@ func (c Coordinator) decryptInviteeSeatingRequest(request entities.InviteeSeatin
 // auth coordination
 
 // Login will authenticate login credentials from the lUser object
-func (c Coordinator) Login(lUser LoginUser) (string, utils.Error) {
-	return c.auth.Login(lUser)
+func (c Coordinator) Login(ctx context.Context, lUser LoginUser) (LoginResult, utils.Error) {
+	return c.auth.Login(ctx, lUser)
 }
 
 // GenerateToken will generate a new token for the provided user id
@@ -363,4 +1214,53 @@ func (c Coordinator) GenerateToken(userID string) (string, utils.Error) {
 	return c.auth.GenerateToken(userID)
 }
 
+// GetJWKS gets the public keys tokens can be verified with
+func (c Coordinator) GetJWKS() jwtkeys.JWKS {
+	return c.auth.GetJWKS()
+}
+
+// BeginOIDCLogin starts a login with the OIDC provider
+func (c Coordinator) BeginOIDCLogin(ctx context.Context) (OIDCAuthorization, utils.Error) {
+	return c.auth.BeginOIDCLogin(ctx)
+}
+
+// CompleteOIDCLogin finishes a login with the OIDC provider
+func (c Coordinator) CompleteOIDCLogin(ctx context.Context, code string, state string) (LoginResult, utils.Error) {
+	return c.auth.CompleteOIDCLogin(ctx, code, state)
+}
+
+// BeginTwoFactorEnrolment generates a new TOTP secret for the user
+func (c Coordinator) BeginTwoFactorEnrolment(ctx context.Context, userID string) (TwoFactorEnrolment, utils.Error) {
+	return c.auth.BeginTwoFactorEnrolment(ctx, userID)
+}
+
+// ConfirmTwoFactor enables TOTP for the user and returns their recovery codes
+func (c Coordinator) ConfirmTwoFactor(ctx context.Context, userID string, code string) ([]string, utils.Error) {
+	return c.auth.ConfirmTwoFactor(ctx, userID, code)
+}
+
+// DisableTwoFactor turns TOTP off for the user
+func (c Coordinator) DisableTwoFactor(ctx context.Context, userID string, code string) utils.Error {
+	return c.auth.DisableTwoFactor(ctx, userID, code)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user
+func (c Coordinator) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, utils.Error) {
+	return c.auth.RegenerateRecoveryCodes(ctx, userID, code)
+}
+
 // end auth coordination
+
+// health coordination
+
+// GetReadiness runs every readiness check and reports on each of them
+func (c Coordinator) GetReadiness(ctx context.Context) Readiness {
+	return c.health.GetReadiness(ctx)
+}
+
+// GetVersion gets the build commit and schema version info
+func (c Coordinator) GetVersion(ctx context.Context) VersionInfo {
+	return c.health.GetVersion(ctx)
+}
+
+// end health coordination
@@ -1,58 +1,73 @@
 package services
 
 import (
+	"context"
+
+	"github.com/grounded042/capacious/calendar"
 	"github.com/grounded042/capacious/entities"
 	"github.com/grounded042/capacious/utils"
 )
 
 type eventsGateway interface {
-	// GetAllEvents gets all of the events in the db that the passed in userID is
-	// an admin of.
-	GetAllEvents(userID string) ([]entities.Event, error)
+	// GetAllEvents gets a page of the events in the db that the passed in
+	// userID is an admin of.
+	GetAllEvents(ctx context.Context, userID string, page entities.Page) ([]entities.Event, entities.PageBounds, error)
+	// GetNumberOfEvents counts the events the passed in userID is an admin of
+	GetNumberOfEvents(ctx context.Context, userID string) (int, error)
 	// CreateEvent creates an event from a supplied event object and adds the
 	// specified user id as an owner of the event
-	CreateEvent(*entities.Event, string) error
+	CreateEvent(context.Context, *entities.Event, string) error
 	// GetEventInfo gets the info for an event matching
 	// the supplied event id
-	GetEventInfo(eventId string) (entities.Event, error)
+	GetEventInfo(ctx context.Context, eventId string) (entities.Event, error)
 	// GetMenuItemsForEvent gets all of the menu items
 	// for an event matching the supplied event id
-	GetMenuItemsForEvent(eventID string) ([]entities.MenuItem, error)
+	GetMenuItemsForEvent(ctx context.Context, eventID string) ([]entities.MenuItem, error)
 	// GetEventAdminRecordForUserAndEventID gets the event admin record that
 	// contains both the user id UserID and the event id EventID.
-	GetEventAdminRecordForUserAndEventID(userID string, eventID string) (entities.EventAdmin, error)
-	// GetNumAttendingForEvent gets the number of guests that are attending for
-	// the specified event id
-	GetNumAttendingForEvent(eventID string) (int, error)
+	GetEventAdminRecordForUserAndEventID(ctx context.Context, userID string, eventID string) (entities.EventAdmin, error)
+	// SetEventRequireTwoFactor sets whether the admins of the specified event
+	// need two factor authentication enabled to manage it
+	SetEventRequireTwoFactor(ctx context.Context, eventID string, required bool) error
+	// BumpEventVersion adds one to the version of an event, if it is at the
+	// supplied version when that isn't 0
+	BumpEventVersion(ctx context.Context, eventID string, version int) (bool, error)
 }
 
 type eventsService struct {
 	da eventsGateway
 }
 
-type EventStats struct {
-	NumInvitees  int `json:"num_invitees"`
-	NumAttending int `json:"num_attending"`
-}
-
 func newEventsService(newDa eventsGateway) eventsService {
 	return eventsService{
 		da: newDa,
 	}
 }
 
-func (es eventsService) GetEvents(userID string) ([]entities.Event, utils.Error) {
-	events, err := es.da.GetAllEvents(userID)
+// GetEvents gets the page p asks for of the events the user with the id
+// userID is an admin of. p is given the number of them and where the page is.
+func (es eventsService) GetEvents(ctx context.Context, userID string, p *PaginationService) ([]entities.Event, utils.Error) {
+	count, err := es.da.GetNumberOfEvents(ctx, userID)
 
 	if err != nil {
 		return []entities.Event{}, utils.NewApiError(500, err.Error())
 	}
 
+	p.SetNumItems(count)
+
+	events, bounds, err := es.da.GetAllEvents(ctx, userID, p.GetPage())
+
+	if err != nil {
+		return []entities.Event{}, pageError(err)
+	}
+
+	p.SetBounds(bounds)
+
 	return events, nil
 }
 
-func (es eventsService) GetEventInfo(eventId string) (entities.Event, utils.Error) {
-	event, err := es.da.GetEventInfo(eventId)
+func (es eventsService) GetEventInfo(ctx context.Context, eventId string) (entities.Event, utils.Error) {
+	event, err := es.da.GetEventInfo(ctx, eventId)
 
 	if err != nil {
 		return entities.Event{}, utils.NewApiError(500, err.Error())
@@ -61,8 +76,21 @@ func (es eventsService) GetEventInfo(eventId string) (entities.Event, utils.Erro
 	return event, nil
 }
 
-func (es eventsService) CreateEvent(event *entities.Event, userID string) utils.Error {
-	err := es.da.CreateEvent(event, userID)
+func (es eventsService) CreateEvent(ctx context.Context, event *entities.Event, userID string) utils.Error {
+	if _, err := calendar.LoadZone(event.TimeZone); err != nil {
+		return utils.NewApiError(422, "time_zone has to be an IANA time zone, like America/Chicago!")
+	}
+
+	if event.TimeZone == "" {
+		event.TimeZone = "UTC"
+	}
+
+	// the times are kept without an offset, which would otherwise be dropped
+	event.StartTime = event.StartTime.UTC()
+	event.EndTime = event.EndTime.UTC()
+	event.RespondBy = event.RespondBy.UTC()
+
+	err := es.da.CreateEvent(ctx, event, userID)
 
 	if err != nil {
 		return utils.NewApiError(500, err.Error())
@@ -73,8 +101,8 @@ func (es eventsService) CreateEvent(event *entities.Event, userID string) utils.
 
 // GetMenuItemsForEvent gets the menu items based on the event id
 // eventID. It returns a slice of menu items and any errors that occured.
-func (es eventsService) GetMenuItemsForEvent(eventID string) ([]entities.MenuItem, utils.Error) {
-	items, err := es.da.GetMenuItemsForEvent(eventID)
+func (es eventsService) GetMenuItemsForEvent(ctx context.Context, eventID string) ([]entities.MenuItem, utils.Error) {
+	items, err := es.da.GetMenuItemsForEvent(ctx, eventID)
 
 	if err != nil {
 		return []entities.MenuItem{}, utils.NewApiError(500, err.Error())
@@ -83,8 +111,8 @@ func (es eventsService) GetMenuItemsForEvent(eventID string) ([]entities.MenuIte
 	return items, nil
 }
 
-func (es eventsService) IsUserAnAdminForEvent(userID string, eventID string) (bool, utils.Error) {
-	eAdmin, err := es.da.GetEventAdminRecordForUserAndEventID(userID, eventID)
+func (es eventsService) IsUserAnAdminForEvent(ctx context.Context, userID string, eventID string) (bool, utils.Error) {
+	eAdmin, err := es.da.GetEventAdminRecordForUserAndEventID(ctx, userID, eventID)
 
 	if err != nil && err.Error() != "record not found" {
 		return false, utils.NewApiError(500, err.Error())
@@ -93,12 +121,36 @@ func (es eventsService) IsUserAnAdminForEvent(userID string, eventID string) (bo
 	return eAdmin.EventAdminID != "", nil
 }
 
-func (es eventsService) GetNumAttendingForEvent(eventID string) (int, utils.Error) {
-	num, err := es.da.GetNumAttendingForEvent(eventID)
+func (es eventsService) IsUserAnOwnerForEvent(ctx context.Context, userID string, eventID string) (bool, utils.Error) {
+	eAdmin, err := es.da.GetEventAdminRecordForUserAndEventID(ctx, userID, eventID)
+
+	if err != nil && err.Error() != "record not found" {
+		return false, utils.NewApiError(500, err.Error())
+	}
+
+	return eAdmin.Owner, nil
+}
+
+// SetRequireTwoFactor sets whether the admins of the event with the id eventID
+// need two factor authentication enabled to manage it.
+func (es eventsService) SetRequireTwoFactor(ctx context.Context, eventID string, required bool) utils.Error {
+	if err := es.da.SetEventRequireTwoFactor(ctx, eventID, required); err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
+
+// BumpVersion adds one to the version of the event with the id eventID. If
+// version isn't 0 the event has to be at that version.
+func (es eventsService) BumpVersion(ctx context.Context, eventID string, version int) utils.Error {
+	ok, err := es.da.BumpEventVersion(ctx, eventID, version)
 
 	if err != nil {
-		return 0, utils.NewApiError(500, err.Error())
+		return utils.NewApiError(500, err.Error())
+	} else if !ok {
+		return utils.NewApiError(412, "The event has been changed since you got it!")
 	}
 
-	return num, nil
+	return nil
 }
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"strconv"
+
+	"github.com/grounded042/capacious/logging"
+)
+
+// BuildCommit is the commit the running binary was built from. It is set at
+// build time with:
+//
+//	-ldflags "-X github.com/grounded042/capacious/services.BuildCommit=<sha>"
+var BuildCommit = "unknown"
+
+const (
+	checkPassed = "ok"
+	checkFailed = "failed"
+)
+
+type healthGateway interface {
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	// GetSchemaVersion gets the highest schema version that has been applied
+	// to the database.
+	GetSchemaVersion(ctx context.Context) (int, error)
+	// GetExpectedSchemaVersion gets the schema version the code expects the
+	// database to be at.
+	GetExpectedSchemaVersion() int
+}
+
+type healthService struct {
+	da healthGateway
+}
+
+// HealthCheck holds the result of a single readiness check. Why a check
+// failed is only logged, as anyone can ask whether the process is ready.
+type HealthCheck struct {
+	Status string `json:"status"`
+}
+
+// Readiness holds the result of every readiness check. Ready is only true when
+// all of the checks passed.
+type Readiness struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// VersionInfo describes the running build and the schema it expects.
+type VersionInfo struct {
+	Commit                string `json:"commit"`
+	SchemaVersion         int    `json:"schema_version"`
+	DatabaseSchemaVersion int    `json:"database_schema_version"`
+}
+
+func newHealthService(newDa healthGateway) healthService {
+	return healthService{
+		da: newDa,
+	}
+}
+
+// GetReadiness runs every readiness check and reports on each of them.
+func (hs healthService) GetReadiness(ctx context.Context) Readiness {
+	r := Readiness{
+		Ready: true,
+		Checks: map[string]HealthCheck{
+			"database":       newHealthCheck(ctx, "database", hs.da.Ping(ctx)),
+			"migrations":     newHealthCheck(ctx, "migrations", hs.checkMigrations(ctx)),
+			"encryption_key": newHealthCheck(ctx, "encryption_key", hs.checkEncryptionKey()),
+		},
+	}
+
+	for _, check := range r.Checks {
+		if check.Status != checkPassed {
+			r.Ready = false
+		}
+	}
+
+	return r
+}
+
+// GetVersion gets the build commit and the schema versions of both the code
+// and the database. The database schema version is 0 if it could not be read.
+func (hs healthService) GetVersion(ctx context.Context) VersionInfo {
+	dbVersion, _ := hs.da.GetSchemaVersion(ctx)
+
+	return VersionInfo{
+		Commit:                BuildCommit,
+		SchemaVersion:         hs.da.GetExpectedSchemaVersion(),
+		DatabaseSchemaVersion: dbVersion,
+	}
+}
+
+// checkMigrations makes sure the database schema is at the version the code
+// expects.
+func (hs healthService) checkMigrations(ctx context.Context) error {
+	version, err := hs.da.GetSchemaVersion(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if expected := hs.da.GetExpectedSchemaVersion(); version != expected {
+		return errors.New("database schema is at version " + strconv.Itoa(version) + ", expected " + strconv.Itoa(expected))
+	}
+
+	return nil
+}
+
+// checkEncryptionKey makes sure the key used to encrypt invitee ids is loaded
+// and is a valid AES key.
+func (hs healthService) checkEncryptionKey() error {
+	if key == "" {
+		return errors.New("ENC_KEY is not set")
+	}
+
+	_, err := aes.NewCipher([]byte(key))
+
+	return err
+}
+
+func newHealthCheck(ctx context.Context, name string, err error) HealthCheck {
+	if err != nil {
+		logging.FromContext(ctx).Error("readiness check failed", "check", name, "error", err)
+		return HealthCheck{Status: checkFailed}
+	}
+
+	return HealthCheck{Status: checkPassed}
+}
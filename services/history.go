@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/utils"
+)
+
+// the kinds of steps in an invitee's RSVP history
+const (
+	historyInvited         = "invited"
+	historyAccepted        = "accepted"
+	historyDeclined        = "declined"
+	historyMaybe           = "maybe"
+	historyFriendAdded     = "friend_added"
+	historyFriendChanged   = "friend_changed"
+	historyMenuChanged     = "menu_changed"
+	historyMenuNoteChanged = "menu_note_changed"
+	historySeatingChanged  = "seating_changed"
+	historyRestored        = "restored"
+	historyChanged         = "changed"
+)
+
+type historyGateway interface {
+	// GetAuditEntriesForInvitee gets all the audit entries about an invitee
+	GetAuditEntriesForInvitee(ctx context.Context, inviteeID string) ([]entities.AuditEntry, error)
+	// GetAuditEntryForInvitee gets an audit entry if it is about an invitee
+	GetAuditEntryForInvitee(ctx context.Context, inviteeID string, auditEntryID string) (entities.AuditEntry, error)
+	// RestoreInvitee puts an invitee back the way it is in a snapshot
+	RestoreInvitee(ctx context.Context, snapshot entities.Invitee) error
+}
+
+type historyService struct {
+	da historyGateway
+}
+
+// HistoryItem is one step in an invitee's RSVP history. AuditEntryID can be
+// used to restore the invitee to how it was after the step. By is the kind of
+// credential the step was taken with, not who took it.
+type HistoryItem struct {
+	AuditEntryID string    `json:"audit_entry_id"`
+	At           time.Time `json:"at"`
+	By           string    `json:"by"`
+	Kind         string    `json:"kind"`
+	GuestID      string    `json:"guest_id"`
+	Summary      string    `json:"summary"`
+}
+
+func newHistoryService(newDa historyGateway) historyService {
+	return historyService{
+		da: newDa,
+	}
+}
+
+// GetHistory gets the RSVP history of the invitee with the id inviteeID,
+// oldest first.
+func (hs historyService) GetHistory(ctx context.Context, inviteeID string) ([]HistoryItem, utils.Error) {
+	entries, err := hs.da.GetAuditEntriesForInvitee(ctx, inviteeID)
+
+	if err != nil {
+		return []HistoryItem{}, utils.NewApiError(500, err.Error())
+	}
+
+	items := make([]HistoryItem, 0, len(entries))
+
+	for _, entry := range entries {
+		// tags are for admins, invitees don't see them
+		if entry.EntityType == auditInviteeTags {
+			continue
+		}
+
+		item, err := historyItem(entry)
+
+		if err != nil {
+			return []HistoryItem{}, utils.NewApiError(500, err.Error())
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// GetSnapshot gets the invitee with the id inviteeID the way it was after the
+// change recorded by the audit entry with the id auditEntryID.
+func (hs historyService) GetSnapshot(ctx context.Context, inviteeID string, auditEntryID string) (entities.Invitee, utils.Error) {
+	entry, err := hs.da.GetAuditEntryForInvitee(ctx, inviteeID, auditEntryID)
+
+	if err != nil {
+		if err.Error() == "record not found" {
+			return entities.Invitee{}, utils.NewApiError(404, "That change is not in the history of this invitee!")
+		}
+
+		return entities.Invitee{}, utils.NewApiError(500, err.Error())
+	}
+
+	var snapshot entities.Invitee
+
+	if err := json.Unmarshal(entry.After, &snapshot); err != nil || snapshot.InviteeID != inviteeID {
+		return entities.Invitee{}, utils.NewApiError(400, "The invitee can't be restored to that change!")
+	}
+
+	return snapshot, nil
+}
+
+// Restore puts the invitee back the way it is in snapshot. Guests in
+// snapshots from before RSVP statuses were kept get the status that matches
+// whether they were attending.
+func (hs historyService) Restore(ctx context.Context, snapshot entities.Invitee) utils.Error {
+	if err := newGuestRSVP(&snapshot.Self); err != nil {
+		return err
+	}
+
+	for i := range snapshot.Friends {
+		if err := newGuestRSVP(&snapshot.Friends[i].Self); err != nil {
+			return err
+		}
+	}
+
+	if err := hs.da.RestoreInvitee(ctx, snapshot); err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
+
+// historyItem describes the change recorded by entry for people rather than
+// as a diff.
+func historyItem(entry entities.AuditEntry) (HistoryItem, error) {
+	var after entities.Invitee
+
+	if len(entry.After) > 0 {
+		if err := json.Unmarshal(entry.After, &after); err != nil {
+			return HistoryItem{}, err
+		}
+	}
+
+	item := HistoryItem{
+		AuditEntryID: entry.AuditEntryID,
+		At:           entry.CreatedAt,
+		By:           entry.ActorType,
+		GuestID:      entry.GuestID,
+	}
+
+	name, self := guestName(after, entry.GuestID)
+
+	switch {
+	case entry.Action == auditRestore:
+		item.Kind, item.Summary = historyRestored, "An earlier version of the RSVP was restored"
+	case entry.EntityType == auditInvitee && entry.Action == auditCreate:
+		item.Kind, item.Summary = historyInvited, "Was invited"
+	// every RSVP is an edit of the invitee, whether or not it changed anything
+	case entry.EntityType == auditInvitee && after.Self.Attending:
+		item.Kind, item.Summary = historyAccepted, "Accepted the invitation"
+	case entry.EntityType == auditInvitee && after.Self.RSVPStatus == entities.RSVPMaybe:
+		item.Kind, item.Summary = historyMaybe, "Might come"
+	case entry.EntityType == auditInvitee:
+		item.Kind, item.Summary = historyDeclined, "Declined the invitation"
+	case entry.EntityType == auditInviteeFriend && entry.Action == auditCreate:
+		item.Kind, item.Summary = historyFriendAdded, fmt.Sprintf("Added %s as a guest", name)
+	case entry.EntityType == auditInviteeFriend:
+		item.Kind, item.Summary = historyFriendChanged, fmt.Sprintf("Changed the details of %s", name)
+	case entry.EntityType == auditMenuChoices && self:
+		item.Kind, item.Summary = historyMenuChanged, "Changed their meal choices"
+	case entry.EntityType == auditMenuChoices:
+		item.Kind, item.Summary = historyMenuChanged, fmt.Sprintf("Changed the meal choices of %s", name)
+	case entry.EntityType == auditMenuNote && self:
+		item.Kind, item.Summary = historyMenuNoteChanged, "Changed their meal note"
+	case entry.EntityType == auditMenuNote:
+		item.Kind, item.Summary = historyMenuNoteChanged, fmt.Sprintf("Changed the meal note of %s", name)
+	case entry.EntityType == auditSeatingRequests:
+		item.Kind, item.Summary = historySeatingChanged, "Changed who they would like to sit near"
+	default:
+		item.Kind, item.Summary = historyChanged, "Changed their RSVP"
+	}
+
+	return item, nil
+}
+
+// guestName gets the name of the guest with the id guestID in invitee, and
+// whether it is the invitee themselves.
+func guestName(invitee entities.Invitee, guestID string) (string, bool) {
+	if invitee.Self.GuestID == guestID {
+		return fullName(invitee.Self), true
+	}
+
+	for _, friend := range invitee.Friends {
+		if friend.Self.GuestID == guestID {
+			return fullName(friend.Self), false
+		}
+	}
+
+	return "a guest", false
+}
+
+func fullName(guest entities.Guest) string {
+	name := strings.TrimSpace(guest.FirstName + " " + guest.LastName)
+
+	if name == "" {
+		return "a guest"
+	}
+
+	return name
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grounded042/capacious/entities"
+)
+
+func TestChangedRSVPs(t *testing.T) {
+	friend := func(id string, status string) entities.InviteeFriend {
+		return entities.InviteeFriend{InviteeFriendID: id, Self: entities.Guest{GuestID: "g" + id, RSVPStatus: status}}
+	}
+
+	before := entities.Invitee{
+		Self:    entities.Guest{GuestID: "g0", RSVPStatus: entities.RSVPAttending},
+		Friends: []entities.InviteeFriend{friend("1", entities.RSVPAttending), friend("2", entities.RSVPMaybe)},
+	}
+
+	after := entities.Invitee{
+		Self: entities.Guest{GuestID: "g0", RSVPStatus: entities.RSVPDeclined},
+		Friends: []entities.InviteeFriend{
+			friend("1", entities.RSVPAttending),
+			friend("2", entities.RSVPDeclined),
+			friend("3", entities.RSVPPending),
+			friend("4", entities.RSVPAttending),
+		},
+	}
+
+	want := []WebhookData{
+		{GuestID: "g0", RSVPStatus: entities.RSVPDeclined, PreviousRSVPStatus: entities.RSVPAttending},
+		{GuestID: "g2", RSVPStatus: entities.RSVPDeclined, PreviousRSVPStatus: entities.RSVPMaybe},
+		// a friend who wasn't there before hadn't answered
+		{GuestID: "g4", RSVPStatus: entities.RSVPAttending, PreviousRSVPStatus: entities.RSVPPending},
+	}
+
+	if got := changedRSVPs(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got := changedRSVPs(before, before); len(got) != 0 {
+		t.Errorf("nothing changed but got %+v", got)
+	}
+}
@@ -1,48 +1,64 @@
 package services
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/grounded042/capacious/entities"
 	"github.com/grounded042/capacious/utils"
 )
 
+// the limits on the tags admins give invitees
+const (
+	maxTagLength = 64
+	maxTags      = 20
+)
+
 type inviteeGateway interface {
-	// GetAllInvitees gets all of the invitees in
-	// the db for a specified event.
-	// TODO: this will need to pagination at some point
-	GetAllInviteesForEvent(string, int, int) ([]entities.Invitee, error)
+	// GetAllInviteesForEvent gets a page of the invitees of an event that
+	// match a filter, in the order it asks for
+	GetAllInviteesForEvent(ctx context.Context, eventID string, filter entities.InviteeFilter, page entities.Page) ([]entities.Invitee, entities.PageBounds, error)
 	// CreateInvitee creates an invitee from a supplied
 	// invitee object
-	CreateInvitee(*entities.Invitee) error
+	CreateInvitee(context.Context, *entities.Invitee) error
 	// GetInviteeFromId fetches an invitee from the database
 	// based on the supplied id
-	GetInviteeFromID(string) (entities.Invitee, error)
+	GetInviteeFromID(context.Context, string) (entities.Invitee, error)
 	// GetInviteeFriendFromId fetches an invitee friend from the
 	// database based on the supplied id
-	GetInviteeFriendFromID(string) (entities.InviteeFriend, error)
+	GetInviteeFriendFromID(context.Context, string) (entities.InviteeFriend, error)
 	// UpdateInvitee updates an invitee in the database
 	// with info from the passed in object
-	UpdateInvitee(entities.Invitee) error
+	UpdateInvitee(context.Context, entities.Invitee) error
 	// CreateInviteeFriend create and invitee guest from
 	// a supplied invitee guest object
-	CreateInviteeFriend(*entities.InviteeFriend) error
+	CreateInviteeFriend(context.Context, *entities.InviteeFriend) error
 	// UpdateInviteeFriend updates an invitee guest in the
 	// database with info from the passed in object
-	UpdateInviteeFriend(entities.InviteeFriend) error
+	UpdateInviteeFriend(context.Context, entities.InviteeFriend) error
 	// SetGuestMenuChoices sets the menu choices with the
 	// supplied choices for the supplied guest id
-	SetGuestMenuChoices(string, []entities.MenuChoice) ([]entities.MenuChoice, error)
+	SetGuestMenuChoices(context.Context, string, []entities.MenuChoice) ([]entities.MenuChoice, error)
 	// SetGuestMenuNote sets the menu note for a guest
 	// based on the supplied guest id
-	SetGuestMenuNote(string, entities.MenuNote) (entities.MenuNote, error)
+	SetGuestMenuNote(context.Context, string, entities.MenuNote) (entities.MenuNote, error)
 	// SetInviteeSeatingRequests sets the invitee seating requests for an invitee
 	// based on the suppllied invitee id
-	SetInviteeSeatingRequests(string, []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, error)
+	SetInviteeSeatingRequests(context.Context, string, []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, error)
 	// GetSeatingRequestInviteesForEvent gets a list of invitees that only includes the needed info for
 	// seating requests
-	GetSeatingRequestInviteesForEvent(string) ([]entities.Invitee, error)
-	// GetNumberOfInviteesForEvent gets the number of invitees for the supplied
-	// event id
-	GetNumberOfInviteesForEvent(string) int
+	GetSeatingRequestInviteesForEvent(context.Context, string) ([]entities.Invitee, error)
+	// GetNumberOfInviteesForEvent counts the invitees of an event that match
+	// a filter
+	GetNumberOfInviteesForEvent(ctx context.Context, eventID string, filter entities.InviteeFilter) (int, error)
+	// SetInviteeTags replaces the tags of an invitee
+	SetInviteeTags(ctx context.Context, inviteeID string, tags []string) error
+	// BumpInviteeVersion adds one to the version of an invitee, if it is at
+	// the supplied version when that isn't 0
+	BumpInviteeVersion(ctx context.Context, inviteeID string, version int) (bool, error)
 }
 
 // the invitee is a subset of the event object -
@@ -56,22 +72,51 @@ func newInviteeService(newDa inviteeGateway) inviteeService {
 	}
 }
 
-func (is inviteeService) GetInviteesForEvent(eventId string, p *PaginationService) ([]entities.Invitee, utils.Error) {
-	invitees, err := is.da.GetAllInviteesForEvent(eventId, p.GetStartNumber(), p.GetSize())
-
-	p.SetNumItems(is.da.GetNumberOfInviteesForEvent(eventId))
+// GetInviteesForEvent gets the page p asks for of the invitees of the event
+// with the id eventID that match filter. p is given the number of them and
+// where the page is.
+func (is inviteeService) GetInviteesForEvent(ctx context.Context, eventID string, filter entities.InviteeFilter, p *PaginationService) ([]entities.Invitee, utils.Error) {
+	count, err := is.da.GetNumberOfInviteesForEvent(ctx, eventID, filter)
 
 	if err != nil {
 		return []entities.Invitee{}, utils.NewApiError(500, err.Error())
 	}
 
+	p.SetNumItems(count)
+
+	invitees, bounds, err := is.da.GetAllInviteesForEvent(ctx, eventID, filter, p.GetPage())
+
+	if err != nil {
+		return []entities.Invitee{}, pageError(err)
+	}
+
+	p.SetBounds(bounds)
+
 	return invitees, nil
 }
 
-func (is inviteeService) CreateInviteeForEvent(invitee *entities.Invitee, event entities.Event) utils.Error {
+func (is inviteeService) CreateInviteeForEvent(ctx context.Context, invitee *entities.Invitee, event entities.Event) utils.Error {
 	invitee.FkEventID = event.EventID
 
-	err := is.da.CreateInvitee(invitee)
+	tags, uErr := normalizeTags(invitee.Tags)
+
+	if uErr != nil {
+		return uErr
+	}
+
+	invitee.Tags = tags
+
+	if err := newGuestRSVP(&invitee.Self); err != nil {
+		return err
+	}
+
+	for i := range invitee.Friends {
+		if err := newGuestRSVP(&invitee.Friends[i].Self); err != nil {
+			return err
+		}
+	}
+
+	err := is.da.CreateInvitee(ctx, invitee)
 
 	if err != nil {
 		return utils.NewApiError(500, err.Error())
@@ -80,8 +125,8 @@ func (is inviteeService) CreateInviteeForEvent(invitee *entities.Invitee, event
 	return nil
 }
 
-func (is inviteeService) GetInviteeFromID(id string) (entities.Invitee, utils.Error) {
-	invitee, err := is.da.GetInviteeFromID(id)
+func (is inviteeService) GetInviteeFromID(ctx context.Context, id string) (entities.Invitee, utils.Error) {
+	invitee, err := is.da.GetInviteeFromID(ctx, id)
 
 	if err != nil {
 		return entities.Invitee{}, utils.NewApiError(500, err.Error())
@@ -90,18 +135,86 @@ func (is inviteeService) GetInviteeFromID(id string) (entities.Invitee, utils.Er
 	return invitee, nil
 }
 
-func (is inviteeService) EditInvitee(updateMe entities.Invitee) utils.Error {
-	err := is.da.UpdateInvitee(updateMe)
+// SetTags replaces the tags of the invitee with the id inviteeID. It returns
+// the tags as they are kept.
+func (is inviteeService) SetTags(ctx context.Context, inviteeID string, tags []string) ([]string, utils.Error) {
+	tags, uErr := normalizeTags(tags)
+
+	if uErr != nil {
+		return []string{}, uErr
+	}
+
+	if err := is.da.SetInviteeTags(ctx, inviteeID, tags); err != nil {
+		return []string{}, utils.NewApiError(500, err.Error())
+	}
+
+	return tags, nil
+}
+
+// normalizeTags trims and lower cases tags, dropping empty and repeated ones,
+// and sorts them. Tags can be at most maxTagLength characters long and an
+// invitee can have at most maxTags of them.
+func normalizeTags(tags []string) ([]string, utils.Error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, utils.NewApiError(422, fmt.Sprintf("Tags can't be longer than %d characters!", maxTagLength))
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > maxTags {
+		return nil, utils.NewApiError(422, fmt.Sprintf("An invitee can't have more than %d tags!", maxTags))
+	}
+
+	sort.Strings(normalized)
+
+	return normalized, nil
+}
+
+// BumpVersion adds one to the version of the invitee with the id inviteeID. If
+// version isn't 0 the invitee has to be at that version.
+func (is inviteeService) BumpVersion(ctx context.Context, inviteeID string, version int) utils.Error {
+	ok, err := is.da.BumpInviteeVersion(ctx, inviteeID, version)
 
 	if err != nil {
 		return utils.NewApiError(500, err.Error())
+	} else if !ok {
+		return utils.NewApiError(412, "The invitee has been changed since you got it!")
 	}
 
 	return nil
 }
 
-func (is inviteeService) CreateInviteeFriend(friend *entities.InviteeFriend) utils.Error {
-	err := is.da.CreateInviteeFriend(friend)
+func (is inviteeService) EditInvitee(ctx context.Context, updateMe entities.Invitee) utils.Error {
+	err := is.da.UpdateInvitee(ctx, updateMe)
+
+	if err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	rsvpsSubmitted.Inc()
+	inviteeResponses.Inc(updateMe.Self.RSVPStatus)
+
+	return nil
+}
+
+func (is inviteeService) CreateInviteeFriend(ctx context.Context, friend *entities.InviteeFriend) utils.Error {
+	if err := newGuestRSVP(&friend.Self); err != nil {
+		return err
+	}
+
+	err := is.da.CreateInviteeFriend(ctx, friend)
 
 	if err != nil {
 		return utils.NewApiError(500, err.Error())
@@ -110,8 +223,8 @@ func (is inviteeService) CreateInviteeFriend(friend *entities.InviteeFriend) uti
 	return nil
 }
 
-func (is inviteeService) EditInviteeFriend(updateMe entities.InviteeFriend) utils.Error {
-	err := is.da.UpdateInviteeFriend(updateMe)
+func (is inviteeService) EditInviteeFriend(ctx context.Context, updateMe entities.InviteeFriend) utils.Error {
+	err := is.da.UpdateInviteeFriend(ctx, updateMe)
 
 	if err != nil {
 		return utils.NewApiError(500, err.Error())
@@ -120,23 +233,25 @@ func (is inviteeService) EditInviteeFriend(updateMe entities.InviteeFriend) util
 	return nil
 }
 
-func (is inviteeService) SetGuestMenuChoices(guestID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
+func (is inviteeService) SetGuestMenuChoices(ctx context.Context, guestID string, choices []entities.MenuChoice) ([]entities.MenuChoice, utils.Error) {
 	// make sure that the FkGuestId is set correctly
 	for key, _ := range choices {
 		choices[key].FkGuestID = guestID
 	}
 
-	updatedChoices, err := is.da.SetGuestMenuChoices(guestID, choices)
+	updatedChoices, err := is.da.SetGuestMenuChoices(ctx, guestID, choices)
 
 	if err != nil {
 		return []entities.MenuChoice{}, utils.NewApiError(500, err.Error())
 	}
 
+	menuChoicesSet.Inc()
+
 	return updatedChoices, nil
 }
 
-func (is inviteeService) GetInviteeFriendFromID(id string) (entities.InviteeFriend, utils.Error) {
-	iFriend, err := is.da.GetInviteeFriendFromID(id)
+func (is inviteeService) GetInviteeFriendFromID(ctx context.Context, id string) (entities.InviteeFriend, utils.Error) {
+	iFriend, err := is.da.GetInviteeFriendFromID(ctx, id)
 
 	if err != nil {
 		return entities.InviteeFriend{}, utils.NewApiError(500, err.Error())
@@ -145,11 +260,11 @@ func (is inviteeService) GetInviteeFriendFromID(id string) (entities.InviteeFrie
 	return iFriend, nil
 }
 
-func (is inviteeService) SetGuestMenuNote(guestID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
+func (is inviteeService) SetGuestMenuNote(ctx context.Context, guestID string, note entities.MenuNote) (entities.MenuNote, utils.Error) {
 	// make sure that the FkGuestId is set correctly
 	note.FkGuestID = guestID
 
-	updatedNote, err := is.da.SetGuestMenuNote(guestID, note)
+	updatedNote, err := is.da.SetGuestMenuNote(ctx, guestID, note)
 
 	if err != nil {
 		return entities.MenuNote{}, utils.NewApiError(500, err.Error())
@@ -158,7 +273,7 @@ func (is inviteeService) SetGuestMenuNote(guestID string, note entities.MenuNote
 	return updatedNote, nil
 }
 
-func (is inviteeService) SetInviteeSeatingRequests(inviteeID string, requests []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, utils.Error) {
+func (is inviteeService) SetInviteeSeatingRequests(ctx context.Context, inviteeID string, requests []entities.InviteeSeatingRequest) ([]entities.InviteeSeatingRequest, utils.Error) {
 	// make sure that the FkGuestId is set correctly
 	for i := len(requests) - 1; i >= 0; i-- {
 		requests[i].FkInviteeID = inviteeID
@@ -173,7 +288,7 @@ func (is inviteeService) SetInviteeSeatingRequests(inviteeID string, requests []
 		}
 	}
 
-	toReturn, err := is.da.SetInviteeSeatingRequests(inviteeID, requests)
+	toReturn, err := is.da.SetInviteeSeatingRequests(ctx, inviteeID, requests)
 
 	if err != nil {
 		return []entities.InviteeSeatingRequest{}, utils.NewApiError(500, err.Error())
@@ -182,8 +297,8 @@ func (is inviteeService) SetInviteeSeatingRequests(inviteeID string, requests []
 	return toReturn, nil
 }
 
-func (is inviteeService) GetSeatingRequestInviteesForEvent(eventID string) ([]entities.Invitee, utils.Error) {
-	toReturn, err := is.da.GetSeatingRequestInviteesForEvent(eventID)
+func (is inviteeService) GetSeatingRequestInviteesForEvent(ctx context.Context, eventID string) ([]entities.Invitee, utils.Error) {
+	toReturn, err := is.da.GetSeatingRequestInviteesForEvent(ctx, eventID)
 
 	if err != nil {
 		return []entities.Invitee{}, utils.NewApiError(500, err.Error())
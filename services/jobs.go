@@ -0,0 +1,380 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jobs"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/mail"
+)
+
+// the kinds of background jobs
+const (
+	JobSendEmail       = "send_email"
+	JobSendReminders   = "send_reminders"
+	JobSendDigest      = "send_digest"
+	JobSendDigestEmail = "send_digest_email"
+	JobLockRSVPs       = "lock_rsvps"
+)
+
+// ReminderDays is how many days before an event's respond_by its pending
+// invitees are reminded to answer.
+var ReminderDays = 3
+
+// DigestHour is the hour of the day, in the server's time zone, that event
+// admins are sent the RSVPs of the day before.
+var DigestHour = 8
+
+// JobRetention is how long jobs that are done are kept.
+var JobRetention = 30 * 24 * time.Hour
+
+type scheduleGateway interface {
+	// GetEventsDueForReminders gets the open events whose respond_by is
+	// within some days
+	GetEventsDueForReminders(ctx context.Context, days int) ([]entities.Event, error)
+	// GetEventsDueForLocking gets the events whose respond_by has passed but
+	// whose RSVPs aren't locked
+	GetEventsDueForLocking(ctx context.Context) ([]entities.Event, error)
+	// LockEventRSVPs locks the RSVPs of an event whose respond_by has passed
+	LockEventRSVPs(ctx context.Context, eventID string) (bool, error)
+	// GetEventsForDigest gets the events that weren't over by a time
+	GetEventsForDigest(ctx context.Context, since time.Time) ([]entities.Event, error)
+	// GetInviteesRespondedBetween gets the invitees of an event that answered
+	// between two times
+	GetInviteesRespondedBetween(ctx context.Context, eventID string, since time.Time, until time.Time) ([]entities.Invitee, error)
+	// GetEventAdminUsers gets the admins of an event
+	GetEventAdminUsers(ctx context.Context, eventID string) ([]entities.User, error)
+	// GetEventInfo gets an event
+	GetEventInfo(ctx context.Context, eventID string) (entities.Event, error)
+	// PruneJobs deletes the jobs that were done before a time
+	PruneJobs(ctx context.Context, before time.Time) error
+}
+
+type scheduleService struct {
+	da     scheduleGateway
+	mailer *mail.Mailer
+	jobs   jobs.Enqueuer
+}
+
+func newScheduleService(newDa scheduleGateway, mailer *mail.Mailer, enqueuer jobs.Enqueuer) scheduleService {
+	return scheduleService{
+		da:     newDa,
+		mailer: mailer,
+		jobs:   enqueuer,
+	}
+}
+
+// eventJob is the payload of the jobs that are about one event.
+type eventJob struct {
+	EventID string `json:"event_id"`
+}
+
+// digestJob is the payload of a send_digest job: the RSVPs of the day until
+// Until.
+type digestJob struct {
+	EventID string    `json:"event_id"`
+	Until   time.Time `json:"until"`
+}
+
+// digestEmailJob is the payload of a send_digest_email job: a digest for one
+// of the event's admins.
+type digestEmailJob struct {
+	EventID   string `json:"event_id"`
+	UserID    string `json:"user_id"`
+	ToAddress string `json:"to_address"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+}
+
+// EnqueueReminders enqueues a job to remind the pending invitees of each event
+// whose respond_by is coming up. Each event is reminded once for each
+// respond_by it has.
+func (ss scheduleService) EnqueueReminders(ctx context.Context, now time.Time) error {
+	events, err := ss.da.GetEventsDueForReminders(ctx, ReminderDays)
+
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		_, err := ss.jobs.Enqueue(ctx, jobs.NewJob{
+			Kind:      JobSendReminders,
+			Payload:   eventJob{EventID: event.EventID},
+			UniqueKey: "reminders:" + event.EventID + ":" + strconv.FormatInt(event.RespondBy.Unix(), 10),
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnqueueLocks enqueues a job to lock the RSVPs of each event whose respond_by
+// has passed.
+func (ss scheduleService) EnqueueLocks(ctx context.Context, now time.Time) error {
+	events, err := ss.da.GetEventsDueForLocking(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		_, err := ss.jobs.Enqueue(ctx, jobs.NewJob{
+			Kind:      JobLockRSVPs,
+			Payload:   eventJob{EventID: event.EventID},
+			UniqueKey: "lock:" + event.EventID + ":" + strconv.FormatInt(event.RespondBy.Unix(), 10),
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnqueueDigests enqueues a job to send the admins of each event that isn't
+// over the day's digest, once DigestHour has come.
+func (ss scheduleService) EnqueueDigests(ctx context.Context, now time.Time) error {
+	if now.Hour() < DigestHour {
+		return nil
+	}
+
+	until := time.Date(now.Year(), now.Month(), now.Day(), DigestHour, 0, 0, 0, now.Location())
+
+	events, err := ss.da.GetEventsForDigest(ctx, until.AddDate(0, 0, -1))
+
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		_, err := ss.jobs.Enqueue(ctx, jobs.NewJob{
+			Kind:      JobSendDigest,
+			Payload:   digestJob{EventID: event.EventID, Until: until},
+			UniqueKey: "digest:" + event.EventID + ":" + until.Format("2006-01-02"),
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prune deletes the jobs that have been done for longer than JobRetention.
+func (ss scheduleService) Prune(ctx context.Context, now time.Time) error {
+	return ss.da.PruneJobs(ctx, now.Add(-JobRetention))
+}
+
+// LockRSVPs runs a lock_rsvps job.
+func (ss scheduleService) LockRSVPs(ctx context.Context, job jobs.Job) error {
+	var payload eventJob
+
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	locked, err := ss.da.LockEventRSVPs(ctx, payload.EventID)
+
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		logging.FromContext(ctx).Info("locked RSVPs", "event_id", payload.EventID)
+	}
+
+	return nil
+}
+
+// SendDigest runs a send_digest job: it writes the digest of the invitees of
+// the event that answered in the day before the digest's time and enqueues a
+// job to email it to each of the event's admins, so one admin's email failing
+// doesn't send it again to the others. Nothing is sent for a day without
+// answers.
+func (ss scheduleService) SendDigest(ctx context.Context, job jobs.Job) error {
+	var payload digestJob
+
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	since := payload.Until.AddDate(0, 0, -1)
+
+	invitees, err := ss.da.GetInviteesRespondedBetween(ctx, payload.EventID, since, payload.Until)
+
+	if err != nil || len(invitees) == 0 {
+		return err
+	}
+
+	event, err := ss.da.GetEventInfo(ctx, payload.EventID)
+
+	if err != nil {
+		return err
+	}
+
+	admins, err := ss.da.GetEventAdminUsers(ctx, payload.EventID)
+
+	if err != nil {
+		return err
+	}
+
+	subject, body := digestEmail(event, invitees, since)
+
+	for _, admin := range admins {
+		if _, err := netmail.ParseAddress(admin.Email); err != nil {
+			logging.FromContext(ctx).Warn("can't send digest to admin", "user_id", admin.UserID, "error", err)
+			continue
+		}
+
+		// a retry of this job doesn't enqueue the admins it already did again
+		_, err := ss.jobs.Enqueue(ctx, jobs.NewJob{
+			Kind: JobSendDigestEmail,
+			Payload: digestEmailJob{
+				EventID:   payload.EventID,
+				UserID:    admin.UserID,
+				ToAddress: admin.Email,
+				Subject:   subject,
+				Body:      body,
+			},
+			UniqueKey:   "digest:" + payload.EventID + ":" + payload.Until.Format("2006-01-02") + ":" + admin.UserID,
+			MaxAttempts: emailMaxAttempts,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendDigestEmail runs a send_digest_email job: it emails a digest to one
+// admin.
+func (ss scheduleService) SendDigestEmail(ctx context.Context, job jobs.Job) error {
+	var payload digestEmailJob
+
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	to, err := netmail.ParseAddress(payload.ToAddress)
+
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	defer cancel()
+
+	return ss.mailer.Send(sendCtx, *to, payload.Subject, payload.Body)
+}
+
+// digestEmail writes the digest of the invitees of event that answered since
+// since.
+func digestEmail(event entities.Event, invitees []entities.Invitee, since time.Time) (string, string) {
+	subject := fmt.Sprintf("%d new RSVPs for %s", len(invitees), event.Name)
+
+	if len(invitees) == 1 {
+		subject = fmt.Sprintf("1 new RSVP for %s", event.Name)
+	}
+
+	var body strings.Builder
+
+	fmt.Fprintf(&body, "These invitees answered for %s since %s:\n\n", event.Name, since.Format("Mon Jan 2 15:04"))
+
+	for _, invitee := range invitees {
+		name := strings.TrimSpace(invitee.Self.FirstName + " " + invitee.Self.LastName)
+
+		fmt.Fprintf(&body, "- %s: %s", name, emailAnswers[invitee.Self.RSVPStatus])
+
+		if len(invitee.Friends) > 0 {
+			fmt.Fprintf(&body, " (with %d friends)", len(invitee.Friends))
+		}
+
+		body.WriteString("\n")
+	}
+
+	return subject, body.String()
+}
+
+// background jobs coordination
+
+// JobHandlers gets the handlers of the kinds of background jobs, for the
+// workers that run them.
+func (c Coordinator) JobHandlers() map[string]jobs.Handler {
+	return map[string]jobs.Handler{
+		JobSendEmail:       c.mail.deliver,
+		JobSendReminders:   c.sendReminders,
+		JobSendDigest:      c.schedule.SendDigest,
+		JobSendDigestEmail: c.schedule.SendDigestEmail,
+		JobLockRSVPs:       c.schedule.LockRSVPs,
+		JobDeliverWebhook:  c.webhooks.deliver,
+	}
+}
+
+// ScheduledTasks gets the tasks that enqueue background jobs as time passes,
+// for the scheduler.
+func (c Coordinator) ScheduledTasks() map[string]jobs.Task {
+	return map[string]jobs.Task{
+		"reminders": c.schedule.EnqueueReminders,
+		"digests":   c.schedule.EnqueueDigests,
+		"locks":     c.schedule.EnqueueLocks,
+		"prune":     c.schedule.Prune,
+	}
+}
+
+// sendReminders runs a send_reminders job: it sends the reminder email to the
+// event's invitees that haven't answered yet, unless its RSVPs were locked in
+// the meantime.
+func (c Coordinator) sendReminders(ctx context.Context, job jobs.Job) error {
+	var payload eventJob
+
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	event, err := c.events.GetEventInfo(ctx, payload.EventID)
+
+	if err != nil {
+		return err
+	}
+
+	if event.RSVPsLockedAt != nil {
+		return nil
+	}
+
+	pending, err := c.mail.GetInviteesMatching(ctx, payload.EventID, entities.InviteeFilter{RSVP: entities.RSVPPending})
+
+	if err != nil {
+		return err
+	}
+
+	invitees := []entities.Invitee{}
+
+	for _, invitee := range pending {
+		if invitee.Email != "" {
+			invitees = append(invitees, invitee)
+		}
+	}
+
+	if len(invitees) == 0 {
+		return nil
+	}
+
+	if _, err := c.sendEmails(ctx, payload.EventID, entities.EmailReminder, invitees); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,408 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/audit"
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jobs"
+	"github.com/grounded042/capacious/mail"
+	"github.com/grounded042/capacious/utils"
+)
+
+// fakeEnqueuer records the jobs enqueued with it, only once for each
+// UniqueKey like the job store.
+type fakeEnqueuer struct {
+	jobs []jobs.NewJob
+	keys map[string]bool
+}
+
+func (f *fakeEnqueuer) Enqueue(ctx context.Context, job jobs.NewJob) (bool, error) {
+	if f.keys == nil {
+		f.keys = map[string]bool{}
+	}
+
+	if job.UniqueKey != "" && f.keys[job.UniqueKey] {
+		return false, nil
+	}
+
+	f.keys[job.UniqueKey] = true
+	f.jobs = append(f.jobs, job)
+
+	return true, nil
+}
+
+func (f *fakeEnqueuer) uniqueKeys() []string {
+	var keys []string
+
+	for _, job := range f.jobs {
+		keys = append(keys, job.UniqueKey)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// fakeTransport records the messages sent through it, failing for the
+// addresses in fail.
+type fakeTransport struct {
+	sent []mail.Message
+	fail map[string]bool
+}
+
+func (f *fakeTransport) Send(ctx context.Context, msg mail.Message) error {
+	if f.fail[msg.To.Address] {
+		return errors.New("mailbox unavailable")
+	}
+
+	f.sent = append(f.sent, msg)
+
+	return nil
+}
+
+// fakeScheduleGateway has the events due for reminders, locks and digests
+// and their admins. Its other methods aren't used.
+type fakeScheduleGateway struct {
+	scheduleGateway
+	due       []entities.Event
+	event     entities.Event
+	admins    []entities.User
+	responded []entities.Invitee
+}
+
+func (f fakeScheduleGateway) GetEventsDueForReminders(ctx context.Context, days int) ([]entities.Event, error) {
+	return f.due, nil
+}
+
+func (f fakeScheduleGateway) GetEventsDueForLocking(ctx context.Context) ([]entities.Event, error) {
+	return f.due, nil
+}
+
+func (f fakeScheduleGateway) GetEventsForDigest(ctx context.Context, since time.Time) ([]entities.Event, error) {
+	return f.due, nil
+}
+
+func (f fakeScheduleGateway) GetEventInfo(ctx context.Context, eventID string) (entities.Event, error) {
+	return f.event, nil
+}
+
+func (f fakeScheduleGateway) GetEventAdminUsers(ctx context.Context, eventID string) ([]entities.User, error) {
+	return f.admins, nil
+}
+
+func (f fakeScheduleGateway) GetInviteesRespondedBetween(ctx context.Context, eventID string, since time.Time, until time.Time) ([]entities.Invitee, error) {
+	return f.responded, nil
+}
+
+// mustJob builds the job a worker claims with payload.
+func mustJob(t *testing.T, kind string, payload interface{}) jobs.Job {
+	t.Helper()
+
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jobs.Job{Kind: kind, Payload: b, Attempt: 1, MaxAttempts: 3}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// errCode is the status code of err, or 0 if there isn't one.
+func errCode(err utils.Error) int {
+	if err == nil {
+		return 0
+	}
+
+	return err.Code()
+}
+
+var respondBy = time.Date(2024, 6, 1, 17, 0, 0, 0, time.UTC)
+
+func TestEnqueueRemindersAndLocksOncePerRespondBy(t *testing.T) {
+	enqueuer := &fakeEnqueuer{}
+	ss := newScheduleService(fakeScheduleGateway{
+		due: []entities.Event{
+			{EventID: "a", RespondBy: respondBy},
+			{EventID: "b", RespondBy: respondBy},
+		},
+	}, nil, enqueuer)
+
+	ctx := context.Background()
+
+	// the scheduler runs its tasks again and again
+	for i := 0; i < 3; i++ {
+		if err := ss.EnqueueReminders(ctx, respondBy.AddDate(0, 0, -1)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ss.EnqueueLocks(ctx, respondBy.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"lock:a:1717261200",
+		"lock:b:1717261200",
+		"reminders:a:1717261200",
+		"reminders:b:1717261200",
+	}
+
+	if got := enqueuer.uniqueKeys(); !equalStrings(got, want) {
+		t.Errorf("enqueued %v, want %v", got, want)
+	}
+
+	// a new respond_by is reminded and locked again
+	ss.da = fakeScheduleGateway{due: []entities.Event{{EventID: "a", RespondBy: respondBy.AddDate(0, 0, 7)}}}
+
+	ss.EnqueueReminders(ctx, respondBy)
+	ss.EnqueueLocks(ctx, respondBy)
+
+	if len(enqueuer.jobs) != 6 {
+		t.Errorf("enqueued %v after respond_by changed, want 6 jobs", enqueuer.uniqueKeys())
+	}
+}
+
+func TestEnqueueDigestsFromDigestHour(t *testing.T) {
+	enqueuer := &fakeEnqueuer{}
+	ss := newScheduleService(fakeScheduleGateway{due: []entities.Event{{EventID: "a"}}}, nil, enqueuer)
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	ss.EnqueueDigests(context.Background(), day.Add(time.Duration(DigestHour)*time.Hour-time.Minute))
+
+	if len(enqueuer.jobs) != 0 {
+		t.Fatalf("enqueued %v before the digest hour", enqueuer.uniqueKeys())
+	}
+
+	ss.EnqueueDigests(context.Background(), day.Add(time.Duration(DigestHour)*time.Hour))
+	ss.EnqueueDigests(context.Background(), day.Add(23*time.Hour))
+
+	if got, want := enqueuer.uniqueKeys(), []string{"digest:a:2024-06-01"}; !equalStrings(got, want) {
+		t.Errorf("enqueued %v, want %v", got, want)
+	}
+}
+
+func TestSendDigestEnqueuesAnEmailForEachAdmin(t *testing.T) {
+	enqueuer := &fakeEnqueuer{}
+	transport := &fakeTransport{fail: map[string]bool{"b@example.com": true}}
+	mailer := mail.NewMailer(transport, mail.Config{})
+
+	ss := newScheduleService(fakeScheduleGateway{
+		event: entities.Event{EventID: "a", Name: "Party"},
+		admins: []entities.User{
+			{UserID: "1", Email: "a@example.com"},
+			{UserID: "2", Email: "b@example.com"},
+			{UserID: "3", Email: "not an address"},
+		},
+		responded: []entities.Invitee{
+			{Self: entities.Guest{FirstName: "Sam", RSVPStatus: entities.RSVPAttending}},
+		},
+	}, mailer, enqueuer)
+
+	ctx := context.Background()
+	job := mustJob(t, JobSendDigest, digestJob{EventID: "a", Until: respondBy})
+
+	// a retry doesn't enqueue the emails again
+	for i := 0; i < 2; i++ {
+		if err := ss.SendDigest(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"digest:a:2024-06-01:1", "digest:a:2024-06-01:2"}
+
+	if got := enqueuer.uniqueKeys(); !equalStrings(got, want) {
+		t.Fatalf("enqueued %v, want %v", got, want)
+	}
+
+	var failed int
+
+	for _, newJob := range enqueuer.jobs {
+		if err := ss.SendDigestEmail(ctx, mustJob(t, newJob.Kind, newJob.Payload)); err != nil {
+			failed++
+		}
+	}
+
+	// one admin's email failing doesn't stop the others getting theirs
+	if failed != 1 || len(transport.sent) != 1 || transport.sent[0].To.Address != "a@example.com" {
+		t.Errorf("sent %v with %d failed, want a@example.com sent and 1 failed", transport.sent, failed)
+	}
+}
+
+func TestSendDigestWithoutAnswers(t *testing.T) {
+	enqueuer := &fakeEnqueuer{}
+	ss := newScheduleService(fakeScheduleGateway{
+		admins: []entities.User{{UserID: "1", Email: "a@example.com"}},
+	}, nil, enqueuer)
+
+	if err := ss.SendDigest(context.Background(), mustJob(t, JobSendDigest, digestJob{EventID: "a", Until: respondBy})); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(enqueuer.jobs) != 0 {
+		t.Errorf("enqueued %v for a day without answers", enqueuer.uniqueKeys())
+	}
+}
+
+// fakeEventsGateway has one event, with the admins in admins.
+type fakeEventsGateway struct {
+	eventsGateway
+	event  entities.Event
+	admins map[string]bool
+}
+
+func (f fakeEventsGateway) GetEventInfo(ctx context.Context, eventID string) (entities.Event, error) {
+	return f.event, nil
+}
+
+func (f fakeEventsGateway) GetMenuItemsForEvent(ctx context.Context, eventID string) ([]entities.MenuItem, error) {
+	return nil, nil
+}
+
+func (f fakeEventsGateway) GetEventAdminRecordForUserAndEventID(ctx context.Context, userID string, eventID string) (entities.EventAdmin, error) {
+	if eventID != f.event.EventID || !f.admins[userID] {
+		return entities.EventAdmin{}, errors.New("record not found")
+	}
+
+	return entities.EventAdmin{EventAdminID: "admin-" + userID, FkUserID: userID, FkEventID: eventID}, nil
+}
+
+// fakeMailGateway has the invitees of an event and records deliveries.
+type fakeMailGateway struct {
+	mailGateway
+	invitees   []entities.Invitee
+	deliveries []*entities.EmailDelivery
+}
+
+func (f *fakeMailGateway) GetEmailTemplatesForEvent(ctx context.Context, eventID string) ([]entities.EmailTemplate, error) {
+	return nil, nil
+}
+
+func (f *fakeMailGateway) GetInviteesMatching(ctx context.Context, eventID string, filter entities.InviteeFilter) ([]entities.Invitee, error) {
+	var matching []entities.Invitee
+
+	for _, invitee := range f.invitees {
+		if filter.RSVP == "" || invitee.Self.RSVPStatus == filter.RSVP {
+			matching = append(matching, invitee)
+		}
+	}
+
+	return matching, nil
+}
+
+func (f *fakeMailGateway) CreateEmailDeliveries(ctx context.Context, deliveries []*entities.EmailDelivery) error {
+	for i, delivery := range deliveries {
+		delivery.EmailDeliveryID = delivery.FkInviteeID + "-" + string(rune('0'+i))
+	}
+
+	f.deliveries = append(f.deliveries, deliveries...)
+
+	return nil
+}
+
+func TestSendRemindersToPendingInviteesWithEmails(t *testing.T) {
+	event := entities.Event{EventID: "a", Name: "Party", RespondBy: respondBy}
+	enqueuer := &fakeEnqueuer{}
+	mails := &fakeMailGateway{
+		invitees: []entities.Invitee{
+			{InviteeID: "pending", Email: "pending@example.com", Self: entities.Guest{RSVPStatus: entities.RSVPPending}},
+			{InviteeID: "no-email", Self: entities.Guest{RSVPStatus: entities.RSVPPending}},
+			{InviteeID: "attending", Email: "attending@example.com", Self: entities.Guest{RSVPStatus: entities.RSVPAttending}},
+		},
+	}
+
+	c := Coordinator{
+		events: newEventsService(fakeEventsGateway{event: event}),
+		mail:   newMailService(mails, mail.NewMailer(&fakeTransport{}, mail.Config{}), enqueuer),
+	}
+
+	job := mustJob(t, JobSendReminders, eventJob{EventID: "a"})
+
+	if err := c.sendReminders(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mails.deliveries) != 1 || mails.deliveries[0].FkInviteeID != "pending" || mails.deliveries[0].Kind != entities.EmailReminder {
+		t.Errorf("deliveries = %v, want a reminder to the pending invitee", mails.deliveries)
+	}
+
+	if len(enqueuer.jobs) != 1 || enqueuer.jobs[0].Kind != JobSendEmail {
+		t.Errorf("enqueued %v, want one email", enqueuer.jobs)
+	}
+
+	// nobody is reminded once the RSVPs are locked
+	locked := respondBy
+	event.RSVPsLockedAt = &locked
+	c.events = newEventsService(fakeEventsGateway{event: event})
+	mails.deliveries = nil
+
+	if err := c.sendReminders(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mails.deliveries) != 0 {
+		t.Errorf("deliveries = %v for an event whose RSVPs are locked", mails.deliveries)
+	}
+}
+
+func TestCheckRSVPsOpen(t *testing.T) {
+	locked := respondBy
+	event := entities.Event{EventID: "a", RSVPsLockedAt: &locked}
+
+	c := Coordinator{
+		events: newEventsService(fakeEventsGateway{event: event, admins: map[string]bool{"admin": true}}),
+	}
+
+	ctx := context.Background()
+	admin := audit.NewContext(ctx, audit.Actor{Type: audit.ActorUser, ID: "admin"})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want int
+	}{
+		{"an invitee", audit.NewContext(ctx, audit.Actor{Type: audit.ActorInvitee, ID: "i"}), 403},
+		{"without a credential", ctx, 403},
+		{"a user who isn't an admin", audit.NewContext(ctx, audit.Actor{Type: audit.ActorUser, ID: "someone"}), 403},
+		{"an admin", admin, 0},
+		{"an admin's key that can write invitees", apikeys.NewContext(ctx, apikeys.Principal{UserID: "admin", EventID: "a", Scopes: []apikeys.Scope{apikeys.WriteInvitees}}), 0},
+		{"an admin's key that can only read", apikeys.NewContext(ctx, apikeys.Principal{UserID: "admin", EventID: "a", Scopes: []apikeys.Scope{apikeys.ReadInvitees, apikeys.ReadReports}}), 403},
+		{"an admin's key for another event", apikeys.NewContext(ctx, apikeys.Principal{UserID: "admin", EventID: "b", Scopes: []apikeys.Scope{apikeys.WriteInvitees}}), 403},
+	}
+
+	for _, tt := range tests {
+		err := c.checkRSVPsOpen(tt.ctx, event)
+
+		if got := errCode(err); got != tt.want {
+			t.Errorf("%s: got %d, want %d (%v)", tt.name, got, tt.want, err)
+		}
+	}
+
+	// anyone can change the invitees of an event whose RSVPs are open
+	event.RSVPsLockedAt = nil
+
+	if err := c.checkRSVPsOpen(ctx, event); err != nil {
+		t.Errorf("open event: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+
+	"github.com/grounded042/capacious/apikeys"
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/live"
+	"github.com/grounded042/capacious/utils"
+)
+
+type liveGateway interface {
+	// ListenForChanges hands the changes the database announces to publish,
+	// and calls resync when some may have been missed
+	ListenForChanges(ctx context.Context, publish func(live.Change), resync func()) error
+	// GetInviteesOfEvent gets those of some invitees that are still invitees
+	// of an event
+	GetInviteesOfEvent(ctx context.Context, eventID string, ids []string) ([]entities.Invitee, error)
+}
+
+type liveService struct {
+	da  liveGateway
+	hub *live.Hub
+}
+
+func newLiveService(newDa liveGateway) liveService {
+	return liveService{
+		da:  newDa,
+		hub: live.NewHub(),
+	}
+}
+
+// SubscribeToEvent subscribes to the changes to the event with the id eventID,
+// for the specified userID if they are an admin of it. The subscription has to
+// be closed when done with.
+func (c Coordinator) SubscribeToEvent(ctx context.Context, eventID string, userID string) (*live.Subscription, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to follow the changes to this event!")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.live.hub.Subscribe(eventID), nil
+}
+
+// ListenForChanges passes the changes the database announces on to the
+// subscriptions to them until ctx is done. Every instance of the server
+// listens, so the admins connected to any of them see every change.
+func (c Coordinator) ListenForChanges(ctx context.Context) error {
+	return c.live.da.ListenForChanges(ctx, c.live.hub.Publish, c.live.hub.Resync)
+}
+
+// CloseSubscriptions ends every subscription to changes, so whoever is
+// following them stops.
+func (c Coordinator) CloseSubscriptions() {
+	c.live.hub.Close()
+}
+
+// CanFollowInvitees checks whether the request carried by ctx can be sent the
+// invitees that change, rather than only the stats. API keys need the
+// invitees:read scope for that.
+func (c Coordinator) CanFollowInvitees(ctx context.Context) bool {
+	p, ok := apikeys.FromContext(ctx)
+
+	return !ok || p.Has(apikeys.ReadInvitees)
+}
+
+// GetChangedInvitees gets those of the invitees with the ids ids that are
+// still invitees of the event with the id eventID. Whoever asks has to have
+// subscribed to the event and be able to follow its invitees.
+func (c Coordinator) GetChangedInvitees(ctx context.Context, eventID string, ids []string) ([]entities.Invitee, utils.Error) {
+	if !c.CanFollowInvitees(ctx) {
+		return []entities.Invitee{}, utils.NewApiError(403, "This API key can't read invitees!")
+	}
+
+	invitees, err := c.live.da.GetInviteesOfEvent(ctx, eventID, ids)
+
+	if err != nil {
+		return []entities.Invitee{}, utils.NewApiError(500, err.Error())
+	}
+
+	return invitees, nil
+}
@@ -0,0 +1,529 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	netmail "net/mail"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jobs"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/mail"
+	"github.com/grounded042/capacious/utils"
+)
+
+// how long the transport has to take one email
+const emailSendTimeout = 30 * time.Second
+
+// how many times sending an email is tried before it is failed
+const emailMaxAttempts = 5
+
+type mailGateway interface {
+	// GetEmailTemplatesForEvent gets the email templates an event's admins
+	// have changed
+	GetEmailTemplatesForEvent(ctx context.Context, eventID string) ([]entities.EmailTemplate, error)
+	// SaveEmailTemplate creates or replaces the email template of its kind
+	// for its event
+	SaveEmailTemplate(ctx context.Context, template entities.EmailTemplate) error
+	// DeleteEmailTemplate deletes an event's email template of one kind
+	DeleteEmailTemplate(ctx context.Context, eventID string, kind string) error
+	// GetInviteesMatching gets all the invitees of an event that match a
+	// filter
+	GetInviteesMatching(ctx context.Context, eventID string, filter entities.InviteeFilter) ([]entities.Invitee, error)
+	// CreateEmailDeliveries records deliveries, all or none of them
+	CreateEmailDeliveries(ctx context.Context, deliveries []*entities.EmailDelivery) error
+	// SetEmailDeliveryStatus records how a delivery went
+	SetEmailDeliveryStatus(ctx context.Context, deliveryID string, status string, reason string) error
+	// GetEmailDeliveriesForEvent gets a page of the email deliveries of an
+	// event that match a filter
+	GetEmailDeliveriesForEvent(ctx context.Context, eventID string, filter entities.EmailDeliveryFilter, page entities.Page) ([]entities.EmailDelivery, entities.PageBounds, error)
+	// GetNumberOfEmailDeliveriesForEvent counts the email deliveries of an
+	// event that match a filter
+	GetNumberOfEmailDeliveriesForEvent(ctx context.Context, eventID string, filter entities.EmailDeliveryFilter) (int, error)
+}
+
+type mailService struct {
+	da     mailGateway
+	mailer *mail.Mailer
+	jobs   jobs.Enqueuer
+}
+
+// EmailTemplate is an email template of an event, and whether it is the
+// default one because the event's admins haven't changed it.
+type EmailTemplate struct {
+	entities.EmailTemplate
+	Default bool `json:"default"`
+}
+
+// the templates used for the kinds of emails until an event's admins change
+// them
+var defaultEmailTemplates = map[string]entities.EmailTemplate{
+	entities.EmailInvitation: {
+		Subject: `You're invited to {{.Event.Name}}`,
+		Body: `Hi {{.FirstName}},
+
+You're invited to {{.Event.Name}}{{with .Event.Location}} at {{.}}{{end}} on {{.Event.StartTime.Format "Monday, January 2, 2006 at 3:04 PM MST"}}.
+{{with .Event.Description}}
+{{.}}
+{{end}}
+Please let us know if you can make it by {{.Event.RespondBy.Format "January 2, 2006"}}:
+
+{{.RSVPLink}}
+`,
+	},
+	entities.EmailRSVPConfirmation: {
+		Subject: `Your RSVP for {{.Event.Name}}`,
+		Body: `Hi {{.FirstName}},
+
+Thanks for letting us know. We have:
+
+{{range .Guests}}- {{.Name}}: {{.Answer}}
+{{end}}
+You can change your answer until {{.Event.RespondBy.Format "January 2, 2006"}}:
+
+{{.RSVPLink}}
+`,
+	},
+	entities.EmailMenuConfirmation: {
+		Subject: `Your menu choices for {{.Event.Name}}`,
+		Body: `Hi {{.FirstName}},
+
+These are the menu choices we have for you:
+{{range .Guests}}{{if .Menu}}
+{{.Name}}:
+{{range .Menu}}  - {{.Item}}: {{.Option}}
+{{end}}{{end}}{{end}}
+You can change them until {{.Event.RespondBy.Format "January 2, 2006"}}:
+
+{{.RSVPLink}}
+`,
+	},
+	entities.EmailReminder: {
+		Subject: `Will you be at {{.Event.Name}}?`,
+		Body: `Hi {{.FirstName}},
+
+We haven't heard from you yet about {{.Event.Name}} on {{.Event.StartTime.Format "Monday, January 2, 2006"}}. Please let us know if you can make it by {{.Event.RespondBy.Format "January 2, 2006"}}:
+
+{{.RSVPLink}}
+`,
+	},
+}
+
+// emailData are the merge fields of an email to an invitee. FirstName and
+// LastName are the invitee's own.
+type emailData struct {
+	Event      entities.Event
+	Invitee    entities.Invitee
+	FirstName  string
+	LastName   string
+	RSVPStatus string
+	RSVPLink   string
+	Guests     []emailGuest
+}
+
+// emailGuest is the invitee or one of their friends, with their answer said
+// the way people say it and their menu choices by name.
+type emailGuest struct {
+	Name       string
+	RSVPStatus string
+	Answer     string
+	Menu       []emailMenuChoice
+}
+
+type emailMenuChoice struct {
+	Item   string
+	Option string
+}
+
+var emailAnswers = map[string]string{
+	entities.RSVPPending:   "hasn't answered yet",
+	entities.RSVPAttending: "attending",
+	entities.RSVPDeclined:  "not attending",
+	entities.RSVPMaybe:     "might come",
+}
+
+// compiledEmail is an email template ready to be rendered.
+type compiledEmail struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newMailService(newDa mailGateway, mailer *mail.Mailer, enqueuer jobs.Enqueuer) mailService {
+	return mailService{
+		da:     newDa,
+		mailer: mailer,
+		jobs:   enqueuer,
+	}
+}
+
+// GetTemplates gets the email templates of the event with the id eventID, the
+// default one for any kind its admins haven't changed.
+func (ms mailService) GetTemplates(ctx context.Context, eventID string) ([]EmailTemplate, utils.Error) {
+	saved, err := ms.da.GetEmailTemplatesForEvent(ctx, eventID)
+
+	if err != nil {
+		return []EmailTemplate{}, utils.NewApiError(500, err.Error())
+	}
+
+	byKind := make(map[string]entities.EmailTemplate, len(saved))
+
+	for _, t := range saved {
+		byKind[t.Kind] = t
+	}
+
+	templates := make([]EmailTemplate, 0, len(entities.EmailKinds))
+
+	for _, kind := range entities.EmailKinds {
+		t, ok := byKind[kind]
+
+		if !ok {
+			t = defaultEmailTemplates[kind]
+			t.FkEventID = eventID
+			t.Kind = kind
+		}
+
+		templates = append(templates, EmailTemplate{EmailTemplate: t, Default: !ok})
+	}
+
+	return templates, nil
+}
+
+// SaveTemplate replaces the event's email template of its kind. It has to
+// render for event, or a 422 says why it doesn't.
+func (ms mailService) SaveTemplate(ctx context.Context, event entities.Event, t entities.EmailTemplate) (EmailTemplate, utils.Error) {
+	if !entities.IsEmailKind(t.Kind) {
+		return EmailTemplate{}, utils.NewApiError(404, "There is no email of that kind!")
+	}
+
+	if strings.TrimSpace(t.Subject) == "" || strings.TrimSpace(t.Body) == "" {
+		return EmailTemplate{}, utils.NewApiError(422, "An email template needs a subject and a body!")
+	}
+
+	compiled, err := compileEmail(t)
+
+	if err != nil {
+		return EmailTemplate{}, utils.NewApiError(422, err.Error())
+	}
+
+	// try it on an invitee with a friend so mistakes show up now rather than
+	// when it is sent
+	sample := entities.Invitee{
+		InviteeID: "00000000-0000-0000-0000-000000000000",
+		Email:     "invitee@example.com",
+		Self:      entities.Guest{FirstName: "Sam", LastName: "Sample", RSVPStatus: entities.RSVPAttending},
+		Friends:   []entities.InviteeFriend{{Self: entities.Guest{FirstName: "Alex", LastName: "Sample", RSVPStatus: entities.RSVPMaybe}}},
+	}
+
+	if _, _, err := ms.render(compiled, event, nil, sample); err != nil {
+		return EmailTemplate{}, utils.NewApiError(422, err.Error())
+	}
+
+	t.FkEventID = event.EventID
+
+	if err := ms.da.SaveEmailTemplate(ctx, t); err != nil {
+		return EmailTemplate{}, utils.NewApiError(500, err.Error())
+	}
+
+	return EmailTemplate{EmailTemplate: t}, nil
+}
+
+// ResetTemplate goes back to the default email template of the kind kind for
+// the event with the id eventID.
+func (ms mailService) ResetTemplate(ctx context.Context, eventID string, kind string) utils.Error {
+	if !entities.IsEmailKind(kind) {
+		return utils.NewApiError(404, "There is no email of that kind!")
+	}
+
+	if err := ms.da.DeleteEmailTemplate(ctx, eventID, kind); err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
+
+// GetInviteesMatching gets all the invitees of the event with the id eventID
+// that match filter.
+func (ms mailService) GetInviteesMatching(ctx context.Context, eventID string, filter entities.InviteeFilter) ([]entities.Invitee, utils.Error) {
+	invitees, err := ms.da.GetInviteesMatching(ctx, eventID, filter)
+
+	if err != nil {
+		return []entities.Invitee{}, utils.NewApiError(500, err.Error())
+	}
+
+	return invitees, nil
+}
+
+// Send sends the email of the kind kind of event to invitees. items are the
+// event's menu items, for the names of the guests' menu choices. The
+// deliveries are recorded as queued and sent by background jobs; invitees
+// that can't be sent to are recorded as failed straight away.
+func (ms mailService) Send(ctx context.Context, event entities.Event, items []entities.MenuItem, kind string, invitees []entities.Invitee) ([]entities.EmailDelivery, utils.Error) {
+	compiled, uErr := ms.template(ctx, event.EventID, kind)
+
+	if uErr != nil {
+		return []entities.EmailDelivery{}, uErr
+	}
+
+	deliveries := make([]*entities.EmailDelivery, len(invitees))
+	messages := make([]mail.Message, len(invitees))
+
+	for i, invitee := range invitees {
+		delivery := &entities.EmailDelivery{
+			FkEventID:   event.EventID,
+			FkInviteeID: invitee.InviteeID,
+			Kind:        kind,
+			ToAddress:   invitee.Email,
+			Status:      entities.EmailQueued,
+		}
+
+		to, err := netmail.ParseAddress(invitee.Email)
+
+		if err == nil {
+			to.Name = strings.TrimSpace(invitee.Self.FirstName + " " + invitee.Self.LastName)
+			messages[i].To = *to
+			messages[i].Subject, messages[i].Body, err = ms.render(compiled, event, items, invitee)
+			delivery.Subject = messages[i].Subject
+		}
+
+		// confirmed guests can add the event to their calendars
+		if err == nil && kind == entities.EmailRSVPConfirmation {
+			messages[i].Attachments = []mail.Attachment{ms.invite(event, invitee)}
+		}
+
+		if err != nil {
+			delivery.Status = entities.EmailFailed
+			delivery.Error = err.Error()
+			emailsSent.Inc(kind, entities.EmailFailed)
+		}
+
+		deliveries[i] = delivery
+	}
+
+	if err := ms.da.CreateEmailDeliveries(ctx, deliveries); err != nil {
+		return []entities.EmailDelivery{}, utils.NewApiError(500, err.Error())
+	}
+
+	// the request can be over before the emails are sent
+	ms.enqueue(ctx, deliveries, messages)
+
+	sent := make([]entities.EmailDelivery, len(deliveries))
+
+	for i, delivery := range deliveries {
+		sent[i] = *delivery
+	}
+
+	return sent, nil
+}
+
+// emailJob is the payload of a send_email job.
+type emailJob struct {
+	DeliveryID  string            `json:"delivery_id"`
+	Kind        string            `json:"kind"`
+	ToName      string            `json:"to_name"`
+	ToAddress   string            `json:"to_address"`
+	Subject     string            `json:"subject"`
+	Body        string            `json:"body"`
+	Attachments []mail.Attachment `json:"attachments,omitempty"`
+}
+
+// enqueue enqueues a job to send each of the queued deliveries. A delivery
+// that can't be enqueued is recorded as failed.
+func (ms mailService) enqueue(ctx context.Context, deliveries []*entities.EmailDelivery, messages []mail.Message) {
+	logger := logging.FromContext(ctx)
+
+	for i, delivery := range deliveries {
+		if delivery.Status != entities.EmailQueued {
+			continue
+		}
+
+		_, err := ms.jobs.Enqueue(ctx, jobs.NewJob{
+			Kind: JobSendEmail,
+			Payload: emailJob{
+				DeliveryID:  delivery.EmailDeliveryID,
+				Kind:        delivery.Kind,
+				ToName:      messages[i].To.Name,
+				ToAddress:   messages[i].To.Address,
+				Subject:     messages[i].Subject,
+				Body:        messages[i].Body,
+				Attachments: messages[i].Attachments,
+			},
+			UniqueKey:   "email:" + delivery.EmailDeliveryID,
+			MaxAttempts: emailMaxAttempts,
+		})
+
+		if err == nil {
+			continue
+		}
+
+		logger.Error("could not enqueue email", "email_delivery_id", delivery.EmailDeliveryID, "error", err)
+
+		delivery.Status, delivery.Error = entities.EmailFailed, err.Error()
+		emailsSent.Inc(delivery.Kind, entities.EmailFailed)
+
+		if err := ms.da.SetEmailDeliveryStatus(ctx, delivery.EmailDeliveryID, delivery.Status, delivery.Error); err != nil {
+			logger.Error("could not record email delivery", "email_delivery_id", delivery.EmailDeliveryID, "error", err)
+		}
+	}
+}
+
+// deliver runs a send_email job: it hands the email to the transport and
+// records how it went. An email that couldn't be sent stays queued, with the
+// reason, until the job runs out of attempts.
+func (ms mailService) deliver(ctx context.Context, job jobs.Job) error {
+	var email emailJob
+
+	if err := job.Decode(&email); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	to := netmail.Address{Name: email.ToName, Address: email.ToAddress}
+
+	sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	err := ms.mailer.Send(sendCtx, to, email.Subject, email.Body, email.Attachments...)
+	cancel()
+
+	status, reason := entities.EmailSent, ""
+
+	if err != nil {
+		status, reason = entities.EmailQueued, err.Error()
+
+		if job.Attempt >= job.MaxAttempts {
+			status = entities.EmailFailed
+		}
+	}
+
+	if status != entities.EmailQueued {
+		emailsSent.Inc(email.Kind, status)
+	}
+
+	if sErr := ms.da.SetEmailDeliveryStatus(ctx, email.DeliveryID, status, reason); sErr != nil {
+		logging.FromContext(ctx).Error("could not record email delivery", "email_delivery_id", email.DeliveryID, "error", sErr)
+	}
+
+	return err
+}
+
+// GetDeliveries gets a page of the email deliveries of the event with the id
+// eventID that match filter.
+func (ms mailService) GetDeliveries(ctx context.Context, eventID string, filter entities.EmailDeliveryFilter, p *PaginationService) ([]entities.EmailDelivery, utils.Error) {
+	count, err := ms.da.GetNumberOfEmailDeliveriesForEvent(ctx, eventID, filter)
+
+	if err != nil {
+		return []entities.EmailDelivery{}, utils.NewApiError(500, err.Error())
+	}
+
+	p.SetNumItems(count)
+
+	deliveries, bounds, err := ms.da.GetEmailDeliveriesForEvent(ctx, eventID, filter, p.GetPage())
+
+	if err != nil {
+		return []entities.EmailDelivery{}, pageError(err)
+	}
+
+	p.SetBounds(bounds)
+
+	return deliveries, nil
+}
+
+// template gets the event's email template of the kind kind, ready to render.
+func (ms mailService) template(ctx context.Context, eventID string, kind string) (compiledEmail, utils.Error) {
+	if !entities.IsEmailKind(kind) {
+		return compiledEmail{}, utils.NewApiError(422, "kind has to be one of "+strings.Join(entities.EmailKinds, ", ")+"!")
+	}
+
+	templates, err := ms.GetTemplates(ctx, eventID)
+
+	if err != nil {
+		return compiledEmail{}, err
+	}
+
+	for _, t := range templates {
+		if t.Kind == kind {
+			compiled, cErr := compileEmail(t.EmailTemplate)
+
+			if cErr != nil {
+				return compiledEmail{}, utils.NewApiError(500, cErr.Error())
+			}
+
+			return compiled, nil
+		}
+	}
+
+	return compiledEmail{}, utils.NewApiError(500, "Missing email template!")
+}
+
+func compileEmail(t entities.EmailTemplate) (compiledEmail, error) {
+	subject, err := template.New("subject").Parse(t.Subject)
+
+	if err != nil {
+		return compiledEmail{}, err
+	}
+
+	body, err := template.New("body").Parse(t.Body)
+
+	if err != nil {
+		return compiledEmail{}, err
+	}
+
+	return compiledEmail{subject: subject, body: body}, nil
+}
+
+// render gets the subject and body of the email compiled to invitee.
+func (ms mailService) render(compiled compiledEmail, event entities.Event, items []entities.MenuItem, invitee entities.Invitee) (string, string, error) {
+	// guests read the times in the event's time zone, not the server's
+	zone := eventZone(event)
+	event.StartTime, event.EndTime, event.RespondBy = event.StartTime.In(zone), event.EndTime.In(zone), event.RespondBy.In(zone)
+
+	data := emailData{
+		Event:      event,
+		Invitee:    invitee,
+		FirstName:  invitee.Self.FirstName,
+		LastName:   invitee.Self.LastName,
+		RSVPStatus: invitee.Self.RSVPStatus,
+		RSVPLink:   ms.mailer.RSVPLink(invitee.InviteeID),
+		Guests:     []emailGuest{newEmailGuest(invitee.Self, items)},
+	}
+
+	for _, friend := range invitee.Friends {
+		data.Guests = append(data.Guests, newEmailGuest(friend.Self, items))
+	}
+
+	var subject, body bytes.Buffer
+
+	if err := compiled.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+
+	if err := compiled.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+
+	return subject.String(), body.String(), nil
+}
+
+func newEmailGuest(guest entities.Guest, items []entities.MenuItem) emailGuest {
+	eg := emailGuest{
+		Name:       strings.TrimSpace(guest.FirstName + " " + guest.LastName),
+		RSVPStatus: guest.RSVPStatus,
+		Answer:     emailAnswers[guest.RSVPStatus],
+	}
+
+	for _, item := range items {
+		for _, choice := range guest.MenuChoices {
+			if choice.FkMenuItemID != item.MenuItemID {
+				continue
+			}
+
+			for _, option := range item.Options {
+				if option.MenuItemOptionID == choice.FkMenuItemOptionID {
+					eg.Menu = append(eg.Menu, emailMenuChoice{Item: item.Name, Option: option.Name})
+				}
+			}
+		}
+	}
+
+	return eg
+}
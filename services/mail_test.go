@@ -0,0 +1,45 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/mail"
+)
+
+func TestEmailsShowTimesInTheEventsTimeZone(t *testing.T) {
+	ms := newMailService(nil, mail.NewMailer(&fakeTransport{}, mail.Config{}), nil)
+
+	compiled, err := compileEmail(defaultEmailTemplates[entities.EmailInvitation])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"America/Chicago", "Saturday, June 1, 2024 at 6:00 PM CDT"},
+		{"Asia/Tokyo", "Sunday, June 2, 2024 at 8:00 AM JST"},
+		{"", "Saturday, June 1, 2024 at 11:00 PM UTC"},
+		// a zone that doesn't load is shown in UTC rather than failing
+		{"Nowhere/Special", "Saturday, June 1, 2024 at 11:00 PM UTC"},
+	}
+
+	for _, tt := range tests {
+		event := entities.Event{Name: "Party", StartTime: start, RespondBy: start, TimeZone: tt.zone}
+
+		_, body, err := ms.render(compiled, event, nil, entities.Invitee{InviteeID: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(body, tt.want) {
+			t.Errorf("zone %q: body %q doesn't have %q", tt.zone, body, tt.want)
+		}
+	}
+}
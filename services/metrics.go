@@ -0,0 +1,34 @@
+package services
+
+import "github.com/grounded042/capacious/metrics"
+
+var (
+	rsvpsSubmitted = metrics.NewCounterVec(
+		"capacious_rsvps_submitted_total",
+		"Number of RSVPs submitted by invitees.",
+	)
+	inviteeResponses = metrics.NewCounterVec(
+		"capacious_invitee_responses_total",
+		"Number of times an invitee answered, by RSVP status.",
+		"response",
+	)
+	menuChoicesSet = metrics.NewCounterVec(
+		"capacious_menu_choices_set_total",
+		"Number of times a guest's menu choices were set.",
+	)
+	emailsSent = metrics.NewCounterVec(
+		"capacious_emails_sent_total",
+		"Number of emails sent to invitees, by kind and whether they were sent or failed.",
+		"kind", "status",
+	)
+	webhookDeliveries = metrics.NewCounterVec(
+		"capacious_webhook_deliveries_total",
+		"Number of attempts to deliver changes to webhooks, by event type and the status they left the delivery in.",
+		"event_type", "status",
+	)
+	logins = metrics.NewCounterVec(
+		"capacious_logins_total",
+		"Number of login attempts, by result.",
+		"result",
+	)
+)
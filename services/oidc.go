@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/oidc"
+	"github.com/grounded042/capacious/utils"
+)
+
+// how long a user has to log in with the provider and come back
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCAuthorization is where to send the user to log in with the OIDC provider.
+// State is sent back with the code from the provider to finish the login.
+type OIDCAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// BeginOIDCLogin starts a login with the OIDC provider.
+func (as authService) BeginOIDCLogin(ctx context.Context) (OIDCAuthorization, utils.Error) {
+	if as.oidc == nil {
+		return OIDCAuthorization{}, utils.NewApiError(404, "OIDC login is not configured.")
+	}
+
+	state := entities.OidcLoginState{ExpiresAt: time.Now().Add(oidcLoginTTL)}
+
+	for _, s := range []*string{&state.State, &state.CodeVerifier, &state.Nonce} {
+		secret, err := oidc.NewSecret()
+		if err != nil {
+			return OIDCAuthorization{}, utils.NewApiError(500, err.Error())
+		}
+
+		*s = secret
+	}
+
+	url, err := as.oidc.AuthCodeURL(ctx, state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return OIDCAuthorization{}, oidcError(err)
+	}
+
+	if err := as.da.CreateOidcLoginState(ctx, &state); err != nil {
+		return OIDCAuthorization{}, utils.NewApiError(500, err.Error())
+	}
+
+	return OIDCAuthorization{AuthorizationURL: url, State: state.State}, nil
+}
+
+// CompleteOIDCLogin finishes a login with the OIDC provider by exchanging code
+// for an ID token. The user is found by their identity with the provider, then
+// by their email address, and is created if that is allowed. Users with TOTP
+// enabled still need to pass a challenge.
+func (as authService) CompleteOIDCLogin(ctx context.Context, code string, state string) (LoginResult, utils.Error) {
+	if as.oidc == nil {
+		return LoginResult{}, utils.NewApiError(404, "OIDC login is not configured.")
+	}
+
+	loginState, err := as.da.TakeOidcLoginState(ctx, state)
+	if err != nil || time.Now().After(loginState.ExpiresAt) {
+		logins.Inc("failure")
+		return LoginResult{}, utils.NewApiError(401, "Unknown or expired login state.")
+	}
+
+	identity, err := as.oidc.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		logins.Inc("failure")
+		return LoginResult{}, oidcError(err)
+	}
+
+	user, uErr := as.getOIDCUser(ctx, identity)
+	if uErr != nil {
+		logins.Inc("failure")
+		return LoginResult{}, uErr
+	}
+
+	// users who only log in with the provider have no login of their own
+	dbUser, err := as.da.GetUserLoginFromUserID(ctx, user.UserID)
+	if err != nil && err.Error() != "record not found" {
+		return LoginResult{}, utils.NewApiError(500, err.Error())
+	}
+
+	if dbUser.TotpEnabled {
+		challenge, uErr := as.createChallenge(ctx, dbUser, time.Now())
+		if uErr != nil {
+			return LoginResult{}, uErr
+		}
+
+		logins.Inc("challenge")
+
+		return LoginResult{Challenge: challenge}, nil
+	}
+
+	token, uErr := as.GenerateToken(user.UserID)
+	if uErr != nil {
+		return LoginResult{}, uErr
+	}
+
+	logins.Inc("success")
+
+	return LoginResult{Token: token}, nil
+}
+
+// getOIDCUser gets the user identity belongs to, linking or creating them on
+// their first login with the provider.
+func (as authService) getOIDCUser(ctx context.Context, identity oidc.Identity) (entities.User, utils.Error) {
+	link, err := as.da.GetUserIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		user, err := as.da.GetUserFromID(ctx, link.FkUserID)
+		if err != nil {
+			return entities.User{}, utils.NewApiError(500, err.Error())
+		}
+
+		return user, nil
+	} else if err.Error() != "record not found" {
+		return entities.User{}, utils.NewApiError(500, err.Error())
+	}
+
+	conf := as.oidc.Config()
+
+	if identity.Email == "" {
+		return entities.User{}, utils.NewApiError(403, "The provider did not give an email address.")
+	}
+
+	// an unverified email can't be used to match an existing user, otherwise
+	// anyone could take over an account by claiming its email
+	if conf.RequireVerifiedEmail && !identity.EmailVerified {
+		return entities.User{}, utils.NewApiError(403, "The email address has not been verified by the provider.")
+	}
+
+	if !allowedDomain(identity.Email, conf.AllowedDomains) {
+		return entities.User{}, utils.NewApiError(403, "Users from this domain are not allowed to log in.")
+	}
+
+	link = entities.UserIdentity{Issuer: identity.Issuer, Subject: identity.Subject}
+
+	user, err := as.da.GetUserFromEmail(ctx, identity.Email)
+	if err == nil {
+		link.FkUserID = user.UserID
+
+		if err := as.da.CreateUserIdentity(ctx, &link); err != nil {
+			return entities.User{}, utils.NewApiError(500, err.Error())
+		}
+
+		return user, nil
+	} else if err.Error() != "record not found" {
+		return entities.User{}, utils.NewApiError(500, err.Error())
+	}
+
+	if !conf.Provision {
+		return entities.User{}, utils.NewApiError(403, "There is no user with this email address.")
+	}
+
+	user = entities.User{
+		Email:     identity.Email,
+		FirstName: identity.FirstName,
+		LastName:  identity.LastName,
+	}
+
+	if err := as.da.CreateUserWithIdentity(ctx, &user, &link); err != nil {
+		return entities.User{}, utils.NewApiError(500, err.Error())
+	}
+
+	return user, nil
+}
+
+// allowedDomain checks whether the domain of email is in domains. Every
+// domain is allowed when domains is empty.
+func allowedDomain(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+
+	if at < 0 {
+		return false
+	}
+
+	for _, d := range domains {
+		if email[at+1:] == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// oidcError gets the error to send the client for an error from the
+// provider. Problems with the provider itself are a bad gateway, anything
+// else means the login wasn't valid.
+func oidcError(err error) utils.Error {
+	if errors.Is(err, oidc.ErrProvider) {
+		return utils.NewApiError(502, "Error talking to the OIDC provider.")
+	}
+
+	return utils.NewApiError(401, err.Error())
+}
@@ -1,13 +1,26 @@
 package services
 
-import "math"
+import (
+	"math"
 
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/utils"
+)
+
+// PaginationService works out which page of a list to get. Pages are either
+// numbered, or, once a cursor is set, the items after or before the item the
+// cursor was made for. Numbered pages shift when items are added or removed
+// in front of them; cursor pages don't.
 type PaginationService struct {
 	minPageSize int
 	maxPageSize int
 	pageSize    int
 	pageNumber  int
 	numItems    int
+	keyset      bool
+	cursor      string
+	backwards   bool
+	bounds      entities.PageBounds
 }
 
 // NewPaginationService returns a new pagination service with pagination
@@ -17,6 +30,7 @@ func NewPaginationService() PaginationService {
 	p.SetMaxPageSize(25)
 	p.SetMinPageSize(10)
 	p.SetPageSize(15)
+	p.SetPageNumber(1)
 
 	return p
 }
@@ -70,8 +84,16 @@ func (p *PaginationService) GetFirst() int {
 	return 1
 }
 
+// GetLast gets the number of the last page. A list without items still has
+// an empty first page.
 func (p *PaginationService) GetLast() int {
-	return int(math.Ceil(float64(p.numItems) / float64(p.pageSize)))
+	last := int(math.Ceil(float64(p.numItems) / float64(p.pageSize)))
+
+	if last < 1 {
+		return 1
+	}
+
+	return last
 }
 
 func (p *PaginationService) GetPrev() int {
@@ -97,3 +119,54 @@ func (p *PaginationService) GetCurrent() int {
 func (p *PaginationService) GetSize() int {
 	return p.pageSize
 }
+
+// SetAfter switches to the page of items after the one cursor was made for,
+// or the first page if cursor is empty.
+func (p *PaginationService) SetAfter(cursor string) {
+	p.keyset = true
+	p.cursor = cursor
+	p.backwards = false
+}
+
+// SetBefore switches to the page of items before the one cursor was made
+// for, or the last page if cursor is empty.
+func (p *PaginationService) SetBefore(cursor string) {
+	p.keyset = true
+	p.cursor = cursor
+	p.backwards = true
+}
+
+// IsKeyset tells if the page is got from a cursor rather than by its number.
+func (p *PaginationService) IsKeyset() bool {
+	return p.keyset
+}
+
+// GetPage gets the page to get from the list.
+func (p *PaginationService) GetPage() entities.Page {
+	return entities.Page{
+		Start:     p.GetStartNumber(),
+		Length:    p.pageSize,
+		Keyset:    p.keyset,
+		Cursor:    p.cursor,
+		Backwards: p.backwards,
+	}
+}
+
+// SetBounds sets where the page that was got is in the list.
+func (p *PaginationService) SetBounds(bounds entities.PageBounds) {
+	p.bounds = bounds
+}
+
+// GetBounds gets where the page that was got is in the list.
+func (p *PaginationService) GetBounds() entities.PageBounds {
+	return p.bounds
+}
+
+// pageError gets the error to send for err, from getting a page of a list.
+func pageError(err error) utils.Error {
+	if err == entities.ErrBadCursor {
+		return utils.NewApiError(400, err.Error())
+	}
+
+	return utils.NewApiError(500, err.Error())
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/grounded042/capacious/audit"
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/patch"
+	"github.com/grounded042/capacious/utils"
+)
+
+// the fields invitees can change with a patch, as dotted paths in the JSON of
+// an invitee and an invitee friend. Menu choices, notes and seating requests
+// have their own routes.
+var (
+	inviteePatchable       = []string{"email", "self.first_name", "self.last_name", "self.attending", "self.rsvp_status"}
+	inviteeFriendPatchable = []string{"self.first_name", "self.last_name", "self.attending", "self.rsvp_status"}
+)
+
+// applyPatch applies p to the JSON of doc and unmarshals the result into out.
+// It fails if the patch changes anything but the fields in allowed.
+func applyPatch(doc interface{}, p patch.Patch, allowed []string, out interface{}) utils.Error {
+	before, err := json.Marshal(doc)
+
+	if err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	after, err := p.Apply(before)
+
+	if err == patch.ErrTestFailed {
+		return utils.NewApiError(409, "The patch's test did not match!")
+	} else if err != nil {
+		return utils.NewApiError(422, err.Error())
+	}
+
+	changes, err := audit.Diff(json.RawMessage(before), json.RawMessage(after))
+
+	if err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	var forbidden []string
+
+	for path := range changes {
+		if !contains(allowed, path) {
+			forbidden = append(forbidden, path)
+		}
+	}
+
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+
+		return utils.NewApiError(422, "These fields can't be changed: "+strings.Join(forbidden, ", "))
+	}
+
+	if err := json.Unmarshal(after, out); err != nil {
+		return utils.NewApiError(422, err.Error())
+	}
+
+	return nil
+}
+
+// findInviteeFriend finds the friend with the id friendID of invitee.
+func findInviteeFriend(invitee entities.Invitee, friendID string) (entities.InviteeFriend, bool) {
+	for _, friend := range invitee.Friends {
+		if friend.InviteeFriendID == friendID {
+			return friend, true
+		}
+	}
+
+	return entities.InviteeFriend{}, false
+}
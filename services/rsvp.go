@@ -0,0 +1,54 @@
+package services
+
+import (
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/utils"
+)
+
+// newGuestRSVP sets the RSVP status of a guest being added. Guests added
+// without one are attending if they are marked so and haven't answered yet
+// otherwise. Guests that have answered answered now.
+func newGuestRSVP(guest *entities.Guest) utils.Error {
+	if guest.RSVPStatus == "" {
+		guest.RSVPStatus = entities.RSVPPending
+
+		if guest.Attending {
+			guest.RSVPStatus = entities.RSVPAttending
+		}
+	}
+
+	if guest.RSVPStatus != entities.RSVPPending && guest.RSVPStatusAt == nil {
+		now := time.Now()
+		guest.RSVPStatusAt = &now
+	}
+
+	return syncAttending(guest)
+}
+
+// resolveRSVP works out the RSVP status of a guest answered for in an RSVP,
+// from the guest as it was before. A status that was sent wins; clients that
+// only change attending get attending or declined.
+func resolveRSVP(before entities.Guest, after *entities.Guest) utils.Error {
+	if after.RSVPStatus == before.RSVPStatus && after.Attending != before.Attending {
+		after.RSVPStatus = entities.RSVPDeclined
+
+		if after.Attending {
+			after.RSVPStatus = entities.RSVPAttending
+		}
+	}
+
+	return syncAttending(after)
+}
+
+// syncAttending checks guest's RSVP status and sets Attending to match it.
+func syncAttending(guest *entities.Guest) utils.Error {
+	if !entities.IsRSVPStatus(guest.RSVPStatus) {
+		return utils.NewApiError(422, "rsvp_status has to be one of pending, attending, declined or maybe!")
+	}
+
+	guest.Attending = guest.RSVPStatus == entities.RSVPAttending
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"math"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/utils"
+)
+
+type statsGateway interface {
+	// GetEventCounts counts the guests of an event by RSVP status along with
+	// their menu choices and seating requests
+	GetEventCounts(ctx context.Context, eventID string) (entities.EventCounts, error)
+	// GetResponseTimeline counts the answers of the invitees of an event by the
+	// day they were set
+	GetResponseTimeline(ctx context.Context, eventID string) ([]entities.ResponseDay, error)
+}
+
+type statsService struct {
+	da statsGateway
+}
+
+// EventStats are the numbers an event's admins watch it by. The guest counts
+// are of invitees and their friends together; Invitees counts the invitees
+// alone, and ResponseRate is the part of them that have answered.
+type EventStats struct {
+	NumInvitees        int                 `json:"num_invitees"`
+	NumFriends         int                 `json:"num_friends"`
+	NumGuests          int                 `json:"num_guests"`
+	NumAttending       int                 `json:"num_attending"`
+	NumDeclined        int                 `json:"num_declined"`
+	NumMaybe           int                 `json:"num_maybe"`
+	NumPending         int                 `json:"num_pending"`
+	Invitees           entities.RSVPCounts `json:"invitees"`
+	ResponseRate       float64             `json:"response_rate"`
+	ProjectedHeadcount float64             `json:"projected_headcount"`
+	Menu               MenuStats           `json:"menu"`
+	SeatingRequests    SeatingStats        `json:"seating_requests"`
+	Timeline           []TimelineDay       `json:"timeline"`
+}
+
+// MenuStats counts the guests attending by how many of the event's menu items
+// they have chosen for.
+type MenuStats struct {
+	Items          int     `json:"items"`
+	Complete       int     `json:"complete"`
+	Partial        int     `json:"partial"`
+	None           int     `json:"none"`
+	CompletionRate float64 `json:"completion_rate"`
+}
+
+// SeatingStats counts the seating requests of an event and the invitees that
+// made them.
+type SeatingStats struct {
+	Total    int `json:"total"`
+	Invitees int `json:"invitees"`
+}
+
+// TimelineDay is a day of answers, with how many invitees had answered by the
+// end of it.
+type TimelineDay struct {
+	entities.ResponseDay
+	Responded int `json:"responded"`
+}
+
+func newStatsService(newDa statsGateway) statsService {
+	return statsService{
+		da: newDa,
+	}
+}
+
+// GetEventStats works out the stats of the event with the id eventID.
+func (ss statsService) GetEventStats(ctx context.Context, eventID string) (EventStats, utils.Error) {
+	counts, err := ss.da.GetEventCounts(ctx, eventID)
+
+	if err != nil {
+		return EventStats{}, utils.NewApiError(500, "Error getting stats!")
+	}
+
+	days, err := ss.da.GetResponseTimeline(ctx, eventID)
+
+	if err != nil {
+		return EventStats{}, utils.NewApiError(500, "Error getting stats!")
+	}
+
+	guests := counts.GuestRSVPs
+	invitees := counts.InviteeRSVPs
+
+	stats := EventStats{
+		NumInvitees:        invitees.Total(),
+		NumFriends:         counts.Friends,
+		NumGuests:          guests.Total(),
+		NumAttending:       guests.Attending,
+		NumDeclined:        guests.Declined,
+		NumMaybe:           guests.Maybe,
+		NumPending:         guests.Pending,
+		Invitees:           invitees,
+		ResponseRate:       rate(invitees.Total()-invitees.Pending, invitees.Total()),
+		ProjectedHeadcount: projectHeadcount(guests),
+		Menu: MenuStats{
+			Items:          counts.MenuItems,
+			Complete:       counts.MenuComplete,
+			Partial:        counts.MenuPartial,
+			None:           counts.MenuNone,
+			CompletionRate: rate(counts.MenuComplete, counts.MenuComplete+counts.MenuPartial+counts.MenuNone),
+		},
+		SeatingRequests: SeatingStats{
+			Total:    counts.SeatingRequests,
+			Invitees: counts.InviteesWithSeatingRequests,
+		},
+		Timeline: make([]TimelineDay, len(days)),
+	}
+
+	responded := 0
+
+	for i, day := range days {
+		responded += day.Attending + day.Declined + day.Maybe
+		stats.Timeline[i] = TimelineDay{ResponseDay: day, Responded: responded}
+	}
+
+	return stats, nil
+}
+
+// projectHeadcount guesses how many guests will come: those attending, half
+// of those that might, and of those that haven't answered the same share as
+// of those that have.
+func projectHeadcount(guests entities.RSVPCounts) float64 {
+	projected := float64(guests.Attending) + float64(guests.Maybe)/2
+
+	if answered := guests.Attending + guests.Declined + guests.Maybe; answered > 0 {
+		projected += float64(guests.Pending) * float64(guests.Attending) / float64(answered)
+	}
+
+	return round(projected, 1)
+}
+
+// rate gets n out of total to four decimal places, or 0 when there is nothing
+// to count.
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return round(float64(n)/float64(total), 4)
+}
+
+func round(x float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+
+	return math.Round(x*scale) / scale
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TOTP codes are the RFC 6238 defaults most authenticator apps expect
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpModulo = 1000000
+	// how many steps either side of now a code is accepted for, to allow for
+	// clock drift
+	totpSkew = 1
+	// the issuer shown in authenticator apps
+	totpIssuer = "Capacious"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTotpSecret generates a new TOTP secret, base32 encoded.
+func newTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURI builds the otpauth:// URI authenticator apps are provisioned with,
+// usually by scanning it as a QR code.
+func totpURI(secret string, account string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+
+	return u.String()
+}
+
+// totpStep gets the time step t falls in.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode gets the code for the base32 encoded secret at step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, bin%totpModulo), nil
+}
+
+// matchTotpCode checks code against the base32 encoded secret for the steps
+// around now. It returns the step the code matched and whether it did.
+func matchTotpCode(secret string, code string, now time.Time) (int64, bool, error) {
+	if len(code) != totpDigits {
+		return 0, false, nil
+	}
+
+	current := totpStep(now)
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/utils"
+)
+
+const (
+	// how long a login has to answer a challenge
+	challengeTTL = 5 * time.Minute
+	// how many wrong codes a challenge takes before it is thrown away
+	maxChallengeAttempts = 5
+	// how many recovery codes a user is given
+	numRecoveryCodes = 10
+	// recovery codes are made of characters that can't be confused for each
+	// other
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+)
+
+// TwoFactorEnrolment holds what a user needs to add capacious to their
+// authenticator app. URI is the otpauth:// URI to show as a QR code and Secret
+// is for entering by hand.
+type TwoFactorEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// BeginTwoFactorEnrolment generates a new TOTP secret for the user with the id
+// userID. TOTP isn't enabled until the user confirms they can generate codes
+// with ConfirmTwoFactor. Users who only log in with an OIDC provider are given
+// a login without a password to keep it in.
+func (as authService) BeginTwoFactorEnrolment(ctx context.Context, userID string) (TwoFactorEnrolment, utils.Error) {
+	login, err := as.da.EnsureUserLogin(ctx, userID)
+	if err != nil {
+		return TwoFactorEnrolment{}, utils.NewApiError(500, err.Error())
+	}
+
+	if login.TotpEnabled {
+		return TwoFactorEnrolment{}, utils.NewApiError(409, "Two factor authentication is already enabled.")
+	}
+
+	user, err := as.da.GetUserFromID(ctx, userID)
+	if err != nil {
+		return TwoFactorEnrolment{}, utils.NewApiError(500, err.Error())
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		return TwoFactorEnrolment{}, utils.NewApiError(500, err.Error())
+	}
+
+	encrypted, err := encryptSecret(secret)
+	if err != nil {
+		return TwoFactorEnrolment{}, utils.NewApiError(500, err.Error())
+	}
+
+	if err := as.da.SetTotpSecret(ctx, login.UserLoginID, encrypted); err != nil {
+		return TwoFactorEnrolment{}, utils.NewApiError(500, err.Error())
+	}
+
+	return TwoFactorEnrolment{Secret: secret, URI: totpURI(secret, user.Email)}, nil
+}
+
+// ConfirmTwoFactor enables TOTP for the user with the id userID once they have
+// shown they can generate a code with the secret from
+// BeginTwoFactorEnrolment. It returns the user's recovery codes, which can't
+// be got again.
+func (as authService) ConfirmTwoFactor(ctx context.Context, userID string, code string) ([]string, utils.Error) {
+	login, uErr := as.getUserLogin(ctx, userID)
+	if uErr != nil {
+		return nil, uErr
+	}
+
+	if login.TotpEnabled {
+		return nil, utils.NewApiError(409, "Two factor authentication is already enabled.")
+	}
+
+	if login.TotpSecret == "" {
+		return nil, utils.NewApiError(409, "Two factor enrolment has not been started.")
+	}
+
+	secret, err := decryptSecret(login.TotpSecret)
+	if err != nil {
+		return nil, utils.NewApiError(500, err.Error())
+	}
+
+	step, ok, err := matchTotpCode(secret, strings.TrimSpace(code), time.Now())
+	if err != nil {
+		return nil, utils.NewApiError(500, err.Error())
+	} else if !ok {
+		return nil, utils.NewApiError(400, "Invalid code.")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, utils.NewApiError(500, err.Error())
+	}
+
+	if err := as.da.EnableTotp(ctx, login, step, hashes); err != nil {
+		return nil, utils.NewApiError(500, err.Error())
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor turns TOTP off for the user with the id userID. code must be
+// a valid TOTP or recovery code.
+func (as authService) DisableTwoFactor(ctx context.Context, userID string, code string) utils.Error {
+	login, uErr := as.getEnabledUserLogin(ctx, userID, code)
+	if uErr != nil {
+		return uErr
+	}
+
+	if err := as.da.DisableTotp(ctx, login); err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user with the id
+// userID. code must be a valid TOTP or recovery code.
+func (as authService) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, utils.Error) {
+	login, uErr := as.getEnabledUserLogin(ctx, userID, code)
+	if uErr != nil {
+		return nil, uErr
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, utils.NewApiError(500, err.Error())
+	}
+
+	if err := as.da.ReplaceRecoveryCodes(ctx, login.FkUserID, hashes); err != nil {
+		return nil, utils.NewApiError(500, err.Error())
+	}
+
+	return codes, nil
+}
+
+// HasTwoFactor gets whether the user with the id userID has TOTP enabled.
+func (as authService) HasTwoFactor(ctx context.Context, userID string) (bool, utils.Error) {
+	login, err := as.da.GetUserLoginFromUserID(ctx, userID)
+
+	if err != nil && err.Error() != "record not found" {
+		return false, utils.NewApiError(500, err.Error())
+	}
+
+	return login.TotpEnabled, nil
+}
+
+// createChallenge creates a challenge for dbUser, who has passed the password
+// check, to answer with a second factor.
+func (as authService) createChallenge(ctx context.Context, dbUser entities.UserLogin, now time.Time) (string, utils.Error) {
+	id := make([]byte, 32)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", utils.NewApiError(500, err.Error())
+	}
+
+	challenge := entities.LoginChallenge{
+		LoginChallengeID: base64.RawURLEncoding.EncodeToString(id),
+		FkUserLoginID:    dbUser.UserLoginID,
+		ExpiresAt:        now.Add(challengeTTL),
+	}
+
+	if err := as.da.CreateLoginChallenge(ctx, &challenge); err != nil {
+		return "", utils.NewApiError(500, err.Error())
+	}
+
+	return challenge.LoginChallengeID, nil
+}
+
+// answerChallenge checks the code in lUser against the challenge in lUser. A
+// token is returned if it is right. Wrong codes count as failed logins, and
+// the challenge is thrown away after too many of them.
+func (as authService) answerChallenge(ctx context.Context, lUser LoginUser) (LoginResult, utils.Error) {
+	now := time.Now()
+
+	challenge, err := as.da.GetLoginChallenge(ctx, lUser.Challenge)
+	if err != nil || now.After(challenge.ExpiresAt) {
+		return LoginResult{}, utils.NewApiError(401, "Login challenge not found or expired.")
+	}
+
+	dbUser, err := as.da.GetUserLoginFromID(ctx, challenge.FkUserLoginID)
+	if err != nil {
+		return LoginResult{}, utils.NewApiError(500, err.Error())
+	}
+
+	if uErr := checkLocked(dbUser, now); uErr != nil {
+		return LoginResult{}, uErr
+	}
+
+	ok, uErr := as.checkSecondFactor(ctx, dbUser, lUser.Code, now)
+	if uErr != nil {
+		return LoginResult{}, uErr
+	}
+
+	if !ok {
+		logins.Inc("failure")
+
+		attempts, err := as.da.RecordFailedChallenge(ctx, challenge.LoginChallengeID)
+		if err != nil {
+			return LoginResult{}, utils.NewApiError(500, err.Error())
+		}
+
+		if attempts >= maxChallengeAttempts {
+			if err := as.da.DeleteLoginChallenge(ctx, challenge.LoginChallengeID); err != nil {
+				return LoginResult{}, utils.NewApiError(500, err.Error())
+			}
+		}
+
+		return LoginResult{}, as.recordFailedLogin(ctx, dbUser, now)
+	}
+
+	if err := as.da.DeleteLoginChallenge(ctx, challenge.LoginChallengeID); err != nil {
+		return LoginResult{}, utils.NewApiError(500, err.Error())
+	}
+
+	return as.completeLogin(ctx, dbUser)
+}
+
+// checkSecondFactor checks code against dbUser's TOTP secret, or if it isn't a
+// TOTP code, against their unused recovery codes. A code that matches is used
+// up.
+func (as authService) checkSecondFactor(ctx context.Context, dbUser entities.UserLogin, code string, now time.Time) (bool, utils.Error) {
+	code = strings.TrimSpace(code)
+
+	if code == "" || !dbUser.TotpEnabled {
+		return false, nil
+	}
+
+	if len(code) != totpDigits {
+		used, err := as.da.UseRecoveryCode(ctx, dbUser.FkUserID, hashRecoveryCode(code))
+		if err != nil {
+			return false, utils.NewApiError(500, err.Error())
+		}
+
+		return used, nil
+	}
+
+	secret, err := decryptSecret(dbUser.TotpSecret)
+	if err != nil {
+		return false, utils.NewApiError(500, err.Error())
+	}
+
+	step, ok, err := matchTotpCode(secret, code, now)
+	if err != nil {
+		return false, utils.NewApiError(500, err.Error())
+	} else if !ok {
+		return false, nil
+	}
+
+	// a code can only be used once
+	used, err := as.da.UseTotpStep(ctx, dbUser.UserLoginID, step)
+	if err != nil {
+		return false, utils.NewApiError(500, err.Error())
+	}
+
+	return used, nil
+}
+
+// getUserLogin gets the user login of the user with the id userID.
+func (as authService) getUserLogin(ctx context.Context, userID string) (entities.UserLogin, utils.Error) {
+	login, err := as.da.GetUserLoginFromUserID(ctx, userID)
+
+	if err != nil && err.Error() == "record not found" {
+		return entities.UserLogin{}, utils.NewApiError(404, "Could not find a login for the user.")
+	} else if err != nil {
+		return entities.UserLogin{}, utils.NewApiError(500, err.Error())
+	}
+
+	return login, nil
+}
+
+// getEnabledUserLogin gets the user login of the user with the id userID,
+// making sure they have TOTP enabled and that code is a valid second factor
+// for them.
+func (as authService) getEnabledUserLogin(ctx context.Context, userID string, code string) (entities.UserLogin, utils.Error) {
+	login, uErr := as.getUserLogin(ctx, userID)
+	if uErr != nil {
+		return entities.UserLogin{}, uErr
+	}
+
+	if !login.TotpEnabled {
+		return entities.UserLogin{}, utils.NewApiError(409, "Two factor authentication is not enabled.")
+	}
+
+	ok, uErr := as.checkSecondFactor(ctx, login, code, time.Now())
+	if uErr != nil {
+		return entities.UserLogin{}, uErr
+	} else if !ok {
+		return entities.UserLogin{}, utils.NewApiError(400, "Invalid code.")
+	}
+
+	return login, nil
+}
+
+// newRecoveryCodes generates a set of recovery codes along with the hashes of
+// them to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, numRecoveryCodes)
+	hashes := make([]string, numRecoveryCodes)
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	for i := range codes {
+		code := make([]byte, recoveryCodeLength)
+
+		for j := range code {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			code[j] = recoveryCodeAlphabet[n.Int64()]
+		}
+
+		half := recoveryCodeLength / 2
+		codes[i] = string(code[:half]) + "-" + string(code[half:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code for storing or looking up. The codes
+// are random enough that they don't need a slow hash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// encryptSecret encrypts a TOTP secret with the ENC_KEY for storing.
+func encryptSecret(secret string) (string, error) {
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts a TOTP secret encrypted by encryptSecret.
+func decryptSecret(encrypted string) (string, error) {
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+func newSecretCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
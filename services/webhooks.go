@@ -0,0 +1,418 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/grounded042/capacious/entities"
+	"github.com/grounded042/capacious/jobs"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/utils"
+	"github.com/grounded042/capacious/webhooks"
+)
+
+// JobDeliverWebhook is the kind of the jobs that send changes to webhooks.
+const JobDeliverWebhook = "deliver_webhook"
+
+// how long a webhook has to answer
+const webhookTimeout = 10 * time.Second
+
+// WebhookAllowedNetworks are the loopback, private and link-local networks
+// webhooks may be sent to anyway. It is for development and testing only:
+// event admins can have the server request anything in them.
+var WebhookAllowedNetworks webhooks.Networks
+
+type webhooksGateway interface {
+	// GetWebhooksForEvent gets the webhooks of an event
+	GetWebhooksForEvent(ctx context.Context, eventID string) ([]entities.Webhook, error)
+	// GetWebhook gets a webhook
+	GetWebhook(ctx context.Context, webhookID string) (entities.Webhook, error)
+	// CreateWebhook creates a webhook
+	CreateWebhook(ctx context.Context, webhook *entities.Webhook) error
+	// DeleteWebhook deletes a webhook of an event
+	DeleteWebhook(ctx context.Context, eventID string, webhookID string) (bool, error)
+	// CreateWebhookDeliveries records a delivery of a change to each webhook
+	// of an event that asked for it
+	CreateWebhookDeliveries(ctx context.Context, eventID string, eventType string, payload entities.JSON) ([]string, error)
+	// GetWebhookDelivery gets a webhook delivery
+	GetWebhookDelivery(ctx context.Context, deliveryID string) (entities.WebhookDelivery, error)
+	// RecordWebhookAttempt records an attempt at a webhook delivery
+	RecordWebhookAttempt(ctx context.Context, deliveryID string, status string, responseStatus int, responseBody string, reason string) error
+	// ResetWebhookDelivery marks a webhook delivery as pending again
+	ResetWebhookDelivery(ctx context.Context, deliveryID string) error
+	// GetWebhookDeliveries gets a page of the deliveries to a webhook that
+	// match a filter
+	GetWebhookDeliveries(ctx context.Context, webhookID string, filter entities.WebhookDeliveryFilter, page entities.Page) ([]entities.WebhookDelivery, entities.PageBounds, error)
+	// GetNumberOfWebhookDeliveries counts the deliveries to a webhook that
+	// match a filter
+	GetNumberOfWebhookDeliveries(ctx context.Context, webhookID string, filter entities.WebhookDeliveryFilter) (int, error)
+}
+
+type webhooksService struct {
+	da     webhooksGateway
+	sender *webhooks.Sender
+	jobs   jobs.Enqueuer
+}
+
+// NewWebhook is a webhook to create.
+type NewWebhook struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+// WebhookData is the data sent to webhooks with a change to an invitee: the
+// invitee after the change and, depending on the change, the guest it was to
+// and what it was.
+type WebhookData struct {
+	Invitee            entities.Invitee        `json:"invitee"`
+	GuestID            string                  `json:"guest_id,omitempty"`
+	RSVPStatus         string                  `json:"rsvp_status,omitempty"`
+	PreviousRSVPStatus string                  `json:"previous_rsvp_status,omitempty"`
+	MenuChoices        []entities.MenuChoice   `json:"menu_choices,omitempty"`
+	Friend             *entities.InviteeFriend `json:"friend,omitempty"`
+}
+
+// webhookPayload is the body of the requests sent to webhooks.
+type webhookPayload struct {
+	Type       string      `json:"type"`
+	EventID    string      `json:"event_id"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       WebhookData `json:"data"`
+}
+
+// webhookJob is the payload of a deliver_webhook job.
+type webhookJob struct {
+	DeliveryID string `json:"delivery_id"`
+}
+
+func newWebhooksService(newDa webhooksGateway, enqueuer jobs.Enqueuer) webhooksService {
+	return webhooksService{
+		da:     newDa,
+		sender: webhooks.NewSender(webhookTimeout, WebhookAllowedNetworks),
+		jobs:   enqueuer,
+	}
+}
+
+// GetWebhooks gets the webhooks of the event with the id eventID, without
+// their secrets.
+func (ws webhooksService) GetWebhooks(ctx context.Context, eventID string) ([]entities.Webhook, utils.Error) {
+	found, err := ws.da.GetWebhooksForEvent(ctx, eventID)
+
+	if err != nil {
+		return []entities.Webhook{}, utils.NewApiError(500, err.Error())
+	}
+
+	for i := range found {
+		found[i].Secret = ""
+	}
+
+	return found, nil
+}
+
+// Create creates a webhook for the event with the id eventID. It is the only
+// time the webhook's secret is given out.
+func (ws webhooksService) Create(ctx context.Context, eventID string, newWebhook NewWebhook) (entities.Webhook, utils.Error) {
+	if err := webhooks.ValidURL(newWebhook.URL, WebhookAllowedNetworks); err != nil {
+		return entities.Webhook{}, utils.NewApiError(422, err.Error())
+	}
+
+	if len(newWebhook.EventTypes) == 0 {
+		return entities.Webhook{}, utils.NewApiError(422, "A webhook needs at least one event type!")
+	}
+
+	for _, eventType := range newWebhook.EventTypes {
+		if !entities.IsWebhookEventType(eventType) {
+			return entities.Webhook{}, utils.NewApiError(422, "Unknown event type: "+eventType)
+		}
+	}
+
+	secret, err := webhooks.NewSecret()
+
+	if err != nil {
+		return entities.Webhook{}, utils.NewApiError(500, err.Error())
+	}
+
+	webhook := entities.Webhook{
+		FkEventID:  eventID,
+		URL:        newWebhook.URL,
+		Secret:     secret,
+		EventTypes: entities.StringList(newWebhook.EventTypes),
+	}
+
+	if err := ws.da.CreateWebhook(ctx, &webhook); err != nil {
+		return entities.Webhook{}, utils.NewApiError(500, err.Error())
+	}
+
+	return webhook, nil
+}
+
+// Delete deletes the webhook with the id webhookID of the event with the id
+// eventID.
+func (ws webhooksService) Delete(ctx context.Context, eventID string, webhookID string) utils.Error {
+	deleted, err := ws.da.DeleteWebhook(ctx, eventID, webhookID)
+
+	if err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	if !deleted {
+		return utils.NewApiError(404, "The event does not have that webhook!")
+	}
+
+	return nil
+}
+
+// get gets the webhook with the id webhookID of the event with the id
+// eventID.
+func (ws webhooksService) get(ctx context.Context, eventID string, webhookID string) (entities.Webhook, utils.Error) {
+	webhook, err := ws.da.GetWebhook(ctx, webhookID)
+
+	if err != nil {
+		return entities.Webhook{}, utils.NewApiError(500, err.Error())
+	}
+
+	if webhook.WebhookID == "" || webhook.FkEventID != eventID {
+		return entities.Webhook{}, utils.NewApiError(404, "The event does not have that webhook!")
+	}
+
+	return webhook, nil
+}
+
+// GetDeliveries gets a page of the deliveries to the webhook with the id
+// webhookID of the event with the id eventID that match filter.
+func (ws webhooksService) GetDeliveries(ctx context.Context, eventID string, webhookID string, filter entities.WebhookDeliveryFilter, p *PaginationService) ([]entities.WebhookDelivery, utils.Error) {
+	if _, err := ws.get(ctx, eventID, webhookID); err != nil {
+		return []entities.WebhookDelivery{}, err
+	}
+
+	count, err := ws.da.GetNumberOfWebhookDeliveries(ctx, webhookID, filter)
+
+	if err != nil {
+		return []entities.WebhookDelivery{}, utils.NewApiError(500, err.Error())
+	}
+
+	p.SetNumItems(count)
+
+	deliveries, bounds, err := ws.da.GetWebhookDeliveries(ctx, webhookID, filter, p.GetPage())
+
+	if err != nil {
+		return []entities.WebhookDelivery{}, pageError(err)
+	}
+
+	p.SetBounds(bounds)
+
+	return deliveries, nil
+}
+
+// Redeliver sends the delivery with the id deliveryID to its webhook again,
+// whatever came of it before. It returns the delivery as it is before it is
+// sent.
+func (ws webhooksService) Redeliver(ctx context.Context, eventID string, webhookID string, deliveryID string) (entities.WebhookDelivery, utils.Error) {
+	if _, err := ws.get(ctx, eventID, webhookID); err != nil {
+		return entities.WebhookDelivery{}, err
+	}
+
+	delivery, err := ws.da.GetWebhookDelivery(ctx, deliveryID)
+
+	if err != nil {
+		return entities.WebhookDelivery{}, utils.NewApiError(500, err.Error())
+	}
+
+	if delivery.WebhookDeliveryID == "" || delivery.FkWebhookID != webhookID {
+		return entities.WebhookDelivery{}, utils.NewApiError(404, "The webhook does not have that delivery!")
+	}
+
+	if err := ws.da.ResetWebhookDelivery(ctx, deliveryID); err != nil {
+		return entities.WebhookDelivery{}, utils.NewApiError(500, err.Error())
+	}
+
+	// no unique key, a delivery can be redelivered any number of times
+	_, err = ws.jobs.Enqueue(ctx, jobs.NewJob{Kind: JobDeliverWebhook, Payload: webhookJob{DeliveryID: deliveryID}})
+
+	if err != nil {
+		return entities.WebhookDelivery{}, utils.NewApiError(500, err.Error())
+	}
+
+	delivery.Status, delivery.Error = entities.WebhookPending, ""
+
+	return delivery, nil
+}
+
+// Publish records a delivery of the change eventType, with data, to each
+// webhook of the event with the id eventID that asked for it, and enqueues a
+// job to send each one. Both are made in the transaction ctx carries, if it
+// does.
+func (ws webhooksService) Publish(ctx context.Context, eventID string, eventType string, data WebhookData) error {
+	payload, err := json.Marshal(webhookPayload{
+		Type:       eventType,
+		EventID:    eventID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ids, err := ws.da.CreateWebhookDeliveries(ctx, eventID, eventType, entities.JSON(payload))
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err := ws.jobs.Enqueue(ctx, jobs.NewJob{
+			Kind:      JobDeliverWebhook,
+			Payload:   webhookJob{DeliveryID: id},
+			UniqueKey: "webhook:" + id,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver runs a deliver_webhook job: it sends the delivery to its webhook and
+// records how it went. A delivery the webhook didn't take stays pending until
+// the job runs out of attempts. Deliveries whose webhook was deleted are
+// dropped.
+func (ws webhooksService) deliver(ctx context.Context, job jobs.Job) error {
+	var payload webhookJob
+
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	delivery, err := ws.da.GetWebhookDelivery(ctx, payload.DeliveryID)
+
+	if err != nil || delivery.WebhookDeliveryID == "" {
+		return err
+	}
+
+	webhook, err := ws.da.GetWebhook(ctx, delivery.FkWebhookID)
+
+	if err != nil || webhook.WebhookID == "" {
+		return err
+	}
+
+	res, err := ws.sender.Send(ctx, webhooks.Request{
+		URL:        webhook.URL,
+		Secret:     webhook.Secret,
+		DeliveryID: delivery.WebhookDeliveryID,
+		Event:      delivery.EventType,
+		Body:       delivery.Payload,
+	})
+
+	status, reason := entities.WebhookDelivered, ""
+
+	if err != nil {
+		status, reason = entities.WebhookPending, err.Error()
+
+		// the address won't stop being private by trying again
+		if errors.Is(err, webhooks.ErrPrivateAddress) {
+			err = jobs.Permanent(err)
+		}
+
+		if job.Attempt >= job.MaxAttempts || jobs.IsPermanent(err) {
+			status = entities.WebhookFailed
+		}
+	}
+
+	webhookDeliveries.Inc(delivery.EventType, status)
+
+	if rErr := ws.da.RecordWebhookAttempt(ctx, delivery.WebhookDeliveryID, status, res.Status, res.Body, reason); rErr != nil {
+		logging.FromContext(ctx).Error("could not record webhook delivery", "webhook_delivery_id", delivery.WebhookDeliveryID, "error", rErr)
+	}
+
+	return err
+}
+
+// webhooks coordination
+
+// GetWebhooks gets the webhooks of the event with the id eventID
+func (c Coordinator) GetWebhooks(ctx context.Context, eventID string, userID string) ([]entities.Webhook, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the webhooks for this event!")
+
+	if err != nil {
+		return []entities.Webhook{}, err
+	}
+
+	return c.webhooks.GetWebhooks(ctx, eventID)
+}
+
+// CreateWebhook creates a webhook for the event with the id eventID
+func (c Coordinator) CreateWebhook(ctx context.Context, eventID string, userID string, newWebhook NewWebhook) (entities.Webhook, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to add webhooks to this event!")
+
+	if err != nil {
+		return entities.Webhook{}, err
+	}
+
+	return c.webhooks.Create(ctx, eventID, newWebhook)
+}
+
+// DeleteWebhook deletes the webhook with the id webhookID of the event with
+// the id eventID
+func (c Coordinator) DeleteWebhook(ctx context.Context, eventID string, webhookID string, userID string) utils.Error {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to delete webhooks of this event!")
+
+	if err != nil {
+		return err
+	}
+
+	return c.webhooks.Delete(ctx, eventID, webhookID)
+}
+
+// GetWebhookDeliveries gets a page of the deliveries to the webhook with the
+// id webhookID of the event with the id eventID that match filter
+func (c Coordinator) GetWebhookDeliveries(ctx context.Context, eventID string, webhookID string, userID string, filter entities.WebhookDeliveryFilter, p *PaginationService) ([]entities.WebhookDelivery, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to view the webhooks for this event!")
+
+	if err != nil {
+		return []entities.WebhookDelivery{}, err
+	}
+
+	return c.webhooks.GetDeliveries(ctx, eventID, webhookID, filter, p)
+}
+
+// RedeliverWebhook sends the delivery with the id deliveryID to the webhook
+// with the id webhookID of the event with the id eventID again
+func (c Coordinator) RedeliverWebhook(ctx context.Context, eventID string, webhookID string, deliveryID string, userID string) (entities.WebhookDelivery, utils.Error) {
+	err := c.checkEventAdmin(ctx, userID, eventID, "You are not authorized to redeliver webhooks of this event!")
+
+	if err != nil {
+		return entities.WebhookDelivery{}, err
+	}
+
+	return c.webhooks.Redeliver(ctx, eventID, webhookID, deliveryID)
+}
+
+// inviteeWebhook is a change to an invitee to send to the webhooks of its
+// event.
+type inviteeWebhook struct {
+	Type string
+	Data WebhookData
+}
+
+// publish sends the change eventType to invitee to the webhooks of its event
+// that asked for it. It is called in the transaction the change is made in,
+// so the deliveries and the jobs that send them are only kept if the change
+// is, and a change isn't made without them.
+func (c Coordinator) publish(ctx context.Context, eventType string, invitee entities.Invitee, data WebhookData) utils.Error {
+	var uErr utils.Error
+
+	if data.Invitee, uErr = c.encryptInviteeSeatingRequests(invitee); uErr != nil {
+		return uErr
+	}
+
+	if err := c.webhooks.Publish(ctx, invitee.FkEventID, eventType, data); err != nil {
+		return utils.NewApiError(500, err.Error())
+	}
+
+	return nil
+}
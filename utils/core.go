@@ -1,9 +1,8 @@
 package utils
 
 import (
-	"log"
 	"runtime"
-	"strconv"
+	"time"
 )
 
 // Capacious Error Interface
@@ -24,6 +23,7 @@ type ApiError struct {
 	c int
 	e string
 	l string
+	r time.Duration
 }
 
 func (err ApiError) Code() int {
@@ -38,6 +38,12 @@ func (err ApiError) Location() string {
 	return err.l
 }
 
+// RetryAfter is how long the client should wait before trying again, or 0 if
+// there is no need to say.
+func (err ApiError) RetryAfter() time.Duration {
+	return err.r
+}
+
 // Build a new ApiError
 func NewApiError(code int, err string) ApiError {
 	// get the location info
@@ -47,31 +53,11 @@ func NewApiError(code int, err string) ApiError {
 	return ApiError{c: code, e: err, l: loc}
 }
 
-// Log errors. When you pass an obj that implements Error, we log
-// the code as well.
-func LogError(logme error) {
-	if e, ok := logme.(Error); ok {
-		PrintRed("Error: " + strconv.Itoa(e.Code()) + " - " + e.Error())
-		PrintRed("Error Location: " + e.Location())
-	} else if _, ok = logme.(error); ok {
-		PrintRed("Error: " + logme.Error())
-	}
-}
-
-// This function is for when there might be an error, but
-// we don't really care about. It will check and see if
-// there is an error and, if there is, log that error.
-func CheckErr(err error, msg string) {
-	if err != nil {
-		PrintRed(msg + ":")
-		PrintRed(err.Error())
-	}
-}
-
-func Print(msg string) {
-	log.Println(msg)
-}
+// Build a new ApiError for a request the client can try again after
+// retryAfter
+func NewRetryAfterError(code int, err string, retryAfter time.Duration) ApiError {
+	pc, _, _, _ := runtime.Caller(1)
+	loc := runtime.FuncForPC(pc).Name()
 
-func PrintRed(msg string) {
-	log.Println("\033[31;1m" + msg + "\033[0m")
+	return ApiError{c: code, e: err, l: loc, r: retryAfter}
 }
@@ -0,0 +1,299 @@
+// Package webhooks signs and sends the requests that tell other systems about
+// changes to an event.
+//
+// Each request is a POST of a JSON body signed with the webhook's secret. The
+// signature is sent in the Capacious-Signature header as t=<unix time>,v1=<hex
+// HMAC-SHA256 of "<t>.<body>">, so a receiver can check both who sent the
+// request and when.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the headers sent with every request
+const (
+	SignatureHeader = "Capacious-Signature"
+	DeliveryHeader  = "Capacious-Delivery"
+	EventHeader     = "Capacious-Event"
+)
+
+// SecretPrefix starts every secret, so they can be found by secret scanners.
+const SecretPrefix = "whsec_"
+
+// ErrBadSignature is returned by Verify when a signature doesn't match.
+var ErrBadSignature = errors.New("webhook signature doesn't match")
+
+// NewSecret generates a secret to sign a webhook's requests with.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Sign signs body, sent at t, with secret. It returns the value of the
+// Capacious-Signature header.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+// Verify checks header, a Capacious-Signature header, against body and
+// secret. Signatures made more than tolerance before now are turned away so
+// captured requests can't be replayed; a tolerance of 0 doesn't check.
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts string
+	var sigs [][]byte
+
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if sig, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+
+	if err != nil || len(sigs) == 0 {
+		return ErrBadSignature
+	}
+
+	if tolerance > 0 && now.Sub(time.Unix(unix, 0)) > tolerance {
+		return ErrBadSignature
+	}
+
+	expected := mac(secret, ts, body)
+
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+
+	return ErrBadSignature
+}
+
+func mac(secret string, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// ErrPrivateAddress is returned for webhooks on loopback, private, link-local
+// or unspecified addresses, which would let whoever registers a webhook read
+// the services next to the server.
+var ErrPrivateAddress = errors.New("webhooks can't be sent to loopback, private or link-local addresses")
+
+// Networks are the private networks webhooks may be sent to anyway, such as
+// a receiver on the developer's own machine.
+type Networks []*net.IPNet
+
+// ParseNetworks parses a comma separated list of CIDRs.
+func ParseNetworks(s string) (Networks, error) {
+	var networks Networks
+
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func (n Networks) contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowed is whether requests can be sent to ip: it is public, or in one of
+// the networks allowed anyway.
+func (n Networks) allowed(ip net.IP) bool {
+	return !isPrivate(ip) || n.contains(ip)
+}
+
+// ValidURL checks that u is somewhere requests can be sent: an absolute http
+// or https URL. Hosts that are private addresses outside allowed are turned
+// away; hosts that only resolve to them are turned away when the request is
+// sent, as what they resolve to can change.
+func ValidURL(u string, allowed Networks) error {
+	parsed, err := url.Parse(u)
+
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("the url has to be http or https")
+	}
+
+	host := parsed.Hostname()
+
+	if host == "" {
+		return errors.New("the url has to have a host")
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		host = "127.0.0.1"
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !allowed.allowed(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// carrier-grade NAT addresses, which aren't public either
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPrivate is whether ip is somewhere only the server's own network can
+// reach.
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// checkAddress gets a net.Dialer Control that turns away connections to
+// private addresses outside allowed. It runs once the host has been resolved,
+// for every address tried, so a host can't pass when the webhook is created
+// and resolve somewhere else when it is sent.
+func checkAddress(allowed Networks) func(network string, address string, c syscall.RawConn) error {
+	return func(network string, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+
+		if err != nil {
+			return err
+		}
+
+		if ip := net.ParseIP(host); ip == nil || !allowed.allowed(ip) {
+			return ErrPrivateAddress
+		}
+
+		return nil
+	}
+}
+
+// Request is one request to send to a webhook.
+type Request struct {
+	URL        string
+	Secret     string
+	DeliveryID string
+	Event      string
+	Body       []byte
+}
+
+// Response is what came of sending a request. Status is 0 if there was no
+// response.
+type Response struct {
+	Status int
+	Body   string
+}
+
+// how much of a response body is kept
+const maxResponseBody = 1 << 10
+
+// Sender sends requests to webhooks.
+type Sender struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+// NewSender builds a Sender that gives each request timeout to be answered.
+// Requests aren't sent to private addresses outside allowed, and redirects
+// aren't followed, as they could lead to one.
+func NewSender(timeout time.Duration, allowed Networks) *Sender {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: checkAddress(allowed)}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// a proxy would be dialled instead of the webhook, skipping the check
+	transport.Proxy = nil
+
+	return &Sender{
+		Client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// the redirect is the response, which isn't a 2xx
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		UserAgent: "Capacious-Webhooks/1",
+	}
+}
+
+// Send signs and sends req. Anything but a 2xx response is an error.
+func (s *Sender) Send(ctx context.Context, req Request) (Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Body))
+
+	if err != nil {
+		return Response{}, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", s.UserAgent)
+	httpReq.Header.Set(DeliveryHeader, req.DeliveryID)
+	httpReq.Header.Set(EventHeader, req.Event)
+	httpReq.Header.Set(SignatureHeader, Sign(req.Secret, time.Now(), req.Body))
+
+	httpRes, err := s.Client.Do(httpReq)
+
+	if err != nil {
+		return Response{}, err
+	}
+
+	defer httpRes.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(httpRes.Body, maxResponseBody))
+	res := Response{Status: httpRes.StatusCode, Body: string(body)}
+
+	if httpRes.StatusCode < 200 || httpRes.StatusCode > 299 {
+		return res, fmt.Errorf("the webhook answered %s", httpRes.Status)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grounded042/capacious/dal"
+	"github.com/grounded042/capacious/jobs"
+	"github.com/grounded042/capacious/logging"
+	"github.com/grounded042/capacious/services"
+	"github.com/grounded042/capacious/webhooks"
+)
+
+const workerUsage = `usage: capacious worker [flags]
+
+Runs background jobs, such as sending emails, reminding invitees and locking
+RSVPs, without serving the API. Any number of workers can run at once.
+`
+
+const jobsUsage = `usage: capacious jobs <command> [flags]
+
+Looks after the background jobs that failed for good.
+
+commands:
+  dead        list the jobs that failed for good
+  retry <id>  put a job that failed for good back on the queue
+`
+
+// addScheduleFlags adds the flags for when the scheduler enqueues jobs to fs.
+func addScheduleFlags(fs *flag.FlagSet) {
+	fs.IntVar(&services.ReminderDays, "reminder-days", services.ReminderDays, "How many days before an event's respond_by its pending invitees are reminded.")
+	fs.IntVar(&services.DigestHour, "digest-hour", services.DigestHour, "The hour of the day event admins are sent the RSVPs of the day before.")
+	fs.DurationVar(&services.JobRetention, "job-retention", services.JobRetention, "How long background jobs that are done are kept.")
+}
+
+// addWebhookFlags adds the flags for where webhooks can be sent to fs.
+func addWebhookFlags(fs *flag.FlagSet) {
+	fs.Func("webhook-allowed-networks", "Comma separated CIDRs of private networks webhooks may be sent to anyway, e.g. 127.0.0.0/8. Only for development and testing.", func(s string) error {
+		networks, err := webhooks.ParseNetworks(s)
+		services.WebhookAllowedNetworks = networks
+
+		return err
+	})
+}
+
+// startJobs runs concurrency workers, and the scheduler if schedule is set,
+// until ctx is done. The function it returns waits for the jobs that were
+// running when ctx was done to finish.
+func startJobs(ctx context.Context, co services.Coordinator, da dal.DataHandler, concurrency int, schedule bool) func() {
+	var wg sync.WaitGroup
+
+	if concurrency > 0 {
+		worker := jobs.NewWorker(da.JobStore(), co.JobHandlers())
+		worker.Concurrency = concurrency
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			worker.Run(ctx)
+		}()
+	}
+
+	if schedule {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			jobs.NewScheduler(co.ScheduledTasks()).Run(ctx)
+		}()
+	}
+
+	return wg.Wait
+}
+
+// workerCommand runs the worker command with args and returns the exit code.
+func workerCommand(args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, workerUsage)
+		fs.PrintDefaults()
+	}
+	logFormat := fs.String("log-format", "json", "The log format: json or logfmt.")
+	logLevel := fs.String("log-level", "info", "The log level: debug, info, warn or error.")
+	concurrency := fs.Int("concurrency", 2, "How many jobs are run at once.")
+	schedule := fs.Bool("scheduler", true, "Also enqueue reminders, digests and RSVP locks as they come due.")
+	addScheduleFlags(fs)
+	addWebhookFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	logger, err := logging.New(os.Stderr, *logFormat, *logLevel)
+
+	if err != nil {
+		slog.Error("could not set up logging", "error", err)
+		return 2
+	}
+
+	slog.SetDefault(logger)
+
+	ac := getAppContext()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("running background jobs", "concurrency", *concurrency, "scheduler", *schedule)
+
+	wait := startJobs(ctx, ac.Services, ac.Data, *concurrency, *schedule)
+
+	<-ctx.Done()
+	slog.Info("stopping, finishing the jobs that are running")
+	wait()
+
+	return 0
+}
+
+// jobsCommand runs the jobs command with args and returns the exit code.
+func jobsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, jobsUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("jobs "+args[0], flag.ContinueOnError)
+	limit := fs.Int("limit", 50, "The most jobs to list.")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	da := dal.NewDal()
+	ctx := context.Background()
+
+	var err error
+
+	switch {
+	case args[0] == "dead":
+		err = listDeadJobs(ctx, da, *limit)
+	case args[0] == "retry" && fs.NArg() == 1:
+		err = retryDeadJob(ctx, da, fs.Arg(0))
+	default:
+		fmt.Fprint(os.Stderr, jobsUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func listDeadJobs(ctx context.Context, da dal.DataHandler, limit int) error {
+	dead, err := da.GetDeadJobs(ctx, limit)
+
+	if err != nil {
+		return err
+	}
+
+	for _, job := range dead {
+		fmt.Printf("%s\t%s\tdied %s after %d attempts\t%s\t%s\n", job.ID, job.Kind, job.FinishedAt.Format(time.RFC3339), job.Attempts, job.LastError, job.Payload)
+	}
+
+	return nil
+}
+
+func retryDeadJob(ctx context.Context, da dal.DataHandler, jobID string) error {
+	ok, err := da.RetryDeadJob(ctx, jobID)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("there is no dead job %s", jobID)
+	}
+
+	fmt.Printf("job %s is queued again\n", jobID)
+
+	return nil
+}